@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/generate"
+	"io"
+	"os"
+	"time"
+)
+
+// generateMain writes alert rules or a dashboard for the metrics vpnck publishes to stdout, returning the exit code
+func generateMain(args []string) int {
+
+	fs := flag.NewFlagSet("vpnck generate", flag.ExitOnError)
+	var (
+		name      = fs.String("name", "vpnck", "Name of the rule group, PrometheusRule resource or dashboard")
+		format    = fs.String("format", "rules", "Format of the rules, either rules for a Prometheus rule file or prometheusrule for a Prometheus Operator PrometheusRule resource")
+		downFor   = fs.Duration("tunnel-down-for", 5*time.Minute, "How long a tunnel has to be down, or not handshaking, before it's alerted on")
+		handshake = fs.Duration("handshake-age-threshold", 10*time.Minute, "How long since a tunnel's latest handshake before it's alerted on, for sources that report handshakes")
+		severity  = fs.String("severity", "warning", "Severity label given to alerts")
+		naming    = namingFlags(fs)
+	)
+
+	fs.Usage = usageFor(fs, os.Args[0]+" generate rules|dashboard [flags]")
+
+	if len(args) == 0 {
+		fs.Usage()
+		return 2
+	}
+	what := args[0]
+	_ = fs.Parse(args[1:])
+
+	metricNaming, err := naming()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	m, err := generate.Discover(metricNaming)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	opts := generate.Options{Name: *name, TunnelDownFor: *downFor, HandshakeAge: *handshake, Severity: *severity}
+
+	var write func(w io.Writer, m *generate.Metrics, opts generate.Options) error
+	switch {
+	case what == "rules" && *format == "rules":
+		write = generate.WriteRules
+	case what == "rules" && *format == "prometheusrule":
+		write = generate.WritePrometheusRule
+	case what == "rules":
+		_, _ = fmt.Fprintf(os.Stderr, "Unknown rules format %q, expected rules or prometheusrule\n", *format)
+		return 2
+	case what == "dashboard":
+		write = generate.WriteDashboard
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	if err := write(os.Stdout, m, opts); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
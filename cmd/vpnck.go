@@ -1,20 +1,43 @@
 package main
 
 import (
+	"crypto/rand"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/clearchannelinternational/vpncheck/pkg/acknowledge"
+	"github.com/clearchannelinternational/vpncheck/pkg/auth"
+	"github.com/clearchannelinternational/vpncheck/pkg/config"
+	"github.com/clearchannelinternational/vpncheck/pkg/health"
 	vpnhttp "github.com/clearchannelinternational/vpncheck/pkg/http"
+	"github.com/clearchannelinternational/vpncheck/pkg/maintenance"
 	"github.com/clearchannelinternational/vpncheck/pkg/metrics"
+	"github.com/clearchannelinternational/vpncheck/pkg/otlp"
+	"github.com/clearchannelinternational/vpncheck/pkg/probe"
+	"github.com/clearchannelinternational/vpncheck/pkg/remotewrite"
 	"github.com/clearchannelinternational/vpncheck/pkg/runtime"
+	"github.com/clearchannelinternational/vpncheck/pkg/sla"
+	"github.com/clearchannelinternational/vpncheck/pkg/source"
+	"github.com/clearchannelinternational/vpncheck/pkg/source/awsvpn"
+	"github.com/clearchannelinternational/vpncheck/pkg/source/strongswan"
+	"github.com/clearchannelinternational/vpncheck/pkg/source/wireguard"
 	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/tracing"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/clearchannelinternational/vpncheck/static"
+	"github.com/clearchannelinternational/vpncheck/templates"
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"text/tabwriter"
 	"time"
 
@@ -25,19 +48,139 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "generate" {
+		os.Exit(generateMain(os.Args[2:]))
+	}
+
 	// Define our flags.
 	fs := flag.NewFlagSet("vpnck", flag.ExitOnError)
 	var (
-		debugAddr = fs.String("debug-addr", ":8081", "Debug and metrics listen address")
-		httpAddr  = fs.String("http-addr", ":8080", "HTTP listen address")
-		insecure  = fs.Bool("insecure", false, "Ignore invalid server TLS certificates")
-		debug     = fs.Bool("debug", false, "More verbose logging")
-		interval  = fs.Duration("interval", 5*time.Minute, "Time between polling the VPN status")
+		debugAddr  = fs.String("debug-addr", ":8081", "Debug and metrics listen address")
+		httpAddr   = fs.String("http-addr", ":8080", "HTTP listen address")
+		insecure   = fs.Bool("insecure", false, "Ignore invalid server TLS certificates")
+		debug      = fs.Bool("debug", false, "More verbose logging")
+		tmplDir    = fs.String("templates-dir", "", "Directory to read the templates of the HTML pages from instead of those built in, for customising or working on them")
+		tmplReload = fs.Bool("templates-reload", false, "Parse the templates again whenever they change, for working on them with -templates-dir")
+		configFile = fs.String("config", "", "Path of a JSON file with further configuration, such as the targets to probe through each connection")
+		interval   = fs.Duration("interval", 5*time.Minute, "Time between polling the VPN status")
+		gwInterval = fs.Duration("gateway-interval", time.Hour, "Time between refreshing customer and VPN gateway details")
+		awsEnabled = fs.Bool("aws", true, "Monitor AWS site to site VPN connections")
+		swanSocket = fs.String("strongswan-socket", "", "Path of the strongSwan VICI socket to monitor connections from, e.g. /var/run/charon.vici")
+		wgDump     = fs.String("wireguard-dump", "", "Path of a file holding the output of `wg show all dump` to monitor WireGuard interfaces from")
+		wgCommand  = fs.String("wireguard-command", "", "Command that prints `wg show all dump` output to monitor WireGuard interfaces from, e.g. \"wg show all dump\"")
+		wgTimeout  = fs.Duration("wireguard-handshake-timeout", 5*time.Minute, "How long since a WireGuard peer's latest handshake before it's down")
+		cwEnabled  = fs.Bool("cloudwatch", false, "Fetch tunnel state and traffic metrics from CloudWatch")
+		cwInterval = fs.Duration("cloudwatch-interval", 5*time.Minute, "Time between fetching CloudWatch metrics")
+		idleAfter  = fs.Duration("idle-threshold", 15*time.Minute, "How long a tunnel can be up with no traffic before it's suspicious")
+		slaWindows = fs.String("availability-windows", "24h,7d,30d,month", "Comma separated windows to calculate availability over, as hours, days or month for the calendar month so far")
+		slaHistory = fs.String("availability-history", "", "Path of a file to keep the history availability is calculated from in, so it survives restarts")
+		prInterval = fs.Duration("probe-interval", time.Minute, "Time between probing the targets configured for each connection")
+		prTimeout  = fs.Duration("probe-timeout", 5*time.Second, "How long a probe can take before it fails")
+		mtFile     = fs.String("maintenance-file", "", "Path of a file to keep maintenance windows created through the API in, so they survive restarts")
+		apiToken   = fs.String("api-token", "", "Bearer token needed to change maintenance windows and acknowledgements through the API, which can't be changed without one")
+		otEndpoint = fs.String("otlp-endpoint", "", "OTLP/HTTP endpoint of an OpenTelemetry collector to export metrics and traces to as well, e.g. http://localhost:4318")
+		otInterval = fs.Duration("otlp-interval", time.Minute, "Time between exports to the OpenTelemetry collector")
+		otHeaders  = fs.String("otlp-headers", "", "Comma separated key=value headers to send with exports to the OpenTelemetry collector, e.g. for authentication")
+		rwURL      = fs.String("remote-write-url", "", "Prometheus remote write endpoint to push metrics to, for when vpnck can't be scraped, e.g. http://prometheus:9090/api/v1/write")
+		rwInterval = fs.Duration("remote-write-interval", time.Minute, "Time between pushing metrics to the remote write endpoint")
+		rwUsername = fs.String("remote-write-username", "", "Username for basic auth with the remote write endpoint")
+		rwPassword = fs.String("remote-write-password", "", "Password for basic auth with the remote write endpoint")
+		rwToken    = fs.String("remote-write-bearer-token", "", "Bearer token for the remote write endpoint, instead of basic auth")
+		rwQueue    = fs.Int("remote-write-queue", 60, "How many pushes to keep while the remote write endpoint is unavailable, after which the oldest are dropped")
+		pgURL      = fs.String("pushgateway-url", "", "Prometheus Pushgateway to push metrics to every poll, e.g. http://pushgateway:9091")
+		pgJob      = fs.String("pushgateway-job", "vpnck", "Job to push metrics to the Pushgateway as")
+		pgGrouping = fs.String("pushgateway-grouping", "", "Comma separated name=value labels to group metrics pushed to the Pushgateway by as well as the job, e.g. site=london")
+		statsdAddr = fs.String("statsd-addr", "", "host:port of a StatsD server, such as the Datadog agent, to send metrics to as DogStatsD gauges every poll, e.g. localhost:8125")
+		groupBy    = fs.String("group-by", "", "Tag to group connections by on the main page, with a roll-up of their health published as metrics, e.g. Site")
+		drain      = fs.Duration("drain-timeout", 15*time.Second, "How long to wait for HTTP requests in flight to complete when shutting down, before closing their connections")
+		tlsMin     = fs.String("tls-min-version", "1.2", "Minimum TLS version the listeners accept when serving TLS, one of 1.0, 1.1, 1.2 or 1.3")
+		tlsCiphers = fs.String("tls-cipher-suites", "", "Comma separated cipher suites the listeners allow up to TLS 1.2 when serving TLS, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, instead of Go's defaults")
+		httpTLS    = tlsFlags(fs, "http", "main HTTP listener", tlsMin, tlsCiphers)
+		debugTLS   = tlsFlags(fs, "debug", "debug and metrics listener", tlsMin, tlsCiphers)
+		authn      = authFlags(fs)
+		naming     = namingFlags(fs)
 	)
 
 	fs.Usage = usageFor(fs, os.Args[0]+" [flags]")
 	fs.Parse(os.Args[1:])
 
+	if *wgDump != "" && *wgCommand != "" {
+		_, _ = fmt.Fprintln(os.Stderr, "Only one of -wireguard-dump and -wireguard-command can be set")
+		os.Exit(2)
+	}
+
+	metricNaming, err := naming()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	grouping, err := metrics.ParseLabels(*pgGrouping)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	exportHeaders, err := otlp.ParseHeaders(*otHeaders)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	assets, err := vpnhttp.NewAssets(static.FS)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	pageTemplates, err := vpnhttp.NewTemplates(templates.FS, assets, false)
+	if *tmplDir != "" {
+		pageTemplates, err = vpnhttp.NewTemplates(os.DirFS(*tmplDir), assets, *tmplReload)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err := cfg.Roles.Validate(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	targets, err := probe.ParseTargets(cfg.Probes)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	windows, err := sla.ParseWindows(*slaWindows)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	history := sla.NewHistory()
+	if *slaHistory != "" {
+		if history, err = sla.LoadHistory(*slaHistory); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Unable to load availability history: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	store, err := maintenance.NewStore(cfg.Maintenance, *mtFile, state.NewUTCClock())
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Unable to load maintenance windows: %v\n", err)
+		os.Exit(1)
+	}
+
+	acknowledgements := acknowledge.NewStore(state.NewUTCClock())
+
 	if *insecure {
 		disableTlsVerify()
 	}
@@ -45,7 +188,15 @@ func main() {
 		SharedConfigState: session.SharedConfigEnable,
 	}))
 
+	// Poll cycles are only traced when they are exported. A nil tracer records nothing.
+	var tracer *tracing.Tracer
+	if *otEndpoint != "" {
+		tracer = tracing.NewTracer()
+		tracer.InstrumentAWS(&sess.Handlers)
+	}
+
 	svc := ec2.New(sess)
+	cw := cloudwatch.New(sess)
 
 	// Create a single logger, which we'll use and give to other components.
 	var logger log.Logger
@@ -62,10 +213,33 @@ func main() {
 
 	}
 
+	authenticator, err := authn(logger)
+	if err != nil {
+		_ = logger.Log("during", "auth", "err", err)
+		os.Exit(1)
+	}
+
+	if len(cfg.Roles) > 0 && authenticator == nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Roles need users to authenticate, with -auth-htpasswd or -oidc-issuer")
+		os.Exit(2)
+	}
+
 	var currentState state.State
-	var handlers = &vpnhttp.StateHandlers{State: &currentState}
 
-	http.DefaultServeMux.Handle("/metrics", promhttp.Handler())
+	// Follows polls through the pipeline, for the liveness and readiness probes
+	checker := health.NewChecker(logger, *interval, state.NewUTCClock())
+
+	var handlers = &vpnhttp.StateHandlers{State: &currentState, Maintenance: store, Acknowledgements: acknowledgements, APIToken: *apiToken, Templates: pageTemplates, Assets: assets, GroupBy: *groupBy, Health: checker, Auth: authenticator, Roles: cfg.Roles}
+
+	// Every metric vpnck exports is registered through this, so is named consistently. They are kept apart from the
+	// Go and process metrics in the default registry, so only they are exported through OTLP.
+	vpnRegistry := prometheus.NewRegistry()
+	registerer := metricNaming.Registerer(vpnRegistry)
+
+	http.DefaultServeMux.Handle("/metrics", promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, vpnRegistry}, promhttp.HandlerOpts{}),
+	))
 
 	// Now we're to the part of the func main where we want to start actually
 	// running things, like servers bound to listeners to receive Connections.
@@ -84,31 +258,35 @@ func main() {
 		// The debug listener mounts the http.DefaultServeMux, and serves up
 		// stuff like the Prometheus metrics route, the Go debug and profiling
 		// routes, and so on.
+		debugServer := vpnhttp.NewServer(http.DefaultServeMux)
+		if options := debugTLS(); options.Enabled() {
+			if debugServer.TLSConfig, err = vpnhttp.NewTLSConfig(log.With(logger, "transport", "debug/HTTP"), options); err != nil {
+				_ = logger.Log("transport", "debug/HTTP", "during", "TLS", "err", err)
+				os.Exit(1)
+			}
+		}
 		debugListener, err := net.Listen("tcp", *debugAddr)
 		if err != nil {
 			_ = logger.Log("transport", "debug/HTTP", "during", "Listen", "err", err)
 			os.Exit(1)
 		}
-		g.Add(func() error {
-			_ = logger.Log("transport", "debug/HTTP", "addr", *debugAddr)
-			return http.Serve(debugListener, http.DefaultServeMux)
-		}, func(error) {
-			_ = debugListener.Close()
-		})
+		vpnhttp.AddServerStage(&g, log.With(logger, "transport", "debug/HTTP"), debugServer, debugListener, *drain)
 	}
 	{
 		// The HTTP listener mounts the Go kit HTTP handler we created.
+		httpServer := vpnhttp.NewServer(handlers.Handler())
+		if options := httpTLS(); options.Enabled() {
+			if httpServer.TLSConfig, err = vpnhttp.NewTLSConfig(log.With(logger, "transport", "HTTP"), options); err != nil {
+				_ = logger.Log("transport", "HTTP", "during", "TLS", "err", err)
+				os.Exit(1)
+			}
+		}
 		httpListener, err := net.Listen("tcp", *httpAddr)
 		if err != nil {
 			_ = logger.Log("transport", "HTTP", "during", "Listen", "err", err)
 			os.Exit(1)
 		}
-		g.Add(func() error {
-			_ = logger.Log("transport", "HTTP", "addr", *httpAddr)
-			return http.Serve(httpListener, handlers.Handler())
-		}, func(error) {
-			_ = httpListener.Close()
-		})
+		vpnhttp.AddServerStage(&g, log.With(logger, "transport", "HTTP"), httpServer, httpListener, *drain)
 	}
 
 	// Assemble the stages for the pipeline that polls for updates, publishes metrics and updates
@@ -116,20 +294,120 @@ func main() {
 	// This is a SEDA (https://stackoverflow.com/questions/3570610/what-is-seda-staged-event-driven-architecture) style approach
 	{
 
-		// Add the stage that exposes the state for HTML pages to render. This stage is a sink
-		status := make(chan []*ec2.VpnConnection)
-		state.AddMonitorStage(&g, logger, status, state.NewUTCClock(), &currentState)
+		// Add the stage that exposes the state for HTML pages to render, and notes each poll has made it through the
+		// pipeline for the readiness probe. This stage is a sink
+		status := make(chan state.Poll)
+		state.AddMonitorStage(&g, logger, status, state.NewUTCClock(), &currentState, checker)
+		checker.AddAsStage(&g)
 
 		// Add the stage that exposes the metrics for Prometheus to collect. This stage is a sink.
-		collector := metrics.NewVpnStatusCollector(prometheus.DefaultRegisterer, logger)
+		collector := metrics.NewVpnStatusCollector(registerer, logger, state.NewUTCClock())
 		collector.AddAsStage(&g)
 
 		// Add the stage that updates the metrics every time new VPN telemetry data is received, and sends to next stage
-		vpnUpdates := make(chan []*ec2.VpnConnection)
+		vpnUpdates := make(chan state.Poll)
 		metrics.AddUpdaterStage(&g, logger, collector, vpnUpdates, status)
 
-		// Add the stage that periodically fetches VPN telemetry data and sends to the next stage. This stage is a generator.
-		state.AddPollerStage(&g, logger, vpnUpdates, svc, interval)
+		// Optionally add the stage that publishes the roll-ups of groups of connections, and sends to next stage
+		grouped := vpnUpdates
+		if *groupBy != "" {
+			grouped = make(chan state.Poll)
+			metrics.AddUpdaterStage(&g, log.With(logger, "updater", "groups"), metrics.NewGroupUpdater(registerer, logger, *groupBy), grouped, vpnUpdates)
+		}
+
+		// Optionally add the stages that send the metrics to a Pushgateway and StatsD as well, and send to next stage
+		sunk := grouped
+		if *statsdAddr != "" {
+			statsd, err := metrics.NewStatsdUpdater(logger, *statsdAddr, metricNaming, state.NewUTCClock())
+			if err != nil {
+				_ = logger.Log("during", "StatsD setup", "err", err)
+				os.Exit(2)
+			}
+			in := make(chan state.Poll)
+			metrics.AddUpdaterStage(&g, log.With(logger, "sink", "statsd"), statsd, in, sunk)
+			sunk = in
+		}
+		if *pgURL != "" {
+			pushgateway := metrics.NewPushgatewayUpdater(logger, *pgURL, *pgJob, grouping, metricNaming, state.NewUTCClock())
+			in := make(chan state.Poll)
+			metrics.AddUpdaterStage(&g, log.With(logger, "sink", "pushgateway"), pushgateway, in, sunk)
+			sunk = in
+		}
+
+		// Add the stage that records the history of the connections and works out their availability, and sends to next stage
+		tracked := make(chan state.Poll)
+		tracker := sla.NewTracker(registerer, logger, history, *slaHistory, windows, store.Exclusions, *interval, state.NewUTCClock())
+		sla.AddTrackerStage(&g, tracker, tracked, sunk)
+
+		// Add the stage that adds any acknowledgements of problems to the connections, and sends to next stage
+		acknowledged := make(chan state.Poll)
+		acknowledge.AddAnnotatorStage(&g, acknowledge.NewAnnotator(registerer, logger, acknowledgements), acknowledged, tracked)
+
+		// Add the stage that adds any maintenance going on to the connections, and sends to next stage
+		annotated := make(chan state.Poll)
+		annotator := maintenance.NewAnnotator(registerer, logger, store)
+		maintenance.AddAnnotatorStage(&g, annotator, annotated, acknowledged)
+
+		// Optionally add the stage that fetches CloudWatch metrics for the tunnels, and sends to next stage
+		polled := annotated
+		if *cwEnabled {
+			polled = make(chan state.Poll)
+			poller := metrics.NewCloudWatchPoller(registerer, logger, cw, state.NewUTCClock(), *idleAfter)
+			metrics.AddCloudWatchStage(&g, poller, polled, annotated, cwInterval)
+		}
+
+		// Optionally add the stage that probes targets through the connections, and sends to next stage
+		probed := polled
+		if len(targets) > 0 {
+			polled = make(chan state.Poll)
+			prober := probe.NewProber(registerer, logger, targets, *prTimeout, state.NewUTCClock())
+			probe.AddProberStage(&g, prober, polled, probed, prInterval)
+		}
+
+		// Optionally add the stage that adds the details of the gateways connections from AWS use, and sends to next stage
+		enriched := polled
+		if *awsEnabled {
+			polled = make(chan state.Poll)
+			awsvpn.AddGatewayStage(&g, awsvpn.NewGatewayEnricher(logger, svc), polled, enriched, gwInterval)
+		}
+
+		// Add the stage that periodically fetches VPN telemetry data from the sources and sends to the next stage. This stage is a generator.
+		var sources []vpn.Source
+		if *awsEnabled {
+			// Connections are labelled with the account so they can be told apart, but can do without it
+			account, err := awsvpn.AccountID(sts.New(sess))
+			if err != nil {
+				_ = level.Warn(logger).Log("msg", "Unable to look up the AWS account", "err", err)
+			}
+			sources = append(sources, awsvpn.NewSource(logger, svc, account, aws.StringValue(sess.Config.Region)))
+		}
+		if *swanSocket != "" {
+			sources = append(sources, strongswan.NewSource(*swanSocket, 10*time.Second, state.NewUTCClock()))
+		}
+		if *wgDump != "" {
+			sources = append(sources, wireguard.NewSource(wireguard.FileDumper(*wgDump), *wgTimeout, state.NewUTCClock()))
+		}
+		if *wgCommand != "" {
+			sources = append(sources, wireguard.NewSource(wireguard.CommandDumper(*wgCommand), *wgTimeout, state.NewUTCClock()))
+		}
+		state.AddPollerStage(&g, logger, polled, checker.Source(source.Combine(logger, sources...)), interval, tracer)
+	}
+
+	// Optionally push the metrics to a remote write endpoint as well
+	if *rwURL != "" {
+		auth := remotewrite.Auth{Username: *rwUsername, Password: *rwPassword, BearerToken: *rwToken}
+		writer, err := remotewrite.NewWriter(registerer, logger, *rwURL, auth, vpnRegistry, *rwQueue, state.NewUTCClock())
+		if err != nil {
+			_ = logger.Log("during", "remote write setup", "err", err)
+			os.Exit(2)
+		}
+		remotewrite.AddWriterStage(&g, writer, rwInterval)
+	}
+
+	// Optionally export the metrics and traces to an OpenTelemetry collector as well
+	if *otEndpoint != "" {
+		exporter := otlp.NewExporter(logger, *otEndpoint, exportHeaders, vpnRegistry, tracer, state.NewUTCClock())
+		otlp.AddExporterStage(&g, exporter, otInterval)
 	}
 
 	// Finally add a shutdown hook to the run group
@@ -157,3 +435,91 @@ func usageFor(fs *flag.FlagSet, short string) func() {
 		_, _ = fmt.Fprintf(os.Stderr, "\n")
 	}
 }
+
+// namingFlags defines the flags for naming metrics, returning a function that gives the naming once they are parsed
+func namingFlags(fs *flag.FlagSet) func() (metrics.Naming, error) {
+
+	namespace := fs.String("metrics-namespace", metrics.DefaultNaming.Namespace, "Namespace the names of metrics start with, which can be empty")
+	subsystem := fs.String("metrics-subsystem", metrics.DefaultNaming.Subsystem, "Subsystem the names of metrics start with after the namespace, which can be empty")
+	constLabels := fs.String("metrics-labels", "", "Comma separated name=value labels to add to every metric, e.g. cluster=eu-1,environment=production")
+
+	return func() (metrics.Naming, error) {
+		return metrics.NewNaming(*namespace, *subsystem, *constLabels)
+	}
+}
+
+// tlsFlags defines the flags for serving TLS on the listener, named with the prefix, returning a func for the options
+// once they are parsed
+func tlsFlags(fs *flag.FlagSet, prefix string, listener string, minVersion *string, cipherSuites *string) func() vpnhttp.TLSOptions {
+
+	cert := fs.String(prefix+"-tls-cert", "", "Path of a PEM certificate to serve the "+listener+" over TLS with, loaded again whenever it changes")
+	key := fs.String(prefix+"-tls-key", "", "Path of the PEM private key of -"+prefix+"-tls-cert")
+	clientCA := fs.String(prefix+"-tls-client-ca", "", "Path of a PEM bundle of CAs the "+listener+" requires client certificates to be signed by, for mutual TLS")
+
+	return func() vpnhttp.TLSOptions {
+		return vpnhttp.TLSOptions{CertFile: *cert, KeyFile: *key, ClientCAFile: *clientCA, MinVersion: *minVersion, CipherSuites: commaSeparated(*cipherSuites)}
+	}
+}
+
+// authFlags defines the flags for authenticating users, returning a func for the authenticator once they are parsed,
+// which is nil when users don't need to authenticate
+func authFlags(fs *flag.FlagSet) func(logger log.Logger) (auth.Authenticator, error) {
+
+	htpasswd := fs.String("auth-htpasswd", "", "Path of an htpasswd file of users to authenticate with basic auth, with passwords hashed by htpasswd -m or -s")
+	htgroups := fs.String("auth-htgroups", "", "Path of a file putting the users of -auth-htpasswd in groups, with a \"group: user user...\" line for each group")
+	users := fs.String("auth-allowed-users", "", "Comma separated names or emails of the users allowed in once authenticated, as well as those in -auth-allowed-groups. Everyone authenticated is allowed in when neither is set")
+	groups := fs.String("auth-allowed-groups", "", "Comma separated groups whose users are allowed in once authenticated")
+	issuer := fs.String("oidc-issuer", "", "URL of an OpenID Connect provider for users to log in through, e.g. https://accounts.google.com")
+	clientID := fs.String("oidc-client-id", "", "Client ID vpnck is registered with the OpenID Connect provider as")
+	clientSecret := fs.String("oidc-client-secret", "", "Client secret vpnck is registered with the OpenID Connect provider with")
+	redirectURL := fs.String("oidc-redirect-url", "", "URL the OpenID Connect provider sends users back to after logging in, which must end in the callback path registered with it, e.g. https://vpnck.example.com/auth/callback")
+	scopes := fs.String("oidc-scopes", "email,profile", "Comma separated scopes to ask the OpenID Connect provider for as well as openid, e.g. to include the groups of users in ID tokens")
+	groupsClaim := fs.String("oidc-groups-claim", "groups", "Claim of ID tokens with the groups users are in")
+	sessionKey := fs.String("oidc-session-key", "", "Key of at least 32 characters to sign session cookies with, so sessions survive restarts and are shared by replicas. A random key is used when empty")
+	sessionDuration := fs.Duration("oidc-session-duration", 12*time.Hour, "How long users stay logged in after logging in through the OpenID Connect provider")
+
+	return func(logger log.Logger) (auth.Authenticator, error) {
+
+		policy := auth.Policy{Users: commaSeparated(*users), Groups: commaSeparated(*groups)}
+
+		switch {
+		case *htpasswd != "" && *issuer != "":
+			return nil, errors.New("only one of -auth-htpasswd and -oidc-issuer can be set")
+
+		case *htpasswd != "":
+			return auth.NewBasic(*htpasswd, *htgroups, policy)
+
+		case *issuer != "":
+			key := []byte(*sessionKey)
+			if len(key) == 0 {
+				key = make([]byte, 32)
+				if _, err := rand.Read(key); err != nil {
+					return nil, err
+				}
+			}
+			return auth.NewOIDC(logger, auth.OIDCOptions{
+				Issuer:          *issuer,
+				ClientID:        *clientID,
+				ClientSecret:    *clientSecret,
+				RedirectURL:     *redirectURL,
+				Scopes:          commaSeparated(*scopes),
+				GroupsClaim:     *groupsClaim,
+				SessionKey:      key,
+				SessionDuration: *sessionDuration,
+			}, policy, state.NewUTCClock(), &http.Client{Timeout: 30 * time.Second})
+		}
+
+		return nil, nil
+	}
+}
+
+// commaSeparated returns the values of a comma separated list, without any blank ones
+func commaSeparated(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
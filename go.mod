@@ -1,6 +1,6 @@
 module github.com/clearchannelinternational/vpncheck
 
-go 1.14
+go 1.16
 
 require (
 	github.com/Pallinder/go-randomdata v1.1.0
@@ -8,12 +8,15 @@ require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/go-kit/kit v0.10.0
 	github.com/go-stack/stack v1.8.0 // indirect
-	github.com/golang/protobuf v1.3.5 // indirect
+	github.com/golang/protobuf v1.3.5
+	github.com/golang/snappy v0.0.1
 	github.com/google/go-cmp v0.4.0
 	github.com/jmespath/go-jmespath v0.3.0 // indirect
 	github.com/oklog/oklog v0.3.2
 	github.com/oklog/run v1.1.0
 	github.com/prometheus/client_golang v1.5.1
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.9.1
 	github.com/prometheus/procfs v0.0.10 // indirect
 	golang.org/x/sys v0.0.0-20200317113312-5766fd39f98d // indirect
 )
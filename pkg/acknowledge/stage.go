@@ -0,0 +1,140 @@
+package acknowledge
+
+import (
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/oklog/pkg/group"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// annotator adds acknowledgements to the connections with problems, forgetting them once the problems are over, and
+// publishes them as Prometheus metrics so alerts can be routed or silenced
+type annotator struct {
+	store                *Store
+	connectionGaugeVec   *prometheus.GaugeVec
+	publishedConnections map[string]prometheus.Labels
+	logger               log.Logger
+}
+
+// NewAnnotator returns an instance ready to use, which takes acknowledgements from the store
+func NewAnnotator(registerer prometheus.Registerer, logger log.Logger, store *Store) *annotator {
+
+	a := annotator{
+		store: store,
+		connectionGaugeVec: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "connection_acknowledged",
+				Help: "If a problem with the VPN connection has been acknowledged, partitioned by VPN Connection ID.",
+			},
+			[]string{"vpn_connection_id"},
+		),
+		publishedConnections: make(map[string]prometheus.Labels),
+		logger:               log.With(logger, "actor", "acknowledgements"),
+	}
+
+	registerer.MustRegister(a.connectionGaugeVec)
+
+	return &a
+}
+
+// AddAnnotatorStage adds a stage to the run group that sends the connections it receives to the next stage with any
+// acknowledgements. They're annotated and sent again as a repeat of their poll whenever acknowledgements are added or
+// deleted, so they're shown without waiting for the next poll.
+func AddAnnotatorStage(group *group.Group, annotator *annotator, in <-chan state.Poll, out chan<- state.Poll) {
+
+	a := annotatorActor(annotator, in, out)
+	group.Add(a.Execute, a.Interrupt)
+
+}
+
+// annotatorActor adds acknowledgements to the connections received before sending them down the out channel,
+// remembering them to annotate and send again as a repeat when acknowledgements change
+func annotatorActor(annotator *annotator, in <-chan state.Poll, out chan<- state.Poll) actor.Actor {
+
+	cancel := make(chan struct{})
+
+	return actor.NewActor(
+		func() error {
+
+			var connections []*vpn.Connection
+
+			for {
+				var poll state.Poll
+
+				select {
+
+				case poll = <-in:
+					connections = poll.Connections
+
+				case <-annotator.store.Changed():
+					if connections == nil {
+						continue
+					}
+					poll = state.Poll{Connections: connections, Repeat: true}
+
+				case <-cancel:
+					_ = level.Info(annotator.logger).Log("cancelled", "Asked to terminate")
+					return nil
+				}
+
+				select {
+				case out <- poll.With(annotator.Annotate(poll.Connections)):
+				case <-cancel:
+					_ = level.Info(annotator.logger).Log("cancelled", "Asked to terminate")
+					return nil
+				}
+			}
+		},
+		func(err error) {
+			_ = level.Info(annotator.logger).Log("interrupted", fmt.Sprintf("interrupted with %v", err))
+			close(cancel)
+		},
+	)
+
+}
+
+// Annotate returns copies of the connections with their acknowledgements, and publishes them. Acknowledgements of
+// connections that are healthy again are forgotten, as the problem they acknowledged is over.
+func (a *annotator) Annotate(connections []*vpn.Connection) []*vpn.Connection {
+
+	current := make(map[string]prometheus.Labels)
+
+	annotated := make([]*vpn.Connection, len(connections))
+
+	for i, conn := range connections {
+
+		c := *conn
+
+		if acknowledgement := a.store.For(conn.ID); acknowledgement != nil {
+			if conn.Healthy() {
+				a.store.resolve(acknowledgement)
+				_ = level.Info(a.logger).Log("msg", "Problem acknowledged is over", "connection", conn.ID, "by", acknowledgement.By)
+			} else {
+				c.Acknowledgement = acknowledgement.toVpn()
+			}
+		}
+
+		labels := prometheus.Labels{"vpn_connection_id": conn.ID}
+		value := 0.0
+		if c.Acknowledgement != nil {
+			value = 1
+		}
+		a.connectionGaugeVec.With(labels).Set(value)
+		current[fmt.Sprintf("%v", labels)] = labels
+
+		annotated[i] = &c
+	}
+
+	for id, labels := range a.publishedConnections {
+		if _, ok := current[id]; !ok {
+			a.connectionGaugeVec.Delete(labels)
+		}
+	}
+	a.publishedConnections = current
+
+	return annotated
+}
@@ -0,0 +1,148 @@
+package acknowledge
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/metrics"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+const acknowledgedMetadata = `
+	# HELP cc_vpn_connection_acknowledged If a problem with the VPN connection has been acknowledged, partitioned by VPN Connection ID.
+	# TYPE cc_vpn_connection_acknowledged gauge
+`
+
+func TestAnnotate(t *testing.T) {
+
+	// Given an acknowledged problem with a connection
+	registry := prometheus.NewRegistry()
+	store := NewStore(&movableClock{now: at(0)})
+	store.Add(&Acknowledgement{ConnectionID: "vpn-1", By: "alice", Comment: "Router rebooting"})
+	underTest := NewAnnotator(metrics.DefaultNaming.Registerer(registry), log.NewNopLogger(), store)
+	sent := connections(vpn.StatusDown)
+
+	// When the connections are annotated
+	annotated := underTest.Annotate(sent)
+
+	// Then the acknowledgement should be added to a copy of the connection
+	if annotated[0] == sent[0] || sent[0].Acknowledgement != nil {
+		t.Errorf("Connections should have been copied, not changed")
+	}
+	if a := annotated[0].Acknowledgement; a == nil || a.By != "alice" || a.Comment != "Router rebooting" || !a.Time.Equal(at(0)) {
+		t.Errorf("Connection acknowledgement incorrect, got %+v", a)
+	}
+	if a := annotated[1].Acknowledgement; a != nil {
+		t.Errorf("Expected no acknowledgement of vpn-2, got %+v", a)
+	}
+
+	// and published
+	expected := acknowledgedMetadata + `
+	cc_vpn_connection_acknowledged{vpn_connection_id="vpn-1"} 1
+	cc_vpn_connection_acknowledged{vpn_connection_id="vpn-2"} 0
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+
+	// and forgotten once the connection is healthy again
+	if annotated = underTest.Annotate(connections(vpn.StatusUp)); annotated[0].Acknowledgement != nil || store.For("vpn-1") != nil {
+		t.Errorf("Expected the acknowledgement to be forgotten, got %+v", annotated[0].Acknowledgement)
+	}
+
+	expected = acknowledgedMetadata + `
+	cc_vpn_connection_acknowledged{vpn_connection_id="vpn-1"} 0
+	cc_vpn_connection_acknowledged{vpn_connection_id="vpn-2"} 0
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+
+	// and removed when the connections go away
+	underTest.Annotate([]*vpn.Connection{})
+	if err := testutil.GatherAndCompare(registry, strings.NewReader("")); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
+
+func TestAcknowledgedBetweenPolls(t *testing.T) {
+
+	// Given connections that have been polled
+	in := make(chan state.Poll)
+	out := make(chan state.Poll)
+
+	store := NewStore(&movableClock{now: at(0)})
+	underTest := annotatorActor(NewAnnotator(prometheus.NewRegistry(), log.NewNopLogger(), store), in, out)
+	defer underTest.Interrupt(nil)
+	go func(a actor.Actor) {
+		_ = a.Execute()
+	}(underTest)
+
+	go func() { in <- state.Poll{Connections: connections(vpn.StatusDown)} }()
+	if received := <-out; received.Repeat || len(received.Connections) != 2 {
+		t.Errorf("Expected the poll to be passed on, got %+v", received)
+	}
+
+	// When a problem is acknowledged through the API
+	store.Add(&Acknowledgement{ConnectionID: "vpn-1", By: "alice"})
+
+	// Then the connections are sent again as a repeat, with the acknowledgement
+	select {
+	case received := <-out:
+		if !received.Repeat || received.Connections[0].Acknowledgement == nil {
+			t.Errorf("Expected a repeat with the acknowledgement, got %+v", received)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("Timed out waiting for the connections to be sent again when the problem was acknowledged")
+	}
+}
+
+var interruptests = []struct {
+	name  string
+	actor actor.Actor
+}{
+	{name: "Annotator", actor: annotatorActor(NewAnnotator(prometheus.NewRegistry(), log.NewNopLogger(), NewStore(&movableClock{})), make(chan state.Poll), make(chan state.Poll))},
+}
+
+// Tests that the actors honour the contract as per https://github.com/oklog/run#run.
+// When the interrupt function is called the actor should return
+func TestInterrupt(t *testing.T) {
+
+	for _, tt := range interruptests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			underTest := tt.actor
+
+			// Run the actor.
+			errors := make(chan error)
+			go func(a actor.Actor) {
+				errors <- a.Execute()
+			}(underTest)
+
+			// Signal for the actor to stop
+			underTest.Interrupt(nil)
+
+			select {
+			case <-errors:
+				return
+			case <-time.After(1 * time.Second):
+			}
+
+			t.Error("actor didn't shut down in response to interrupt")
+
+		})
+	}
+}
+
+// connections returns two connections, the first with a tunnel of the supplied status and the second up
+func connections(status vpn.Status) []*vpn.Connection {
+	return []*vpn.Connection{
+		{ID: "vpn-1", Tunnels: []*vpn.Tunnel{{ID: "1.1.1.1", OutsideIP: "1.1.1.1", Status: status}}},
+		{ID: "vpn-2", Tunnels: []*vpn.Tunnel{{ID: "2.2.2.2", OutsideIP: "2.2.2.2", Status: vpn.StatusUp}}},
+	}
+}
@@ -0,0 +1,117 @@
+package acknowledge
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Acknowledgement is someone noting they're dealing with a problem with a connection, so others needn't
+type Acknowledgement struct {
+	ConnectionID string    `json:"connection_id"`
+	By           string    `json:"by"`
+	Comment      string    `json:"comment,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+func (a *Acknowledgement) toVpn() *vpn.Acknowledgement {
+	return &vpn.Acknowledgement{By: a.By, Comment: a.Comment, Time: a.Time}
+}
+
+// Store holds the acknowledgements of problems with connections, at most one for each connection. They're only kept
+// in memory, as the problems they acknowledge are usually over by the time vpnck restarts. It's safe for concurrent
+// use.
+type Store struct {
+	mutex        sync.RWMutex
+	acknowledged map[string]*Acknowledgement
+	clock        state.Clock
+	changed      chan struct{}
+}
+
+// NewStore returns an empty store
+func NewStore(clock state.Clock) *Store {
+	return &Store{acknowledged: make(map[string]*Acknowledgement), clock: clock, changed: make(chan struct{}, 1)}
+}
+
+// Acknowledgements returns all the acknowledgements, ordered by connection ID
+func (s *Store) Acknowledgements() []*Acknowledgement {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	acknowledgements := make([]*Acknowledgement, 0, len(s.acknowledged))
+	for _, a := range s.acknowledged {
+		acknowledgements = append(acknowledgements, a)
+	}
+	sort.Slice(acknowledgements, func(i, j int) bool {
+		return acknowledgements[i].ConnectionID < acknowledgements[j].ConnectionID
+	})
+
+	return acknowledgements
+}
+
+// Add adds the acknowledgement as of now, replacing any the connection already had
+func (s *Store) Add(a *Acknowledgement) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	added := *a
+	added.Time = s.clock.Now()
+	*a = added
+
+	s.acknowledged[a.ConnectionID] = &added
+	s.notify()
+}
+
+// Delete removes the acknowledgement of the connection, which is false if it hasn't got one
+func (s *Store) Delete(connectionID string) bool {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.acknowledged[connectionID]; !ok {
+		return false
+	}
+
+	delete(s.acknowledged, connectionID)
+	s.notify()
+
+	return true
+}
+
+// resolve forgets the acknowledgement, now the problem is over, unless it's been replaced since. It's not a change
+// worth noting, as it's found out while annotating the connections.
+func (s *Store) resolve(a *Acknowledgement) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.acknowledged[a.ConnectionID] == a {
+		delete(s.acknowledged, a.ConnectionID)
+	}
+}
+
+// For returns the acknowledgement of the connection, or nil if it hasn't got one
+func (s *Store) For(connectionID string) *Acknowledgement {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.acknowledged[connectionID]
+}
+
+// Changed returns a channel that receives when acknowledgements are added or deleted, without waiting for a receiver,
+// so changes made in quick succession can be received once
+func (s *Store) Changed() <-chan struct{} {
+	return s.changed
+}
+
+func (s *Store) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,59 @@
+package acknowledge
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+
+	clock := &movableClock{now: at(0)}
+	store := NewStore(clock)
+
+	// Given problems with two connections are acknowledged
+	store.Add(&Acknowledgement{ConnectionID: "vpn-2", By: "alice", Comment: "On it"})
+	store.Add(&Acknowledgement{ConnectionID: "vpn-1", By: "bob"})
+
+	// When one is acknowledged again later by someone else
+	clock.now = at(1)
+	again := &Acknowledgement{ConnectionID: "vpn-2", By: "carol"}
+	store.Add(again)
+
+	// Then it replaces the first, as of then
+	want := []*Acknowledgement{
+		{ConnectionID: "vpn-1", By: "bob", Time: at(0)},
+		{ConnectionID: "vpn-2", By: "carol", Time: at(1)},
+	}
+	if got := store.Acknowledgements(); !cmp.Equal(want, got) {
+		t.Errorf("Acknowledgements incorrect: %s", cmp.Diff(want, got))
+	}
+	if !again.Time.Equal(at(1)) {
+		t.Errorf("Expected the time of the acknowledgement to be filled in, got %s", again.Time)
+	}
+
+	// and the change is noted
+	select {
+	case <-store.Changed():
+	default:
+		t.Error("Expected the change to be noted")
+	}
+
+	// and deleting it leaves the other
+	if !store.Delete("vpn-2") || store.Delete("vpn-2") {
+		t.Error("Expected the acknowledgement to be deleted once")
+	}
+	if store.For("vpn-2") != nil || store.For("vpn-1") == nil {
+		t.Errorf("Expected only the acknowledgement of vpn-1 to be left, got %v", store.Acknowledgements())
+	}
+}
+
+func at(hours int) time.Time {
+	return time.Date(2020, 9, 13, hours, 0, 0, 0, time.UTC)
+}
+
+type movableClock struct {
+	now time.Time
+}
+
+func (c *movableClock) Now() time.Time { return c.now }
@@ -0,0 +1,86 @@
+// Package auth authenticates the users of the dashboard and the API, with basic auth against an htpasswd file or by
+// logging in through an OpenID Connect provider, and checks they are allowed in
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// User is who made a request
+type User struct {
+	Name   string   `json:"name"`
+	Email  string   `json:"email,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// String is how the user is shown, by email if they have one
+func (u *User) String() string {
+	if u.Email != "" {
+		return u.Email
+	}
+	return u.Name
+}
+
+// Authenticator wraps handlers so requests only reach them from users it has authenticated and the policy allows,
+// with the user in the context of the request
+type Authenticator interface {
+	Wrap(next http.Handler) http.Handler
+}
+
+type contextKey struct{}
+
+// NewContext returns a context with the user
+func NewContext(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// FromContext returns the user in the context, if there is one
+func FromContext(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(contextKey{}).(*User)
+	return user, ok
+}
+
+// Policy is who is allowed in, by their name or email, or by a group they are in. Everyone who authenticates is
+// allowed in when it's empty.
+type Policy struct {
+	Users  []string
+	Groups []string
+}
+
+// Allows is whether the policy lets the user in
+func (p Policy) Allows(user *User) bool {
+
+	if len(p.Users) == 0 && len(p.Groups) == 0 {
+		return true
+	}
+
+	for _, allowed := range p.Users {
+		if strings.EqualFold(allowed, user.Name) || (user.Email != "" && strings.EqualFold(allowed, user.Email)) {
+			return true
+		}
+	}
+
+	for _, allowed := range p.Groups {
+		for _, group := range user.Groups {
+			if allowed == group {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// serve serves the request with the user in its context if the policy allows them in, or forbids it if it doesn't
+func (p Policy) serve(next http.Handler, user *User, w http.ResponseWriter, r *http.Request) {
+
+	if !p.Allows(user) {
+		http.Error(w, fmt.Sprintf("Forbidden: %s isn't allowed to use vpnck", user), http.StatusForbidden)
+		return
+	}
+
+	next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), user)))
+}
@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Basic authenticates users with basic auth, against the hashes of their passwords in an htpasswd file
+type Basic struct {
+	hashes map[string]string
+	groups map[string][]string
+	policy Policy
+}
+
+// NewBasic returns an authenticator for the users in the htpasswd file, which can be created with `htpasswd -c -m`.
+// Their passwords must be hashed with MD5 (-m, the default of htpasswd) or SHA-1 (-s), as bcrypt isn't supported. An
+// optional groups file, in the format of Apache's AuthGroupFile, puts the users in groups for the policy.
+func NewBasic(htpasswdFile string, groupsFile string, policy Policy) (*Basic, error) {
+
+	hashes, err := loadHtpasswd(htpasswdFile)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]string)
+	if groupsFile != "" {
+		if groups, err = loadGroups(groupsFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Basic{hashes: hashes, groups: groups, policy: policy}, nil
+}
+
+// Wrap asks for a username and password, letting the request through if they match and the policy allows the user in
+func (b *Basic) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		name, password, ok := r.BasicAuth()
+		if !ok || !b.authenticate(name, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="vpnck", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		b.policy.serve(next, &User{Name: name, Groups: b.groups[name]}, w, r)
+	})
+}
+
+// authenticate checks the password is the user's, taking as long for users that don't exist
+func (b *Basic) authenticate(name string, password string) bool {
+
+	hash, ok := b.hashes[name]
+	if !ok {
+		hash = "$apr1$unknown$"
+	}
+
+	return verifyPassword(hash, password) && ok
+}
+
+// loadHtpasswd reads the name:hash lines of an htpasswd file, checking the hashes are of a supported kind
+func loadHtpasswd(name string) (map[string]string, error) {
+
+	hashes := make(map[string]string)
+
+	err := readLines(name, func(line string) error {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("expected name:hash, got %q", line)
+		}
+		if !strings.HasPrefix(parts[1], apr1Magic) && !strings.HasPrefix(parts[1], shaPrefix) {
+			return fmt.Errorf("the password of %s isn't hashed with MD5 (htpasswd -m) or SHA-1 (htpasswd -s)", parts[0])
+		}
+		hashes[parts[0]] = parts[1]
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid htpasswd file %s: %w", name, err)
+	}
+
+	return hashes, nil
+}
+
+// loadGroups reads the "group: user user" lines of a groups file, returning the groups of each user
+func loadGroups(name string) (map[string][]string, error) {
+
+	groups := make(map[string][]string)
+
+	err := readLines(name, func(line string) error {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return fmt.Errorf("expected group: user user..., got %q", line)
+		}
+		group := strings.TrimSpace(parts[0])
+		for _, user := range strings.Fields(parts[1]) {
+			groups[user] = append(groups[user], group)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid groups file %s: %w", name, err)
+	}
+
+	return groups, nil
+}
+
+// readLines calls the func with each line of the file that isn't blank or a comment
+func readLines(name string, f func(line string) error) error {
+
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := f(line); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+const (
+	apr1Magic = "$apr1$"
+	shaPrefix = "{SHA}"
+)
+
+// verifyPassword checks the password against an htpasswd hash
+func verifyPassword(hash string, password string) bool {
+
+	var computed string
+	switch {
+	case strings.HasPrefix(hash, shaPrefix):
+		sum := sha1.Sum([]byte(password))
+		computed = shaPrefix + base64.StdEncoding.EncodeToString(sum[:])
+	case strings.HasPrefix(hash, apr1Magic):
+		salt := strings.SplitN(strings.TrimPrefix(hash, apr1Magic), "$", 2)[0]
+		computed = apr1(password, salt)
+	default:
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+}
+
+// apr1 hashes the password with the salt using Apache's variant of MD5-crypt
+func apr1(password string, salt string) string {
+
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alternate := md5.New()
+	alternate.Write(pw)
+	_, _ = io.WriteString(alternate, salt)
+	alternate.Write(pw)
+	alt := alternate.Sum(nil)
+
+	h := md5.New()
+	h.Write(pw)
+	_, _ = io.WriteString(h, apr1Magic+salt)
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			h.Write(alt)
+		} else {
+			h.Write(alt[:i])
+		}
+	}
+	for i := len(pw); i != 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	final := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			_, _ = io.WriteString(round, salt)
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	var encoded []byte
+	encode := func(v uint, n int) {
+		for ; n > 0; n-- {
+			encoded = append(encoded, itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	encode(uint(final[0])<<16|uint(final[6])<<8|uint(final[12]), 4)
+	encode(uint(final[1])<<16|uint(final[7])<<8|uint(final[13]), 4)
+	encode(uint(final[2])<<16|uint(final[8])<<8|uint(final[14]), 4)
+	encode(uint(final[3])<<16|uint(final[9])<<8|uint(final[15]), 4)
+	encode(uint(final[4])<<16|uint(final[10])<<8|uint(final[5]), 4)
+	encode(uint(final[11]), 2)
+
+	return apr1Magic + salt + "$" + string(encoded)
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Hashed with openssl passwd -apr1 and htpasswd -s
+const htpasswd = `# Managed by hand
+alice:$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/
+bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=
+carol:$apr1$x1$ibhRLiQ6UQUspa5gnJ.6n.
+`
+
+const htgroups = `network: alice
+payments: bob carol
+`
+
+// echoUser responds with the name or email and groups of the user in the context of the request
+var echoUser = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	user, ok := FromContext(r.Context())
+	if !ok {
+		http.Error(w, "no user", http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write([]byte(user.String()))
+	for _, group := range user.Groups {
+		_, _ = w.Write([]byte(" " + group))
+	}
+})
+
+var basictests = []struct {
+	name     string
+	user     string
+	password string
+	policy   Policy
+	status   int
+	body     string
+}{
+	{name: "MD5", user: "alice", password: "secret", status: http.StatusOK, body: "alice network"},
+	{name: "SHA-1", user: "bob", password: "secret", status: http.StatusOK, body: "bob payments"},
+	{name: "Long password", user: "carol", password: "a much longer password of thirty-plus chars", status: http.StatusOK, body: "carol payments"},
+	{name: "Wrong password", user: "alice", password: "guess", status: http.StatusUnauthorized},
+	{name: "Unknown user", user: "mallory", password: "secret", status: http.StatusUnauthorized},
+	{name: "Without credentials", status: http.StatusUnauthorized},
+	{name: "Allowed by group", user: "alice", password: "secret", policy: Policy{Groups: []string{"network"}}, status: http.StatusOK, body: "alice network"},
+	{name: "Allowed by name", user: "bob", password: "secret", policy: Policy{Users: []string{"Bob"}, Groups: []string{"network"}}, status: http.StatusOK, body: "bob payments"},
+	{name: "Not allowed", user: "bob", password: "secret", policy: Policy{Groups: []string{"network"}}, status: http.StatusForbidden},
+}
+
+func TestBasic(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	passwords, groups := filepath.Join(dir, "htpasswd"), filepath.Join(dir, "htgroups")
+	if err := ioutil.WriteFile(passwords, []byte(htpasswd), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(groups, []byte(htgroups), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range basictests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// Given users in an htpasswd file, in groups
+			underTest, err := NewBasic(passwords, groups, tt.policy)
+			if err != nil {
+				t.Fatalf("unable to load users: %v", err)
+			}
+
+			// When a request is made with their credentials
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+			w := httptest.NewRecorder()
+			underTest.Wrap(echoUser).ServeHTTP(w, r)
+
+			// Then it's only let through if they match and the user is allowed in
+			if w.Code != tt.status {
+				t.Errorf("want status %d; got %d", tt.status, w.Code)
+			}
+			if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("want to be asked for credentials")
+			}
+			if tt.status == http.StatusOK && w.Body.String() != tt.body {
+				t.Errorf("want user %q; got %q", tt.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBasicRejectsUnsupportedHashes(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	// Given a user with a bcrypt hash
+	passwords := filepath.Join(dir, "htpasswd")
+	if err := ioutil.WriteFile(passwords, []byte("dave:$2y$05$c4WoMPo3SXsafkva.HHa6uXQZWr7oboPiC2bT/r7q1BB8I2s0BRqC\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Then they can't be loaded, rather than never being able to log in
+	if _, err := NewBasic(passwords, "", Policy{}); err == nil {
+		t.Error("want error for bcrypt hash")
+	}
+}
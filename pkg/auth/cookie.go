@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// cookieSigner signs values kept in cookies, so they can't be forged or tampered with by the browser
+type cookieSigner struct {
+	key []byte
+}
+
+// sign returns the value as JSON with its signature, encoded for a cookie
+func (s cookieSigner) sign(v interface{}) (string, error) {
+
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.mac(encoded)), nil
+}
+
+// verify checks the signature of a cookie, decoding its value if it's valid
+func (s cookieSigner) verify(cookie string, v interface{}) error {
+
+	parts := strings.SplitN(cookie, ".", 2)
+	if len(parts) != 2 {
+		return errors.New("malformed cookie")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, s.mac(parts[0])) {
+		return errors.New("invalid cookie signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(payload, v)
+}
+
+func (s cookieSigner) mac(encoded string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	_, _ = h.Write([]byte(encoded))
+	return h.Sum(nil)
+}
@@ -0,0 +1,432 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LogoutPath is where users log out of vpnck, which doesn't log them out of the provider
+const LogoutPath = "/auth/logout"
+
+const (
+	sessionCookie = "vpnck_session"
+	loginCookie   = "vpnck_login"
+
+	// loginTimeout is how long users have to log in with the provider
+	loginTimeout = 10 * time.Minute
+
+	// clockSkew is allowed between vpnck and the provider when checking ID tokens have expired
+	clockSkew = time.Minute
+)
+
+// OIDCOptions are how users log in through an OpenID Connect provider
+type OIDCOptions struct {
+
+	// Issuer is the URL of the provider, which its configuration is discovered from
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL is where the provider sends users back to after logging in, e.g.
+	// https://vpnck.example.com/auth/callback, which must be registered with the provider
+	RedirectURL string
+
+	// Scopes are asked for as well as openid
+	Scopes []string
+
+	// GroupsClaim is the claim of ID tokens with the groups users are in
+	GroupsClaim string
+
+	// SessionKey signs the cookies that keep users logged in
+	SessionKey []byte
+
+	// SessionDuration is how long users stay logged in
+	SessionDuration time.Duration
+}
+
+// OIDC logs users in through an OpenID Connect provider with the authorization code flow, keeping them logged in with
+// a session cookie
+type OIDC struct {
+	options  OIDCOptions
+	policy   Policy
+	logger   log.Logger
+	clock    state.Clock
+	client   *http.Client
+	signer   cookieSigner
+	keys     *keySet
+	callback string
+	secure   bool
+
+	authorizationEndpoint string
+	tokenEndpoint         string
+}
+
+// providerConfig is the part of the configuration of a provider vpnck needs
+type providerConfig struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewOIDC returns an authenticator that logs users in through the provider, discovering its configuration
+func NewOIDC(logger log.Logger, options OIDCOptions, policy Policy, clock state.Clock, client *http.Client) (*OIDC, error) {
+
+	if options.ClientID == "" {
+		return nil, errors.New("OpenID Connect needs a client ID")
+	}
+	if len(options.SessionKey) < 32 {
+		return nil, errors.New("the session key must be at least 32 bytes")
+	}
+
+	redirect, err := url.Parse(options.RedirectURL)
+	if err != nil || !redirect.IsAbs() || redirect.Path == "" {
+		return nil, fmt.Errorf("invalid redirect URL %q, should be absolute, e.g. https://vpnck.example.com/auth/callback", options.RedirectURL)
+	}
+
+	issuer := strings.TrimSuffix(options.Issuer, "/")
+	discovery := issuer + "/.well-known/openid-configuration"
+
+	resp, err := client.Get(discovery)
+	if err != nil {
+		return nil, fmt.Errorf("unable to discover the OpenID Connect provider: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovering the OpenID Connect provider from %s returned status %d", discovery, resp.StatusCode)
+	}
+
+	var config providerConfig
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %w", discovery, err)
+	}
+
+	// Ensures tokens are checked against the issuer they will have
+	if strings.TrimSuffix(config.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("the provider's issuer is %s rather than %s", config.Issuer, options.Issuer)
+	}
+	options.Issuer = config.Issuer
+
+	if options.GroupsClaim == "" {
+		options.GroupsClaim = "groups"
+	}
+
+	return &OIDC{
+		options:               options,
+		policy:                policy,
+		logger:                log.With(logger, "auth", "oidc"),
+		clock:                 clock,
+		client:                client,
+		signer:                cookieSigner{key: options.SessionKey},
+		keys:                  &keySet{url: config.JWKSURI, client: client},
+		callback:              redirect.Path,
+		secure:                redirect.Scheme == "https",
+		authorizationEndpoint: config.AuthorizationEndpoint,
+		tokenEndpoint:         config.TokenEndpoint,
+	}, nil
+}
+
+// session is who is logged in, until when
+type session struct {
+	User    *User     `json:"user"`
+	Expires time.Time `json:"expires"`
+}
+
+// login is a login in progress, with the state and nonce sent to the provider and where to return to afterwards
+type login struct {
+	State   string    `json:"state"`
+	Nonce   string    `json:"nonce"`
+	Return  string    `json:"return"`
+	Expires time.Time `json:"expires"`
+}
+
+// Wrap lets requests from users logged in through the provider through if the policy allows them in. Users of pages
+// that aren't logged in are sent to the provider to log in, while requests to the API are unauthorized.
+func (o *OIDC) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		switch r.URL.Path {
+		case o.callback:
+			o.finishLogin(w, r)
+			return
+		case LogoutPath:
+			o.setCookie(w, sessionCookie, "", -1)
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+
+		if s, ok := o.session(r); ok {
+			o.policy.serve(next, s.User, w, r)
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/api/") || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			http.Error(w, "Unauthorized, log in to vpnck first", http.StatusUnauthorized)
+			return
+		}
+
+		o.startLogin(w, r)
+	})
+}
+
+// session returns the session of the request, if it has one that hasn't expired
+func (o *OIDC) session(r *http.Request) (session, bool) {
+
+	var s session
+
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return s, false
+	}
+
+	if err := o.signer.verify(cookie.Value, &s); err != nil || s.User == nil || !o.clock.Now().Before(s.Expires) {
+		return s, false
+	}
+
+	return s, true
+}
+
+// startLogin sends the user to the provider to log in, remembering the page they wanted
+func (o *OIDC) startLogin(w http.ResponseWriter, r *http.Request) {
+
+	l := login{State: randomString(), Nonce: randomString(), Return: r.URL.RequestURI(), Expires: o.clock.Now().Add(loginTimeout)}
+
+	value, err := o.signer.sign(l)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to start logging in: %v", err), http.StatusInternalServerError)
+		return
+	}
+	o.setCookie(w, loginCookie, value, loginTimeout)
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.options.ClientID},
+		"redirect_uri":  {o.options.RedirectURL},
+		"scope":         {strings.Join(append([]string{"openid"}, o.options.Scopes...), " ")},
+		"state":         {l.State},
+		"nonce":         {l.Nonce},
+	}
+
+	separator := "?"
+	if strings.Contains(o.authorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, o.authorizationEndpoint+separator+query.Encode(), http.StatusFound)
+}
+
+// finishLogin exchanges the code the provider sent the user back with for an ID token, which starts their session
+func (o *OIDC) finishLogin(w http.ResponseWriter, r *http.Request) {
+
+	var l login
+	cookie, err := r.Cookie(loginCookie)
+	if err != nil || o.signer.verify(cookie.Value, &l) != nil || !o.clock.Now().Before(l.Expires) {
+		http.Error(w, "Login expired or wasn't started by vpnck, try again", http.StatusBadRequest)
+		return
+	}
+	o.setCookie(w, loginCookie, "", -1)
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		http.Error(w, fmt.Sprintf("Unable to log in: %s %s", e, query.Get("error_description")), http.StatusForbidden)
+		return
+	}
+
+	if query.Get("state") != l.State {
+		http.Error(w, "Login state doesn't match, try again", http.StatusBadRequest)
+		return
+	}
+
+	user, err := o.exchange(query.Get("code"), l.Nonce)
+	if err != nil {
+		_ = level.Warn(o.logger).Log("msg", "Unable to log in", "err", err)
+		http.Error(w, "Unable to log in with the provider", http.StatusBadGateway)
+		return
+	}
+
+	value, err := o.signer.sign(session{User: user, Expires: o.clock.Now().Add(o.options.SessionDuration)})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to start session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	o.setCookie(w, sessionCookie, value, o.options.SessionDuration)
+
+	_ = level.Info(o.logger).Log("msg", "Logged in", "user", user, "allowed", o.policy.Allows(user))
+
+	http.Redirect(w, r, localPath(l.Return), http.StatusFound)
+}
+
+// idClaims are the claims of ID tokens vpnck checks or uses, apart from the groups claim which can be configured
+type idClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	Expires           int64    `json:"exp"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     *bool    `json:"email_verified"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// audience is the audience of a token, which can be a string or an array of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (a audience) contains(id string) bool {
+	for _, aud := range a {
+		if aud == id {
+			return true
+		}
+	}
+	return false
+}
+
+// exchange exchanges the code for an ID token, returning the user it identifies once it's been verified
+func (o *OIDC) exchange(code string, nonce string) (*User, error) {
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {o.options.RedirectURL},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, o.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(o.options.ClientID), url.QueryEscape(o.options.ClientSecret))
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("unable to decode tokens: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("no ID token returned")
+	}
+
+	var payload json.RawMessage
+	if err := verifyJWT(tokens.IDToken, o.keys, &payload); err != nil {
+		return nil, err
+	}
+
+	// The groups claim is named by the options, so is decoded separately
+	var claims idClaims
+	var all map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(payload, &all); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case claims.Issuer != o.options.Issuer:
+		return nil, fmt.Errorf("ID token issued by %s", claims.Issuer)
+	case !claims.Audience.contains(o.options.ClientID) || (claims.AuthorizedParty != "" && claims.AuthorizedParty != o.options.ClientID):
+		return nil, errors.New("ID token issued to another client")
+	case o.clock.Now().After(time.Unix(claims.Expires, 0).Add(clockSkew)):
+		return nil, errors.New("ID token expired")
+	case claims.Nonce != nonce:
+		return nil, errors.New("ID token nonce doesn't match")
+	case claims.Subject == "":
+		return nil, errors.New("ID token has no subject")
+	}
+
+	user := &User{Name: claims.Subject}
+	if claims.PreferredUsername != "" {
+		user.Name = claims.PreferredUsername
+	}
+	if claims.EmailVerified == nil || *claims.EmailVerified {
+		user.Email = claims.Email
+	}
+	user.Groups = stringsClaim(all[o.options.GroupsClaim])
+
+	return user, nil
+}
+
+// stringsClaim returns the strings of a claim that's an array of them or a single one
+func stringsClaim(claim interface{}) []string {
+	switch c := claim.(type) {
+	case string:
+		return []string{c}
+	case []interface{}:
+		var values []string
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func (o *OIDC) setCookie(w http.ResponseWriter, name string, value string, maxAge time.Duration) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Secure:   o.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(maxAge.Seconds()),
+	}
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// localPath returns the path if it's on vpnck, so logging in can't be used to redirect users elsewhere
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+	return path
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
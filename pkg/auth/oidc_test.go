@@ -0,0 +1,357 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/go-kit/kit/log"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var loginTime = time.Date(2009, 11, 17, 20, 34, 58, 0, time.UTC)
+
+type movableClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *movableClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *movableClock) add(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fakeProvider is an OpenID Connect provider that logs everyone in as the same user straight away
+type fakeProvider struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	clock *movableClock
+
+	// tamper changes the claims of the ID tokens it issues
+	tamper func(claims map[string]interface{})
+
+	// signer signs ID tokens instead of its key when set
+	signer *rsa.PrivateKey
+
+	mutex  sync.Mutex
+	nonces map[string]string
+}
+
+func newFakeProvider(t *testing.T, clock *movableClock) *fakeProvider {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+
+	p := &fakeProvider{key: key, clock: clock, nonces: make(map[string]string)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(providerConfig{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			JWKSURI:               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{{
+			ID:   "key-1",
+			Type: "RSA",
+			Use:  "sig",
+			N:    base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:    base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("response_type") != "code" || query.Get("client_id") != "vpnck" || !strings.Contains(query.Get("scope"), "openid") {
+			http.Error(w, "invalid authorization request", http.StatusBadRequest)
+			return
+		}
+		code := randomString()
+		p.mutex.Lock()
+		p.nonces[code] = query.Get("nonce")
+		p.mutex.Unlock()
+		http.Redirect(w, r, query.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {query.Get("state")}}.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		p.mutex.Lock()
+		nonce, ok := p.nonces[r.PostFormValue("code")]
+		delete(p.nonces, r.PostFormValue("code"))
+		p.mutex.Unlock()
+		if id != "vpnck" || secret != "shh" || !ok || r.PostFormValue("grant_type") != "authorization_code" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "opaque", "token_type": "Bearer", "id_token": p.idToken(t, nonce)})
+	})
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+// idToken returns an ID token for alice, signed with RS256
+func (p *fakeProvider) idToken(t *testing.T, nonce string) string {
+
+	claims := map[string]interface{}{
+		"iss":            p.URL,
+		"sub":            "248289761001",
+		"aud":            "vpnck",
+		"exp":            p.clock.Now().Add(time.Hour).Unix(),
+		"iat":            p.clock.Now().Unix(),
+		"nonce":          nonce,
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"groups":         []string{"network", "payments"},
+	}
+	if p.tamper != nil {
+		p.tamper(claims)
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "key-1", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	signer := p.key
+	if p.signer != nil {
+		signer = p.signer
+	}
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, signer, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("unable to sign ID token: %v", err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// newOIDCServer serves the user of each request behind OIDC against the provider
+func newOIDCServer(t *testing.T, provider *fakeProvider, policy Policy) (*httptest.Server, *OIDC) {
+
+	server := httptest.NewServer(nil)
+	t.Cleanup(server.Close)
+
+	underTest, err := NewOIDC(log.NewNopLogger(), OIDCOptions{
+		Issuer:          provider.URL,
+		ClientID:        "vpnck",
+		ClientSecret:    "shh",
+		RedirectURL:     server.URL + "/auth/callback",
+		Scopes:          []string{"email", "groups"},
+		SessionKey:      []byte(strings.Repeat("k", 32)),
+		SessionDuration: 8 * time.Hour,
+	}, policy, provider.clock, http.DefaultClient)
+	if err != nil {
+		t.Fatalf("unable to set up OIDC: %v", err)
+	}
+
+	server.Config.Handler = underTest.Wrap(echoUser)
+	return server, underTest
+}
+
+// browser returns a client that keeps cookies, like a browser
+func browser(t *testing.T) *http.Client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Jar: jar}
+}
+
+func get(t *testing.T, client *http.Client, url string) (int, string) {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("unable to get %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestOIDCLogin(t *testing.T) {
+
+	// Given vpnck behind a provider
+	clock := &movableClock{now: loginTime}
+	provider := newFakeProvider(t, clock)
+	server, _ := newOIDCServer(t, provider, Policy{Groups: []string{"payments"}})
+	client := browser(t)
+
+	// When the user visits a page
+	var landed string
+	client.CheckRedirect = func(r *http.Request, via []*http.Request) error {
+		landed = r.URL.RequestURI()
+		return nil
+	}
+	status, body := get(t, client, server.URL+"/connections/vpn-1?tab=routes")
+
+	// Then they log in through the provider and end up where they were going, as themselves
+	if status != http.StatusOK || body != "alice@example.com network payments" {
+		t.Errorf("want alice logged in; got %d %q", status, body)
+	}
+	if landed != "/connections/vpn-1?tab=routes" {
+		t.Errorf("want to land on the page visited; got %s", landed)
+	}
+
+	// When they visit another page
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	// Then their session keeps them logged in
+	if status, _ := get(t, client, server.URL+"/api/state"); status != http.StatusOK {
+		t.Errorf("want session used; got status %d", status)
+	}
+
+	// When their session expires
+	clock.add(8 * time.Hour)
+
+	// Then pages log them in again, while the API doesn't
+	if status, _ := get(t, client, server.URL+"/"); status != http.StatusFound {
+		t.Errorf("want to log in again; got status %d", status)
+	}
+	if status, _ := get(t, client, server.URL+"/api/state"); status != http.StatusUnauthorized {
+		t.Errorf("want API unauthorized; got status %d", status)
+	}
+}
+
+func TestOIDCLogout(t *testing.T) {
+
+	// Given a logged in user
+	clock := &movableClock{now: loginTime}
+	server, _ := newOIDCServer(t, newFakeProvider(t, clock), Policy{})
+	client := browser(t)
+	if status, _ := get(t, client, server.URL+"/"); status != http.StatusOK {
+		t.Fatalf("unable to log in, got status %d", status)
+	}
+
+	// When they log out
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	get(t, client, server.URL+LogoutPath)
+
+	// Then they are no longer logged in
+	if status, _ := get(t, client, server.URL+"/api/state"); status != http.StatusUnauthorized {
+		t.Errorf("want logged out; got status %d", status)
+	}
+}
+
+var oidctests = []struct {
+	name   string
+	tamper func(claims map[string]interface{})
+	other  bool
+	policy Policy
+	status int
+	body   string
+}{
+	{name: "Allowed by email", policy: Policy{Users: []string{"alice@example.com"}}, status: http.StatusOK, body: "alice@example.com network payments"},
+	{name: "Username", tamper: func(c map[string]interface{}) { c["preferred_username"] = "alice"; delete(c, "email") }, status: http.StatusOK, body: "alice network payments"},
+	{name: "Unverified email", tamper: func(c map[string]interface{}) { c["email_verified"] = false }, policy: Policy{Users: []string{"alice@example.com"}}, status: http.StatusForbidden},
+	{name: "Not in an allowed group", policy: Policy{Groups: []string{"support"}}, status: http.StatusForbidden},
+	{name: "Audience of several", tamper: func(c map[string]interface{}) { c["aud"] = []string{"other", "vpnck"} }, status: http.StatusOK, body: "alice@example.com network payments"},
+	{name: "Another audience", tamper: func(c map[string]interface{}) { c["aud"] = "other" }, status: http.StatusBadGateway},
+	{name: "Another issuer", tamper: func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, status: http.StatusBadGateway},
+	{name: "Expired", tamper: func(c map[string]interface{}) { c["exp"] = loginTime.Add(-time.Hour).Unix() }, status: http.StatusBadGateway},
+	{name: "Replayed nonce", tamper: func(c map[string]interface{}) { c["nonce"] = "replayed" }, status: http.StatusBadGateway},
+	{name: "Signed by another key", other: true, status: http.StatusBadGateway},
+}
+
+func TestOIDCIDTokens(t *testing.T) {
+
+	for _, tt := range oidctests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// Given a provider issuing ID tokens
+			clock := &movableClock{now: loginTime}
+			provider := newFakeProvider(t, clock)
+			provider.tamper = tt.tamper
+			if tt.other {
+				other, err := rsa.GenerateKey(rand.Reader, 2048)
+				if err != nil {
+					t.Fatal(err)
+				}
+				provider.signer = other
+			}
+			server, _ := newOIDCServer(t, provider, tt.policy)
+
+			// When a user logs in
+			status, body := get(t, browser(t), server.URL+"/")
+
+			// Then they are only let in with a valid token, if the policy allows them in
+			if status != tt.status {
+				t.Errorf("want status %d; got %d %q", tt.status, status, body)
+			}
+			if tt.status == http.StatusOK && body != tt.body {
+				t.Errorf("want user %q; got %q", tt.body, body)
+			}
+		})
+	}
+}
+
+func TestOIDCRejectsForgedRequests(t *testing.T) {
+
+	clock := &movableClock{now: loginTime}
+	server, underTest := newOIDCServer(t, newFakeProvider(t, clock), Policy{})
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	// When a session cookie is forged
+	forged, _ := cookieSigner{key: []byte(strings.Repeat("x", 32))}.sign(session{User: &User{Name: "mallory"}, Expires: loginTime.Add(time.Hour)})
+	r, _ := http.NewRequest(http.MethodGet, server.URL+"/api/state", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: forged})
+	resp, err := client.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	// Then it's ignored
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("want forged session ignored; got status %d", resp.StatusCode)
+	}
+
+	// When the callback is called with a state vpnck didn't start
+	login, _ := underTest.signer.sign(login{State: "started", Nonce: "n", Return: "/", Expires: loginTime.Add(time.Minute)})
+	r, _ = http.NewRequest(http.MethodGet, server.URL+"/auth/callback?code=stolen&state=other", nil)
+	r.AddCookie(&http.Cookie{Name: loginCookie, Value: login})
+	resp, err = client.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	// Then it's rejected
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("want mismatched state rejected; got status %d", resp.StatusCode)
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	for path, want := range map[string]string{
+		"/connections/vpn-1?tab=routes": "/connections/vpn-1?tab=routes",
+		"//evil.example.com/":           "/",
+		"/\\evil.example.com/":          "/",
+		"https://evil.example.com/":     "/",
+	} {
+		if got := localPath(path); got != want {
+			t.Errorf("%s: want %s; got %s", path, want, got)
+		}
+	}
+}
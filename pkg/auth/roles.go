@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"strings"
+)
+
+// Permission is something a role lets its users do with the connections it covers
+type Permission string
+
+const (
+	// PermissionView lets users see connections on the pages and through the API
+	PermissionView Permission = "view"
+
+	// PermissionViewRaw lets users see the raw state of connections, with all their configuration
+	PermissionViewRaw Permission = "view-raw"
+
+	// PermissionManageMaintenance lets users create and delete maintenance windows for connections
+	PermissionManageMaintenance Permission = "manage-maintenance"
+
+	// PermissionAcknowledge lets users acknowledge problems with connections, and delete the acknowledgements
+	PermissionAcknowledge Permission = "acknowledge"
+)
+
+var permissions = []Permission{PermissionView, PermissionViewRaw, PermissionManageMaintenance, PermissionAcknowledge}
+
+// Role is what the users in its groups, or named by it, can do with the connections it covers
+type Role struct {
+	Name string `json:"name"`
+
+	// Groups are the groups of users the role is mapped to, with * for all authenticated users
+	Groups []string `json:"groups,omitempty"`
+
+	// Users are the names or emails of users the role is mapped to, whatever groups they are in
+	Users []string `json:"users,omitempty"`
+
+	Permissions []Permission `json:"permissions"`
+
+	// Connections are the tags of the connections the role covers, given as key=value, or key for any value. It
+	// covers connections with any of them, and all connections when there are none.
+	Connections []string `json:"connections,omitempty"`
+}
+
+// Validate returns an error if the role has no name, isn't mapped to anyone or has unknown permissions
+func (r *Role) Validate() error {
+
+	if r.Name == "" {
+		return errors.New("role needs a name")
+	}
+
+	if len(r.Groups) == 0 && len(r.Users) == 0 {
+		return fmt.Errorf("role %s needs groups or users", r.Name)
+	}
+
+	for _, p := range r.Permissions {
+		if !knownPermission(p) {
+			return fmt.Errorf("role %s has unknown permission %q, should be one of %s", r.Name, p, permissionNames())
+		}
+	}
+
+	for _, tag := range r.Connections {
+		if strings.TrimSpace(tag) == "" {
+			return fmt.Errorf("role %s has a blank connection tag", r.Name)
+		}
+	}
+
+	return nil
+}
+
+// mappedTo is whether the role is mapped to the user, by name, email or group
+func (r *Role) mappedTo(user *User) bool {
+	return Policy{Users: r.Users, Groups: r.Groups}.Allows(user) || contains(r.Groups, "*")
+}
+
+// covers is whether the connection is one of those the role covers
+func (r *Role) covers(conn *vpn.Connection) bool {
+
+	if len(r.Connections) == 0 {
+		return true
+	}
+
+	for _, tag := range r.Connections {
+		parts := strings.SplitN(tag, "=", 2)
+		value, ok := conn.Tags[parts[0]]
+		if ok && (len(parts) == 1 || value == parts[1]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Role) grants(p Permission) bool {
+	for _, granted := range r.Permissions {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Roles map users to what they can do
+type Roles []*Role
+
+// Validate returns an error if any of the roles isn't valid, or two have the same name
+func (rs Roles) Validate() error {
+
+	names := make(map[string]bool)
+	for _, r := range rs {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+		if names[r.Name] {
+			return fmt.Errorf("more than one role is named %s", r.Name)
+		}
+		names[r.Name] = true
+	}
+
+	return nil
+}
+
+// For returns what the user can do, with the roles they are mapped to
+func (rs Roles) For(user *User) Access {
+
+	var mapped []*Role
+	for _, r := range rs {
+		if r.mappedTo(user) {
+			mapped = append(mapped, r)
+		}
+	}
+
+	return Access{roles: mapped}
+}
+
+// Access is what a user can do, with the roles they are mapped to
+type Access struct {
+	all   bool
+	roles []*Role
+}
+
+// FullAccess can do everything with every connection, for when there are no roles or with the API token
+func FullAccess() Access {
+	return Access{all: true}
+}
+
+// Roles returns the names of the roles, which is empty with full access
+func (a Access) Roles() []string {
+	var names []string
+	for _, r := range a.roles {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+// Has is whether any of the roles grants the permission, for any connection
+func (a Access) Has(p Permission) bool {
+
+	if a.all {
+		return true
+	}
+
+	for _, r := range a.roles {
+		if r.grants(p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Can is whether any of the roles covering the connection grants the permission
+func (a Access) Can(p Permission, conn *vpn.Connection) bool {
+
+	if a.all {
+		return true
+	}
+
+	for _, r := range a.roles {
+		if r.grants(p) && r.covers(conn) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Filter returns the connections the permission is granted for, in the same order
+func (a Access) Filter(p Permission, connections []*vpn.Connection) []*vpn.Connection {
+
+	if a.all {
+		return connections
+	}
+
+	picked := make([]*vpn.Connection, 0, len(connections))
+	for _, conn := range connections {
+		if a.Can(p, conn) {
+			picked = append(picked, conn)
+		}
+	}
+
+	return picked
+}
+
+func knownPermission(p Permission) bool {
+	for _, known := range permissions {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
+
+func permissionNames() string {
+	var names []string
+	for _, p := range permissions {
+		names = append(names, string(p))
+	}
+	return strings.Join(names, ", ")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+var testRoles = Roles{
+	{Name: "network", Groups: []string{"network"}, Permissions: []Permission{PermissionView, PermissionViewRaw, PermissionManageMaintenance}},
+	{Name: "payments", Groups: []string{"payments"}, Users: []string{"oncall@example.com"}, Permissions: []Permission{PermissionView, PermissionManageMaintenance, PermissionAcknowledge}, Connections: []string{"Team=payments"}},
+	{Name: "everyone", Groups: []string{"*"}, Permissions: []Permission{PermissionView}, Connections: []string{"Shared"}},
+}
+
+var testConnections = []*vpn.Connection{
+	{ID: "payments", Tags: map[string]string{"Team": "payments"}},
+	{ID: "search", Tags: map[string]string{"Team": "search"}},
+	{ID: "shared", Tags: map[string]string{"Shared": "yes", "Team": "search"}},
+	{ID: "untagged"},
+}
+
+var accesstests = []struct {
+	name   string
+	user   *User
+	roles  []string
+	view   []string
+	raw    []string
+	manage []string
+	ack    []string
+}{
+	{
+		name:   "All connections",
+		user:   &User{Name: "alice", Groups: []string{"network"}},
+		roles:  []string{"network", "everyone"},
+		view:   []string{"payments", "search", "shared", "untagged"},
+		raw:    []string{"payments", "search", "shared", "untagged"},
+		manage: []string{"payments", "search", "shared", "untagged"},
+		ack:    []string{},
+	},
+	{
+		name:   "Connections with a tag",
+		user:   &User{Name: "bob", Groups: []string{"payments"}},
+		roles:  []string{"payments", "everyone"},
+		view:   []string{"payments", "shared"},
+		raw:    []string{},
+		manage: []string{"payments"},
+		ack:    []string{"payments"},
+	},
+	{
+		name:   "Mapped by email",
+		user:   &User{Name: "carol", Email: "OnCall@example.com"},
+		roles:  []string{"payments", "everyone"},
+		view:   []string{"payments", "shared"},
+		raw:    []string{},
+		manage: []string{"payments"},
+		ack:    []string{"payments"},
+	},
+	{
+		name:   "Only in all users",
+		user:   &User{Name: "dave", Groups: []string{"marketing"}},
+		roles:  []string{"everyone"},
+		view:   []string{"shared"},
+		raw:    []string{},
+		manage: []string{},
+		ack:    []string{},
+	},
+}
+
+func TestAccess(t *testing.T) {
+
+	for _, tt := range accesstests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// Given roles mapped from groups, names and emails
+			// When working out what the user can do
+			access := testRoles.For(tt.user)
+
+			// Then they get the roles they are mapped to, and the permissions for the connections those roles cover
+			if !cmp.Equal(tt.roles, access.Roles()) {
+				t.Errorf("roles incorrect: %s", cmp.Diff(tt.roles, access.Roles()))
+			}
+			for p, want := range map[Permission][]string{PermissionView: tt.view, PermissionViewRaw: tt.raw, PermissionManageMaintenance: tt.manage, PermissionAcknowledge: tt.ack} {
+				got := ids(access.Filter(p, testConnections))
+				if !cmp.Equal(want, got) {
+					t.Errorf("connections with %s incorrect: %s", p, cmp.Diff(want, got))
+				}
+				if access.Has(p) != (len(want) > 0) {
+					t.Errorf("want %s granted to be %v", p, len(want) > 0)
+				}
+			}
+		})
+	}
+}
+
+func TestFullAccess(t *testing.T) {
+
+	// Given full access
+	access := FullAccess()
+
+	// Then everything is permitted on every connection
+	for _, p := range permissions {
+		if !access.Has(p) {
+			t.Errorf("want %s granted", p)
+		}
+		if got := access.Filter(p, testConnections); len(got) != len(testConnections) {
+			t.Errorf("want all connections with %s; got %v", p, ids(got))
+		}
+	}
+}
+
+var validatetests = []struct {
+	name  string
+	roles Roles
+	err   bool
+}{
+	{name: "Valid", roles: testRoles},
+	{name: "No roles", roles: nil},
+	{name: "No name", roles: Roles{{Groups: []string{"network"}}}, err: true},
+	{name: "Not mapped", roles: Roles{{Name: "network", Permissions: []Permission{PermissionView}}}, err: true},
+	{name: "Unknown permission", roles: Roles{{Name: "network", Groups: []string{"network"}, Permissions: []Permission{"edit"}}}, err: true},
+	{name: "Blank tag", roles: Roles{{Name: "network", Groups: []string{"network"}, Connections: []string{" "}}}, err: true},
+	{name: "Same name", roles: Roles{{Name: "network", Groups: []string{"network"}}, {Name: "network", Users: []string{"alice"}}}, err: true},
+}
+
+func TestValidate(t *testing.T) {
+
+	for _, tt := range validatetests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			err := tt.roles.Validate()
+
+			if tt.err && err == nil {
+				t.Error("want an error")
+			}
+			if !tt.err && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func ids(connections []*vpn.Connection) []string {
+	picked := make([]string, 0, len(connections))
+	for _, conn := range connections {
+		picked = append(picked, conn.ID)
+	}
+	return picked
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/auth"
+	"github.com/clearchannelinternational/vpncheck/pkg/maintenance"
+	"io/ioutil"
+)
+
+// Config is the configuration that doesn't fit in flags, loaded from a JSON file
+type Config struct {
+	// Probes lists the targets to probe through each connection, keyed by connection ID.
+	// Targets are URLs such as tcp://10.0.0.1:22, http://10.0.0.2/health or icmp://10.0.0.3
+	Probes map[string][]string `json:"probes"`
+
+	// Maintenance lists planned maintenance windows, in addition to those created through the API
+	Maintenance []*maintenance.Window `json:"maintenance"`
+
+	// Roles map authenticated users to the connections they can see and what they can do with them
+	Roles auth.Roles `json:"roles"`
+}
+
+// Load reads the configuration from the JSON file at the supplied path. No path gives an empty configuration.
+func Load(path string) (*Config, error) {
+
+	config := &Config{}
+
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	return config, nil
+}
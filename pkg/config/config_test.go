@@ -0,0 +1,71 @@
+package config
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/auth"
+	"github.com/clearchannelinternational/vpncheck/pkg/maintenance"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+	"time"
+)
+
+var loadtests = []struct {
+	name  string
+	path  string
+	truth *Config
+	err   bool
+}{
+	{name: "No config file", path: "", truth: &Config{}},
+	{name: "Probes", path: "testdata/probes.json", truth: &Config{Probes: map[string][]string{
+		"vpn-0123456789abcdef0": {"tcp://10.0.0.10:22", "http://10.0.0.20/health"},
+		"wireguard:wg0":         {"icmp://10.40.0.1"},
+	}}},
+	{name: "Maintenance", path: "testdata/maintenance.json", truth: &Config{Maintenance: []*maintenance.Window{{
+		Tag:    "site=london",
+		Start:  time.Date(2020, 9, 13, 2, 0, 0, 0, time.UTC),
+		End:    time.Date(2020, 9, 13, 4, 0, 0, 0, time.UTC),
+		Reason: "ISP line upgrade",
+	}}}},
+	{name: "Roles", path: "testdata/roles.json", truth: &Config{Roles: auth.Roles{
+		{
+			Name:        "network",
+			Groups:      []string{"network-engineers"},
+			Permissions: []auth.Permission{auth.PermissionView, auth.PermissionViewRaw, auth.PermissionManageMaintenance, auth.PermissionAcknowledge},
+		},
+		{
+			Name:        "payments",
+			Groups:      []string{"payments"},
+			Users:       []string{"oncall@example.com"},
+			Permissions: []auth.Permission{auth.PermissionView, auth.PermissionManageMaintenance},
+			Connections: []string{"Team=payments"},
+		},
+	}}},
+	{name: "Missing config file", path: "testdata/missing.json", err: true},
+	{name: "Invalid config file", path: "testdata/invalid.json", err: true},
+}
+
+func TestLoad(t *testing.T) {
+
+	for _, tt := range loadtests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			config, err := Load(tt.path)
+
+			if tt.err {
+				if err == nil {
+					t.Errorf("Expected an error but got %+v", config)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+
+			if !cmp.Equal(tt.truth, config) {
+				t.Errorf("Config incorrect: %s", cmp.Diff(tt.truth, config))
+			}
+
+		})
+	}
+}
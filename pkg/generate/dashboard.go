@@ -0,0 +1,196 @@
+package generate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The parts of the Grafana dashboard model that are generated
+
+type dashboard struct {
+	Title         string     `json:"title"`
+	UID           string     `json:"uid"`
+	Tags          []string   `json:"tags"`
+	Timezone      string     `json:"timezone"`
+	Refresh       string     `json:"refresh"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Time          timeRange  `json:"time"`
+	Templating    templating `json:"templating"`
+	Panels        []panel    `json:"panels"`
+}
+
+type timeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type templating struct {
+	List []variable `json:"list"`
+}
+
+type variable struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Type  string `json:"type"`
+	Query string `json:"query"`
+}
+
+type panel struct {
+	ID          int         `json:"id"`
+	Title       string      `json:"title"`
+	Description string      `json:"description,omitempty"`
+	Type        string      `json:"type"`
+	Datasource  string      `json:"datasource"`
+	GridPos     gridPos     `json:"gridPos"`
+	FieldConfig fieldConfig `json:"fieldConfig"`
+	Targets     []target    `json:"targets"`
+}
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type fieldConfig struct {
+	Defaults fieldDefaults `json:"defaults"`
+}
+
+type fieldDefaults struct {
+	Unit       string      `json:"unit,omitempty"`
+	Thresholds *thresholds `json:"thresholds,omitempty"`
+}
+
+type thresholds struct {
+	Mode  string      `json:"mode"`
+	Steps []threshold `json:"steps"`
+}
+
+type threshold struct {
+	Color string   `json:"color"`
+	Value *float64 `json:"value"`
+}
+
+type target struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+}
+
+// WriteDashboard writes a Grafana dashboard of the metrics as JSON, for a Prometheus data source picked when it's
+// viewed
+func WriteDashboard(w io.Writer, m *Metrics, opts Options) error {
+
+	handshakeAge := opts.HandshakeAge.Seconds()
+	zero := 0.0
+	one := 1.0
+
+	d := dashboard{
+		Title:         opts.Name,
+		UID:           opts.Name,
+		Tags:          []string{"vpn"},
+		Timezone:      "utc",
+		Refresh:       "1m",
+		SchemaVersion: 27,
+		Time:          timeRange{From: "now-24h", To: "now"},
+		Templating: templating{List: []variable{
+			{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+		}},
+		Panels: []panel{
+			{
+				Title: "Tunnels up",
+				Type:  "stat",
+				FieldConfig: fieldConfig{Defaults: fieldDefaults{Thresholds: &thresholds{Mode: "absolute", Steps: []threshold{
+					{Color: "green", Value: nil},
+				}}}},
+				Targets: []target{{Expr: fmt.Sprintf("sum(%s)", m.TunnelUp.Name)}},
+			},
+			{
+				Title: "Tunnels down",
+				Type:  "stat",
+				FieldConfig: fieldConfig{Defaults: fieldDefaults{Thresholds: &thresholds{Mode: "absolute", Steps: []threshold{
+					{Color: "green", Value: nil},
+					{Color: "red", Value: &one},
+				}}}},
+				Targets: []target{{Expr: fmt.Sprintf("count(%s == 0) or vector(0)", m.TunnelUp.Name)}},
+			},
+			{
+				Title: "Tunnels in maintenance",
+				Type:  "stat",
+				FieldConfig: fieldConfig{Defaults: fieldDefaults{Thresholds: &thresholds{Mode: "absolute", Steps: []threshold{
+					{Color: "green", Value: nil},
+					{Color: "orange", Value: &one},
+				}}}},
+				Targets: []target{{Expr: fmt.Sprintf("sum(%s)", m.TunnelMaintenance.Name)}},
+			},
+			{
+				Title:       "Tunnel status",
+				Description: m.TunnelUp.Help,
+				Type:        "timeseries",
+				FieldConfig: fieldConfig{Defaults: fieldDefaults{Thresholds: &thresholds{Mode: "absolute", Steps: []threshold{
+					{Color: "red", Value: nil},
+					{Color: "green", Value: &one},
+				}}}},
+				Targets: []target{{Expr: m.TunnelUp.Name, LegendFormat: legend(m.TunnelUp)}},
+			},
+			{
+				Title:       "Handshake age",
+				Description: m.TunnelHandshakeAge.Help,
+				Type:        "timeseries",
+				FieldConfig: fieldConfig{Defaults: fieldDefaults{Unit: "s", Thresholds: &thresholds{Mode: "absolute", Steps: []threshold{
+					{Color: "green", Value: &zero},
+					{Color: "red", Value: &handshakeAge},
+				}}}},
+				Targets: []target{{Expr: m.TunnelHandshakeAge.Name, LegendFormat: legend(m.TunnelHandshakeAge)}},
+			},
+			{
+				Title:       "Traffic",
+				Description: "Bytes per second received and sent through the VPN tunnel, for sources that count them.",
+				Type:        "timeseries",
+				FieldConfig: fieldConfig{Defaults: fieldDefaults{Unit: "Bps"}},
+				Targets: []target{
+					{Expr: fmt.Sprintf("rate(%s[5m])", m.TunnelReceived.Name), LegendFormat: "received " + legend(m.TunnelReceived)},
+					{Expr: fmt.Sprintf("rate(%s[5m])", m.TunnelSent.Name), LegendFormat: "sent " + legend(m.TunnelSent)},
+				},
+			},
+		},
+	}
+
+	// Lay the stats out along the top, with the graphs below them
+	stats := 0
+	for i := range d.Panels {
+		p := &d.Panels[i]
+		p.ID = i + 1
+		p.Datasource = "${datasource}"
+		for j := range p.Targets {
+			p.Targets[j].RefID = string(rune('A' + j))
+		}
+		if p.Type == "stat" {
+			p.GridPos = gridPos{H: 4, W: 8, X: 8 * stats, Y: 0}
+			stats++
+			continue
+		}
+		graphs := i - stats
+		p.GridPos = gridPos{H: 8, W: 12, X: 12 * (graphs % 2), Y: 4 + 8*(graphs/2)}
+	}
+
+	data, err := json.MarshalIndent(&d, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// legend returns the Grafana legend format showing the labels of the metric
+func legend(m Metric) string {
+	var parts []string
+	for _, name := range m.LabelNames {
+		parts = append(parts, "{{"+name+"}}")
+	}
+	return strings.Join(parts, " ")
+}
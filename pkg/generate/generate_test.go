@@ -0,0 +1,119 @@
+package generate
+
+import (
+	"bytes"
+	"flag"
+	"github.com/clearchannelinternational/vpncheck/pkg/metrics"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "Update the golden files in testdata with the output generated")
+
+var generatetests = []struct {
+	name   string
+	golden string
+	write  func(w io.Writer, m *Metrics, opts Options) error
+}{
+	{name: "Rules", golden: "rules.yaml", write: WriteRules},
+	{name: "PrometheusRule", golden: "prometheusrule.yaml", write: WritePrometheusRule},
+	{name: "Dashboard", golden: "dashboard.json", write: WriteDashboard},
+}
+
+// Tests that the generated rules and dashboards match the golden files in testdata, so any change to the metrics
+// they're derived from shows up. Run with -update to regenerate the golden files.
+func TestGenerate(t *testing.T) {
+
+	m, err := Discover(metrics.DefaultNaming)
+	if err != nil {
+		t.Fatalf("Unable to discover metrics: %v", err)
+	}
+
+	opts := Options{Name: "vpnck", TunnelDownFor: 5 * time.Minute, HandshakeAge: 10 * time.Minute, Severity: "warning"}
+
+	for _, tt := range generatetests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var got bytes.Buffer
+			if err := tt.write(&got, m, opts); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			path := filepath.Join("testdata", tt.golden)
+			if *update {
+				if err := ioutil.WriteFile(path, got.Bytes(), 0644); err != nil {
+					t.Fatalf("Unable to update golden file: %v", err)
+				}
+			}
+
+			want, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Unable to read golden file: %v", err)
+			}
+
+			if diff := cmp.Diff(string(want), got.String()); diff != "" {
+				t.Errorf("Generated output differs from %s, run with -update if the change is intended:\n%s", path, diff)
+			}
+		})
+	}
+}
+
+var discovertests = []struct {
+	name   string
+	naming metrics.Naming
+	truth  Metric
+}{
+	{name: "Default naming", naming: metrics.DefaultNaming, truth: Metric{
+		Name:       "cc_vpn_tunnel_up",
+		Help:       "If the site to site VPN tunnel status is up, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.",
+		LabelNames: []string{"outside_ip", "transit_gateway_id", "tunnel_id", "vpn_id"},
+	}},
+	{name: "Custom naming", naming: metrics.Naming{Namespace: "acme", ConstLabels: prometheus.Labels{"cluster": "eu-1"}}, truth: Metric{
+		Name:       "acme_tunnel_up",
+		Help:       "If the site to site VPN tunnel status is up, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.",
+		LabelNames: []string{"cluster", "outside_ip", "transit_gateway_id", "tunnel_id", "vpn_id"},
+	}},
+}
+
+func TestDiscover(t *testing.T) {
+
+	for _, tt := range discovertests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			m, err := Discover(tt.naming)
+			if err != nil {
+				t.Fatalf("Unable to discover metrics: %v", err)
+			}
+
+			if !cmp.Equal(tt.truth, m.TunnelUp) {
+				t.Errorf("Tunnel up metric incorrect: %s", cmp.Diff(tt.truth, m.TunnelUp))
+			}
+		})
+	}
+}
+
+var durationtests = []struct {
+	duration time.Duration
+	truth    string
+}{
+	{0, "0s"},
+	{1500 * time.Millisecond, "1500ms"},
+	{90 * time.Second, "90s"},
+	{5 * time.Minute, "5m"},
+	{2 * time.Hour, "2h"},
+	{48 * time.Hour, "2d"},
+}
+
+func TestPromDuration(t *testing.T) {
+
+	for _, tt := range durationtests {
+		if got := promDuration(tt.duration); got != tt.truth {
+			t.Errorf("%v: want %s; got %s", tt.duration, tt.truth, got)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package generate
+
+import (
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/maintenance"
+	"github.com/clearchannelinternational/vpncheck/pkg/metrics"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// Metric describes a metric published by vpnck, as alert rules and dashboards need to refer to it
+type Metric struct {
+	Name       string
+	Help       string
+	LabelNames []string
+}
+
+// Metrics are the metrics rules and dashboards are generated from
+type Metrics struct {
+	TunnelUp           Metric
+	TunnelHandshakeAge Metric
+	TunnelReceived     Metric
+	TunnelSent         Metric
+	TunnelMaintenance  Metric
+}
+
+// sampleTime is when the sample tunnel is published, which doesn't matter as only the names of metrics are used
+var sampleTime = time.Date(2020, 9, 13, 0, 0, 0, 0, time.UTC)
+
+// Discover returns the metrics the VPN status collector and the maintenance annotator actually register when named
+// as supplied, by publishing a sample tunnel to a registry and gathering from it, so that rules and dashboards can't
+// fall out of step with them
+func Discover(naming metrics.Naming) (*Metrics, error) {
+
+	registry := prometheus.NewRegistry()
+	registerer := naming.Registerer(registry)
+	clock := fixedClock{sampleTime}
+
+	collector := metrics.NewVpnStatusCollector(registerer, log.NewNopLogger(), clock)
+	defer collector.Interrupt(nil)
+	go func() { _ = collector.Execute() }()
+
+	store, err := maintenance.NewStore(nil, "", clock)
+	if err != nil {
+		return nil, err
+	}
+	annotator := maintenance.NewAnnotator(registerer, log.NewNopLogger(), store)
+
+	sample := []*vpn.Connection{
+		{
+			ID:         "vpn-1",
+			Attributes: map[string]string{vpn.AttrVpnGatewayID: "vgw-1"},
+			Tunnels: []*vpn.Tunnel{
+				{
+					OutsideIP:     "1.1.1.1",
+					Status:        vpn.StatusUp,
+					LastHandshake: sampleTime,
+					Traffic:       &vpn.Traffic{},
+				},
+			},
+		},
+	}
+	collector.Update(sample)
+	annotator.Annotate(sample)
+
+	families, err := registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]Metric)
+	for _, family := range families {
+		m := Metric{Name: family.GetName(), Help: family.GetHelp()}
+		if len(family.Metric) > 0 {
+			for _, label := range family.Metric[0].Label {
+				m.LabelNames = append(m.LabelNames, label.GetName())
+			}
+		}
+		found[m.Name] = m
+	}
+
+	discovered := &Metrics{}
+	for _, want := range []struct {
+		name   string
+		metric *Metric
+	}{
+		{"tunnel_up", &discovered.TunnelUp},
+		{"tunnel_handshake_age_seconds", &discovered.TunnelHandshakeAge},
+		{"tunnel_received_bytes", &discovered.TunnelReceived},
+		{"tunnel_sent_bytes", &discovered.TunnelSent},
+		{"tunnel_maintenance", &discovered.TunnelMaintenance},
+	} {
+		m, ok := found[naming.Prefix()+want.name]
+		if !ok {
+			return nil, fmt.Errorf("no %s metric is registered", naming.Prefix()+want.name)
+		}
+		*want.metric = m
+	}
+
+	return discovered, nil
+}
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.now
+}
@@ -0,0 +1,141 @@
+package generate
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options are the thresholds and naming used in the rules and dashboards generated
+type Options struct {
+	// Name of the rule group, PrometheusRule resource and dashboard
+	Name string
+
+	// TunnelDownFor is how long a tunnel has to be down before it's alerted on
+	TunnelDownFor time.Duration
+
+	// HandshakeAge is how long since a tunnel last handshook before it's alerted on
+	HandshakeAge time.Duration
+
+	// Severity is the severity label given to alerts
+	Severity string
+}
+
+// rule is a Prometheus alerting rule
+type rule struct {
+	alert       string
+	expr        string
+	duration    time.Duration
+	labels      [][2]string
+	annotations [][2]string
+}
+
+// rules returns the alerting rules for the metrics, which don't fire during maintenance windows
+func rules(m *Metrics, opts Options) []rule {
+
+	unlessMaintenance := fmt.Sprintf("unless on(%s) %s == 1", strings.Join(m.TunnelMaintenance.LabelNames, ", "), m.TunnelMaintenance.Name)
+	tunnel := "{{ or $labels.tunnel_id $labels.outside_ip }} of {{ $labels.vpn_id }}{{ $labels.transit_gateway_id }}"
+	severity := [][2]string{{"severity", opts.Severity}}
+
+	return []rule{
+		{
+			alert:    "VPNTunnelDown",
+			expr:     fmt.Sprintf("%s == 0 %s", m.TunnelUp.Name, unlessMaintenance),
+			duration: opts.TunnelDownFor,
+			labels:   severity,
+			annotations: [][2]string{
+				{"summary", "VPN tunnel " + tunnel + " is down"},
+				{"description", fmt.Sprintf("The VPN tunnel has been down for more than %s, outside of any maintenance window.", promDuration(opts.TunnelDownFor))},
+			},
+		},
+		{
+			alert:    "VPNTunnelHandshakeStale",
+			expr:     fmt.Sprintf("%s > %s %s", m.TunnelHandshakeAge.Name, strconv.FormatFloat(opts.HandshakeAge.Seconds(), 'f', -1, 64), unlessMaintenance),
+			duration: opts.TunnelDownFor,
+			labels:   severity,
+			annotations: [][2]string{
+				{"summary", "VPN tunnel " + tunnel + " hasn't handshook recently"},
+				{"description", fmt.Sprintf("The ends of the VPN tunnel last handshook {{ $value | humanizeDuration }} ago, more than %s, outside of any maintenance window.", promDuration(opts.HandshakeAge))},
+			},
+		},
+	}
+}
+
+// WriteRules writes a Prometheus rule file alerting on the metrics
+func WriteRules(w io.Writer, m *Metrics, opts Options) error {
+	return writeGroups(w, "", m, opts)
+}
+
+// WritePrometheusRule writes a PrometheusRule resource for the Prometheus Operator alerting on the metrics
+func WritePrometheusRule(w io.Writer, m *Metrics, opts Options) error {
+
+	if _, err := fmt.Fprintf(w, "apiVersion: monitoring.coreos.com/v1\nkind: PrometheusRule\nmetadata:\n  name: %s\nspec:\n", quote(opts.Name)); err != nil {
+		return err
+	}
+
+	return writeGroups(w, "  ", m, opts)
+}
+
+// writeGroups writes the rule group as YAML, with each line indented by the prefix
+func writeGroups(w io.Writer, indent string, m *Metrics, opts Options) error {
+
+	var b strings.Builder
+
+	line := func(format string, a ...interface{}) {
+		b.WriteString(indent)
+		b.WriteString(fmt.Sprintf(format, a...))
+		b.WriteString("\n")
+	}
+
+	line("groups:")
+	line("- name: %s", quote(opts.Name))
+	line("  rules:")
+
+	for _, r := range rules(m, opts) {
+		line("  - alert: %s", r.alert)
+		line("    expr: %s", quote(r.expr))
+		line("    for: %s", promDuration(r.duration))
+		line("    labels:")
+		for _, l := range r.labels {
+			line("      %s: %s", l[0], quote(l[1]))
+		}
+		line("    annotations:")
+		for _, a := range r.annotations {
+			line("      %s: %s", a[0], quote(a[1]))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// quote returns the string as a YAML double quoted scalar, which Go's quoting is compatible with
+func quote(s string) string {
+	return strconv.Quote(s)
+}
+
+// promDuration formats the duration the way Prometheus does, in its largest whole unit
+func promDuration(d time.Duration) string {
+
+	for _, unit := range []struct {
+		suffix string
+		length time.Duration
+	}{
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+	} {
+		if d >= unit.length && d%unit.length == 0 {
+			return fmt.Sprintf("%d%s", d/unit.length, unit.suffix)
+		}
+	}
+
+	if d == 0 {
+		return "0s"
+	}
+
+	return fmt.Sprintf("%dms", d/time.Millisecond)
+}
@@ -0,0 +1,249 @@
+// Package health works out whether vpnck is alive and ready to serve, for the liveness and readiness probes of
+// orchestrators such as Kubernetes
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/oklog/pkg/group"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check is the outcome of checking one thing vpnck needs
+type Check struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+
+	// Message explains the outcome, in particular why the check failed
+	Message string `json:"message"`
+}
+
+// Report is the outcome of all the checks of a probe, which is OK if they all are
+type Report struct {
+	OK     bool    `json:"ok"`
+	Checks []Check `json:"checks"`
+}
+
+// Checker follows polls through the pipeline, by wrapping the source polled and being updated at the end of the
+// pipeline, to work out whether vpnck is alive and ready. It runs as an actor in the run group too, answering pings
+// from the liveness probe to show the actor loops are responding.
+type Checker struct {
+	clock       state.Clock
+	staleAfter  time.Duration
+	started     time.Time
+	logger      log.Logger
+	cancel      chan struct{}
+	pings       chan chan struct{}
+	pingTimeout time.Duration
+
+	mutex       sync.Mutex
+	lastPoll    time.Time
+	lastSuccess time.Time
+	lastErr     error
+	lastUpdate  time.Time
+	stopped     error
+}
+
+// NewChecker returns a checker for a pipeline polling every interval. Polls and updates are late once they are three
+// intervals and a minute apart, allowing for slow sources and a poll or two going missing.
+func NewChecker(logger log.Logger, interval time.Duration, clock state.Clock) *Checker {
+	return &Checker{
+		clock:       clock,
+		staleAfter:  3*interval + time.Minute,
+		started:     clock.Now(),
+		logger:      log.With(logger, "actor", "health"),
+		cancel:      make(chan struct{}),
+		pings:       make(chan chan struct{}),
+		pingTimeout: 5 * time.Second,
+	}
+}
+
+// Source returns the source, noting the outcome whenever it's polled
+func (c *Checker) Source(source vpn.Source) vpn.Source {
+	return checkedSource{source: source, checker: c}
+}
+
+type checkedSource struct {
+	source  vpn.Source
+	checker *Checker
+}
+
+func (s checkedSource) Connections() ([]*vpn.Connection, error) {
+
+	connections, err := s.source.Connections()
+
+	c := s.checker
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lastPoll = c.clock.Now()
+	c.lastErr = err
+	if err == nil {
+		c.lastSuccess = c.lastPoll
+	}
+
+	return connections, err
+}
+
+// Update notes a poll made it through the pipeline at the supplied time, as an Updater of the stage at the end of it
+func (c *Checker) Update(connections []*vpn.Connection, timeStamp time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lastUpdate = timeStamp
+}
+
+// AddAsStage adds the checker to the run group, so it knows when the pipeline is stopping
+func (c *Checker) AddAsStage(group *group.Group) {
+	group.Add(c.Execute, c.Interrupt)
+}
+
+// Execute answers pings from the liveness probe until the pipeline stops
+func (c *Checker) Execute() error {
+	for {
+		select {
+		case reply := <-c.pings:
+			close(reply)
+		case <-c.cancel:
+			_ = level.Info(c.logger).Log("cancelled", "Asked to terminate")
+			return nil
+		}
+	}
+}
+
+// Interrupt notes the pipeline is stopping, as one of its stages has stopped or vpnck has been asked to terminate
+func (c *Checker) Interrupt(err error) {
+	_ = level.Info(c.logger).Log("interrupted", fmt.Sprintf("interrupted with %v", err))
+
+	c.mutex.Lock()
+	if err == nil {
+		err = errors.New("stopped")
+	}
+	c.stopped = err
+	c.mutex.Unlock()
+
+	close(c.cancel)
+}
+
+// Liveness checks the actor loops respond, and that vpnck is still polling, which stops if any stage of the pipeline
+// gets stuck
+func (c *Checker) Liveness() Report {
+
+	actors := c.ping()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.clock.Now()
+
+	last, what := c.lastPoll, "last polled"
+	if last.IsZero() {
+		last, what = c.started, "started without polling"
+	}
+
+	poller := Check{Name: "poller", OK: now.Sub(last) <= c.staleAfter, Message: fmt.Sprintf("%s %s ago", what, age(now, last))}
+	if !poller.OK {
+		poller.Message += fmt.Sprintf(", which is more than %s, so the pipeline looks stuck", c.staleAfter)
+	}
+
+	return report(actors, poller)
+}
+
+// ping checks the checker's actor loop answers a ping in time. Once the pipeline is stopping the loop has returned,
+// which is expected rather than a sign vpnck is stuck.
+func (c *Checker) ping() Check {
+
+	reply := make(chan struct{})
+	timeout := time.NewTimer(c.pingTimeout)
+	defer timeout.Stop()
+
+	select {
+	case c.pings <- reply:
+		<-reply
+		return Check{Name: "actors", OK: true, Message: "actor loop responded to a ping"}
+	case <-c.cancel:
+		return Check{Name: "actors", OK: true, Message: "actor loop has returned, as the pipeline is stopping"}
+	case <-timeout.C:
+		return Check{Name: "actors", OK: false, Message: fmt.Sprintf("actor loop didn't respond to a ping within %s, so vpnck looks stuck", c.pingTimeout)}
+	}
+}
+
+// Readiness checks vpnck has state to serve, that it's up to date, and that the pipeline that updates it is running
+func (c *Checker) Readiness() Report {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.clock.Now()
+
+	pipeline := Check{Name: "pipeline", OK: c.stopped == nil, Message: "all stages are running"}
+	if c.stopped != nil {
+		pipeline.Message = fmt.Sprintf("stopping: %v", c.stopped)
+	}
+
+	poll := Check{Name: "poll", OK: !c.lastSuccess.IsZero() && c.lastErr == nil}
+	switch {
+	case c.lastErr != nil:
+		poll.Message = fmt.Sprintf("last poll failed: %v", c.lastErr)
+	case c.lastSuccess.IsZero():
+		poll.Message = "no poll has succeeded yet"
+	default:
+		poll.Message = fmt.Sprintf("last poll succeeded %s ago", age(now, c.lastSuccess))
+	}
+
+	data := Check{Name: "data", OK: !c.lastUpdate.IsZero() && now.Sub(c.lastUpdate) <= c.staleAfter}
+	switch {
+	case c.lastUpdate.IsZero():
+		data.Message = "no poll has made it through the pipeline yet"
+	case !data.OK:
+		data.Message = fmt.Sprintf("last updated %s ago, which is more than %s", age(now, c.lastUpdate), c.staleAfter)
+	default:
+		data.Message = fmt.Sprintf("last updated %s ago", age(now, c.lastUpdate))
+	}
+
+	return report(pipeline, poll, data)
+}
+
+// LivenessHandler serves the liveness report as JSON, with status 503 if vpnck isn't alive
+func (c *Checker) LivenessHandler() http.Handler {
+	return reportHandler(c.Liveness)
+}
+
+// ReadinessHandler serves the readiness report as JSON, with status 503 if vpnck isn't ready
+func (c *Checker) ReadinessHandler() http.Handler {
+	return reportHandler(c.Readiness)
+}
+
+func reportHandler(probe func() Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		report := probe()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if !report.OK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		_ = json.NewEncoder(w).Encode(&report)
+	})
+}
+
+func report(checks ...Check) Report {
+	r := Report{OK: true, Checks: checks}
+	for _, check := range checks {
+		r.OK = r.OK && check.OK
+	}
+	return r
+}
+
+// age is how long before now the time was, to the second
+func age(now time.Time, t time.Time) time.Duration {
+	return now.Sub(t).Round(time.Second)
+}
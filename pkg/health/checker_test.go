@@ -0,0 +1,240 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2009, 11, 17, 20, 34, 58, 0, time.UTC)
+
+// after returns the time the supplied number of minutes after the start
+func after(minutes int) time.Time {
+	return start.Add(time.Duration(minutes) * time.Minute)
+}
+
+type movableClock struct {
+	now time.Time
+}
+
+func (c *movableClock) Now() time.Time { return c.now }
+
+// stubSource returns its error, or no connections
+type stubSource struct {
+	err error
+}
+
+func (s *stubSource) Connections() ([]*vpn.Connection, error) {
+	return nil, s.err
+}
+
+// event is something that happens to the pipeline, at a number of minutes after the start
+type event struct {
+	at      int
+	pollErr error
+	poll    bool
+	update  bool
+	stop    bool
+}
+
+var checkertests = []struct {
+	name    string
+	events  []event
+	now     int
+	stalled bool
+	alive   bool
+	ready   map[string]bool
+}{
+	{
+		name:  "Just started",
+		now:   1,
+		alive: true,
+		ready: map[string]bool{"pipeline": true, "poll": false, "data": false},
+	},
+	{
+		name:   "Polled but not through the pipeline yet",
+		events: []event{{at: 0, poll: true}},
+		now:    1,
+		alive:  true,
+		ready:  map[string]bool{"pipeline": true, "poll": true, "data": false},
+	},
+	{
+		name:   "Polled through the pipeline",
+		events: []event{{at: 0, poll: true}, {at: 0, update: true}, {at: 5, poll: true}, {at: 5, update: true}},
+		now:    6,
+		alive:  true,
+		ready:  map[string]bool{"pipeline": true, "poll": true, "data": true},
+	},
+	{
+		name:   "Poll failed",
+		events: []event{{at: 0, poll: true}, {at: 0, update: true}, {at: 5, poll: true, pollErr: errors.New("no credentials")}},
+		now:    6,
+		alive:  true,
+		ready:  map[string]bool{"pipeline": true, "poll": false, "data": true},
+	},
+	{
+		name:  "Never polled",
+		now:   17,
+		alive: false,
+		ready: map[string]bool{"pipeline": true, "poll": false, "data": false},
+	},
+	{
+		name:   "Stuck after polling",
+		events: []event{{at: 0, poll: true}, {at: 0, update: true}, {at: 5, poll: true}},
+		now:    22,
+		alive:  false,
+		ready:  map[string]bool{"pipeline": true, "poll": true, "data": false},
+	},
+	{
+		name: "Polls keep failing",
+		events: []event{
+			{at: 0, poll: true, pollErr: errors.New("no credentials")},
+			{at: 5, poll: true, pollErr: errors.New("no credentials")},
+			{at: 10, poll: true, pollErr: errors.New("no credentials")},
+			{at: 15, poll: true, pollErr: errors.New("no credentials")},
+			{at: 20, poll: true, pollErr: errors.New("no credentials")},
+		},
+		now:   22,
+		alive: true,
+		ready: map[string]bool{"pipeline": true, "poll": false, "data": false},
+	},
+	{
+		name:    "Actor loop not responding",
+		events:  []event{{at: 0, poll: true}, {at: 0, update: true}},
+		now:     1,
+		stalled: true,
+		alive:   false,
+		ready:   map[string]bool{"pipeline": true, "poll": true, "data": true},
+	},
+	{
+		name:   "Stopping",
+		events: []event{{at: 0, poll: true}, {at: 0, update: true}, {at: 1, stop: true}},
+		now:    2,
+		alive:  true,
+		ready:  map[string]bool{"pipeline": false, "poll": true, "data": true},
+	},
+}
+
+func TestChecker(t *testing.T) {
+
+	for _, tt := range checkertests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// Given a pipeline polling every 5 minutes
+			clock := &movableClock{now: start}
+			underTest := NewChecker(log.NewNopLogger(), 5*time.Minute, clock)
+			underTest.pingTimeout = 10 * time.Millisecond
+			if !tt.stalled {
+				go func() { _ = underTest.Execute() }()
+			}
+			source := &stubSource{}
+			checked := underTest.Source(source)
+
+			// When things happen to it
+			for _, e := range tt.events {
+				clock.now = after(e.at)
+				if e.poll {
+					source.err = e.pollErr
+					_, _ = checked.Connections()
+				}
+				if e.update {
+					underTest.Update(nil, clock.now)
+				}
+				if e.stop {
+					underTest.Interrupt(errors.New("received signal terminated"))
+				}
+			}
+			clock.now = after(tt.now)
+			if underTest.stopped == nil {
+				defer underTest.Interrupt(nil)
+			}
+
+			// Then it's alive and ready as expected
+			if liveness := underTest.Liveness(); liveness.OK != tt.alive {
+				t.Errorf("want alive %v; got %+v", tt.alive, liveness)
+			}
+
+			readiness := underTest.Readiness()
+			ready := true
+			for _, check := range readiness.Checks {
+				if want, ok := tt.ready[check.Name]; !ok || check.OK != want {
+					t.Errorf("want check %s to be %v; got %+v", check.Name, want, check)
+				}
+				if check.Message == "" {
+					t.Errorf("want check %s explained", check.Name)
+				}
+				ready = ready && tt.ready[check.Name]
+			}
+			if readiness.OK != ready || len(readiness.Checks) != len(tt.ready) {
+				t.Errorf("want ready %v from %d checks; got %+v", ready, len(tt.ready), readiness)
+			}
+		})
+	}
+}
+
+func TestHandlers(t *testing.T) {
+
+	// Given a pipeline that has only just started
+	clock := &movableClock{now: start}
+	underTest := NewChecker(log.NewNopLogger(), 5*time.Minute, clock)
+	go func() { _ = underTest.Execute() }()
+	defer underTest.Interrupt(nil)
+
+	for _, tt := range []struct {
+		name    string
+		handler http.Handler
+		status  int
+	}{
+		{"liveness", underTest.LivenessHandler(), http.StatusOK},
+		{"readiness", underTest.ReadinessHandler(), http.StatusServiceUnavailable},
+	} {
+
+		// When it's probed
+		w := httptest.NewRecorder()
+		tt.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		// Then the report is served as JSON with a status probes understand
+		if w.Code != tt.status {
+			t.Errorf("%s: want status %d; got %d", tt.name, tt.status, w.Code)
+		}
+
+		var report Report
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Errorf("%s: unable to decode report: %v", tt.name, err)
+			continue
+		}
+		if report.OK != (tt.status == http.StatusOK) || len(report.Checks) == 0 {
+			t.Errorf("%s: report incorrect, got %+v", tt.name, report)
+		}
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+
+	// Given a checker running in the run group
+	underTest := NewChecker(log.NewNopLogger(), 5*time.Minute, &movableClock{now: start})
+	done := make(chan error)
+	go func() { done <- underTest.Execute() }()
+
+	// When it's interrupted
+	underTest.Interrupt(nil)
+
+	// Then it returns
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("want no error; got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("want the checker to return when interrupted")
+	}
+
+	if underTest.Readiness().OK {
+		t.Error("want it no longer ready")
+	}
+}
@@ -0,0 +1,143 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AssetsPrefix is the path the assets are served under
+const AssetsPrefix = "/static/"
+
+// contentTypes are the types of assets the mime package doesn't know on every platform
+var contentTypes = map[string]string{
+	".css":   "text/css; charset=utf-8",
+	".woff2": "font/woff2",
+}
+
+// asset is a file served under a URL with the hash of its content, so it can be cached for as long as browsers will
+// and a changed asset is a different URL
+type asset struct {
+	content     []byte
+	hash        string
+	contentType string
+}
+
+// Assets serves the stylesheets and fonts of the HTML pages
+type Assets struct {
+	// urls are the URLs of the assets by their names
+	urls map[string]string
+
+	// served are the assets by their URLs
+	served map[string]*asset
+}
+
+// NewAssets returns the assets read from the file system, such as static.FS for those embedded in the binary.
+// References to other assets in stylesheets, such as url("fonts/open-sans-regular.woff2"), are rewritten to their
+// URLs, so stylesheets change when the assets they use do.
+func NewAssets(fsys fs.FS) (*Assets, error) {
+
+	var names []string
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			names = append(names, name)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the assets: %v", err)
+	}
+
+	// Stylesheets go last, so the URLs of the assets they use are known
+	sort.SliceStable(names, func(i, j int) bool {
+		return path.Ext(names[i]) != ".css" && path.Ext(names[j]) == ".css"
+	})
+
+	a := &Assets{urls: make(map[string]string), served: make(map[string]*asset)}
+
+	for _, name := range names {
+
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read asset %s: %v", name, err)
+		}
+
+		if path.Ext(name) == ".css" {
+			content = a.rewrite(path.Dir(name), content)
+		}
+
+		sum := sha256.Sum256(content)
+		hash := hex.EncodeToString(sum[:8])
+
+		ext := path.Ext(name)
+		url := AssetsPrefix + strings.TrimSuffix(name, ext) + "." + hash + ext
+
+		contentType, ok := contentTypes[ext]
+		if !ok {
+			contentType = mime.TypeByExtension(ext)
+		}
+
+		a.urls[name] = url
+		a.served[url] = &asset{content: content, hash: hash, contentType: contentType}
+	}
+
+	return a, nil
+}
+
+// rewrite replaces references in the stylesheet in the directory to the assets read so far under it with their URLs
+func (a *Assets) rewrite(dir string, content []byte) []byte {
+
+	var replacements []string
+	for name, url := range a.urls {
+		relative := name
+		if dir != "." {
+			if !strings.HasPrefix(name, dir+"/") {
+				continue
+			}
+			relative = strings.TrimPrefix(name, dir+"/")
+		}
+		for _, quote := range []string{`"`, `'`, ``} {
+			replacements = append(replacements, "url("+quote+relative+quote+")", "url("+quote+url+quote+")")
+		}
+	}
+
+	return []byte(strings.NewReplacer(replacements...).Replace(string(content)))
+}
+
+// URL returns the URL the named asset is served from, for the static function of the templates
+func (a *Assets) URL(name string) (string, error) {
+
+	url, ok := a.urls[name]
+	if !ok {
+		return "", fmt.Errorf("no asset named %s", name)
+	}
+
+	return url, nil
+}
+
+// ServeHTTP serves the asset at the URL. As the URL changes with the content, browsers and proxies are told to cache
+// it for a year without checking it again.
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	served, ok := a.served[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+served.hash+`"`)
+	if served.contentType != "" {
+		w.Header().Set("Content-Type", served.contentType)
+	}
+
+	http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(served.content))
+}
@@ -0,0 +1,160 @@
+package http
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/static"
+	"github.com/clearchannelinternational/vpncheck/templates"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var assettests = []struct {
+	name        string
+	asset       string
+	contentType string
+}{
+	{"stylesheet", "vpnck.css", "text/css; charset=utf-8"},
+	{"font", "fonts/open-sans-regular.woff2", "font/woff2"},
+	{"bold font", "fonts/open-sans-700.woff2", "font/woff2"},
+}
+
+func TestAssetsAreServedFromHashedURLs(t *testing.T) {
+
+	for _, tt := range assettests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// Given the assets built in
+			assets := embeddedAssets(t)
+			url, err := assets.URL(tt.asset)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// When the asset is requested from its URL
+			w := httptest.NewRecorder()
+			StateHandlers{Assets: assets}.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+
+			// Then the URL has the hash of the content
+			ext := tt.asset[strings.LastIndex(tt.asset, "."):]
+			want := "^/static/" + regexp.QuoteMeta(strings.TrimSuffix(tt.asset, ext)) + `\.[0-9a-f]{16}` + regexp.QuoteMeta(ext) + "$"
+			if !regexp.MustCompile(want).MatchString(url) {
+				t.Errorf("want a URL matching %s; got %s", want, url)
+			}
+
+			// And it's served to be cached for good
+			if w.Code != http.StatusOK {
+				t.Fatalf("want status 200; got %d", w.Code)
+			}
+			if got := w.Header().Get("Cache-Control"); got != "public, max-age=31536000, immutable" {
+				t.Errorf("want it cached for a year; got Cache-Control %q", got)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("want Content-Type %q; got %q", tt.contentType, got)
+			}
+			if w.Header().Get("ETag") == "" {
+				t.Error("want an ETag")
+			}
+		})
+	}
+}
+
+func TestAssetsAreOnlyServedFromHashedURLs(t *testing.T) {
+
+	// Given the assets built in
+	handler := StateHandlers{Assets: embeddedAssets(t)}.Handler()
+
+	for _, path := range []string{"/static/vpnck.css", "/static/fonts/open-sans-regular.woff2", "/static/static.go", "/static/"} {
+
+		// When an asset is requested without its hash
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+		// Then it's not found
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: want status 404; got %d", path, w.Code)
+		}
+	}
+}
+
+func TestStylesheetsLinkToAssetsByTheirURLs(t *testing.T) {
+
+	// Given a stylesheet referring to fonts next to it, however they are quoted
+	assets, err := NewAssets(fstest.MapFS{
+		"css/site.css":      {Data: []byte(`@font-face { src: url("fonts/a.woff2"), url('fonts/b.woff2'), url(fonts/c.woff2); }`)},
+		"css/fonts/a.woff2": {Data: []byte("a")},
+		"css/fonts/b.woff2": {Data: []byte("b")},
+		"css/fonts/c.woff2": {Data: []byte("c")},
+		"fonts/a.woff2":     {Data: []byte("not next to it")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When the stylesheet is served
+	url, _ := assets.URL("css/site.css")
+	w := httptest.NewRecorder()
+	assets.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+
+	// Then the fonts next to it are referred to by their URLs
+	for _, font := range []string{"css/fonts/a.woff2", "css/fonts/b.woff2", "css/fonts/c.woff2"} {
+		fontURL, _ := assets.URL(font)
+		if !strings.Contains(w.Body.String(), fontURL) {
+			t.Errorf("want %s in the stylesheet; got %s", fontURL, w.Body.String())
+		}
+	}
+}
+
+func TestPagesOnlyLoadFromVpnck(t *testing.T) {
+
+	// Given the templates and assets built in
+	assets := embeddedAssets(t)
+	tmpl, err := NewTemplates(templates.FS, assets, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers := StateHandlers{
+		State:     &state.State{Timestamp: time.Date(2009, 11, 17, 20, 34, 58, 0, time.UTC)},
+		Templates: tmpl,
+		Assets:    assets,
+	}
+
+	for _, page := range []string{"/", "/raw", "/api/state"} {
+
+		// When the page is requested
+		w := httptest.NewRecorder()
+		handlers.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, page, nil))
+
+		// Then the policy only lets it load from vpnck
+		if got := w.Header().Get("Content-Security-Policy"); !strings.HasPrefix(got, "default-src 'self';") {
+			t.Errorf("%s: want a policy allowing only vpnck by default; got %q", page, got)
+		}
+
+		// And it doesn't link anywhere else
+		for _, origin := range []string{"http://", "https://", "<style"} {
+			if strings.Contains(w.Body.String(), origin) {
+				t.Errorf("%s: want no %s in the page; got %s", page, origin, w.Body.String())
+			}
+		}
+	}
+
+	// And the index links the stylesheet by its URL
+	url, _ := assets.URL("vpnck.css")
+	w := httptest.NewRecorder()
+	handlers.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(w.Body.String(), `href="`+url+`"`) {
+		t.Errorf("want the stylesheet linked from %s; got %s", url, w.Body.String())
+	}
+}
+
+func embeddedAssets(t *testing.T) *Assets {
+	assets, err := NewAssets(static.FS)
+	if err != nil {
+		t.Fatalf("Unable to read the embedded assets: %v", err)
+	}
+	return assets
+}
@@ -0,0 +1,202 @@
+package http
+
+import (
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Health filters connections can be filtered by
+const (
+	HealthHealthy     = "healthy"
+	HealthUnhealthy   = "unhealthy"
+	HealthMaintenance = "maintenance"
+)
+
+// Orders connections can be sorted in
+const (
+	SortName       = "name"
+	SortHealth     = "health"
+	SortLastChange = "last-change"
+)
+
+// Filter picks the connections to show and the order to show them in, from the query parameters of a request so
+// filtered views can be linked to. The zero value shows every connection in the order the sources return them.
+type Filter struct {
+	// Name is part of the name or ID of the connection, in any case
+	Name string
+
+	// Tags are tags the connection must have as key=value, or just key for any value
+	Tags []string
+
+	// Health is one of the health filters, or empty for any health
+	Health string
+
+	Region  string
+	Account string
+
+	// Sort is one of the orders, or empty for the order the sources return connections in
+	Sort string
+}
+
+// parseFilter returns the filter from the query parameters q, tag, health, region, account and sort. Tag can be given
+// more than once.
+func parseFilter(query url.Values) (Filter, error) {
+
+	f := Filter{
+		Name:    strings.TrimSpace(query.Get("q")),
+		Health:  query.Get("health"),
+		Region:  query.Get("region"),
+		Account: query.Get("account"),
+		Sort:    query.Get("sort"),
+	}
+
+	for _, tag := range query["tag"] {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			f.Tags = append(f.Tags, tag)
+		}
+	}
+
+	switch f.Health {
+	case "", HealthHealthy, HealthUnhealthy, HealthMaintenance:
+	default:
+		return Filter{}, fmt.Errorf("unknown health %q, should be %s, %s or %s", f.Health, HealthHealthy, HealthUnhealthy, HealthMaintenance)
+	}
+
+	switch f.Sort {
+	case "", SortName, SortHealth, SortLastChange:
+	default:
+		return Filter{}, fmt.Errorf("unknown sort %q, should be %s, %s or %s", f.Sort, SortName, SortHealth, SortLastChange)
+	}
+
+	return f, nil
+}
+
+// Active is true if the filter leaves out any connections
+func (f Filter) Active() bool {
+	return f.Name != "" || len(f.Tags) > 0 || f.Health != "" || f.Region != "" || f.Account != ""
+}
+
+// Apply returns the connections the filter matches in its order, leaving the connections supplied as they are
+func (f Filter) Apply(connections []*vpn.Connection) []*vpn.Connection {
+
+	matched := make([]*vpn.Connection, 0, len(connections))
+	for _, conn := range connections {
+		if f.matches(conn) {
+			matched = append(matched, conn)
+		}
+	}
+
+	switch f.Sort {
+	case SortName:
+		sort.SliceStable(matched, func(i, j int) bool { return sortName(matched[i]) < sortName(matched[j]) })
+	case SortHealth:
+		sort.SliceStable(matched, func(i, j int) bool { return healthRank(matched[i]) < healthRank(matched[j]) })
+	case SortLastChange:
+		sort.SliceStable(matched, func(i, j int) bool { return lastChange(matched[i]).After(lastChange(matched[j])) })
+	}
+
+	return matched
+}
+
+func (f Filter) matches(conn *vpn.Connection) bool {
+
+	if f.Name != "" {
+		name := strings.ToLower(f.Name)
+		if !strings.Contains(strings.ToLower(conn.Name), name) && !strings.Contains(strings.ToLower(conn.ID), name) {
+			return false
+		}
+	}
+
+	for _, tag := range f.Tags {
+		parts := strings.SplitN(tag, "=", 2)
+		value, ok := conn.Tags[parts[0]]
+		if !ok || len(parts) == 2 && value != parts[1] {
+			return false
+		}
+	}
+
+	switch f.Health {
+	case HealthHealthy:
+		if !conn.Healthy() {
+			return false
+		}
+	case HealthUnhealthy:
+		if conn.Healthy() {
+			return false
+		}
+	case HealthMaintenance:
+		if !inMaintenance(conn) {
+			return false
+		}
+	}
+
+	if f.Region != "" && conn.Attribute(vpn.AttrRegion) != f.Region {
+		return false
+	}
+
+	if f.Account != "" && conn.Attribute(vpn.AttrAccountID) != f.Account {
+		return false
+	}
+
+	return true
+}
+
+// inMaintenance is true if the connection or any of its tunnels are in maintenance
+func inMaintenance(conn *vpn.Connection) bool {
+	if len(conn.Maintenance) > 0 {
+		return true
+	}
+	for _, tunnel := range conn.Tunnels {
+		if len(tunnel.Maintenance) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// sortName is the name of the connection to sort by, or its ID if it has no name
+func sortName(conn *vpn.Connection) string {
+	if conn.Name == "" {
+		return strings.ToLower(conn.ID)
+	}
+	return strings.ToLower(conn.Name)
+}
+
+// healthRank puts connections that are down first, then those that are up but unhealthy, then healthy ones
+func healthRank(conn *vpn.Connection) int {
+	switch {
+	case !conn.Up():
+		return 0
+	case !conn.Healthy():
+		return 1
+	default:
+		return 2
+	}
+}
+
+// lastChange is when the connection or any of its tunnels last went up or down, and is zero if not known
+func lastChange(conn *vpn.Connection) time.Time {
+
+	var last time.Time
+	consider := func(t time.Time) {
+		if t.After(last) {
+			last = t
+		}
+	}
+
+	if tl := conn.Timeline; tl != nil && len(tl.Transitions) > 0 {
+		consider(tl.Transitions[len(tl.Transitions)-1].Time)
+	}
+	for _, tunnel := range conn.Tunnels {
+		consider(tunnel.LastStatusChange)
+		if tl := tunnel.Timeline; tl != nil && len(tl.Transitions) > 0 {
+			consider(tl.Transitions[len(tl.Transitions)-1].Time)
+		}
+	}
+
+	return last
+}
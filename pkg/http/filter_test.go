@@ -0,0 +1,180 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/clearchannelinternational/vpncheck/templates"
+	"github.com/google/go-cmp/cmp"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// filterConnections are connections to filter, in the order sources return them
+func filterConnections() []*vpn.Connection {
+
+	changed := func(hours int) time.Time { return time.Date(2009, 11, 17, hours, 0, 0, 0, time.UTC) }
+
+	return []*vpn.Connection{
+		{
+			ID:         "vpn-1",
+			Name:       "London office",
+			Tags:       map[string]string{"Team": "payments", "aws:cloudformation:stack-name": "offices"},
+			Attributes: map[string]string{vpn.AttrRegion: "eu-west-1", vpn.AttrAccountID: "111111111111"},
+			Tunnels:    []*vpn.Tunnel{{Status: vpn.StatusUp, LastStatusChange: changed(1)}},
+		},
+		{
+			ID:         "vpn-2",
+			Name:       "Paris office",
+			Tags:       map[string]string{"Team": "search"},
+			Attributes: map[string]string{vpn.AttrRegion: "eu-west-3", vpn.AttrAccountID: "111111111111"},
+			Tunnels:    []*vpn.Tunnel{{Status: vpn.StatusDown, LastStatusChange: changed(3)}},
+		},
+		{
+			ID:          "vpn-3",
+			Name:        "berlin datacentre",
+			Tags:        map[string]string{"Team": "payments"},
+			Attributes:  map[string]string{vpn.AttrRegion: "eu-west-1", vpn.AttrAccountID: "222222222222"},
+			Tunnels:     []*vpn.Tunnel{{Status: vpn.StatusUp, LastStatusChange: changed(2)}},
+			Probes:      []*vpn.Probe{{Success: false}},
+			Maintenance: []*vpn.Maintenance{{Reason: "upgrade"}},
+		},
+		{
+			ID:      "vpn-4",
+			Tunnels: []*vpn.Tunnel{{Status: vpn.StatusUp}},
+		},
+	}
+}
+
+var filtertests = []struct {
+	name  string
+	query string
+	ids   []string
+}{
+	{name: "No filter", query: "", ids: []string{"vpn-1", "vpn-2", "vpn-3", "vpn-4"}},
+	{name: "Name in any case", query: "q=OFFICE", ids: []string{"vpn-1", "vpn-2"}},
+	{name: "ID", query: "q=vpn-4", ids: []string{"vpn-4"}},
+	{name: "Tag value", query: "tag=Team%3Dpayments", ids: []string{"vpn-1", "vpn-3"}},
+	{name: "Tag key with a colon", query: "tag=aws:cloudformation:stack-name", ids: []string{"vpn-1"}},
+	{name: "Tags", query: "tag=Team%3Dpayments&tag=aws:cloudformation:stack-name%3Doffices", ids: []string{"vpn-1"}},
+	{name: "Empty tag", query: "tag=", ids: []string{"vpn-1", "vpn-2", "vpn-3", "vpn-4"}},
+	{name: "Healthy", query: "health=healthy", ids: []string{"vpn-1", "vpn-4"}},
+	{name: "Unhealthy", query: "health=unhealthy", ids: []string{"vpn-2", "vpn-3"}},
+	{name: "In maintenance", query: "health=maintenance", ids: []string{"vpn-3"}},
+	{name: "Region", query: "region=eu-west-1", ids: []string{"vpn-1", "vpn-3"}},
+	{name: "Account", query: "account=111111111111", ids: []string{"vpn-1", "vpn-2"}},
+	{name: "Region and account", query: "region=eu-west-1&account=111111111111", ids: []string{"vpn-1"}},
+	{name: "Sorted by name, or ID without one", query: "sort=name", ids: []string{"vpn-3", "vpn-1", "vpn-2", "vpn-4"}},
+	{name: "Sorted by health", query: "sort=health", ids: []string{"vpn-2", "vpn-3", "vpn-1", "vpn-4"}},
+	{name: "Sorted by last change", query: "sort=last-change", ids: []string{"vpn-2", "vpn-3", "vpn-1", "vpn-4"}},
+	{name: "Filtered and sorted", query: "tag=Team&sort=name", ids: []string{"vpn-3", "vpn-1", "vpn-2"}},
+}
+
+func TestFilter(t *testing.T) {
+
+	for _, tt := range filtertests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// Given a filter from a query
+			query, _ := url.ParseQuery(tt.query)
+			filter, err := parseFilter(query)
+			if err != nil {
+				t.Fatalf("Unexpected error parsing %s: %v", tt.query, err)
+			}
+
+			// When it's applied
+			connections := filterConnections()
+			filtered := filter.Apply(connections)
+
+			// Then only the connections it matches are left, in its order
+			var ids []string
+			for _, conn := range filtered {
+				ids = append(ids, conn.ID)
+			}
+			if diff := cmp.Diff(tt.ids, ids); diff != "" {
+				t.Errorf("Connections incorrect (-want +got):\n%s", diff)
+			}
+
+			if connections[0].ID != "vpn-1" || len(connections) != 4 {
+				t.Error("The connections supplied should not be changed")
+			}
+		})
+	}
+}
+
+func TestFiltersThatArentValid(t *testing.T) {
+
+	for _, query := range []string{"health=poorly", "sort=size"} {
+
+		// Given a filter that isn't valid
+		handlers := StateHandlers{State: &state.State{Connections: filterConnections()}}
+
+		// When it's asked for
+		w := httptest.NewRecorder()
+		handlers.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/state?"+query, nil))
+
+		// Then it's refused
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: want status 400; got %d", query, w.Code)
+		}
+	}
+}
+
+func TestFilteredPages(t *testing.T) {
+
+	// Given some connections
+	assets := embeddedAssets(t)
+	tmpl, err := NewTemplates(templates.FS, assets, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers := StateHandlers{
+		State:     &state.State{Connections: filterConnections(), Timestamp: time.Date(2009, 11, 17, 20, 34, 58, 0, time.UTC)},
+		Templates: tmpl,
+		Assets:    assets,
+	}
+
+	// When the JSON API is filtered
+	w := httptest.NewRecorder()
+	handlers.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/state?region=eu-west-1&sort=name", nil))
+
+	// Then it only has the connections the filter picks
+	var got struct {
+		Connections []struct{ ID string }
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("unable to decode response: %v", err)
+	}
+	if len(got.Connections) != 2 || got.Connections[0].ID != "vpn-3" || got.Connections[1].ID != "vpn-1" {
+		t.Errorf("want vpn-3 and vpn-1; got %+v", got.Connections)
+	}
+
+	// When the index is filtered
+	w = httptest.NewRecorder()
+	handlers.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?health=unhealthy&region=eu-west-1", nil))
+	page := w.Body.String()
+
+	// Then it only has the connections the filter picks, with the filter filled in and linked to from the raw page
+	for _, want := range []string{
+		`>vpn-3</a>`,
+		`Showing 1 of 4 connections`,
+		`<option value="unhealthy" selected>`,
+		`<option selected>eu-west-1</option>`,
+		`<option>eu-west-3</option>`,
+		`<option>222222222222</option>`,
+		`href="/raw?health=unhealthy&amp;region=eu-west-1"`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("want %s in the page; got %s", want, page)
+		}
+	}
+	for _, unwanted := range []string{`>vpn-1</a>`, `>vpn-2</a>`, `>vpn-4</a>`} {
+		if strings.Contains(page, unwanted) {
+			t.Errorf("want %s filtered out of the page", unwanted)
+		}
+	}
+}
@@ -1,47 +1,138 @@
 package http
 
 import (
+	"crypto/subtle"
+	"encoding/json"
 	"fmt"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	vpn "github.com/clearchannelinternational/vpncheck/pkg/state"
-	"html/template"
+	"github.com/clearchannelinternational/vpncheck/pkg/acknowledge"
+	"github.com/clearchannelinternational/vpncheck/pkg/auth"
+	"github.com/clearchannelinternational/vpncheck/pkg/health"
+	"github.com/clearchannelinternational/vpncheck/pkg/maintenance"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 )
 
 type StateHandlers struct {
-	*vpn.State
-}
+	*state.State
+
+	// Maintenance holds the maintenance windows served by the API, which isn't served when it's nil
+	Maintenance *maintenance.Store
+
+	// Acknowledgements holds the acknowledgements of problems served by the API, which isn't served when it's nil
+	Acknowledgements *acknowledge.Store
+
+	// APIToken is the bearer token needed to change maintenance windows and acknowledgements through the API.
+	// Changes aren't allowed when it's empty.
+	APIToken string
+
+	// Templates renders the HTML pages
+	Templates *Templates
+
+	// Assets serves the stylesheets and fonts the HTML pages link to
+	Assets *Assets
+
+	// GroupBy is the tag connections are grouped by on the main page, which doesn't group them when it's empty
+	GroupBy string
+
+	// Health serves the liveness and readiness probes, which aren't served when it's nil
+	Health *health.Checker
 
-var templateFuncs = template.FuncMap{
-	"connectionName": getConnectionName,
+	// Auth authenticates users of everything but the stylesheets, fonts and probes, which anyone can use when it's nil
+	Auth auth.Authenticator
+
+	// Roles restrict which connections authenticated users see and what they can do with them. Users can see and do
+	// everything but change maintenance windows and acknowledgements when there are none.
+	Roles auth.Roles
 }
 
+// connectionsPrefix is the path the pages of each connection are served under
+const connectionsPrefix = "/connections/"
+
+// contentSecurityPolicy only lets pages load what vpnck serves itself, so they work without internet access and
+// nothing injected into them can load or send anything elsewhere
+const contentSecurityPolicy = "default-src 'self'; object-src 'none'; base-uri 'none'; form-action 'self'; frame-ancestors 'none'"
+
 func (s StateHandlers) Handler() http.Handler {
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/raw", s.rawHandler)
+	mux.HandleFunc(connectionsPrefix, s.connectionHandler)
+	mux.HandleFunc("/api/state", s.apiHandler)
+	if s.Maintenance != nil {
+		mux.HandleFunc("/api/maintenance", s.maintenanceHandler)
+		mux.HandleFunc("/api/maintenance/", s.maintenanceWindowHandler)
+	}
+	if s.Acknowledgements != nil {
+		mux.HandleFunc("/api/acknowledgements", s.acknowledgementsHandler)
+		mux.HandleFunc("/api/acknowledgements/", s.acknowledgementHandler)
+	}
 	mux.HandleFunc("/", s.defaultHandler)
-	return mux
+
+	// The stylesheets, fonts and probes don't reveal anything, so are served without logging in
+	public := http.NewServeMux()
+	if s.Assets != nil {
+		public.Handle(AssetsPrefix, s.Assets)
+	}
+	if s.Health != nil {
+		public.Handle("/healthz", s.Health.LivenessHandler())
+		public.Handle("/readyz", s.Health.ReadinessHandler())
+	}
+	public.Handle("/", s.authenticated(mux))
+
+	return securityHeaders(public)
+}
+
+// authenticated only lets requests through from users the authenticator lets in, if there is one. Requests with the
+// API token are let through too, so scripts can change maintenance windows without logging in.
+func (s StateHandlers) authenticated(next http.Handler) http.Handler {
+
+	if s.Auth == nil {
+		return next
+	}
+
+	wrapped := s.Auth.Wrap(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.hasAPIToken(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		wrapped.ServeHTTP(w, r)
+	})
+}
+
+// securityHeaders adds the Content-Security-Policy to every response
+func securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", contentSecurityPolicy)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		next.ServeHTTP(w, r)
+	})
 }
 
 func (s StateHandlers) rawHandler(w http.ResponseWriter, r *http.Request) {
 
-	t, err := template.ParseFiles("templates/raw.gohtml")
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Unable to read template file: %v", err), http.StatusInternalServerError)
+	visible, ok := s.permitted(w, r, auth.PermissionViewRaw)
+	if !ok {
+		return
+	}
+
+	_, connections, ok := s.filtered(w, r, visible)
+	if !ok {
 		return
 	}
 
 	var data = struct {
 		Timestamp   string
-		Connections []*ec2.VpnConnection
+		Connections []*vpn.Connection
 	}{
 		fmt.Sprintf("State recorded at %s:\n", s.Timestamp),
-		s.Connections,
+		connections,
 	}
-	if err := t.Execute(w, &data); err != nil {
+	if err := s.Templates.Execute(w, "raw.gohtml", &data); err != nil {
 		http.Error(w, fmt.Sprintf("Unable to render result: %v", err), http.StatusInternalServerError)
 	}
 	return
@@ -49,35 +140,507 @@ func (s StateHandlers) rawHandler(w http.ResponseWriter, r *http.Request) {
 
 func (s StateHandlers) defaultHandler(w http.ResponseWriter, r *http.Request) {
 
-	t, err := template.New("index.gohtml").Funcs(templateFuncs).ParseFiles("templates/index.gohtml")
+	visible, ok := s.permitted(w, r, auth.PermissionView)
+	if !ok {
+		return
+	}
 
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Unable to read template file: %v", err), http.StatusInternalServerError)
+	filter, connections, ok := s.filtered(w, r, visible)
+	if !ok {
 		return
 	}
 
+	// Only linked to for users who can see it
+	raw := rawURL(r)
+	if !s.access(r).Has(auth.PermissionViewRaw) {
+		raw = ""
+	}
+
+	var groups []*vpn.Group
+	if s.GroupBy != "" {
+		groups = vpn.GroupByTag(connections, s.GroupBy)
+	}
+
 	var data = struct {
 		Timestamp   time.Time
-		Connections []*ec2.VpnConnection
+		Connections []*vpn.Connection
+		GroupBy     string
+		Groups      []*vpn.Group
+		Filter      Filter
+		RawURL      string
+		Total       int
+		Regions     []string
+		Accounts    []string
 	}{
 		s.Timestamp,
-		s.Connections,
+		connections,
+		s.GroupBy,
+		groups,
+		filter,
+		raw,
+		len(visible),
+		attributeValues(visible, vpn.AttrRegion),
+		attributeValues(visible, vpn.AttrAccountID),
 	}
-	if err := t.Execute(w, &data); err != nil {
+	if err := s.Templates.Execute(w, "index.gohtml", &data); err != nil {
 		http.Error(w, fmt.Sprintf("Unable to render result: %v", err), http.StatusInternalServerError)
 	}
 	return
 }
 
-var noName = ""
+// filtered returns the connections picked from those supplied by the filter in the query of the request, writing an
+// error response if the filter isn't valid
+func (s StateHandlers) filtered(w http.ResponseWriter, r *http.Request, connections []*vpn.Connection) (Filter, []*vpn.Connection, bool) {
+
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return Filter{}, nil, false
+	}
+
+	return filter, filter.Apply(connections), true
+}
+
+// access returns what the user of the request can do. Everyone can do everything without roles, as can requests with
+// the API token.
+func (s StateHandlers) access(r *http.Request) auth.Access {
+
+	if len(s.Roles) == 0 || s.hasAPIToken(r) {
+		return auth.FullAccess()
+	}
+
+	user, ok := auth.FromContext(r.Context())
+	if !ok {
+		return auth.Access{}
+	}
+
+	return s.Roles.For(user)
+}
+
+// permitted returns the connections the user of the request has the permission for, writing an error response if
+// none of their roles grants it
+func (s StateHandlers) permitted(w http.ResponseWriter, r *http.Request, p auth.Permission) ([]*vpn.Connection, bool) {
+
+	access := s.access(r)
+	if !access.Has(p) {
+		http.Error(w, fmt.Sprintf("Forbidden: you need a role with the %s permission", p), http.StatusForbidden)
+		return nil, false
+	}
+
+	return access.Filter(p, s.Connections), true
+}
+
+// rawURL returns the URL of the raw version of the page, with the same filter
+func rawURL(r *http.Request) string {
+	if r.URL.RawQuery == "" {
+		return "/raw"
+	}
+	return "/raw?" + r.URL.RawQuery
+}
+
+// attributeValues returns the different values the connections have for the attribute in order, to filter them by
+func attributeValues(connections []*vpn.Connection, name string) []string {
+
+	seen := make(map[string]bool)
+	var values []string
+	for _, conn := range connections {
+		if value := conn.Attribute(name); value != "" && !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+
+	return values
+}
+
+// connectionHandler renders everything known about one connection, identified by the rest of the path
+func (s StateHandlers) connectionHandler(w http.ResponseWriter, r *http.Request) {
+
+	id := strings.TrimPrefix(r.URL.Path, connectionsPrefix)
+
+	// Connections users can't see are not found, rather than forbidden, so their IDs can't be probed for
+	var conn *vpn.Connection
+	for _, c := range s.access(r).Filter(auth.PermissionView, s.Connections) {
+		if c.ID == id {
+			conn = c
+			break
+		}
+	}
+
+	if conn == nil {
+		http.Error(w, fmt.Sprintf("No connection %s", id), http.StatusNotFound)
+		return
+	}
+
+	var data = struct {
+		Timestamp  time.Time
+		Connection *vpn.Connection
+	}{
+		s.Timestamp,
+		conn,
+	}
+	if err := s.Templates.Execute(w, "connection.gohtml", &data); err != nil {
+		http.Error(w, fmt.Sprintf("Unable to render result: %v", err), http.StatusInternalServerError)
+	}
+}
+
+func (s StateHandlers) apiHandler(w http.ResponseWriter, r *http.Request) {
+
+	visible, ok := s.permitted(w, r, auth.PermissionView)
+	if !ok {
+		return
+	}
+
+	_, connections, ok := s.filtered(w, r, visible)
+	if !ok {
+		return
+	}
+
+	var data = struct {
+		Timestamp   time.Time         `json:"timestamp"`
+		Connections []*vpn.Connection `json:"connections"`
+	}{
+		s.Timestamp,
+		connections,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&data); err != nil {
+		http.Error(w, fmt.Sprintf("Unable to render result: %v", err), http.StatusInternalServerError)
+	}
+	return
+}
+
+// maintenanceHandler lists the maintenance windows, or creates one from the JSON posted
+func (s StateHandlers) maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+
+	case http.MethodGet:
+		windows, ok := s.visibleWindows(w, r)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(windows); err != nil {
+			http.Error(w, fmt.Sprintf("Unable to render result: %v", err), http.StatusInternalServerError)
+		}
+
+	case http.MethodPost:
+		if !s.authorized(w, r, auth.PermissionManageMaintenance) {
+			return
+		}
+
+		// Users could otherwise be tricked into posting a form to create windows, with their basic auth credentials
+		if !s.hasAPIToken(r) && !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			http.Error(w, "Maintenance windows have to be posted as application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var window maintenance.Window
+		if err := json.NewDecoder(r.Body).Decode(&window); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid maintenance window: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if err := window.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid maintenance window: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if !s.canManage(w, r, &window) {
+			return
+		}
+
+		if err := s.Maintenance.Add(&window); err != nil {
+			http.Error(w, fmt.Sprintf("Unable to add maintenance window: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(&window)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// maintenanceWindowHandler deletes the maintenance window with the ID at the end of the path
+func (s StateHandlers) maintenanceWindowHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.authorized(w, r, auth.PermissionManageMaintenance) {
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/maintenance/")
+
+	windows, ok := s.visibleWindows(w, r)
+	if !ok {
+		return
+	}
+
+	var window *maintenance.Window
+	for _, visible := range windows {
+		if visible.ID == id {
+			window = visible
+		}
+	}
+	if window == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !s.canManage(w, r, window) {
+		return
+	}
+
+	deleted, err := s.Maintenance.Delete(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to delete maintenance window: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if !deleted {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorized checks the request has the API token, or is from a user with a role that grants the permission to make
+// changes, writing an error response if it isn't
+func (s StateHandlers) authorized(w http.ResponseWriter, r *http.Request, p auth.Permission) bool {
+
+	if _, ok := auth.FromContext(r.Context()); ok && len(s.Roles) > 0 {
+		if s.access(r).Has(p) {
+			return true
+		}
+		http.Error(w, fmt.Sprintf("Forbidden: you need a role with the %s permission", p), http.StatusForbidden)
+		return false
+	}
+
+	if s.APIToken == "" {
+		http.Error(w, "Changes through the API are disabled", http.StatusForbidden)
+		return false
+	}
 
-var getConnectionName = func(connection ec2.VpnConnection) *string {
+	if !s.hasAPIToken(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// hasAPIToken is whether the request has the API token as its bearer token
+func (s StateHandlers) hasAPIToken(r *http.Request) bool {
+
+	if s.APIToken == "" {
+		return false
+	}
+
+	header := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(header, "Bearer ")
+	return token != header && subtle.ConstantTimeCompare([]byte(token), []byte(s.APIToken)) == 1
+}
 
-	for _, tag := range connection.Tags {
-		if strings.ToLower(*tag.Key) == "name" {
-			return tag.Value
+// visibleWindows returns the maintenance windows that apply to connections the user of the request can see, writing
+// an error response if they can't see any connections
+func (s StateHandlers) visibleWindows(w http.ResponseWriter, r *http.Request) ([]*maintenance.Window, bool) {
+
+	access := s.access(r)
+	if !access.Has(auth.PermissionView) {
+		http.Error(w, fmt.Sprintf("Forbidden: you need a role with the %s permission", auth.PermissionView), http.StatusForbidden)
+		return nil, false
+	}
+
+	windows := s.Maintenance.Windows()
+	if len(s.Roles) == 0 || s.hasAPIToken(r) {
+		return windows, true
+	}
+
+	visible := access.Filter(auth.PermissionView, s.Connections)
+	picked := make([]*maintenance.Window, 0, len(windows))
+	for _, window := range windows {
+		if len(appliesTo(window, visible)) > 0 {
+			picked = append(picked, window)
 		}
 	}
 
-	return &noName
+	return picked, true
+}
+
+// canManage checks the user of the request can manage maintenance of every connection the window applies to, writing
+// an error response if they can't. Windows that don't apply to any connections can only be managed with full access,
+// as they could apply to connections of anyone once they exist.
+func (s StateHandlers) canManage(w http.ResponseWriter, r *http.Request, window *maintenance.Window) bool {
+
+	if len(s.Roles) == 0 || s.hasAPIToken(r) {
+		return true
+	}
+
+	access := s.access(r)
+	connections := appliesTo(window, s.Connections)
+	if len(connections) > 0 && len(access.Filter(auth.PermissionManageMaintenance, connections)) == len(connections) {
+		return true
+	}
+
+	http.Error(w, "Forbidden: the maintenance window applies to connections you can't manage maintenance of", http.StatusForbidden)
+	return false
+}
+
+// appliesTo returns the connections the window applies to, as a whole or to one of their tunnels
+func appliesTo(window *maintenance.Window, connections []*vpn.Connection) []*vpn.Connection {
+
+	var applies []*vpn.Connection
+	for _, conn := range connections {
+		if window.AppliesTo(conn, nil) {
+			applies = append(applies, conn)
+			continue
+		}
+		for _, tunnel := range conn.Tunnels {
+			if window.AppliesTo(conn, tunnel) {
+				applies = append(applies, conn)
+				break
+			}
+		}
+	}
+
+	return applies
+}
+
+// acknowledgementsHandler lists the acknowledgements, or acknowledges the problem with a connection from the JSON
+// posted
+func (s StateHandlers) acknowledgementsHandler(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+
+	case http.MethodGet:
+		visible, ok := s.permitted(w, r, auth.PermissionView)
+		if !ok {
+			return
+		}
+
+		acknowledgements := make([]*acknowledge.Acknowledgement, 0)
+		for _, a := range s.Acknowledgements.Acknowledgements() {
+			if find(visible, a.ConnectionID) != nil {
+				acknowledgements = append(acknowledgements, a)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(acknowledgements); err != nil {
+			http.Error(w, fmt.Sprintf("Unable to render result: %v", err), http.StatusInternalServerError)
+		}
+
+	case http.MethodPost:
+		if !s.authorized(w, r, auth.PermissionAcknowledge) {
+			return
+		}
+
+		// Users could otherwise be tricked into posting a form to acknowledge problems, with their basic auth credentials
+		if !s.hasAPIToken(r) && !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			http.Error(w, "Acknowledgements have to be posted as application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var acknowledgement acknowledge.Acknowledgement
+		if err := json.NewDecoder(r.Body).Decode(&acknowledgement); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid acknowledgement: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		conn, ok := s.acknowledgeable(w, r, acknowledgement.ConnectionID)
+		if !ok {
+			return
+		}
+
+		if conn.Healthy() {
+			http.Error(w, fmt.Sprintf("Connection %s is healthy, so has no problem to acknowledge", conn.ID), http.StatusConflict)
+			return
+		}
+
+		acknowledgement.By = "API token"
+		if user, ok := auth.FromContext(r.Context()); ok && !s.hasAPIToken(r) {
+			acknowledgement.By = user.Name
+		}
+		s.Acknowledgements.Add(&acknowledgement)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(&acknowledgement)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// acknowledgementHandler deletes the acknowledgement of the connection with the ID at the end of the path
+func (s StateHandlers) acknowledgementHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.authorized(w, r, auth.PermissionAcknowledge) {
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/acknowledgements/")
+
+	if _, ok := s.acknowledgeable(w, r, id); !ok {
+		return
+	}
+
+	if !s.Acknowledgements.Delete(id) {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// acknowledgeable returns the connection with the ID if the user of the request can acknowledge its problems, writing
+// an error response if they can't. Connections they can't see are not found, rather than forbidden.
+func (s StateHandlers) acknowledgeable(w http.ResponseWriter, r *http.Request, id string) (*vpn.Connection, bool) {
+
+	access := s.access(r)
+
+	conn := find(access.Filter(auth.PermissionView, s.Connections), id)
+	if conn == nil {
+		http.Error(w, fmt.Sprintf("No connection %s", id), http.StatusNotFound)
+		return nil, false
+	}
+
+	if find(access.Filter(auth.PermissionAcknowledge, s.Connections), id) == nil {
+		http.Error(w, fmt.Sprintf("Forbidden: you can't acknowledge problems with connection %s", id), http.StatusForbidden)
+		return nil, false
+	}
+
+	return conn, true
+}
+
+// find returns the connection with the ID, or nil if there isn't one
+func find(connections []*vpn.Connection, id string) *vpn.Connection {
+	for _, conn := range connections {
+		if conn.ID == id {
+			return conn
+		}
+	}
+	return nil
 }
@@ -1,56 +1,438 @@
 package http
 
 import (
-	"bytes"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"html/template"
+	"encoding/json"
+	"github.com/clearchannelinternational/vpncheck/pkg/acknowledge"
+	"github.com/clearchannelinternational/vpncheck/pkg/auth"
+	"github.com/clearchannelinternational/vpncheck/pkg/health"
+	"github.com/clearchannelinternational/vpncheck/pkg/maintenance"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/clearchannelinternational/vpncheck/templates"
+	"github.com/go-kit/kit/log"
+	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
-var nametests = []struct {
-	name  string
-	tags  []*ec2.Tag
-	truth string
+func TestApiHandler(t *testing.T) {
+
+	// Given some state
+	handlers := StateHandlers{State: &state.State{
+		Connections: []*vpn.Connection{
+			{
+				ID:         "vpn-1",
+				Attributes: map[string]string{"customer_gateway_ip": "1.2.3.4"},
+				Tunnels:    []*vpn.Tunnel{{OutsideIP: "5.6.7.8", Status: vpn.StatusUp}},
+				Raw:        map[string]string{"PreSharedKey": "secret"},
+			},
+		},
+		Timestamp: time.Date(2009, 11, 17, 20, 34, 58, 0, time.UTC),
+	}}
+
+	// When the API is called
+	w := httptest.NewRecorder()
+	handlers.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/state", nil))
+
+	// Then the state should be returned as JSON
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("want content type application/json; got %s", ct)
+	}
+
+	if body := w.Body.String(); strings.Contains(body, "PreSharedKey") {
+		t.Errorf("want no raw data; got %s", body)
+	}
+
+	var got struct {
+		Timestamp   time.Time `json:"timestamp"`
+		Connections []struct {
+			ID         string            `json:"id"`
+			Attributes map[string]string `json:"attributes"`
+			Tunnels    []struct {
+				OutsideIP string `json:"outside_ip"`
+				Status    string `json:"status"`
+			} `json:"tunnels"`
+		} `json:"connections"`
+	}
+
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Errorf("unable to decode response: %v", err)
+		return
+	}
+
+	if !got.Timestamp.Equal(handlers.Timestamp) {
+		t.Errorf("want timestamp %s; got %s", handlers.Timestamp, got.Timestamp)
+	}
+
+	if len(got.Connections) != 1 || got.Connections[0].ID != "vpn-1" || got.Connections[0].Attributes["customer_gateway_ip"] != "1.2.3.4" {
+		t.Errorf("want connection vpn-1 with customer gateway 1.2.3.4; got %+v", got.Connections)
+		return
+	}
+
+	if tunnels := got.Connections[0].Tunnels; len(tunnels) != 1 || tunnels[0].OutsideIP != "5.6.7.8" || tunnels[0].Status != "UP" {
+		t.Errorf("want 1 tunnel to 5.6.7.8 that is UP; got %+v", tunnels)
+	}
+}
+
+// fixedClock always returns the same time
+type fixedClock struct {
+	now time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.now
+}
+
+var maintenancetests = []struct {
+	name   string
+	token  string
+	method string
+	path   string
+	auth   string
+	body   string
+	status int
+	count  int
 }{
-	{name: "Pascal case", tags: []*ec2.Tag{asTag("Name", "blah")}, truth: "blah"},
-	{name: "No tags", tags: []*ec2.Tag{}, truth: ""},
-	{name: "No name tag", tags: []*ec2.Tag{asTag("foo", "bar")}, truth: ""},
-	{name: "Lower case", tags: []*ec2.Tag{asTag("name", "bar")}, truth: "bar"},
+	{name: "List", token: "secret", method: http.MethodGet, path: "/api/maintenance", status: http.StatusOK, count: 1},
+	{name: "Create", token: "secret", method: http.MethodPost, path: "/api/maintenance", auth: "Bearer secret",
+		body: `{"connection_id":"vpn-1","start":"2020-09-13T02:00:00Z","end":"2020-09-13T04:00:00Z","reason":"Upgrade"}`, status: http.StatusCreated, count: 2},
+	{name: "Create invalid", token: "secret", method: http.MethodPost, path: "/api/maintenance", auth: "Bearer secret",
+		body: `{"start":"2020-09-13T02:00:00Z","end":"2020-09-13T04:00:00Z"}`, status: http.StatusBadRequest, count: 1},
+	{name: "Create without token", token: "secret", method: http.MethodPost, path: "/api/maintenance",
+		body: `{"connection_id":"vpn-1","start":"2020-09-13T02:00:00Z","end":"2020-09-13T04:00:00Z"}`, status: http.StatusUnauthorized, count: 1},
+	{name: "Create with wrong token", token: "secret", method: http.MethodPost, path: "/api/maintenance", auth: "Bearer guess",
+		body: `{"connection_id":"vpn-1","start":"2020-09-13T02:00:00Z","end":"2020-09-13T04:00:00Z"}`, status: http.StatusUnauthorized, count: 1},
+	{name: "Create with token not as bearer", token: "secret", method: http.MethodPost, path: "/api/maintenance", auth: "secret",
+		body: `{"connection_id":"vpn-1","start":"2020-09-13T02:00:00Z","end":"2020-09-13T04:00:00Z"}`, status: http.StatusUnauthorized, count: 1},
+	{name: "Create when disabled", method: http.MethodPost, path: "/api/maintenance", auth: "Bearer ",
+		body: `{"connection_id":"vpn-1","start":"2020-09-13T02:00:00Z","end":"2020-09-13T04:00:00Z"}`, status: http.StatusForbidden, count: 1},
+	{name: "Delete", token: "secret", method: http.MethodDelete, path: "/api/maintenance/created", auth: "Bearer secret", status: http.StatusNoContent, count: 0},
+	{name: "Delete missing", token: "secret", method: http.MethodDelete, path: "/api/maintenance/missing", auth: "Bearer secret", status: http.StatusNotFound, count: 1},
+	{name: "Delete without token", token: "secret", method: http.MethodDelete, path: "/api/maintenance/created", status: http.StatusUnauthorized, count: 1},
 }
 
-func asTag(k string, v string) *ec2.Tag {
-	return &ec2.Tag{Key: aws.String(k), Value: aws.String(v)}
+func TestMaintenanceHandlers(t *testing.T) {
+
+	for _, tt := range maintenancetests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// Given a store with a window created through the API
+			dir, err := ioutil.TempDir("", "maintenance")
+			if err != nil {
+				t.Fatalf("Unable to create temp dir: %v", err)
+			}
+			t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+			file := filepath.Join(dir, "maintenance.json")
+			if err := ioutil.WriteFile(file, []byte(`[{"id":"created","tunnel_ip":"5.6.7.8","start":"2020-09-13T00:00:00Z","end":"2020-09-13T01:00:00Z"}]`), 0600); err != nil {
+				t.Fatal(err)
+			}
+			store, err := maintenance.NewStore(nil, file, fixedClock{time.Date(2020, 9, 13, 0, 0, 0, 0, time.UTC)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			handlers := StateHandlers{State: &state.State{}, Maintenance: store, APIToken: tt.token}
+
+			// When the API is called
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			handlers.Handler().ServeHTTP(w, r)
+
+			// Then the response should have the right status, and the store the right number of windows
+			if w.Code != tt.status {
+				t.Errorf("want status %d; got %d: %s", tt.status, w.Code, w.Body)
+			}
+
+			if count := len(store.Windows()); count != tt.count {
+				t.Errorf("want %d windows; got %d", tt.count, count)
+			}
+		})
+	}
 }
 
-func TestGetConnectionName(t *testing.T) {
-	testTemplate, err := template.New("test").Funcs(templateFuncs).Parse("{{connectionName .Connection}}")
+func TestHealthHandlers(t *testing.T) {
+
+	for _, tt := range []struct {
+		path   string
+		status int
+	}{
+		{path: "/healthz", status: http.StatusOK},
+		{path: "/readyz", status: http.StatusServiceUnavailable},
+	} {
+
+		// Given vpnck has only just started
+		checker := health.NewChecker(log.NewNopLogger(), time.Minute, fixedClock{now: time.Date(2009, 11, 17, 20, 34, 58, 0, time.UTC)})
+		go func() { _ = checker.Execute() }()
+		defer checker.Interrupt(nil)
+		handlers := StateHandlers{State: &state.State{}, Health: checker}
 
+		// When it's probed
+		w := httptest.NewRecorder()
+		handlers.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		// Then it's alive but not ready, explaining why as JSON
+		if w.Code != tt.status {
+			t.Errorf("%s: want status %d; got %d", tt.path, tt.status, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: want content type application/json; got %s", tt.path, ct)
+		}
+	}
+}
+
+// headerAuth lets in the user named by the X-User header, in the groups in the X-Groups header, as a stand in for
+// basic auth or OIDC
+type headerAuth struct{}
+
+func (headerAuth) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get("X-User")
+		if name == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		user := &auth.User{Name: name}
+		if groups := r.Header.Get("X-Groups"); groups != "" {
+			user.Groups = strings.Split(groups, ",")
+		}
+		next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), user)))
+	})
+}
+
+var authtests = []struct {
+	name   string
+	method string
+	path   string
+	user   string
+	token  string
+	status int
+}{
+	{name: "Page", method: http.MethodGet, path: "/", status: http.StatusUnauthorized},
+	{name: "Page logged in", method: http.MethodGet, path: "/", user: "alice", status: http.StatusOK},
+	{name: "Raw page", method: http.MethodGet, path: "/raw", status: http.StatusUnauthorized},
+	{name: "API", method: http.MethodGet, path: "/api/state", status: http.StatusUnauthorized},
+	{name: "API logged in", method: http.MethodGet, path: "/api/state", user: "alice", status: http.StatusOK},
+	{name: "API with token", method: http.MethodGet, path: "/api/state", token: "Bearer secret", status: http.StatusOK},
+	{name: "API with wrong token", method: http.MethodGet, path: "/api/state", token: "Bearer guess", status: http.StatusUnauthorized},
+	{name: "Liveness", method: http.MethodGet, path: "/healthz", status: http.StatusOK},
+	{name: "Readiness", method: http.MethodGet, path: "/readyz", status: http.StatusServiceUnavailable},
+}
+
+func TestAuthenticatedHandlers(t *testing.T) {
+
+	tmpl, err := NewTemplates(templates.FS, embeddedAssets(t), false)
 	if err != nil {
-		t.Errorf("Unable to create test testTemplate: %v", err)
-		return
+		t.Fatalf("Unable to parse the embedded templates: %v", err)
+	}
+
+	for _, tt := range authtests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// Given users need to log in
+			checker := health.NewChecker(log.NewNopLogger(), time.Minute, fixedClock{now: time.Date(2009, 11, 17, 20, 34, 58, 0, time.UTC)})
+			go func() { _ = checker.Execute() }()
+			defer checker.Interrupt(nil)
+			handlers := StateHandlers{State: &state.State{}, Templates: tmpl, APIToken: "secret", Health: checker, Auth: headerAuth{}}
+
+			// When a request is made
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.user != "" {
+				r.Header.Set("X-User", tt.user)
+			}
+			if tt.token != "" {
+				r.Header.Set("Authorization", tt.token)
+			}
+			w := httptest.NewRecorder()
+			handlers.Handler().ServeHTTP(w, r)
+
+			// Then only the probes are served without logging in or the API token
+			if w.Code != tt.status {
+				t.Errorf("want status %d; got %d: %s", tt.status, w.Code, w.Body)
+			}
+		})
 	}
+}
+
+var roletests = []struct {
+	name        string
+	method      string
+	path        string
+	groups      string
+	token       string
+	contentType string
+	body        string
+	status      int
+	contains    []string
+	excludes    []string
+	windows     int
+	// acknowledged are the connections with acknowledgements afterwards, which aren't checked when nil
+	acknowledged []string
+}{
+	{name: "Page", method: http.MethodGet, path: "/", groups: "payments", status: http.StatusOK,
+		contains: []string{"vpn-payments"}, excludes: []string{"vpn-search", "/raw"}},
+	{name: "Page with raw", method: http.MethodGet, path: "/", groups: "network", status: http.StatusOK,
+		contains: []string{"vpn-payments", "vpn-search", "/raw"}},
+	{name: "Page without a role", method: http.MethodGet, path: "/", groups: "marketing", status: http.StatusForbidden},
+	{name: "Raw page", method: http.MethodGet, path: "/raw", groups: "payments", status: http.StatusForbidden},
+	{name: "Raw page with raw", method: http.MethodGet, path: "/raw", groups: "network", status: http.StatusOK,
+		contains: []string{"psk-payments", "psk-search"}},
+	{name: "Connection page", method: http.MethodGet, path: connectionsPrefix + "vpn-payments", groups: "payments", status: http.StatusOK},
+	{name: "Connection page of another team", method: http.MethodGet, path: connectionsPrefix + "vpn-search", groups: "payments", status: http.StatusNotFound},
+	{name: "API", method: http.MethodGet, path: "/api/state", groups: "payments", status: http.StatusOK,
+		contains: []string{"vpn-payments"}, excludes: []string{"vpn-search", "psk-payments"}},
+	{name: "API with raw", method: http.MethodGet, path: "/api/state", groups: "network", status: http.StatusOK,
+		contains: []string{"vpn-payments", "vpn-search"}, excludes: []string{"psk-payments", "psk-search"}},
+	{name: "API with token", method: http.MethodGet, path: "/api/state", token: "Bearer secret", status: http.StatusOK,
+		contains: []string{"vpn-payments", "vpn-search"}, excludes: []string{"psk-payments", "psk-search"}},
+	{name: "List maintenance", method: http.MethodGet, path: "/api/maintenance", groups: "payments", status: http.StatusOK,
+		contains: []string{"payments-window"}, excludes: []string{"search-window"}},
+	{name: "Create maintenance", method: http.MethodPost, path: "/api/maintenance", groups: "payments", contentType: "application/json",
+		body: `{"tag":"Team=payments","start":"2020-09-13T02:00:00Z","end":"2020-09-13T04:00:00Z"}`, status: http.StatusCreated, windows: 3},
+	{name: "Create maintenance of another team", method: http.MethodPost, path: "/api/maintenance", groups: "payments", contentType: "application/json",
+		body: `{"connection_id":"vpn-search","start":"2020-09-13T02:00:00Z","end":"2020-09-13T04:00:00Z"}`, status: http.StatusForbidden, windows: 2},
+	{name: "Create maintenance of nothing", method: http.MethodPost, path: "/api/maintenance", groups: "payments", contentType: "application/json",
+		body: `{"tag":"Team=unknown","start":"2020-09-13T02:00:00Z","end":"2020-09-13T04:00:00Z"}`, status: http.StatusForbidden, windows: 2},
+	{name: "Create maintenance as a form", method: http.MethodPost, path: "/api/maintenance", groups: "payments", contentType: "application/x-www-form-urlencoded",
+		body: `{"tag":"Team=payments","start":"2020-09-13T02:00:00Z","end":"2020-09-13T04:00:00Z"}`, status: http.StatusUnsupportedMediaType, windows: 2},
+	{name: "Create maintenance without permission", method: http.MethodPost, path: "/api/maintenance", groups: "viewers", contentType: "application/json",
+		body: `{"tag":"Team=payments","start":"2020-09-13T02:00:00Z","end":"2020-09-13T04:00:00Z"}`, status: http.StatusForbidden, windows: 2},
+	{name: "Delete maintenance", method: http.MethodDelete, path: "/api/maintenance/payments-window", groups: "payments", status: http.StatusNoContent, windows: 1},
+	{name: "Delete maintenance of another team", method: http.MethodDelete, path: "/api/maintenance/search-window", groups: "payments", status: http.StatusNotFound, windows: 2},
+	{name: "Delete maintenance with token", method: http.MethodDelete, path: "/api/maintenance/search-window", token: "Bearer secret", status: http.StatusNoContent, windows: 1},
+	{name: "List acknowledgements", method: http.MethodGet, path: "/api/acknowledgements", groups: "payments", status: http.StatusOK,
+		excludes: []string{"bob"}},
+	{name: "List acknowledgements with token", method: http.MethodGet, path: "/api/acknowledgements", token: "Bearer secret", status: http.StatusOK,
+		contains: []string{"bob"}},
+	{name: "Acknowledge", method: http.MethodPost, path: "/api/acknowledgements", groups: "payments", contentType: "application/json",
+		body: `{"connection_id":"vpn-payments","comment":"On it"}`, status: http.StatusCreated, contains: []string{`"by":"alice"`}, acknowledged: []string{"vpn-payments", "vpn-search"}},
+	{name: "Acknowledge another team's", method: http.MethodPost, path: "/api/acknowledgements", groups: "payments", contentType: "application/json",
+		body: `{"connection_id":"vpn-search"}`, status: http.StatusNotFound, acknowledged: []string{"vpn-search"}},
+	{name: "Acknowledge without permission", method: http.MethodPost, path: "/api/acknowledgements", groups: "viewers", contentType: "application/json",
+		body: `{"connection_id":"vpn-payments"}`, status: http.StatusForbidden, acknowledged: []string{"vpn-search"}},
+	{name: "Acknowledge a connection the permission doesn't cover", method: http.MethodPost, path: "/api/acknowledgements", groups: "viewers,support", contentType: "application/json",
+		body: `{"connection_id":"vpn-search"}`, status: http.StatusForbidden, acknowledged: []string{"vpn-search"}},
+	{name: "Acknowledge a healthy connection", method: http.MethodPost, path: "/api/acknowledgements", token: "Bearer secret",
+		body: `{"connection_id":"vpn-search"}`, status: http.StatusConflict, acknowledged: []string{"vpn-search"}},
+	{name: "Acknowledge as a form", method: http.MethodPost, path: "/api/acknowledgements", groups: "payments", contentType: "application/x-www-form-urlencoded",
+		body: `{"connection_id":"vpn-payments"}`, status: http.StatusUnsupportedMediaType, acknowledged: []string{"vpn-search"}},
+	{name: "Delete acknowledgement of another team", method: http.MethodDelete, path: "/api/acknowledgements/vpn-search", groups: "payments", status: http.StatusNotFound, acknowledged: []string{"vpn-search"}},
+	{name: "Delete acknowledgement with token", method: http.MethodDelete, path: "/api/acknowledgements/vpn-search", token: "Bearer secret", status: http.StatusNoContent, acknowledged: []string{}},
+}
 
-	b := new(bytes.Buffer)
+func TestRoleHandlers(t *testing.T) {
 
-	for _, tt := range nametests {
+	tmpl, err := NewTemplates(templates.FS, embeddedAssets(t), false)
+	if err != nil {
+		t.Fatalf("Unable to parse the embedded templates: %v", err)
+	}
+
+	for _, tt := range roletests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			var data = struct {
-				Connection *ec2.VpnConnection
-			}{&ec2.VpnConnection{Tags: tt.tags}}
+			// Given connections of two teams, each with a maintenance window
+			dir, err := ioutil.TempDir("", "maintenance")
+			if err != nil {
+				t.Fatalf("Unable to create temp dir: %v", err)
+			}
+			t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+			file := filepath.Join(dir, "maintenance.json")
+			if err := ioutil.WriteFile(file, []byte(`[
+				{"id":"payments-window","connection_id":"vpn-payments","start":"2020-09-13T00:00:00Z","end":"2020-09-13T01:00:00Z"},
+				{"id":"search-window","tunnel_ip":"5.6.7.9","start":"2020-09-13T00:00:00Z","end":"2020-09-13T01:00:00Z"}
+			]`), 0600); err != nil {
+				t.Fatal(err)
+			}
+			store, err := maintenance.NewStore(nil, file, fixedClock{time.Date(2020, 9, 13, 0, 0, 0, 0, time.UTC)})
+			if err != nil {
+				t.Fatal(err)
+			}
 
-			b.Reset()
+			// And an acknowledged problem with one of them
+			acknowledgements := acknowledge.NewStore(fixedClock{time.Date(2020, 9, 13, 0, 0, 0, 0, time.UTC)})
+			acknowledgements.Add(&acknowledge.Acknowledgement{ConnectionID: "vpn-search", By: "bob"})
 
-			if err := testTemplate.Execute(b, &data); err != nil {
-				t.Errorf("errored incorrectly : %v", err)
-				return
+			// And roles that restrict users to the connections of their team
+			handlers := StateHandlers{
+				State: &state.State{Connections: []*vpn.Connection{
+					{
+						ID:      "vpn-payments",
+						Tags:    map[string]string{"Team": "payments"},
+						Tunnels: []*vpn.Tunnel{{OutsideIP: "5.6.7.8", Status: vpn.StatusDown}},
+						Raw:     map[string]string{"PreSharedKey": "psk-payments"},
+					},
+					{
+						ID:      "vpn-search",
+						Tags:    map[string]string{"Team": "search"},
+						Tunnels: []*vpn.Tunnel{{OutsideIP: "5.6.7.9", Status: vpn.StatusUp}},
+						Raw:     map[string]string{"PreSharedKey": "psk-search"},
+					},
+				}},
+				Templates:        tmpl,
+				Maintenance:      store,
+				Acknowledgements: acknowledgements,
+				APIToken:         "secret",
+				Auth:             headerAuth{},
+				Roles: auth.Roles{
+					{Name: "network", Groups: []string{"network"}, Permissions: []auth.Permission{auth.PermissionView, auth.PermissionViewRaw, auth.PermissionManageMaintenance}},
+					{Name: "payments", Groups: []string{"payments"}, Permissions: []auth.Permission{auth.PermissionView, auth.PermissionManageMaintenance, auth.PermissionAcknowledge}, Connections: []string{"Team=payments"}},
+					{Name: "viewers", Groups: []string{"viewers"}, Permissions: []auth.Permission{auth.PermissionView}},
+					{Name: "support", Groups: []string{"support"}, Permissions: []auth.Permission{auth.PermissionAcknowledge}, Connections: []string{"Team=payments"}},
+				},
 			}
 
-			if b.String() != tt.truth {
-				t.Errorf("want %s; got %s", tt.truth, b.String())
+			// When a user in some groups, or with the API token, makes a request
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.groups != "" {
+				r.Header.Set("X-User", "alice")
+				r.Header.Set("X-Groups", tt.groups)
+			}
+			if tt.token != "" {
+				r.Header.Set("Authorization", tt.token)
+			}
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
 			}
+			w := httptest.NewRecorder()
+			handlers.Handler().ServeHTTP(w, r)
 
+			// Then they only see, and change, what their roles let them
+			if w.Code != tt.status {
+				t.Errorf("want status %d; got %d: %s", tt.status, w.Code, w.Body)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("want %q in response", want)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(w.Body.String(), unwanted) {
+					t.Errorf("want no %q in response", unwanted)
+				}
+			}
+			if tt.windows != 0 {
+				if count := len(store.Windows()); count != tt.windows {
+					t.Errorf("want %d windows; got %d", tt.windows, count)
+				}
+			}
+			if tt.acknowledged != nil {
+				acknowledged := make([]string, 0)
+				for _, a := range acknowledgements.Acknowledgements() {
+					acknowledged = append(acknowledged, a.ConnectionID)
+				}
+				if !cmp.Equal(tt.acknowledged, acknowledged) {
+					t.Errorf("acknowledged connections incorrect: %s", cmp.Diff(tt.acknowledged, acknowledged))
+				}
+			}
 		})
 	}
 }
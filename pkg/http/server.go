@@ -0,0 +1,85 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/oklog/pkg/group"
+	stdlog "log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Timeouts of the servers, so slow or idle clients can't hold on to connections forever. Writes are allowed long
+// enough for the profiles of the debug server, which take 30 seconds by default.
+const (
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 30 * time.Second
+	writeTimeout      = time.Minute
+	idleTimeout       = 2 * time.Minute
+)
+
+// NewServer returns a server for the handler, with timeouts for reading requests, writing responses and idle
+// keep-alive connections
+func NewServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+}
+
+// AddServerStage adds a stage to the run group that serves on the listener, over TLS if the server has a TLS config.
+// When interrupted the server stops accepting connections and waits up to the drain timeout for requests in flight to
+// complete, before closing any connections still open.
+func AddServerStage(g *group.Group, logger log.Logger, server *http.Server, listener net.Listener, drain time.Duration) {
+	a := serverActor(logger, server, listener, drain)
+	g.Add(a.Execute, a.Interrupt)
+}
+
+func serverActor(logger log.Logger, server *http.Server, listener net.Listener, drain time.Duration) actor.Actor {
+
+	// Errors such as failed TLS handshakes are logged like everything else
+	server.ErrorLog = stdlog.New(log.NewStdlibAdapter(level.Warn(logger)), "", 0)
+
+	// Closed once requests in flight have drained, as Serve returns as soon as shutting down starts
+	drained := make(chan struct{})
+
+	return actor.NewActor(
+		func() error {
+			_ = logger.Log("addr", listener.Addr(), "tls", server.TLSConfig != nil)
+			serve := server.Serve
+			if server.TLSConfig != nil {
+				// The certificate comes from the TLS config rather than files
+				serve = func(l net.Listener) error { return server.ServeTLS(l, "", "") }
+			}
+			if err := serve(listener); err != http.ErrServerClosed {
+				return err
+			}
+			<-drained
+			_ = level.Info(logger).Log("cancelled", "Asked to terminate")
+			return nil
+		},
+		func(err error) {
+			_ = level.Info(logger).Log("interrupted", fmt.Sprintf("interrupted with %v", err))
+
+			// Drains in the background, so the other stages are interrupted meanwhile
+			go func() {
+				defer close(drained)
+
+				ctx, cancel := context.WithTimeout(context.Background(), drain)
+				defer cancel()
+
+				if err := server.Shutdown(ctx); err != nil {
+					_ = level.Warn(logger).Log("msg", fmt.Sprintf("Requests still in flight after %s, closing their connections", drain), "err", err)
+					_ = server.Close()
+				}
+			}()
+		},
+	)
+}
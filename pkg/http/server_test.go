@@ -0,0 +1,137 @@
+package http
+
+import (
+	"errors"
+	"github.com/go-kit/kit/log"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// slowHandler signals when a request has arrived, then responds once released
+type slowHandler struct {
+	arrived chan struct{}
+	release chan struct{}
+}
+
+func newSlowHandler() *slowHandler {
+	return &slowHandler{arrived: make(chan struct{}, 1), release: make(chan struct{})}
+}
+
+func (h *slowHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.arrived <- struct{}{}
+	<-h.release
+	_, _ = w.Write([]byte("done"))
+}
+
+// response is the outcome of a request
+type response struct {
+	body string
+	err  error
+}
+
+// serve runs the server stage for the handler, returning its address and the outcome of the stage
+func serve(t *testing.T, handler http.Handler, drain time.Duration) (string, func(error), <-chan error) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+
+	underTest := serverActor(log.NewNopLogger(), NewServer(handler), listener, drain)
+	done := make(chan error, 1)
+	go func() { done <- underTest.Execute() }()
+
+	return "http://" + listener.Addr().String(), underTest.Interrupt, done
+}
+
+// get requests the URL in the background
+func get(url string) <-chan response {
+	responses := make(chan response, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			responses <- response{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		responses <- response{body: string(body), err: err}
+	}()
+	return responses
+}
+
+func TestServerDrainsRequestsInFlight(t *testing.T) {
+
+	// Given a request in flight
+	handler := newSlowHandler()
+	url, interrupt, done := serve(t, handler, 5*time.Second)
+	responses := get(url)
+	<-handler.arrived
+
+	// When the server is asked to shut down
+	interrupt(errors.New("received signal terminated"))
+
+	// Then it stops accepting connections
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := http.Get(url); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("want new connections refused while draining")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// But it waits for the request in flight
+	select {
+	case err := <-done:
+		t.Fatalf("want the server to wait for the request in flight; returned %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Which completes
+	close(handler.release)
+	if r := <-responses; r.err != nil || r.body != "done" {
+		t.Errorf("want the request in flight to complete; got %+v", r)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("want no error; got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("want the server to return once drained")
+	}
+}
+
+func TestServerClosesRequestsAfterDrainTimeout(t *testing.T) {
+
+	// Given a request that never completes
+	handler := newSlowHandler()
+	defer close(handler.release)
+	url, interrupt, done := serve(t, handler, 100*time.Millisecond)
+	responses := get(url)
+	<-handler.arrived
+
+	// When the server is asked to shut down
+	interrupt(nil)
+
+	// Then the request is cut off once the drain timeout has passed
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("want no error; got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("want the server to return after the drain timeout")
+	}
+
+	if r := <-responses; r.err == nil {
+		t.Errorf("want the request cut off; got %+v", r)
+	}
+}
@@ -0,0 +1,113 @@
+package http
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// templateNames are the templates the HTML pages are rendered with
+var templateNames = []string{"index.gohtml", "connection.gohtml", "raw.gohtml"}
+
+// Templates renders the HTML pages. The templates are parsed once up front, so a template that doesn't parse is
+// found straight away rather than by the first request for the page.
+type Templates struct {
+	fsys     fs.FS
+	funcs    template.FuncMap
+	reload   bool
+	mutex    sync.Mutex
+	parsed   map[string]*template.Template
+	modTimes map[string]time.Time
+}
+
+// NewTemplates returns the templates parsed from the file system, such as templates.FS for those embedded in the
+// binary. Templates link to the assets with the static function, as in {{ static "vpnck.css" }}. When reload is true,
+// templates are parsed again if their files have changed since, for working on them.
+func NewTemplates(fsys fs.FS, assets *Assets, reload bool) (*Templates, error) {
+
+	t := &Templates{
+		fsys: fsys,
+		funcs: template.FuncMap{
+			"static":        assets.URL,
+			"connectionURL": connectionURL,
+			"sparkline":     sparkline,
+			"newestFirst":   newestFirst,
+		},
+		reload: reload,
+	}
+
+	if err := t.parse(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// parse parses every template, keeping the ones parsed before if any of them fail
+func (t *Templates) parse() error {
+
+	parsed := make(map[string]*template.Template)
+	modTimes := make(map[string]time.Time)
+
+	for _, name := range templateNames {
+
+		tmpl, err := template.New(name).Funcs(t.funcs).ParseFS(t.fsys, name)
+		if err != nil {
+			return fmt.Errorf("unable to parse template %s: %v", name, err)
+		}
+		parsed[name] = tmpl
+
+		if info, err := fs.Stat(t.fsys, name); err == nil {
+			modTimes[name] = info.ModTime()
+		}
+	}
+
+	t.parsed = parsed
+	t.modTimes = modTimes
+
+	return nil
+}
+
+// changed returns true if any of the template files have changed since they were parsed
+func (t *Templates) changed() bool {
+	for _, name := range templateNames {
+		info, err := fs.Stat(t.fsys, name)
+		if err != nil || !info.ModTime().Equal(t.modTimes[name]) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the parsed template, parsing them all again first if they are reloaded and have changed
+func (t *Templates) lookup(name string) (*template.Template, error) {
+
+	if !t.reload {
+		return t.parsed[name], nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.changed() {
+		if err := t.parse(); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.parsed[name], nil
+}
+
+// Execute renders the named template with the data
+func (t *Templates) Execute(w io.Writer, name string, data interface{}) error {
+
+	tmpl, err := t.lookup(name)
+	if err != nil {
+		return err
+	}
+
+	return tmpl.Execute(w, data)
+}
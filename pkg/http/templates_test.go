@@ -0,0 +1,275 @@
+package http
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/clearchannelinternational/vpncheck/templates"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPagesRenderWithEmbeddedTemplates(t *testing.T) {
+
+	// Given the templates and assets built in
+	assets := embeddedAssets(t)
+	tmpl, err := NewTemplates(templates.FS, assets, false)
+	if err != nil {
+		t.Fatalf("Unable to parse the embedded templates: %v", err)
+	}
+
+	handlers := StateHandlers{
+		State: &state.State{
+			Connections: []*vpn.Connection{
+				{
+					ID:      "vpn-1",
+					Name:    "London office",
+					Tunnels: []*vpn.Tunnel{{OutsideIP: "5.6.7.8", Status: vpn.StatusUp}},
+				},
+			},
+			Timestamp: time.Date(2009, 11, 17, 20, 34, 58, 0, time.UTC),
+		},
+		Templates: tmpl,
+		Assets:    assets,
+	}
+
+	for _, page := range []string{"/", "/raw", "/connections/vpn-1"} {
+		t.Run(page, func(t *testing.T) {
+
+			// When the page is requested
+			w := httptest.NewRecorder()
+			handlers.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, page, nil))
+
+			// Then it renders the connection
+			if w.Code != http.StatusOK {
+				t.Errorf("want status 200; got %d: %s", w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), "vpn-1") {
+				t.Errorf("want the connection in the page; got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestConnectionPage(t *testing.T) {
+
+	// Given a connection with everything known about it
+	assets := embeddedAssets(t)
+	tmpl, err := NewTemplates(templates.FS, assets, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timestamp := time.Date(2009, 11, 17, 20, 34, 58, 0, time.UTC)
+	handlers := StateHandlers{
+		State: &state.State{
+			Connections: []*vpn.Connection{
+				{ID: "vpn-0"},
+				{
+					ID:              "vpn-1",
+					Name:            "London office",
+					Tags:            map[string]string{"Team": "payments"},
+					Attributes:      map[string]string{"customer_gateway_ip": "9.9.9.9"},
+					Routes:          []*vpn.Route{{Destination: "10.0.0.0/16", State: "available", Origin: "Static"}},
+					Availability:    []*vpn.Availability{{Window: "24h", Percent: 99.5}},
+					Acknowledgement: &vpn.Acknowledgement{By: "alice", Comment: "Router rebooting", Time: timestamp},
+					Timeline:        &vpn.Timeline{Transitions: []*vpn.Transition{{Time: timestamp.Add(-3 * time.Hour), Up: true}, {Time: timestamp.Add(-2 * time.Hour), Unknown: true}, {Time: timestamp.Add(-time.Hour), Up: true}}, LastSeen: timestamp},
+					Tunnels: []*vpn.Tunnel{{
+						OutsideIP:  "5.6.7.8",
+						Status:     vpn.StatusUp,
+						Attributes: map[string]string{"ike_versions": "ikev2"},
+						Timeline:   &vpn.Timeline{Transitions: []*vpn.Transition{{Time: timestamp.Add(-84 * time.Hour), Up: false}}, LastSeen: timestamp},
+					}},
+				},
+			},
+			Timestamp: timestamp,
+		},
+		Templates: tmpl,
+		Assets:    assets,
+	}
+
+	// When its page is requested
+	w := httptest.NewRecorder()
+	handlers.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/connections/vpn-1", nil))
+
+	// Then it renders everything known about it
+	if w.Code != http.StatusOK {
+		t.Fatalf("want status 200; got %d: %s", w.Code, w.Body.String())
+	}
+	for _, want := range []string{
+		`London office`,
+		`Team`,
+		`9.9.9.9`,
+		`10.0.0.0/16`,
+		`99.500%`,
+		`ikev2`,
+		`<td>UNKNOWN</td>`,
+		`ACKNOWLEDGED`,
+		`Acknowledged by alice`,
+		`Router rebooting`,
+		`<rect class="sparkline-DOWN" x="50.0000" y="0" width="50.0000" height="10">`,
+		`href="/connections/vpn-1">permalink</a>`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("want %s in the page; got %s", want, w.Body.String())
+		}
+	}
+	if strings.Contains(w.Body.String(), "vpn-0") {
+		t.Error("want only the connection requested in the page")
+	}
+
+	// And the index links to it
+	w = httptest.NewRecorder()
+	handlers.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(w.Body.String(), `<a href="/connections/vpn-1">vpn-1</a>`) {
+		t.Errorf("want a link to the page of the connection; got %s", w.Body.String())
+	}
+
+	// And connections that don't exist aren't found
+	w = httptest.NewRecorder()
+	handlers.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/connections/vpn-2", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("want status 404 for an unknown connection; got %d", w.Code)
+	}
+}
+
+func TestGroupedIndex(t *testing.T) {
+
+	// Given connections grouped by team
+	assets := embeddedAssets(t)
+	tmpl, err := NewTemplates(templates.FS, assets, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers := StateHandlers{
+		State:     &state.State{Connections: filterConnections(), Timestamp: time.Date(2009, 11, 17, 20, 34, 58, 0, time.UTC)},
+		Templates: tmpl,
+		Assets:    assets,
+		GroupBy:   "Team",
+	}
+
+	for _, tt := range []struct {
+		query  string
+		groups []string
+	}{
+		// Groups that aren't all healthy are open
+		{"", []string{
+			`<details class="group" open>`,
+			`<summary>Team payments - 1/2 healthy <code class="state DOWN-telemetrystatus">UNHEALTHY</code></summary>`,
+			`<summary>Team search - 0/1 healthy`,
+			`<details class="group">`,
+			`<summary>Team ungrouped - 1/1 healthy <code class="state UP-telemetrystatus">HEALTHY</code></summary>`,
+		}},
+		// Groups are all open when filtered, and only have the connections the filter picks
+		{"?health=healthy", []string{
+			`<summary>Team payments - 1/1 healthy <code class="state UP-telemetrystatus">HEALTHY</code></summary>`,
+			`<details class="group" open>`,
+		}},
+	} {
+
+		// When the main page is requested
+		w := httptest.NewRecorder()
+		handlers.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+tt.query, nil))
+
+		// Then the connections are in collapsible groups with a summary of their health
+		page := w.Body.String()
+		for _, want := range tt.groups {
+			if !strings.Contains(page, want) {
+				t.Errorf("%s: want %s in the page; got %s", tt.query, want, page)
+			}
+		}
+		if strings.Index(page, "Team payments") > strings.Index(page, "Team ungrouped") {
+			t.Errorf("%s: want ungrouped connections last", tt.query)
+		}
+	}
+}
+
+func TestTemplatesThatDontParseFailUpFront(t *testing.T) {
+
+	// Given a template that doesn't parse
+	dir := templatesDir(t)
+	writeTemplate(t, dir, "index.gohtml", "{{ .Broken ", time.Now())
+
+	// When the templates are loaded
+	_, err := NewTemplates(os.DirFS(dir), embeddedAssets(t), false)
+
+	// Then they fail
+	if err == nil {
+		t.Error("want an error parsing the templates")
+	}
+}
+
+func TestTemplatesReload(t *testing.T) {
+
+	for _, reload := range []bool{false, true} {
+
+		// Given templates in a directory
+		dir := templatesDir(t)
+		modified := time.Now().Add(-time.Hour)
+		writeTemplate(t, dir, "index.gohtml", "before", modified)
+		writeTemplate(t, dir, "raw.gohtml", "raw", modified)
+
+		tmpl, err := NewTemplates(os.DirFS(dir), embeddedAssets(t), reload)
+		if err != nil {
+			t.Fatalf("Unable to parse templates: %v", err)
+		}
+
+		// When one changes
+		writeTemplate(t, dir, "index.gohtml", "after", modified.Add(time.Minute))
+
+		// Then the change is only rendered if they are reloaded
+		var rendered strings.Builder
+		if err := tmpl.Execute(&rendered, "index.gohtml", nil); err != nil {
+			t.Fatalf("Unable to render: %v", err)
+		}
+
+		want := "before"
+		if reload {
+			want = "after"
+		}
+		if rendered.String() != want {
+			t.Errorf("With reload %v, want %q; got %q", reload, want, rendered.String())
+		}
+
+		// When the change breaks it
+		writeTemplate(t, dir, "index.gohtml", "{{ .Broken ", modified.Add(2*time.Minute))
+
+		// Then rendering fails until it's fixed, if reloaded
+		err = tmpl.Execute(&strings.Builder{}, "index.gohtml", nil)
+		if reload && err == nil {
+			t.Error("want an error rendering a broken template")
+		}
+		if !reload && err != nil {
+			t.Errorf("want the template parsed up front to be rendered; got %v", err)
+		}
+	}
+}
+
+func templatesDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	for _, name := range templateNames {
+		writeTemplate(t, dir, name, name, time.Now())
+	}
+
+	return dir
+}
+
+func writeTemplate(t *testing.T, dir string, name string, content string, modified time.Time) {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"net/url"
+	"time"
+)
+
+// sparklineSpan is how far back the sparklines of tunnels go
+const sparklineSpan = 7 * 24 * time.Hour
+
+// segment is a stretch of a sparkline where the status stayed the same, positioned as a percentage of its width
+type segment struct {
+	X      float64
+	Width  float64
+	Status vpn.Status
+}
+
+// sparkline returns the segments of the timeline over the span before now. Time the status wasn't known for has no
+// segment.
+func sparkline(timeline *vpn.Timeline, now time.Time) []segment {
+
+	if timeline == nil {
+		return nil
+	}
+
+	start := now.Add(-sparklineSpan)
+	position := func(t time.Time) float64 {
+		if t.Before(start) {
+			t = start
+		}
+		if t.After(now) {
+			t = now
+		}
+		return 100 * float64(t.Sub(start)) / float64(sparklineSpan)
+	}
+
+	var segments []segment
+	for i, transition := range timeline.Transitions {
+
+		end := timeline.LastSeen
+		if i+1 < len(timeline.Transitions) {
+			end = timeline.Transitions[i+1].Time
+		}
+
+		x, width := position(transition.Time), position(end)-position(transition.Time)
+		if transition.Unknown || width <= 0 {
+			continue
+		}
+
+		status := vpn.StatusDown
+		if transition.Up {
+			status = vpn.StatusUp
+		}
+		segments = append(segments, segment{X: x, Width: width, Status: status})
+	}
+
+	return segments
+}
+
+// newestFirst returns the transitions of the timeline with the latest first
+func newestFirst(timeline *vpn.Timeline) []*vpn.Transition {
+
+	if timeline == nil {
+		return nil
+	}
+
+	transitions := make([]*vpn.Transition, len(timeline.Transitions))
+	for i, transition := range timeline.Transitions {
+		transitions[len(transitions)-1-i] = transition
+	}
+
+	return transitions
+}
+
+// connectionURL returns the path of the page of the connection, to link to and paste elsewhere
+func connectionURL(id string) string {
+	return connectionsPrefix + url.PathEscape(id)
+}
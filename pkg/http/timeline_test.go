@@ -0,0 +1,92 @@
+package http
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2009, 11, 17, 20, 34, 58, 0, time.UTC)
+
+// daysAgo returns the time the supplied number of days before now
+func daysAgo(days float64) time.Time {
+	return now.Add(-time.Duration(days * float64(24*time.Hour)))
+}
+
+var sparklinetests = []struct {
+	name     string
+	timeline *vpn.Timeline
+	segments []segment
+}{
+	{name: "No timeline", timeline: nil, segments: nil},
+	{
+		name:     "Up all week",
+		timeline: &vpn.Timeline{Transitions: []*vpn.Transition{{Time: daysAgo(10), Up: true}}, LastSeen: now},
+		segments: []segment{{X: 0, Width: 100, Status: vpn.StatusUp}},
+	},
+	{
+		name: "Down for a day",
+		timeline: &vpn.Timeline{
+			Transitions: []*vpn.Transition{{Time: daysAgo(7), Up: true}, {Time: daysAgo(3.5), Up: false}, {Time: daysAgo(2.1), Up: true}},
+			LastSeen:    now,
+		},
+		segments: []segment{{X: 0, Width: 50, Status: vpn.StatusUp}, {X: 50, Width: 20, Status: vpn.StatusDown}, {X: 70, Width: 30, Status: vpn.StatusUp}},
+	},
+	{
+		name:     "Not seen lately",
+		timeline: &vpn.Timeline{Transitions: []*vpn.Transition{{Time: daysAgo(3.5), Up: false}}, LastSeen: daysAgo(1.4)},
+		segments: []segment{{X: 50, Width: 30, Status: vpn.StatusDown}},
+	},
+	{
+		name: "Not known for a day",
+		timeline: &vpn.Timeline{
+			Transitions: []*vpn.Transition{{Time: daysAgo(7), Up: true}, {Time: daysAgo(3.5), Unknown: true}, {Time: daysAgo(2.1), Up: true}},
+			LastSeen:    now,
+		},
+		segments: []segment{{X: 0, Width: 50, Status: vpn.StatusUp}, {X: 70, Width: 30, Status: vpn.StatusUp}},
+	},
+	{
+		name:     "Not seen all week",
+		timeline: &vpn.Timeline{Transitions: []*vpn.Transition{{Time: daysAgo(10), Up: true}}, LastSeen: daysAgo(8)},
+		segments: nil,
+	},
+}
+
+func TestSparkline(t *testing.T) {
+
+	for _, tt := range sparklinetests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			segments := sparkline(tt.timeline, now)
+
+			if diff := cmp.Diff(tt.segments, segments, cmp.Comparer(func(x, y float64) bool { return x-y < 1e-9 && y-x < 1e-9 })); diff != "" {
+				t.Errorf("Segments incorrect (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewestFirst(t *testing.T) {
+
+	// Given a timeline
+	first, second := &vpn.Transition{Time: daysAgo(2), Up: true}, &vpn.Transition{Time: daysAgo(1)}
+	timeline := &vpn.Timeline{Transitions: []*vpn.Transition{first, second}}
+
+	// When the transitions are listed newest first
+	transitions := newestFirst(timeline)
+
+	// Then they are reversed, without changing the timeline
+	if diff := cmp.Diff([]*vpn.Transition{second, first}, transitions); diff != "" {
+		t.Errorf("Transitions incorrect (-want +got):\n%s", diff)
+	}
+	if timeline.Transitions[0] != first {
+		t.Error("The timeline should not be changed")
+	}
+}
+
+func TestConnectionURL(t *testing.T) {
+	if url := connectionURL("my vpn/1"); url != "/connections/my%20vpn%2F1" {
+		t.Errorf("want the ID escaped; got %s", url)
+	}
+}
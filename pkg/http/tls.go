@@ -0,0 +1,214 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLSOptions are how a listener serves TLS
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile is the path of a PEM bundle of the CAs client certificates must be signed by. Client certificates
+	// aren't asked for without one.
+	ClientCAFile string
+
+	// MinVersion is the minimum TLS version, e.g. 1.2
+	MinVersion string
+
+	// CipherSuites are the names of the cipher suites allowed up to TLS 1.2, e.g.
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Go's defaults are used when empty. Those of TLS 1.3 can't be configured.
+	CipherSuites []string
+}
+
+// Enabled is whether TLS is configured at all
+func (o TLSOptions) Enabled() bool {
+	return o.CertFile != "" || o.KeyFile != "" || o.ClientCAFile != ""
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewTLSConfig returns the config of a listener serving TLS. The certificate, key and client CA bundle are loaded
+// again whenever their files change, such as when cert-manager rotates them, so connections made after that use the
+// new ones. If they can't be loaded again, e.g. as they are only part way through being written, the old ones are
+// used until they can.
+func NewTLSConfig(logger log.Logger, options TLSOptions) (*tls.Config, error) {
+
+	if options.CertFile == "" || options.KeyFile == "" {
+		return nil, errors.New("TLS needs both a certificate and a key")
+	}
+
+	minVersion, ok := tlsVersions[options.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS version %q, should be one of %s", options.MinVersion, strings.Join(versionNames(), ", "))
+	}
+
+	cipherSuites, err := cipherSuiteIDs(options.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &certificates{certFile: options.CertFile, keyFile: options.KeyFile, caFile: options.ClientCAFile, logger: logger}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		GetCertificate: c.certificate,
+	}
+
+	if options.ClientCAFile != "" {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			clientConfig := config.Clone()
+			clientConfig.ClientCAs = c.clientCAs()
+			return clientConfig, nil
+		}
+	}
+
+	return config, nil
+}
+
+// certificates holds the certificate and client CA bundle loaded from files, and when they were last modified
+type certificates struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	logger   log.Logger
+
+	mutex    sync.Mutex
+	modified []time.Time
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+}
+
+// load loads the files if any have been modified since they were last loaded
+func (c *certificates) load() error {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var modified []time.Time
+	for _, name := range []string{c.certFile, c.keyFile, c.caFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		modified = append(modified, info.ModTime())
+	}
+
+	if sameTimes(modified, c.modified) {
+		return nil
+	}
+
+	// Only tried again once they change again
+	c.modified = modified
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load the TLS certificate: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if c.caFile != "" {
+		bundle, err := ioutil.ReadFile(c.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return fmt.Errorf("no certificates found in client CA bundle %s", c.caFile)
+		}
+	}
+
+	if c.cert != nil {
+		_ = level.Info(c.logger).Log("msg", "Loaded changed TLS certificate", "cert", c.certFile)
+	}
+	c.cert, c.pool = &cert, pool
+
+	return nil
+}
+
+// certificate returns the certificate, loading it again first if it's changed
+func (c *certificates) certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.reload()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.cert, nil
+}
+
+// clientCAs returns the client CA bundle, loading it again first if it's changed
+func (c *certificates) clientCAs() *x509.CertPool {
+	c.reload()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.pool
+}
+
+func (c *certificates) reload() {
+	if err := c.load(); err != nil {
+		_ = level.Warn(c.logger).Log("msg", "Unable to load changed TLS files, still using the old ones", "err", err)
+	}
+}
+
+func sameTimes(a []time.Time, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// cipherSuiteIDs returns the IDs of the named cipher suites, of those Go considers secure
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	var ids []uint16
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure TLS cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func versionNames() []string {
+	var names []string
+	for name := range tlsVersions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
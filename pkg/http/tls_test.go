@@ -0,0 +1,276 @@
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/go-kit/kit/log"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// issuer is a CA that issues certificates for the tests
+type issuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newIssuer(t *testing.T) *issuer {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	key, der := createCertificate(t, template, nil, nil)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("unable to parse CA certificate: %v", err)
+	}
+	return &issuer{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key for the name, for a server or a client
+func (i *issuer) issue(t *testing.T, name string, serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	key, der := createCertificate(t, template, i.cert, i.key)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unable to marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// createCertificate creates a key and a certificate for it, signed by the parent, or self-signed without one
+func createCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("unable to create certificate: %v", err)
+	}
+	return key, der
+}
+
+// writeFile writes the file, moving its modification time on so changes are seen however coarse the file system's
+// timestamps are
+func writeFile(t *testing.T, name string, data []byte, modified time.Time) {
+	if err := ioutil.WriteFile(name, data, 0600); err != nil {
+		t.Fatalf("unable to write %s: %v", name, err)
+	}
+	if err := os.Chtimes(name, modified, modified); err != nil {
+		t.Fatalf("unable to touch %s: %v", name, err)
+	}
+}
+
+// tlsClient returns a client trusting the CA, presenting the certificate if there is one
+func tlsClient(t *testing.T, ca *issuer, cert []byte, key []byte) *http.Client {
+	config := &tls.Config{RootCAs: x509.NewCertPool()}
+	config.RootCAs.AddCert(ca.cert)
+	if cert != nil {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			t.Fatalf("unable to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}, Timeout: 5 * time.Second}
+}
+
+// serveTLS serves over TLS with the config, returning the URL served at
+func serveTLS(t *testing.T, config *tls.Config) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	server := NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLSConfig = config
+	underTest := serverActor(log.NewNopLogger(), server, listener, time.Second)
+	go func() { _ = underTest.Execute() }()
+	t.Cleanup(func() { underTest.Interrupt(nil) })
+	return "https://" + listener.Addr().String()
+}
+
+// servedSerial returns the serial number of the certificate the server presents
+func servedSerial(t *testing.T, client *http.Client, url string) int64 {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+	_ = resp.Body.Close()
+	return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestTLSReloadsCertificate(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	// Given vpnck serving TLS with a certificate
+	ca := newIssuer(t)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	cert, key := ca.issue(t, "vpnck", 2, x509.ExtKeyUsageServerAuth)
+	modified := time.Now().Add(-time.Hour)
+	writeFile(t, certFile, cert, modified)
+	writeFile(t, keyFile, key, modified)
+
+	config, err := NewTLSConfig(log.NewNopLogger(), TLSOptions{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2"})
+	if err != nil {
+		t.Fatalf("unable to configure TLS: %v", err)
+	}
+	url := serveTLS(t, config)
+	client := tlsClient(t, ca, nil, nil)
+
+	if serial := servedSerial(t, client, url); serial != 2 {
+		t.Errorf("want certificate 2 served; got %d", serial)
+	}
+
+	// When only the certificate has been rotated so far
+	cert, key = ca.issue(t, "vpnck", 3, x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, cert, modified.Add(time.Minute))
+
+	// Then the old certificate is still served, as the new one doesn't match the key
+	if serial := servedSerial(t, client, url); serial != 2 {
+		t.Errorf("want certificate 2 still served; got %d", serial)
+	}
+
+	// When its key has been rotated too
+	writeFile(t, keyFile, key, modified.Add(time.Minute))
+
+	// Then the new certificate is served
+	if serial := servedSerial(t, client, url); serial != 3 {
+		t.Errorf("want certificate 3 served; got %d", serial)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	// Given vpnck serving TLS, requiring client certificates signed by a CA
+	ca, other := newIssuer(t), newIssuer(t)
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	cert, key := ca.issue(t, "vpnck", 2, x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, cert, time.Now())
+	writeFile(t, keyFile, key, time.Now())
+	writeFile(t, caFile, ca.pem, time.Now())
+
+	config, err := NewTLSConfig(log.NewNopLogger(), TLSOptions{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, MinVersion: "1.2"})
+	if err != nil {
+		t.Fatalf("unable to configure TLS: %v", err)
+	}
+	url := serveTLS(t, config)
+
+	prometheusCert, prometheusKey := ca.issue(t, "prometheus", 4, x509.ExtKeyUsageClientAuth)
+	strangerCert, strangerKey := other.issue(t, "stranger", 5, x509.ExtKeyUsageClientAuth)
+
+	for _, tt := range []struct {
+		name string
+		cert []byte
+		key  []byte
+		ok   bool
+	}{
+		{name: "Signed by the CA", cert: prometheusCert, key: prometheusKey, ok: true},
+		{name: "Signed by another CA", cert: strangerCert, key: strangerKey},
+		{name: "Without a certificate"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// When a client connects
+			resp, err := tlsClient(t, ca, tt.cert, tt.key).Get(url)
+
+			// Then only those with a certificate signed by the CA are let in
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+			if (err == nil) != tt.ok {
+				t.Errorf("want allowed %v; got error %v", tt.ok, err)
+			}
+		})
+	}
+}
+
+var tlsoptionstests = []struct {
+	name    string
+	options TLSOptions
+	valid   bool
+}{
+	{name: "Defaults", options: TLSOptions{CertFile: "tls.crt", KeyFile: "tls.key", MinVersion: "1.2"}, valid: true},
+	{name: "Cipher suites", options: TLSOptions{CertFile: "tls.crt", KeyFile: "tls.key", MinVersion: "1.2", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}}, valid: true},
+	{name: "Unknown version", options: TLSOptions{CertFile: "tls.crt", KeyFile: "tls.key", MinVersion: "2"}},
+	{name: "Unknown cipher suite", options: TLSOptions{CertFile: "tls.crt", KeyFile: "tls.key", MinVersion: "1.2", CipherSuites: []string{"TLS_MADE_UP"}}},
+	{name: "Insecure cipher suite", options: TLSOptions{CertFile: "tls.crt", KeyFile: "tls.key", MinVersion: "1.2", CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}},
+	{name: "Without a key", options: TLSOptions{CertFile: "tls.crt", MinVersion: "1.2"}},
+	{name: "Missing client CA bundle", options: TLSOptions{CertFile: "tls.crt", KeyFile: "tls.key", ClientCAFile: "ca.crt", MinVersion: "1.2"}},
+	{name: "Key as client CA bundle", options: TLSOptions{CertFile: "tls.crt", KeyFile: "tls.key", ClientCAFile: "tls.key", MinVersion: "1.2"}},
+}
+
+func TestTLSOptions(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	ca := newIssuer(t)
+	cert, key := ca.issue(t, "vpnck", 2, x509.ExtKeyUsageServerAuth)
+	writeFile(t, filepath.Join(dir, "tls.crt"), cert, time.Now())
+	writeFile(t, filepath.Join(dir, "tls.key"), key, time.Now())
+
+	for _, tt := range tlsoptionstests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// Given options for files in the directory
+			options := tt.options
+			for _, name := range []*string{&options.CertFile, &options.KeyFile, &options.ClientCAFile} {
+				if *name != "" {
+					*name = filepath.Join(dir, *name)
+				}
+			}
+
+			// When TLS is configured with them
+			_, err := NewTLSConfig(log.NewNopLogger(), options)
+
+			// Then it's only configured if they are valid
+			if (err == nil) != tt.valid {
+				t.Errorf("want valid %v; got error %v", tt.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package maintenance
+
+import (
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/metrics"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/oklog/pkg/group"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// annotator adds the maintenance going on to connections and tunnels, and publishes it as Prometheus metrics so
+// alerts can be silenced during it
+type annotator struct {
+	store                *Store
+	tunnelGaugeVec       *prometheus.GaugeVec
+	connectionGaugeVec   *prometheus.GaugeVec
+	publishedTunnels     map[string]prometheus.Labels
+	publishedConnections map[string]prometheus.Labels
+	logger               log.Logger
+}
+
+// NewAnnotator returns an instance ready to use, which takes maintenance windows from the store
+func NewAnnotator(registerer prometheus.Registerer, logger log.Logger, store *Store) *annotator {
+
+	a := annotator{
+		store: store,
+		tunnelGaugeVec: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "tunnel_maintenance",
+				Help: "If the VPN tunnel is in a maintenance window, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.",
+			},
+			metrics.TunnelLabelNames,
+		),
+		connectionGaugeVec: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "connection_maintenance",
+				Help: "If the VPN connection as a whole is in a maintenance window, partitioned by VPN Connection ID.",
+			},
+			[]string{"vpn_connection_id"},
+		),
+		publishedTunnels:     make(map[string]prometheus.Labels),
+		publishedConnections: make(map[string]prometheus.Labels),
+		logger:               log.With(logger, "actor", "maintenance"),
+	}
+
+	registerer.MustRegister(a.tunnelGaugeVec, a.connectionGaugeVec)
+
+	return &a
+}
+
+// AddAnnotatorStage adds a stage to the run group that sends the connections it receives to the next stage with any
+// maintenance going on. They're annotated and sent again as a repeat of their poll whenever a window starts or ends,
+// or windows are added or deleted, so maintenance doesn't wait for the next poll.
+func AddAnnotatorStage(group *group.Group, annotator *annotator, in <-chan state.Poll, out chan<- state.Poll) {
+
+	a := annotatorActor(annotator, in, out)
+	group.Add(a.Execute, a.Interrupt)
+
+}
+
+// annotatorActor adds maintenance to the connections received before sending them down the out channel, remembering
+// them to annotate and send again as a repeat when the maintenance going on changes
+func annotatorActor(annotator *annotator, in <-chan state.Poll, out chan<- state.Poll) actor.Actor {
+
+	cancel := make(chan struct{})
+
+	return actor.NewActor(
+		func() error {
+
+			wake := time.NewTimer(time.Hour)
+			wake.Stop()
+			defer wake.Stop()
+
+			var connections []*vpn.Connection
+
+			for {
+				var poll state.Poll
+
+				select {
+
+				case poll = <-in:
+					connections = poll.Connections
+
+				case <-wake.C:
+					poll = state.Poll{Connections: connections, Repeat: true}
+
+				case <-annotator.store.Changed():
+					if connections == nil {
+						continue
+					}
+					poll = state.Poll{Connections: connections, Repeat: true}
+
+				case <-cancel:
+					_ = level.Info(annotator.logger).Log("cancelled", "Asked to terminate")
+					return nil
+				}
+
+				annotated := annotator.Annotate(poll.Connections)
+				annotator.wakeAtNextChange(wake)
+
+				select {
+				case out <- poll.With(annotated):
+				case <-cancel:
+					_ = level.Info(annotator.logger).Log("cancelled", "Asked to terminate")
+					return nil
+				}
+			}
+		},
+		func(err error) {
+			_ = level.Info(annotator.logger).Log("interrupted", fmt.Sprintf("interrupted with %v", err))
+			close(cancel)
+		},
+	)
+
+}
+
+// Annotate returns copies of the connections and their tunnels with the maintenance going on now, and publishes it.
+// Tunnels include the maintenance of their connection.
+func (a *annotator) Annotate(connections []*vpn.Connection) []*vpn.Connection {
+
+	currentTunnels := make(map[string]prometheus.Labels)
+	currentConnections := make(map[string]prometheus.Labels)
+
+	annotated := make([]*vpn.Connection, len(connections))
+
+	for i, conn := range connections {
+
+		c := *conn
+		c.Maintenance = a.store.Active(conn, nil)
+
+		labels := prometheus.Labels{"vpn_connection_id": conn.ID}
+		a.connectionGaugeVec.With(labels).Set(gaugeValue(c.Maintenance))
+		currentConnections[fmt.Sprintf("%v", labels)] = labels
+
+		c.Tunnels = make([]*vpn.Tunnel, len(conn.Tunnels))
+		for j, tunnel := range conn.Tunnels {
+
+			copied := *tunnel
+			copied.Maintenance = a.store.Active(conn, tunnel)
+
+			labels := metrics.TunnelLabels(conn, tunnel)
+			a.tunnelGaugeVec.With(labels).Set(gaugeValue(copied.Maintenance))
+			currentTunnels[fmt.Sprintf("%v", labels)] = labels
+
+			c.Tunnels[j] = &copied
+		}
+
+		annotated[i] = &c
+	}
+
+	deleteRedundant(a.tunnelGaugeVec, a.publishedTunnels, currentTunnels)
+	deleteRedundant(a.connectionGaugeVec, a.publishedConnections, currentConnections)
+
+	a.publishedTunnels = currentTunnels
+	a.publishedConnections = currentConnections
+
+	return annotated
+}
+
+// wakeAtNextChange sets the timer to fire when the next window starts or ends, or stops it if none will
+func (a *annotator) wakeAtNextChange(wake *time.Timer) {
+
+	if !wake.Stop() {
+		select {
+		case <-wake.C:
+		default:
+		}
+	}
+
+	if next, ok := a.store.NextChange(); ok {
+		wake.Reset(next.Sub(a.store.clock.Now()))
+	}
+}
+
+func gaugeValue(maintenance []*vpn.Maintenance) float64 {
+	if len(maintenance) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func deleteRedundant(vec *prometheus.GaugeVec, published map[string]prometheus.Labels, current map[string]prometheus.Labels) {
+	for id, labels := range published {
+		if _, ok := current[id]; !ok {
+			vec.Delete(labels)
+		}
+	}
+}
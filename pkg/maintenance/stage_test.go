@@ -0,0 +1,207 @@
+package maintenance
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/metrics"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+const maintenanceMetadata = `
+	# HELP cc_vpn_connection_maintenance If the VPN connection as a whole is in a maintenance window, partitioned by VPN Connection ID.
+	# TYPE cc_vpn_connection_maintenance gauge
+	cc_vpn_connection_maintenance{vpn_connection_id="vpn-1"} 0
+	# HELP cc_vpn_tunnel_maintenance If the VPN tunnel is in a maintenance window, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.
+	# TYPE cc_vpn_tunnel_maintenance gauge
+	cc_vpn_tunnel_maintenance{outside_ip="1.1.1.1",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 1
+	cc_vpn_tunnel_maintenance{outside_ip="2.2.2.2",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 0
+`
+
+func TestAnnotate(t *testing.T) {
+
+	// Given a tunnel in a maintenance window
+	registry := prometheus.NewRegistry()
+	store, _ := NewStore([]*Window{{TunnelIP: "1.1.1.1", Start: at(0), End: at(2), Reason: "Upgrade"}}, "", &movableClock{now: at(1)})
+	underTest := NewAnnotator(metrics.DefaultNaming.Registerer(registry), log.NewNopLogger(), store)
+	sent := connection()
+
+	// When the connection is annotated
+	annotated := underTest.Annotate(sent)
+
+	// Then the maintenance should be added to copies of the connection and its tunnels
+	if annotated[0] == sent[0] || annotated[0].Tunnels[0] == sent[0].Tunnels[0] || sent[0].Tunnels[0].Maintenance != nil {
+		t.Errorf("Connections should have been copied, not changed")
+	}
+
+	if m := annotated[0].Maintenance; len(m) != 0 {
+		t.Errorf("Connection maintenance incorrect, got %v", m)
+	}
+
+	if m := annotated[0].Tunnels[0].Maintenance; len(m) != 1 || m[0].Reason != "Upgrade" {
+		t.Errorf("Tunnel maintenance incorrect, got %v", m)
+	}
+
+	// and published
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(maintenanceMetadata)); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+
+	// and removed when the connection goes away
+	underTest.Annotate([]*vpn.Connection{})
+
+	if err := testutil.GatherAndCompare(registry, strings.NewReader("")); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
+
+func TestAnnotatorStagePassesConnectionsOn(t *testing.T) {
+
+	in := make(chan state.Poll)
+	out := make(chan state.Poll)
+
+	store, _ := NewStore(nil, "", &movableClock{now: at(0)})
+	underTest := annotatorActor(NewAnnotator(prometheus.NewRegistry(), log.NewNopLogger(), store), in, out)
+	defer underTest.Interrupt(nil)
+
+	// When the stage is running
+	go func(a actor.Actor) {
+		_ = a.Execute()
+	}(underTest)
+
+	go func() { in <- state.Poll{Connections: connection()} }()
+
+	// Then the connections should be sent to the next stage in the pipeline
+	select {
+	case received := <-out:
+		if len(received.Connections) != 1 || received.Connections[0].ID != "vpn-1" {
+			t.Errorf("Data sent to next stage incorrect : got %v", received)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("Timed out waiting for connections to be passed down the pipeline")
+	}
+}
+
+func TestWindowStartingBetweenPolls(t *testing.T) {
+
+	// Given a window that starts shortly after a poll
+	in := make(chan state.Poll)
+	out := make(chan state.Poll)
+	registry := prometheus.NewRegistry()
+
+	start := time.Now().UTC().Add(100 * time.Millisecond)
+	store, _ := NewStore([]*Window{{TunnelIP: "1.1.1.1", Start: start, End: start.Add(time.Hour), Reason: "Upgrade"}}, "", state.NewUTCClock())
+	underTest := annotatorActor(NewAnnotator(metrics.DefaultNaming.Registerer(registry), log.NewNopLogger(), store), in, out)
+	defer underTest.Interrupt(nil)
+	go func(a actor.Actor) {
+		_ = a.Execute()
+	}(underTest)
+
+	go func() { in <- state.Poll{Connections: connection()} }()
+	if received := <-out; received.Repeat || len(received.Connections[0].Tunnels[0].Maintenance) != 0 {
+		t.Errorf("Expected the poll without maintenance, got %+v", received)
+	}
+
+	// When the window starts, before the next poll
+	// Then the connections are sent again as a repeat, with the maintenance
+	select {
+	case received := <-out:
+		if !received.Repeat || len(received.Connections[0].Tunnels[0].Maintenance) != 1 {
+			t.Errorf("Expected a repeat with the maintenance, got %+v", received)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timed out waiting for the connections to be sent again when the window started")
+	}
+
+	// and it's published
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(maintenanceMetadata)); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
+
+func TestWindowAddedBetweenPolls(t *testing.T) {
+
+	// Given connections that have been polled
+	in := make(chan state.Poll)
+	out := make(chan state.Poll)
+
+	store, _ := NewStore(nil, "", &movableClock{now: at(1)})
+	underTest := annotatorActor(NewAnnotator(prometheus.NewRegistry(), log.NewNopLogger(), store), in, out)
+	defer underTest.Interrupt(nil)
+	go func(a actor.Actor) {
+		_ = a.Execute()
+	}(underTest)
+
+	go func() { in <- state.Poll{Connections: connection()} }()
+	<-out
+
+	// When a window going on now is added through the API
+	if err := store.Add(&Window{ConnectionID: "vpn-1", Start: at(0), End: at(2), Reason: "Upgrade"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Then the connections are sent again as a repeat, with the maintenance
+	select {
+	case received := <-out:
+		if !received.Repeat || len(received.Connections[0].Maintenance) != 1 {
+			t.Errorf("Expected a repeat with the maintenance, got %+v", received)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("Timed out waiting for the connections to be sent again when the window was added")
+	}
+}
+
+var interruptests = []struct {
+	name  string
+	actor actor.Actor
+}{
+	{name: "Annotator", actor: annotatorActor(NewAnnotator(prometheus.NewRegistry(), log.NewNopLogger(), &Store{clock: &movableClock{}}), make(chan state.Poll), make(chan state.Poll))},
+}
+
+// Tests that the actors honour the contract as per https://github.com/oklog/run#run.
+// When the interrupt function is called the actor should return
+func TestInterrupt(t *testing.T) {
+
+	for _, tt := range interruptests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			underTest := tt.actor
+
+			// Run the actor.
+			errors := make(chan error)
+			go func(a actor.Actor) {
+				errors <- a.Execute()
+			}(underTest)
+
+			// Signal for the actor to stop
+			underTest.Interrupt(nil)
+
+			select {
+			case <-errors:
+				return
+			case <-time.After(1 * time.Second):
+			}
+
+			t.Error("actor didn't shut down in response to interrupt")
+
+		})
+	}
+}
+
+func connection() []*vpn.Connection {
+	return []*vpn.Connection{
+		{
+			ID:         "vpn-1",
+			Attributes: map[string]string{vpn.AttrVpnGatewayID: "vgw-1"},
+			Tunnels: []*vpn.Tunnel{
+				{ID: "1.1.1.1", OutsideIP: "1.1.1.1", Status: vpn.StatusUp},
+				{ID: "2.2.2.2", OutsideIP: "2.2.2.2", Status: vpn.StatusUp},
+			},
+		},
+	}
+}
@@ -0,0 +1,218 @@
+package maintenance
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/clearchannelinternational/vpncheck/pkg/sla"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// retention is how long windows created through the API are kept after they end, which covers the longest
+// availability window
+const retention = 32 * 24 * time.Hour
+
+// Store holds the maintenance windows from the config file, and those created through the API. Windows created
+// through the API are saved to a file if there is one, so they survive restarts. It's safe for concurrent use.
+type Store struct {
+	mutex      sync.RWMutex
+	configured []*Window
+	created    []*Window
+	file       string
+	clock      state.Clock
+	changed    chan struct{}
+}
+
+// NewStore returns a store of the configured windows, along with any previously created through the API and saved
+// to the file
+func NewStore(configured []*Window, file string, clock state.Clock) (*Store, error) {
+
+	for _, w := range configured {
+		if err := w.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &Store{configured: configured, file: file, clock: clock, changed: make(chan struct{}, 1)}
+
+	if file == "" {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &s.created); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Windows returns all the windows, configured ones first
+func (s *Store) Windows() []*Window {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return append(append([]*Window{}, s.configured...), s.created...)
+}
+
+// Add validates the window and adds it with a new ID, forgetting windows that ended long ago
+func (s *Store) Add(w *Window) error {
+
+	if err := w.Validate(); err != nil {
+		return err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	added := *w
+	added.ID = id
+	*w = added
+
+	cutoff := s.clock.Now().Add(-retention)
+	kept := make([]*Window, 0, len(s.created)+1)
+	for _, existing := range s.created {
+		if existing.End.After(cutoff) {
+			kept = append(kept, existing)
+		}
+	}
+
+	return s.save(append(kept, &added))
+}
+
+// Delete removes the window created through the API with the ID, which is false if there isn't one
+func (s *Store) Delete(id string) (bool, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	kept := make([]*Window, 0, len(s.created))
+	for _, w := range s.created {
+		if w.ID != id {
+			kept = append(kept, w)
+		}
+	}
+
+	if len(kept) == len(s.created) {
+		return false, nil
+	}
+
+	return true, s.save(kept)
+}
+
+// save saves the windows created through the API, and only keeps them if that works
+func (s *Store) save(created []*Window) error {
+
+	if s.file != "" {
+
+		data, err := json.Marshal(created)
+		if err != nil {
+			return err
+		}
+
+		tmp, err := ioutil.TempFile(filepath.Dir(s.file), filepath.Base(s.file)+".*")
+		if err != nil {
+			return err
+		}
+
+		_, err = tmp.Write(data)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), s.file)
+		}
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+			return err
+		}
+	}
+
+	s.created = created
+
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Changed returns a channel that receives when windows are added or deleted through the API, without waiting for a
+// receiver, so changes made in quick succession can be received once
+func (s *Store) Changed() <-chan struct{} {
+	return s.changed
+}
+
+// NextChange returns when the next window starts or ends after now, which is false if none will
+func (s *Store) NextChange() (time.Time, bool) {
+
+	now := s.clock.Now()
+
+	var next time.Time
+	for _, w := range s.Windows() {
+		for _, t := range []time.Time{w.Start, w.End} {
+			if t.After(now) && (next.IsZero() || t.Before(next)) {
+				next = t
+			}
+		}
+	}
+
+	return next, !next.IsZero()
+}
+
+// Active returns the maintenance going on now for the connection, or the tunnel of it when the tunnel isn't nil
+func (s *Store) Active(conn *vpn.Connection, tunnel *vpn.Tunnel) []*vpn.Maintenance {
+
+	now := s.clock.Now()
+
+	var active []*vpn.Maintenance
+	for _, w := range s.Windows() {
+		if w.Active(now) && w.AppliesTo(conn, tunnel) {
+			active = append(active, w.toMaintenance())
+		}
+	}
+
+	return active
+}
+
+// Exclusions returns the windows that apply to the connection, or the tunnel of it when the tunnel isn't nil, for
+// leaving out of availability calculations
+func (s *Store) Exclusions(conn *vpn.Connection, tunnel *vpn.Tunnel) []sla.Interval {
+
+	var excluded []sla.Interval
+	for _, w := range s.Windows() {
+		if w.AppliesTo(conn, tunnel) {
+			excluded = append(excluded, sla.Interval{Start: w.Start, End: w.End})
+		}
+	}
+
+	return excluded
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
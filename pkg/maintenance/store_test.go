@@ -0,0 +1,147 @@
+package maintenance
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/sla"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorePersistsCreatedWindows(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "maintenance")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "maintenance.json")
+	configured := []*Window{{Tag: "site=london", Start: at(0), End: at(1), Reason: "Configured"}}
+	clock := &movableClock{now: at(0)}
+
+	// Given a window created through the API
+	store, err := NewStore(configured, path, clock)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	created := &Window{ConnectionID: "vpn-1", Start: at(2), End: at(3), Reason: "Created"}
+	if err := store.Add(created); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if created.ID == "" {
+		t.Error("Created window should have been given an ID")
+	}
+
+	// When the store is loaded again
+	reloaded, err := NewStore(configured, path, clock)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Then it should have the configured and created windows
+	want := []*Window{configured[0], created}
+	if got := reloaded.Windows(); !cmp.Equal(want, got) {
+		t.Errorf("Windows incorrect: %s", cmp.Diff(want, got))
+	}
+
+	// and forget the created window once it's deleted
+	if deleted, err := reloaded.Delete(created.ID); !deleted || err != nil {
+		t.Errorf("Expected the window to be deleted, got %v and %v", deleted, err)
+	}
+
+	if deleted, _ := reloaded.Delete(created.ID); deleted {
+		t.Error("Expected the window to have gone already")
+	}
+
+	reloaded, _ = NewStore(configured, path, clock)
+	if got := reloaded.Windows(); len(got) != 1 {
+		t.Errorf("Expected only the configured window, got %v", got)
+	}
+}
+
+func TestStoreForgetsOldWindows(t *testing.T) {
+
+	// Given a window that ended long ago
+	clock := &movableClock{now: at(0)}
+	store, _ := NewStore(nil, "", clock)
+	_ = store.Add(&Window{ConnectionID: "vpn-1", Start: at(0), End: at(1)})
+
+	clock.now = at(1).Add(retention + 1)
+
+	// When another window is added
+	_ = store.Add(&Window{ConnectionID: "vpn-2", Start: clock.now, End: clock.now.Add(1)})
+
+	// Then the old window should be forgotten
+	if got := store.Windows(); len(got) != 1 || got[0].ConnectionID != "vpn-2" {
+		t.Errorf("Expected only the new window, got %v", got)
+	}
+}
+
+func TestNewStoreRejectsInvalidWindows(t *testing.T) {
+
+	if _, err := NewStore([]*Window{{Start: at(0), End: at(1)}}, "", &movableClock{}); err == nil {
+		t.Error("Expected an error for a window with no scope")
+	}
+}
+
+func TestActiveAndExclusions(t *testing.T) {
+
+	conn := &vpn.Connection{ID: "vpn-1", Tunnels: []*vpn.Tunnel{{OutsideIP: "1.1.1.1"}, {OutsideIP: "2.2.2.2"}}}
+	clock := &movableClock{now: at(1)}
+
+	// Given a window for the connection happening now, and one for a tunnel later on
+	store, _ := NewStore([]*Window{
+		{ConnectionID: "vpn-1", Start: at(1), End: at(2), Reason: "Now"},
+		{TunnelIP: "1.1.1.1", Start: at(3), End: at(4), Reason: "Later"},
+	}, "", clock)
+
+	// Then only the window happening now should be active, for the connection and its tunnels
+	if got := store.Active(conn, nil); len(got) != 1 || got[0].Reason != "Now" {
+		t.Errorf("Connection maintenance incorrect, got %v", got)
+	}
+
+	if got := store.Active(conn, conn.Tunnels[0]); len(got) != 1 || got[0].Reason != "Now" {
+		t.Errorf("Tunnel maintenance incorrect, got %v", got)
+	}
+
+	// and both windows should be excluded for the tunnel they apply to
+	want := []sla.Interval{{Start: at(1), End: at(2)}, {Start: at(3), End: at(4)}}
+	if got := store.Exclusions(conn, conn.Tunnels[0]); !cmp.Equal(want, got) {
+		t.Errorf("Tunnel exclusions incorrect: %s", cmp.Diff(want, got))
+	}
+
+	want = []sla.Interval{{Start: at(1), End: at(2)}}
+	if got := store.Exclusions(conn, conn.Tunnels[1]); !cmp.Equal(want, got) {
+		t.Errorf("Other tunnel exclusions incorrect: %s", cmp.Diff(want, got))
+	}
+}
+
+func TestNextChange(t *testing.T) {
+
+	clock := &movableClock{now: at(1)}
+	store, _ := NewStore([]*Window{
+		{ConnectionID: "vpn-1", Start: at(0), End: at(4)},
+		{ConnectionID: "vpn-2", Start: at(2), End: at(3)},
+	}, "", clock)
+
+	// The next change is the soonest start or end still to come
+	if next, ok := store.NextChange(); !ok || !next.Equal(at(2)) {
+		t.Errorf("want %s; got %s, %v", at(2), next, ok)
+	}
+
+	clock.now = at(3)
+	if next, ok := store.NextChange(); !ok || !next.Equal(at(4)) {
+		t.Errorf("want %s; got %s, %v", at(4), next, ok)
+	}
+
+	// and there isn't one once every window has ended
+	clock.now = at(4)
+	if next, ok := store.NextChange(); ok {
+		t.Errorf("want no change; got %s", next)
+	}
+}
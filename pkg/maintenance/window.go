@@ -0,0 +1,82 @@
+package maintenance
+
+import (
+	"errors"
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"strings"
+	"time"
+)
+
+// Window is a period of planned work on the connections or tunnels in its scope. A window applies to whatever
+// matches all the scope fields that are set.
+type Window struct {
+	// ID identifies windows created through the API, and is empty for those from the config file
+	ID string `json:"id,omitempty"`
+
+	// ConnectionID scopes the window to one connection
+	ConnectionID string `json:"connection_id,omitempty"`
+
+	// Tag scopes the window to connections with a tag, given as key=value
+	Tag string `json:"tag,omitempty"`
+
+	// TunnelIP scopes the window to tunnels with the outside IP
+	TunnelIP string `json:"tunnel_ip,omitempty"`
+
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason"`
+}
+
+// Validate returns an error if the window has no scope, or ends before it starts
+func (w *Window) Validate() error {
+
+	if w.ConnectionID == "" && w.Tag == "" && w.TunnelIP == "" {
+		return errors.New("maintenance window needs a connection_id, tag or tunnel_ip")
+	}
+
+	if w.Tag != "" && !strings.Contains(w.Tag, "=") {
+		return fmt.Errorf("maintenance window tag %q should be key=value", w.Tag)
+	}
+
+	if w.Start.IsZero() || w.End.IsZero() {
+		return errors.New("maintenance window needs a start and end")
+	}
+
+	if !w.End.After(w.Start) {
+		return errors.New("maintenance window has to end after it starts")
+	}
+
+	return nil
+}
+
+// Active is true if the window covers the supplied time
+func (w *Window) Active(now time.Time) bool {
+	return !now.Before(w.Start) && now.Before(w.End)
+}
+
+// AppliesTo is true if the connection, or the tunnel of it when the tunnel isn't nil, is in the scope of the window.
+// Windows scoped to a tunnel never apply to the connection as a whole.
+func (w *Window) AppliesTo(conn *vpn.Connection, tunnel *vpn.Tunnel) bool {
+
+	if w.ConnectionID != "" && w.ConnectionID != conn.ID {
+		return false
+	}
+
+	if w.Tag != "" {
+		parts := strings.SplitN(w.Tag, "=", 2)
+		if value, ok := conn.Tags[parts[0]]; !ok || value != parts[1] {
+			return false
+		}
+	}
+
+	if w.TunnelIP != "" {
+		return tunnel != nil && tunnel.OutsideIP == w.TunnelIP
+	}
+
+	return true
+}
+
+func (w *Window) toMaintenance() *vpn.Maintenance {
+	return &vpn.Maintenance{Reason: w.Reason, Start: w.Start, End: w.End}
+}
@@ -0,0 +1,103 @@
+package maintenance
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"testing"
+	"time"
+)
+
+var validatetests = []struct {
+	name   string
+	window Window
+	valid  bool
+}{
+	{name: "Connection", window: Window{ConnectionID: "vpn-1", Start: at(0), End: at(1)}, valid: true},
+	{name: "Tag", window: Window{Tag: "site=london", Start: at(0), End: at(1)}, valid: true},
+	{name: "Tunnel", window: Window{TunnelIP: "1.1.1.1", Start: at(0), End: at(1)}, valid: true},
+	{name: "No scope", window: Window{Start: at(0), End: at(1)}},
+	{name: "Tag without value", window: Window{Tag: "site", Start: at(0), End: at(1)}},
+	{name: "No start", window: Window{ConnectionID: "vpn-1", End: at(1)}},
+	{name: "No end", window: Window{ConnectionID: "vpn-1", Start: at(0)}},
+	{name: "Ends before it starts", window: Window{ConnectionID: "vpn-1", Start: at(1), End: at(0)}},
+}
+
+func TestValidate(t *testing.T) {
+
+	for _, tt := range validatetests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			err := tt.window.Validate()
+
+			if tt.valid && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+
+			if !tt.valid && err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+var appliestotests = []struct {
+	name       string
+	window     Window
+	connection bool
+	tunnel     bool
+	other      bool
+}{
+	{name: "Connection", window: Window{ConnectionID: "vpn-1"}, connection: true, tunnel: true, other: true},
+	{name: "Other connection", window: Window{ConnectionID: "vpn-2"}},
+	{name: "Tag", window: Window{Tag: "site=london"}, connection: true, tunnel: true, other: true},
+	{name: "Other tag value", window: Window{Tag: "site=paris"}},
+	{name: "Missing tag", window: Window{Tag: "region=eu"}},
+	{name: "Tunnel", window: Window{TunnelIP: "1.1.1.1"}, tunnel: true},
+	{name: "Connection and tunnel", window: Window{ConnectionID: "vpn-1", TunnelIP: "1.1.1.1"}, tunnel: true},
+	{name: "Other connection and tunnel", window: Window{ConnectionID: "vpn-2", TunnelIP: "1.1.1.1"}},
+}
+
+func TestAppliesTo(t *testing.T) {
+
+	conn := &vpn.Connection{
+		ID:      "vpn-1",
+		Tags:    map[string]string{"site": "london"},
+		Tunnels: []*vpn.Tunnel{{OutsideIP: "1.1.1.1"}, {OutsideIP: "2.2.2.2"}},
+	}
+
+	for _, tt := range appliestotests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			if got := tt.window.AppliesTo(conn, nil); got != tt.connection {
+				t.Errorf("Applies to connection: want %v; got %v", tt.connection, got)
+			}
+
+			if got := tt.window.AppliesTo(conn, conn.Tunnels[0]); got != tt.tunnel {
+				t.Errorf("Applies to tunnel: want %v; got %v", tt.tunnel, got)
+			}
+
+			if got := tt.window.AppliesTo(conn, conn.Tunnels[1]); got != tt.other {
+				t.Errorf("Applies to other tunnel: want %v; got %v", tt.other, got)
+			}
+		})
+	}
+}
+
+func TestActive(t *testing.T) {
+
+	w := Window{ConnectionID: "vpn-1", Start: at(1), End: at(2)}
+
+	if w.Active(at(0)) || !w.Active(at(1)) || w.Active(at(2)) {
+		t.Error("Window should only be active from its start until its end")
+	}
+}
+
+// at returns a time the supplied number of hours into the test
+func at(hours int) time.Time {
+	return time.Date(2020, 9, 13, hours, 0, 0, 0, time.UTC)
+}
+
+type movableClock struct {
+	now time.Time
+}
+
+func (c *movableClock) Now() time.Time { return c.now }
@@ -0,0 +1,315 @@
+package metrics
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/source/awsvpn"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/oklog/pkg/group"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+const (
+	// The CloudWatch namespace AWS publishes site to site VPN metrics in
+	vpnNamespace = "AWS/VPN"
+
+	// The most tunnels we ask about in one GetMetricData call, which allows up to 500 queries
+	maxTunnelsPerRequest = 100
+)
+
+// cloudWatchTunnel is a tunnel we fetch CloudWatch metrics for
+type cloudWatchTunnel struct {
+	id     string
+	ip     string
+	labels prometheus.Labels
+}
+
+// cloudWatchPoller fetches the AWS/VPN CloudWatch metrics for tunnels and publishes them as Prometheus metrics.
+type cloudWatchPoller struct {
+	svc        cloudwatchiface.CloudWatchAPI
+	clock      state.Clock
+	idle       time.Duration
+	dataIn     *prometheus.GaugeVec
+	dataOut    *prometheus.GaugeVec
+	suspicious *prometheus.GaugeVec
+	tunnels    []cloudWatchTunnel
+	published  map[string]prometheus.Labels
+	logger     log.Logger
+}
+
+// NewCloudWatchPoller returns an instance ready to use. A tunnel is flagged as suspicious if CloudWatch reports it as
+// UP but no traffic has passed through it for the idle duration.
+func NewCloudWatchPoller(registerer prometheus.Registerer, logger log.Logger, svc cloudwatchiface.CloudWatchAPI, clock state.Clock, idle time.Duration) *cloudWatchPoller {
+
+	p := cloudWatchPoller{
+		svc:   svc,
+		clock: clock,
+		idle:  idle,
+		dataIn: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "tunnel_data_in_bytes",
+				Help: "Bytes received through the site to site VPN tunnel in the last CloudWatch period, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.",
+			},
+			TunnelLabelNames,
+		),
+		dataOut: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "tunnel_data_out_bytes",
+				Help: "Bytes sent through the site to site VPN tunnel in the last CloudWatch period, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.",
+			},
+			TunnelLabelNames,
+		),
+		suspicious: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "tunnel_suspicious",
+				Help: "If the site to site VPN tunnel is up but has had no traffic for a while, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.",
+			},
+			TunnelLabelNames,
+		),
+		published: make(map[string]prometheus.Labels),
+		logger:    log.With(logger, "actor", "cloudwatch poller"),
+	}
+
+	registerer.MustRegister(p.dataIn, p.dataOut, p.suspicious)
+
+	return &p
+}
+
+// AddCloudWatchStage adds a stage to the run group that passes VPN connections on to the next stage, while polling
+// CloudWatch for the metrics of their tunnels at the supplied interval
+func AddCloudWatchStage(group *group.Group, poller *cloudWatchPoller, in <-chan state.Poll, out chan<- state.Poll, interval *time.Duration) {
+
+	ticker := time.NewTicker(*interval)
+
+	a := cloudWatchActor(poller, in, out, ticker.C)
+	group.Add(a.Execute, func(err error) {
+		ticker.Stop()
+		a.Interrupt(err)
+	})
+
+}
+
+// cloudWatchActor remembers the tunnels of the connections received before sending them down the out channel, and
+// fetches the CloudWatch metrics for those tunnels every time the tick channel fires
+func cloudWatchActor(poller *cloudWatchPoller, in <-chan state.Poll, out chan<- state.Poll, tick <-chan time.Time) actor.Actor {
+
+	cancel := make(chan struct{})
+
+	return actor.NewActor(
+		func() error {
+
+			for {
+				select {
+
+				case poll := <-in:
+					first := poller.tunnels == nil
+					poller.monitor(poll.Connections)
+
+					// Don't make people wait for the first tick to see any traffic
+					if first {
+						poller.poll()
+					}
+
+					select {
+					case out <- poll:
+					case <-cancel:
+						_ = level.Info(poller.logger).Log("cancelled", "Asked to terminate")
+						return nil
+					}
+
+				case <-tick:
+					poller.poll()
+
+				case <-cancel:
+					_ = level.Info(poller.logger).Log("cancelled", "Asked to terminate")
+					return nil
+				}
+			}
+		},
+		func(err error) {
+			_ = level.Info(poller.logger).Log("interrupted", fmt.Sprintf("interrupted with %v", err))
+			close(cancel)
+		},
+	)
+
+}
+
+// monitor sets the tunnels we fetch metrics for to those of the supplied connections that come from AWS
+func (p *cloudWatchPoller) monitor(connections []*vpn.Connection) {
+
+	tunnels := make([]cloudWatchTunnel, 0)
+
+	for _, conn := range connections {
+
+		if conn.Source != awsvpn.SourceName {
+			continue
+		}
+
+		for _, tunnel := range conn.Tunnels {
+
+			labels := TunnelLabels(conn, tunnel)
+
+			tunnels = append(tunnels, cloudWatchTunnel{
+				id:     idForTunnelGauge(labels),
+				ip:     tunnel.OutsideIP,
+				labels: labels,
+			})
+		}
+	}
+
+	p.tunnels = tunnels
+}
+
+// poll fetches the latest metrics from CloudWatch for the monitored tunnels and publishes them.
+// Metrics for tunnels that are no longer monitored are deleted.
+func (p *cloudWatchPoller) poll() {
+
+	current := make(map[string]prometheus.Labels)
+
+	for start := 0; start < len(p.tunnels); start += maxTunnelsPerRequest {
+
+		end := start + maxTunnelsPerRequest
+		if end > len(p.tunnels) {
+			end = len(p.tunnels)
+		}
+
+		results, err := p.fetch(p.tunnels[start:end])
+		if err != nil {
+			_ = level.Warn(p.logger).Log("msg", "Unable to get CloudWatch metrics", "err", err)
+
+			// Keep what we published before rather than have metrics flap
+			for _, tunnel := range p.tunnels[start:end] {
+				if labels, ok := p.published[tunnel.id]; ok {
+					current[tunnel.id] = labels
+				}
+			}
+			continue
+		}
+
+		for i, tunnel := range p.tunnels[start:end] {
+			p.publish(tunnel, results, i)
+			current[tunnel.id] = tunnel.labels
+		}
+	}
+
+	for id, labels := range p.published {
+		if _, ok := current[id]; !ok {
+			_ = level.Debug(p.logger).Log("msg", fmt.Sprintf("Removing CloudWatch metrics for redundant tunnel: %v", id))
+			p.dataIn.Delete(labels)
+			p.dataOut.Delete(labels)
+			p.suspicious.Delete(labels)
+		}
+	}
+
+	p.published = current
+}
+
+// publish sets the metrics for a tunnel from the results of the queries for it
+func (p *cloudWatchPoller) publish(tunnel cloudWatchTunnel, results map[string]*cloudwatch.MetricDataResult, i int) {
+
+	in := results[queryID("in", i)]
+	out := results[queryID("out", i)]
+	tunnelState := results[queryID("state", i)]
+
+	p.dataIn.With(tunnel.labels).Set(latest(in))
+	p.dataOut.With(tunnel.labels).Set(latest(out))
+
+	suspicious := 0.0
+	if latest(tunnelState) == 1 && total(in)+total(out) == 0 {
+		_ = level.Warn(p.logger).Log("msg", "Tunnel is up but has had no traffic", "tunnel", tunnel.ip, "for", p.idle)
+		suspicious = 1
+	}
+	p.suspicious.With(tunnel.labels).Set(suspicious)
+}
+
+// fetch gets the TunnelState, TunnelDataIn and TunnelDataOut metrics covering the idle duration for the supplied
+// tunnels, keyed by query ID
+func (p *cloudWatchPoller) fetch(tunnels []cloudWatchTunnel) (map[string]*cloudwatch.MetricDataResult, error) {
+
+	end := p.clock.Now()
+	input := &cloudwatch.GetMetricDataInput{
+		StartTime: aws.Time(end.Add(-p.idle)),
+		EndTime:   aws.Time(end),
+		ScanBy:    aws.String(cloudwatch.ScanByTimestampDescending),
+	}
+
+	for i, tunnel := range tunnels {
+		input.MetricDataQueries = append(input.MetricDataQueries,
+			tunnelQuery(queryID("in", i), "TunnelDataIn", cloudwatch.StatisticSum, tunnel.ip),
+			tunnelQuery(queryID("out", i), "TunnelDataOut", cloudwatch.StatisticSum, tunnel.ip),
+			tunnelQuery(queryID("state", i), "TunnelState", cloudwatch.StatisticMaximum, tunnel.ip),
+		)
+	}
+
+	results := make(map[string]*cloudwatch.MetricDataResult)
+
+	for {
+		output, err := p.svc.GetMetricData(input)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, result := range output.MetricDataResults {
+			id := aws.StringValue(result.Id)
+			if existing, ok := results[id]; ok {
+				existing.Values = append(existing.Values, result.Values...)
+				continue
+			}
+			results[id] = result
+		}
+
+		if aws.StringValue(output.NextToken) == "" {
+			return results, nil
+		}
+
+		input.NextToken = output.NextToken
+	}
+}
+
+func queryID(metric string, i int) string {
+	return fmt.Sprintf("%s%d", metric, i)
+}
+
+func tunnelQuery(id string, metric string, stat string, ip string) *cloudwatch.MetricDataQuery {
+	return &cloudwatch.MetricDataQuery{
+		Id: aws.String(id),
+		MetricStat: &cloudwatch.MetricStat{
+			Metric: &cloudwatch.Metric{
+				Namespace:  aws.String(vpnNamespace),
+				MetricName: aws.String(metric),
+				Dimensions: []*cloudwatch.Dimension{
+					{Name: aws.String("TunnelIpAddress"), Value: aws.String(ip)},
+				},
+			},
+			Period: aws.Int64(300),
+			Stat:   aws.String(stat),
+		},
+	}
+}
+
+// latest returns the most recent value of a result, or zero if there isn't one.
+// Results are returned newest first.
+func latest(result *cloudwatch.MetricDataResult) float64 {
+	if result == nil || len(result.Values) == 0 {
+		return 0
+	}
+	return aws.Float64Value(result.Values[0])
+}
+
+// total returns the sum of all the values of a result
+func total(result *cloudwatch.MetricDataResult) float64 {
+	sum := 0.0
+	if result != nil {
+		for _, v := range result.Values {
+			sum += aws.Float64Value(v)
+		}
+	}
+	return sum
+}
@@ -0,0 +1,235 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/source/awsvpn"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+const cloudWatchMetadata = `
+	# HELP cc_vpn_tunnel_data_in_bytes Bytes received through the site to site VPN tunnel in the last CloudWatch period, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.
+	# TYPE cc_vpn_tunnel_data_in_bytes gauge
+	%s
+	# HELP cc_vpn_tunnel_data_out_bytes Bytes sent through the site to site VPN tunnel in the last CloudWatch period, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.
+	# TYPE cc_vpn_tunnel_data_out_bytes gauge
+	%s
+	# HELP cc_vpn_tunnel_suspicious If the site to site VPN tunnel is up but has had no traffic for a while, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.
+	# TYPE cc_vpn_tunnel_suspicious gauge
+	%s
+`
+
+var cloudwatchtests = []struct {
+	name    string
+	metrics map[string][]float64
+	truth   string
+}{
+	{
+		name:    "Tunnel up with traffic",
+		metrics: map[string][]float64{"TunnelState": {1, 1}, "TunnelDataIn": {100, 50}, "TunnelDataOut": {200}},
+		truth: expectedCloudWatchOutput(
+			`cc_vpn_tunnel_data_in_bytes{outside_ip="1.2.3.4",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 100`,
+			`cc_vpn_tunnel_data_out_bytes{outside_ip="1.2.3.4",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 200`,
+			`cc_vpn_tunnel_suspicious{outside_ip="1.2.3.4",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 0`,
+		),
+	},
+	{
+		name:    "Tunnel up with no traffic",
+		metrics: map[string][]float64{"TunnelState": {1, 1}, "TunnelDataIn": {0, 0}, "TunnelDataOut": {0, 0}},
+		truth: expectedCloudWatchOutput(
+			`cc_vpn_tunnel_data_in_bytes{outside_ip="1.2.3.4",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 0`,
+			`cc_vpn_tunnel_data_out_bytes{outside_ip="1.2.3.4",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 0`,
+			`cc_vpn_tunnel_suspicious{outside_ip="1.2.3.4",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 1`,
+		),
+	},
+	{
+		name:    "Tunnel down with no traffic",
+		metrics: map[string][]float64{"TunnelState": {0}},
+		truth: expectedCloudWatchOutput(
+			`cc_vpn_tunnel_data_in_bytes{outside_ip="1.2.3.4",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 0`,
+			`cc_vpn_tunnel_data_out_bytes{outside_ip="1.2.3.4",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 0`,
+			`cc_vpn_tunnel_suspicious{outside_ip="1.2.3.4",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 0`,
+		),
+	},
+}
+
+func TestCloudWatchMetrics(t *testing.T) {
+
+	for _, tt := range cloudwatchtests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// Given CloudWatch has metrics for a tunnel
+			registry := prometheus.NewRegistry()
+			cwClient := newMockCloudWatchClient(tt.metrics)
+			underTest := NewCloudWatchPoller(DefaultNaming.Registerer(registry), log.NewNopLogger(), cwClient, fixedClock{}, 15*time.Minute)
+
+			// When the tunnel is polled
+			underTest.monitor(connectionWithTunnel("vgw-1", "1.2.3.4"))
+			underTest.poll()
+
+			// Then the metrics should be published
+			if err := testutil.GatherAndCompare(registry, strings.NewReader(tt.truth)); err != nil {
+				t.Errorf("unexpected collecting result:\n%s", err)
+			}
+		})
+	}
+}
+
+func TestCloudWatchMetricsRemoved(t *testing.T) {
+
+	// Given metrics have been published for a tunnel
+	registry := prometheus.NewRegistry()
+	cwClient := newMockCloudWatchClient(map[string][]float64{"TunnelState": {1}, "TunnelDataIn": {1}})
+	underTest := NewCloudWatchPoller(DefaultNaming.Registerer(registry), log.NewNopLogger(), cwClient, fixedClock{}, 15*time.Minute)
+
+	underTest.monitor(connectionWithTunnel("vgw-1", "1.2.3.4"))
+	underTest.poll()
+
+	// When the tunnel goes away
+	underTest.monitor(connectionWithTunnel("vgw-1", "5.6.7.8"))
+	underTest.poll()
+
+	// Then only the remaining tunnel should have metrics
+	truth := expectedCloudWatchOutput(
+		`cc_vpn_tunnel_data_in_bytes{outside_ip="5.6.7.8",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 1`,
+		`cc_vpn_tunnel_data_out_bytes{outside_ip="5.6.7.8",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 0`,
+		`cc_vpn_tunnel_suspicious{outside_ip="5.6.7.8",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 0`,
+	)
+
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(truth)); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
+
+func TestCloudWatchErrorsKeepMetrics(t *testing.T) {
+
+	// Given metrics have been published for a tunnel
+	registry := prometheus.NewRegistry()
+	cwClient := newMockCloudWatchClient(map[string][]float64{"TunnelState": {1}, "TunnelDataIn": {1}})
+	underTest := NewCloudWatchPoller(DefaultNaming.Registerer(registry), log.NewNopLogger(), cwClient, fixedClock{}, 15*time.Minute)
+
+	underTest.monitor(connectionWithTunnel("vgw-1", "1.2.3.4"))
+	underTest.poll()
+
+	// When CloudWatch can't be reached
+	cwClient.getMetricData = func(*cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+		return nil, errors.New("test error")
+	}
+	underTest.poll()
+
+	// Then the last known metrics should be kept
+	truth := expectedCloudWatchOutput(
+		`cc_vpn_tunnel_data_in_bytes{outside_ip="1.2.3.4",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 1`,
+		`cc_vpn_tunnel_data_out_bytes{outside_ip="1.2.3.4",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 0`,
+		`cc_vpn_tunnel_suspicious{outside_ip="1.2.3.4",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 0`,
+	)
+
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(truth)); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
+
+func TestCloudWatchIgnoresOtherSources(t *testing.T) {
+
+	underTest := cloudWatchPollerForTesting()
+
+	// Given a connection that doesn't come from AWS
+	connections := connectionWithTunnel("vgw-1", "1.2.3.4")
+	connections[0].Source = "elsewhere"
+
+	// When it's monitored
+	underTest.monitor(connections)
+
+	// Then there should be no tunnels to fetch metrics for
+	if len(underTest.tunnels) != 0 {
+		t.Errorf("Expected no tunnels to be monitored, but got %v", underTest.tunnels)
+	}
+}
+
+func TestCloudWatchStagePassesConnectionsOn(t *testing.T) {
+
+	in := make(chan state.Poll)
+	out := make(chan state.Poll)
+
+	underTest := cloudWatchActor(cloudWatchPollerForTesting(), in, out, make(chan time.Time))
+	defer underTest.Interrupt(nil)
+
+	// When the stage is running
+	go func(a actor.Actor) {
+		_ = a.Execute()
+	}(underTest)
+
+	sent := connectionWithTunnel("vgw-1", "1.2.3.4")
+	go func() { in <- state.Poll{Connections: sent} }()
+
+	// Then the connections should be sent to the next stage in the pipeline
+	select {
+	case received := <-out:
+		if len(received.Connections) != 1 || received.Connections[0] != sent[0] {
+			t.Errorf("Data sent to next stage incorrect : expected %v got %v", sent, received)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("Timed out waiting for connections to be passed down the pipeline")
+	}
+}
+
+// expectedCloudWatchOutput returns the expected metrics output for the supplied series
+func expectedCloudWatchOutput(in string, out string, suspicious string) string {
+	return fmt.Sprintf(cloudWatchMetadata, in, out, suspicious)
+}
+
+func connectionWithTunnel(gatewayId string, ip string) []*vpn.Connection {
+	return []*vpn.Connection{
+		{
+			Source:     awsvpn.SourceName,
+			Attributes: map[string]string{vpn.AttrVpnGatewayID: gatewayId},
+			Tunnels:    []*vpn.Tunnel{{OutsideIP: ip, Status: vpn.StatusUp}},
+		},
+	}
+}
+
+func cloudWatchPollerForTesting() *cloudWatchPoller {
+	return NewCloudWatchPoller(prometheus.NewRegistry(), log.NewNopLogger(), newMockCloudWatchClient(nil), fixedClock{}, 15*time.Minute)
+}
+
+type fixedClock struct{}
+
+func (fixedClock) Now() time.Time { return time.Date(2009, 11, 17, 20, 34, 58, 0, time.UTC) }
+
+type mockCloudWatchClient struct {
+	cloudwatchiface.CloudWatchAPI
+	getMetricData func(*cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error)
+}
+
+func (m *mockCloudWatchClient) GetMetricData(input *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+	return m.getMetricData(input)
+}
+
+// newMockCloudWatchClient returns a client that answers every query with the values for the metric it asks for
+func newMockCloudWatchClient(values map[string][]float64) *mockCloudWatchClient {
+	return &mockCloudWatchClient{
+		getMetricData: func(input *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+
+			output := &cloudwatch.GetMetricDataOutput{}
+			for _, query := range input.MetricDataQueries {
+				output.MetricDataResults = append(output.MetricDataResults, &cloudwatch.MetricDataResult{
+					Id:     query.Id,
+					Values: aws.Float64Slice(values[*query.MetricStat.Metric.MetricName]),
+				})
+			}
+
+			return output, nil
+		},
+	}
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// groupLabelNames are the labels of the roll-ups of groups, the tag connections are grouped by and its value
+var groupLabelNames = []string{"group_by", "group"}
+
+// groupUpdater publishes how many connections of each group are up and healthy, with connections grouped by the
+// value of a tag
+type groupUpdater struct {
+	tag                 string
+	connectionsGaugeVec *prometheus.GaugeVec
+	upGaugeVec          *prometheus.GaugeVec
+	healthyGaugeVec     *prometheus.GaugeVec
+	published           map[string]prometheus.Labels
+	logger              log.Logger
+}
+
+// NewGroupUpdater returns an instance ready to use, which groups connections by the tag. Connections without it are
+// counted in the vpn.Ungrouped group.
+func NewGroupUpdater(registerer prometheus.Registerer, logger log.Logger, tag string) *groupUpdater {
+
+	u := groupUpdater{
+		tag: tag,
+		connectionsGaugeVec: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "group_connections",
+				Help: "Number of VPN connections in the group, partitioned by the tag connections are grouped by and its value.",
+			},
+			groupLabelNames,
+		),
+		upGaugeVec: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "group_up_connections",
+				Help: "Number of VPN connections in the group with at least one tunnel up, partitioned by the tag connections are grouped by and its value.",
+			},
+			groupLabelNames,
+		),
+		healthyGaugeVec: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "group_healthy_connections",
+				Help: "Number of VPN connections in the group that are up with all their probes succeeding, partitioned by the tag connections are grouped by and its value.",
+			},
+			groupLabelNames,
+		),
+		published: make(map[string]prometheus.Labels),
+		logger:    log.With(logger, "actor", "groups"),
+	}
+
+	registerer.MustRegister(u.connectionsGaugeVec, u.upGaugeVec, u.healthyGaugeVec)
+
+	return &u
+}
+
+// Update publishes the roll-ups of the groups of the connections, and stops publishing those of groups that no
+// longer have any connections
+func (u *groupUpdater) Update(connections []*vpn.Connection) {
+
+	current := make(map[string]prometheus.Labels)
+
+	for _, g := range vpn.GroupByTag(connections, u.tag) {
+
+		labels := prometheus.Labels{"group_by": u.tag, "group": g.Name}
+		u.connectionsGaugeVec.With(labels).Set(float64(len(g.Connections)))
+		u.upGaugeVec.With(labels).Set(float64(g.Up()))
+		u.healthyGaugeVec.With(labels).Set(float64(g.Healthy()))
+
+		current[g.Name] = labels
+	}
+
+	for name, labels := range u.published {
+		if _, ok := current[name]; !ok {
+			_ = level.Debug(u.logger).Log("msg", "Group has gone away", "group", name)
+			u.connectionsGaugeVec.Delete(labels)
+			u.upGaugeVec.Delete(labels)
+			u.healthyGaugeVec.Delete(labels)
+		}
+	}
+
+	u.published = current
+}
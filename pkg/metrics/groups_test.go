@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+)
+
+const groupMetadata = `
+	# HELP cc_vpn_group_connections Number of VPN connections in the group, partitioned by the tag connections are grouped by and its value.
+	# TYPE cc_vpn_group_connections gauge
+	# HELP cc_vpn_group_healthy_connections Number of VPN connections in the group that are up with all their probes succeeding, partitioned by the tag connections are grouped by and its value.
+	# TYPE cc_vpn_group_healthy_connections gauge
+	# HELP cc_vpn_group_up_connections Number of VPN connections in the group with at least one tunnel up, partitioned by the tag connections are grouped by and its value.
+	# TYPE cc_vpn_group_up_connections gauge
+`
+
+func TestGroupUpdater(t *testing.T) {
+
+	// Given connections at two sites, and one without a site
+	registry := prometheus.NewRegistry()
+	underTest := NewGroupUpdater(DefaultNaming.Registerer(registry), log.NewNopLogger(), "Site")
+
+	up, down := []*vpn.Tunnel{{Status: vpn.StatusUp}}, []*vpn.Tunnel{{Status: vpn.StatusDown}}
+	connections := []*vpn.Connection{
+		{ID: "vpn-1", Tags: map[string]string{"Site": "london"}, Tunnels: up},
+		{ID: "vpn-2", Tags: map[string]string{"Site": "london"}, Tunnels: up, Probes: []*vpn.Probe{{Success: false}}},
+		{ID: "vpn-3", Tags: map[string]string{"Site": "london"}, Tunnels: down},
+		{ID: "vpn-4", Tags: map[string]string{"Site": "paris"}, Tunnels: up},
+		{ID: "vpn-5", Tunnels: down},
+	}
+
+	// When they are updated
+	underTest.Update(connections)
+
+	// Then the roll-ups of each group are published
+	expected := groupMetadata + `
+	cc_vpn_group_connections{group="london",group_by="Site"} 3
+	cc_vpn_group_connections{group="paris",group_by="Site"} 1
+	cc_vpn_group_connections{group="ungrouped",group_by="Site"} 1
+	cc_vpn_group_healthy_connections{group="london",group_by="Site"} 1
+	cc_vpn_group_healthy_connections{group="paris",group_by="Site"} 1
+	cc_vpn_group_healthy_connections{group="ungrouped",group_by="Site"} 0
+	cc_vpn_group_up_connections{group="london",group_by="Site"} 2
+	cc_vpn_group_up_connections{group="paris",group_by="Site"} 1
+	cc_vpn_group_up_connections{group="ungrouped",group_by="Site"} 0
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+
+	// When a group no longer has any connections
+	underTest.Update(connections[3:4])
+
+	// Then it's no longer published
+	expected = groupMetadata + `
+	cc_vpn_group_connections{group="paris",group_by="Site"} 1
+	cc_vpn_group_healthy_connections{group="paris",group_by="Site"} 1
+	cc_vpn_group_up_connections{group="paris",group_by="Site"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// TunnelHealth is the health of a tunnel as published in metrics, so every sink metrics are sent to publishes the same
+type TunnelHealth struct {
+	// Labels identify the tunnel, as returned by TunnelLabels
+	Labels prometheus.Labels
+
+	Up bool
+
+	// LastHandshake is zero for sources that don't report handshakes
+	LastHandshake time.Time
+
+	// Traffic is nil for sources that don't count it
+	Traffic *vpn.Traffic
+}
+
+// Health returns the health of every tunnel of the connections
+func Health(connections []*vpn.Connection) []TunnelHealth {
+
+	var health []TunnelHealth
+
+	for _, conn := range connections {
+		for _, tunnel := range conn.Tunnels {
+			health = append(health, TunnelHealth{
+				Labels:        TunnelLabels(conn, tunnel),
+				Up:            tunnel.Status == vpn.StatusUp,
+				LastHandshake: tunnel.LastHandshake,
+				Traffic:       tunnel.Traffic,
+			})
+		}
+	}
+
+	return health
+}
+
+// UpValue is the value of the tunnel_up metric, 1 if the tunnel is up and 0 if it isn't
+func (h TunnelHealth) UpValue() float64 {
+	if h.Up {
+		return 1
+	}
+	return 0
+}
+
+// HandshakeAge returns how long before now the tunnel last handshook, or false if it's not known
+func (h TunnelHealth) HandshakeAge(now time.Time) (time.Duration, bool) {
+	if h.LastHandshake.IsZero() {
+		return 0, false
+	}
+	return now.Sub(h.LastHandshake), true
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"regexp"
+	"strings"
+)
+
+// Naming is how every metric vpnck exports is named. Metrics are defined with just their name, and get the
+// namespace, subsystem and constant labels from the registerer they are registered with.
+type Naming struct {
+	Namespace   string
+	Subsystem   string
+	ConstLabels prometheus.Labels
+}
+
+// DefaultNaming gives metrics names such as cc_vpn_tunnel_up
+var DefaultNaming = Naming{Namespace: "cc", Subsystem: "vpn"}
+
+// reservedLabelNames are used by the metrics themselves, including the result of remote write batches and the
+// roll-ups of groups, so can't be constant labels
+var reservedLabelNames = append(append([]string{"vpn_connection_id", "target", "window", "le", "result"}, TunnelLabelNames...), groupLabelNames...)
+
+// namePattern matches what's valid in metric and label names
+var namePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// NewNaming returns the naming for the namespace, subsystem and constant labels, which are given as comma separated
+// name=value pairs such as cluster=eu-1,environment=production
+func NewNaming(namespace string, subsystem string, constLabels string) (Naming, error) {
+
+	for _, part := range []string{namespace, subsystem} {
+		if part != "" && !namePattern.MatchString(part) {
+			return Naming{}, fmt.Errorf("invalid metric namespace or subsystem %q: expected letters, digits and underscores", part)
+		}
+	}
+
+	labels, err := ParseLabels(constLabels)
+	if err != nil {
+		return Naming{}, err
+	}
+
+	return Naming{Namespace: namespace, Subsystem: subsystem, ConstLabels: labels}, nil
+}
+
+// ParseLabels parses labels to add to every metric, given as comma separated name=value pairs such as
+// cluster=eu-1,environment=production. Labels the metrics already have can't be added. Nil is returned if there
+// aren't any.
+func ParseLabels(s string) (prometheus.Labels, error) {
+
+	var labels prometheus.Labels
+
+	for _, pair := range strings.Split(s, ",") {
+
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || !namePattern.MatchString(parts[0]) || strings.HasPrefix(parts[0], "__") {
+			return nil, fmt.Errorf("invalid metric label %q: expected name=value", pair)
+		}
+
+		for _, reserved := range reservedLabelNames {
+			if parts[0] == reserved {
+				return nil, fmt.Errorf("invalid metric label %q: %s is already used by metrics", pair, reserved)
+			}
+		}
+
+		if labels == nil {
+			labels = make(prometheus.Labels)
+		}
+		labels[parts[0]] = parts[1]
+	}
+
+	return labels, nil
+}
+
+// Prefix is what the names of metrics start with
+func (n Naming) Prefix() string {
+	var prefix string
+	for _, part := range []string{n.Namespace, n.Subsystem} {
+		if part != "" {
+			prefix += part + "_"
+		}
+	}
+	return prefix
+}
+
+// Registerer returns a registerer that registers metrics with the one supplied, named with the prefix and labelled
+// with the constant labels
+func (n Naming) Registerer(registerer prometheus.Registerer) prometheus.Registerer {
+
+	if len(n.ConstLabels) > 0 {
+		registerer = prometheus.WrapRegistererWith(n.ConstLabels, registerer)
+	}
+
+	if prefix := n.Prefix(); prefix != "" {
+		registerer = prometheus.WrapRegistererWithPrefix(prefix, registerer)
+	}
+
+	return registerer
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+)
+
+var namingtests = []struct {
+	name        string
+	namespace   string
+	subsystem   string
+	constLabels string
+	truth       Naming
+	err         bool
+}{
+	{name: "Default", namespace: "cc", subsystem: "vpn", truth: DefaultNaming},
+	{name: "No prefix", truth: Naming{}},
+	{name: "Const labels", namespace: "acme", constLabels: "cluster=eu-1, environment=production,", truth: Naming{
+		Namespace:   "acme",
+		ConstLabels: prometheus.Labels{"cluster": "eu-1", "environment": "production"},
+	}},
+	{name: "Invalid namespace", namespace: "acme-corp", err: true},
+	{name: "Invalid subsystem", subsystem: "vpn.check", err: true},
+	{name: "Label without value", constLabels: "cluster", err: true},
+	{name: "Invalid label name", constLabels: "1cluster=eu-1", err: true},
+	{name: "Reserved label name", constLabels: "__name__=up", err: true},
+	{name: "Label used by metrics", constLabels: "vpn_id=vgw-1", err: true},
+	{name: "Label used by remote write metrics", constLabels: "result=x", err: true},
+	{name: "Label used by group metrics", constLabels: "group=eu", err: true},
+	{name: "Label used by group metrics for the tag", constLabels: "group_by=Team", err: true},
+}
+
+func TestNewNaming(t *testing.T) {
+
+	for _, tt := range namingtests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			naming, err := NewNaming(tt.namespace, tt.subsystem, tt.constLabels)
+
+			if tt.err {
+				if err == nil {
+					t.Errorf("Expected an error but got %+v", naming)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+
+			if !cmp.Equal(tt.truth, naming) {
+				t.Errorf("Naming incorrect: %s", cmp.Diff(tt.truth, naming))
+			}
+		})
+	}
+}
+
+func TestNamingRegisterer(t *testing.T) {
+
+	// Given a metric registered with a registerer for custom naming
+	registry := prometheus.NewRegistry()
+	naming := Naming{Namespace: "acme", Subsystem: "network", ConstLabels: prometheus.Labels{"cluster": "eu-1"}}
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "tunnel_up", Help: "Test gauge."})
+	naming.Registerer(registry).MustRegister(gauge)
+
+	// When it's set
+	gauge.Set(1)
+
+	// Then it should be published with the prefix and constant labels
+	const truth = `
+		# HELP acme_network_tunnel_up Test gauge.
+		# TYPE acme_network_tunnel_up gauge
+		acme_network_tunnel_up{cluster="eu-1"} 1
+	`
+
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(truth)); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
@@ -2,98 +2,100 @@ package metrics
 
 import (
 	"fmt"
-	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/oklog/oklog/pkg/group"
 	"github.com/prometheus/client_golang/prometheus"
 	"sort"
 	"strings"
+	"sync/atomic"
 )
 
-// tunnelUpGauge wraps a Gauge from the Prometheus client library for lifecycle management.
-// This allows us to dynamically add and remove gauges as needed
-type tunnelUpGauge struct {
-	id     string
-	labels prometheus.Labels
-	gauge  prometheus.Gauge
-	delete func()
-}
-
-// newTunnelUpGauge returns a populated gauge with the supplied details
-func newTunnelUpGauge(id string, labels prometheus.Labels, gauge prometheus.Gauge, delete func()) *tunnelUpGauge {
-	return &tunnelUpGauge{
-		id:     id,
-		labels: labels,
-		gauge:  gauge,
-		delete: delete,
-	}
+// Updater is told about the latest state of the VPN connections
+type Updater interface {
+	Update(connections []*vpn.Connection)
 }
 
-// updateFrom updates the gauge from the supplied tunnel telemetry data
-func (t *tunnelUpGauge) updateFrom(telemetry *ec2.VgwTelemetry) *tunnelUpGauge {
-
-	status := 1.0
-	if *telemetry.Status != ec2.TelemetryStatusUp {
-		status = 0
+// The gauges published for the health of every tunnel, by the collector and the other sinks metrics are sent to
+var (
+	tunnelUpOpts = prometheus.GaugeOpts{
+		Name: "tunnel_up",
+		Help: "If the site to site VPN tunnel status is up, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.",
 	}
-
-	t.gauge.Set(status)
-
-	return t
-}
-
-type Updater interface {
-	Update(connections []*ec2.VpnConnection)
-}
+	handshakeAgeOpts = prometheus.GaugeOpts{
+		Name: "tunnel_handshake_age_seconds",
+		Help: "Seconds since the ends of the VPN tunnel last handshook, for sources that report it, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.",
+	}
+	receivedOpts = prometheus.GaugeOpts{
+		Name: "tunnel_received_bytes",
+		Help: "Bytes received through the VPN tunnel since it was set up, for sources that count them, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.",
+	}
+	sentOpts = prometheus.GaugeOpts{
+		Name: "tunnel_sent_bytes",
+		Help: "Bytes sent through the VPN tunnel since it was set up, for sources that count them, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.",
+	}
+)
 
 // vpnCollector manages prometheus metrics for VPNs we care about.
-// As VPN components can come and go we have to add a layer of management on top of the standard Prometheus functionality
+// As VPN components can come and go we have to add a layer of management on top of the standard Prometheus functionality.
+// Updates are processed by the Execute() loop, which publishes an immutable snapshot of the health of the tunnels
+// after each one. Scrapes only read the latest snapshot, so are never held up by updates, nor by the loop having exited.
 type vpnCollector struct {
-	tunnelUpGaugeVec *prometheus.GaugeVec
-	gauges           map[string]*tunnelUpGauge
-	collect          chan *collectAndDone
-	update           chan []*ec2.VpnConnection
+	tunnelUpDesc     *prometheus.Desc
+	handshakeAgeDesc *prometheus.Desc
+	receivedDesc     *prometheus.Desc
+	sentDesc         *prometheus.Desc
+	snapshot         atomic.Value
+	clock            state.Clock
+	update           chan *update
 	cancel           chan struct{}
 	logger           log.Logger
 }
 
-// NewVpnStatusCollector returns an instance ready to use. The Execute() method should be called from a go routine to process updates and publish metrics, with the Interrupt() method being called to signal that process should stop.
-func NewVpnStatusCollector(registerer prometheus.Registerer, logger log.Logger) *vpnCollector {
+// snapshot is the health of the tunnels as of an update, keyed by the ID of their metrics. Once published it's
+// never changed.
+type snapshot map[string]TunnelHealth
+
+// update is a request for the Execute() loop to update the metrics, with done closed once they are
+type update struct {
+	connections []*vpn.Connection
+	done        chan struct{}
+}
+
+// NewVpnStatusCollector returns an instance ready to use. The clock is used to work out how long ago tunnels last
+// handshook when metrics are collected. The Execute() method should be called from a go routine to process updates and publish metrics, with the Interrupt() method being called to signal that process should stop.
+func NewVpnStatusCollector(registerer prometheus.Registerer, logger log.Logger, clock state.Clock) *vpnCollector {
 
 	c := vpnCollector{
-		tunnelUpGaugeVec: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Namespace: "cc",
-				Subsystem: "vpn",
-				Name:      "tunnel_up",
-				Help:      "If the site to site VPN tunnel status is up, partitioned by VPN Connection ID and Outside IP.",
-			},
-			[]string{
-				// Which VPN ?
-				"vpn_id",
-				// and what's the Outside IP ?
-				"outside_ip",
-			},
-		),
-		gauges:  make(map[string]*tunnelUpGauge),
-		collect: make(chan *collectAndDone),
-		cancel:  make(chan struct{}),
-		update:  make(chan []*ec2.VpnConnection),
-		logger:  log.With(logger, "actor", "vpncollector"),
+		tunnelUpDesc:     newTunnelDesc(tunnelUpOpts),
+		handshakeAgeDesc: newTunnelDesc(handshakeAgeOpts),
+		receivedDesc:     newTunnelDesc(receivedOpts),
+		sentDesc:         newTunnelDesc(sentOpts),
+		clock:            clock,
+		cancel:           make(chan struct{}),
+		update:           make(chan *update),
+		logger:           log.With(logger, "actor", "vpncollector"),
 	}
+	c.snapshot.Store(snapshot{})
 
-	registerer.MustRegister(c.tunnelUpGaugeVec)
+	registerer.MustRegister(&c)
 
 	return &c
 }
 
+// newTunnelDesc returns the description of a gauge with the labels identifying a tunnel
+func newTunnelDesc(opts prometheus.GaugeOpts) *prometheus.Desc {
+	return prometheus.NewDesc(opts.Name, opts.Help, TunnelLabelNames, nil)
+}
+
 // AddAsStage adds as a stage to the supplied run group
 func (c *vpnCollector) AddAsStage(group *group.Group) {
 	group.Add(c.Execute, c.Interrupt)
 }
 
-// Execute is the heart of this unit. All logic that deals with state happens here.
+// Execute is the heart of this unit. All logic that deals with updating state happens here.
 // This should be called from a go routine, with the Interrupt() method used to signal this body should be exited.
 func (c *vpnCollector) Execute() error {
 
@@ -101,13 +103,10 @@ func (c *vpnCollector) Execute() error {
 
 	for {
 		select {
-		case collect := <-c.collect:
-			_ = level.Debug(c.logger).Log("msg", "sending metrics to collector")
-			c.tunnelUpGaugeVec.Collect(collect.ch)
-			collect.finished()
-		case connections := <-c.update:
+		case u := <-c.update:
 			_ = level.Debug(c.logger).Log("msg", "received new VPN status")
-			c.updateWith(connections)
+			c.updateWith(u.connections)
+			close(u.done)
 		case <-c.cancel:
 			_ = level.Info(c.logger).Log("msg", "received cancellation - exiting loop")
 			return nil
@@ -116,108 +115,107 @@ func (c *vpnCollector) Execute() error {
 
 }
 
-// Interrupt signals that the processing of updates & publishing metrics should finish.
-// Once called instances cannot be re-used.
+// Interrupt signals that the processing of updates should finish. Metrics can still be collected afterwards, as of
+// the last update. Once called instances cannot be re-used.
 func (c *vpnCollector) Interrupt(err error) {
 	_ = level.Info(c.logger).Log("interrupted", fmt.Sprintf("interrupted with %v", err))
 
 	close(c.cancel)
 }
 
-// Describe returns all descriptions of the managedGauge.
+// Describe returns all descriptions of the metrics.
 func (c *vpnCollector) Describe(ch chan<- *prometheus.Desc) {
-	c.tunnelUpGaugeVec.Describe(ch)
+	ch <- c.tunnelUpDesc
+	ch <- c.handshakeAgeDesc
+	ch <- c.receivedDesc
+	ch <- c.sentDesc
 }
 
-// Collect returns the current state of all metrics of the managedGauge.
+// Collect returns the metrics from the latest snapshot, working out how long ago tunnels last handshook as of now.
+// The handshake age is only published for tunnels that have handshook, and traffic for tunnels whose source counts it.
 func (c *vpnCollector) Collect(ch chan<- prometheus.Metric) {
 
-	cd := &collectAndDone{
-		ch:   ch,
-		done: make(chan interface{}),
-	}
-	c.collect <- cd
+	now := c.clock.Now()
 
-	cd.wait()
+	for _, health := range c.current() {
 
-}
-
-// Update refreshes metrics with the tunnel connection data
-func (c *vpnCollector) Update(connections []*ec2.VpnConnection) {
-	c.update <- connections
-}
+		labelValues := make([]string, len(TunnelLabelNames))
+		for i, name := range TunnelLabelNames {
+			labelValues[i] = health.Labels[name]
+		}
 
-// Update updates the metric gauges with the current state of the VPNs.
-// Collectors for tunnels that have been removed are deleted, and new ones are created.
-func (c *vpnCollector) updateWith(connections []*ec2.VpnConnection) {
+		ch <- prometheus.MustNewConstMetric(c.tunnelUpDesc, prometheus.GaugeValue, health.UpValue(), labelValues...)
 
-	// Gauges we want to keep
-	currentGauges := make(map[string]*tunnelUpGauge)
+		if age, ok := health.HandshakeAge(now); ok {
+			ch <- prometheus.MustNewConstMetric(c.handshakeAgeDesc, prometheus.GaugeValue, age.Seconds(), labelValues...)
+		}
 
-	for _, conn := range connections {
+		if health.Traffic != nil {
+			ch <- prometheus.MustNewConstMetric(c.receivedDesc, prometheus.GaugeValue, float64(health.Traffic.BytesIn), labelValues...)
+			ch <- prometheus.MustNewConstMetric(c.sentDesc, prometheus.GaugeValue, float64(health.Traffic.BytesOut), labelValues...)
+		}
+	}
+}
 
-		for _, tunnel := range conn.VgwTelemetry {
+// Update refreshes metrics with the tunnel connection data, returning once they are refreshed, or straight away if
+// the collector has been interrupted
+func (c *vpnCollector) Update(connections []*vpn.Connection) {
 
-			labels := labelsForTunnelGauge(*conn.VpnGatewayId, *tunnel.OutsideIpAddress)
-			id := idForTunnelGauge(labels)
+	u := &update{connections: connections, done: make(chan struct{})}
 
-			if existingGauge, ok := c.gauges[id]; ok {
+	select {
+	case c.update <- u:
+	case <-c.cancel:
+		return
+	}
 
-				_ = level.Debug(c.logger).Log("msg", fmt.Sprintf("Updating existing gauge: %v", labels))
+	select {
+	case <-u.done:
+	case <-c.cancel:
+	}
+}
 
-				existingGauge.updateFrom(tunnel)
-				currentGauges[id] = existingGauge
-				delete(c.gauges, id)
+// current returns the latest snapshot
+func (c *vpnCollector) current() snapshot {
+	return c.snapshot.Load().(snapshot)
+}
 
-			} else {
+// updateWith publishes a new snapshot of the current state of the VPNs.
+// Metrics for tunnels that have been removed are no longer collected, and new ones are.
+func (c *vpnCollector) updateWith(connections []*vpn.Connection) {
 
-				_ = level.Debug(c.logger).Log("msg", fmt.Sprintf("Adding gauge for new tunnel instance: %v", labels))
+	previous := c.current()
+	current := make(snapshot)
 
-				newGauge := newTunnelUpGauge(id,
-					labels,
-					c.tunnelUpGaugeVec.With(labels),
-					func() {
-						c.tunnelUpGaugeVec.Delete(labels)
-					},
-				).updateFrom(tunnel)
+	for _, health := range Health(connections) {
 
-				currentGauges[newGauge.id] = newGauge
+		id := idForTunnelGauge(health.Labels)
 
-			}
+		if _, ok := previous[id]; ok {
+			_ = level.Debug(c.logger).Log("msg", fmt.Sprintf("Updating existing gauge: %v", health.Labels))
+		} else {
+			_ = level.Debug(c.logger).Log("msg", fmt.Sprintf("Adding gauge for new tunnel instance: %v", health.Labels))
+		}
 
+		// Take a copy of the traffic, so the snapshot doesn't change if the tunnel does
+		if health.Traffic != nil {
+			traffic := *health.Traffic
+			health.Traffic = &traffic
 		}
 
+		current[id] = health
 	}
 
-	for _, redundantCollector := range c.gauges {
-		_ = level.Debug(c.logger).Log("msg", fmt.Sprintf("Removing redundant gauge: %v", redundantCollector.id))
-		delete(c.gauges, redundantCollector.id)
-		redundantCollector.delete()
+	for id := range previous {
+		if _, ok := current[id]; !ok {
+			_ = level.Debug(c.logger).Log("msg", fmt.Sprintf("Removing redundant gauge: %v", id))
+		}
 	}
 
-	c.gauges = currentGauges
+	c.snapshot.Store(current)
 
 }
 
-// collectAndDone is used to perform the Prometheus Collect operation from a go routine.
-type collectAndDone struct {
-	// The channel to be used for the Collect operation
-	ch chan<- prometheus.Metric
-
-	// signals when the collect operation is complete by closing this channel
-	done chan interface{}
-}
-
-// wait blocks until the collect is completed
-func (c collectAndDone) wait() {
-	<-c.done
-}
-
-// finished signals the collect is completed
-func (c collectAndDone) finished() {
-	close(c.done)
-}
-
 // buildCollectorID returns an id that distinguishes a gauge from any other
 func buildCollectorID(metricName string, labels prometheus.Labels) string {
 	var labelNamesValues []string
@@ -228,10 +226,24 @@ func buildCollectorID(metricName string, labels prometheus.Labels) string {
 	return metricName + ":" + strings.Join(labelNamesValues, "|")
 }
 
-func labelsForTunnelGauge(gatewayId string, outsideIP string) prometheus.Labels {
+// TunnelLabelNames are the names of the labels identifying a tunnel in metrics about it
+var TunnelLabelNames = []string{"vpn_id", "transit_gateway_id", "outside_ip", "tunnel_id"}
+
+// TunnelLabels returns the labels identifying a tunnel in metrics about it, whose names are TunnelLabelNames.
+// Connections that don't terminate on an AWS gateway are identified by their own ID instead, and their tunnels by
+// their IDs too, as their outside IPs can be unknown or shared by tunnels behind the same NAT.
+func TunnelLabels(conn *vpn.Connection, tunnel *vpn.Tunnel) prometheus.Labels {
+
+	vpnID, tunnelID := conn.Attribute(vpn.AttrVpnGatewayID), ""
+	if vpnID == "" && conn.Attribute(vpn.AttrTransitGatewayID) == "" {
+		vpnID, tunnelID = conn.ID, tunnel.ID
+	}
+
 	return prometheus.Labels{
-		"vpn_id":     gatewayId,
-		"outside_ip": outsideIP,
+		"vpn_id":             vpnID,
+		"transit_gateway_id": conn.Attribute(vpn.AttrTransitGatewayID),
+		"outside_ip":         tunnel.OutsideIP,
+		"tunnel_id":          tunnelID,
 	}
 }
 
@@ -3,13 +3,13 @@ package metrics
 import (
 	"fmt"
 	"github.com/Pallinder/go-randomdata"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
 	"github.com/go-kit/kit/log"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	"strings"
 	"testing"
+	"time"
 )
 
 var tunneltests = []struct {
@@ -26,7 +26,7 @@ func TestTunnelUp(t *testing.T) {
 
 	for _, tt := range tunneltests {
 		t.Run(tt.name, func(t *testing.T) {
-			underTest := NewVpnStatusCollector(prometheus.NewRegistry(), log.NewNopLogger())
+			underTest, registry := newCollectorForTesting()
 			defer underTest.Interrupt(nil)
 
 			// When the actor is run
@@ -36,13 +36,95 @@ func TestTunnelUp(t *testing.T) {
 
 			underTest.Update(tt.test.telemetry)
 
-			if err := testutil.CollectAndCompare(underTest, strings.NewReader(tt.test.truth)); err != nil {
+			if err := testutil.GatherAndCompare(registry, strings.NewReader(tt.test.truth)); err != nil {
 				t.Errorf("unexpected collecting result:\n%s", err)
 			}
 		})
 	}
 }
 
+func TestTransitGatewayTunnelUp(t *testing.T) {
+
+	underTest, registry := newCollectorForTesting()
+	defer underTest.Interrupt(nil)
+
+	// When the actor is run
+	go func(c *vpnCollector) {
+		_ = c.Execute()
+	}(underTest)
+
+	// Given a connection that terminates on a transit gateway rather than a VPN gateway
+	underTest.Update([]*vpn.Connection{
+		{Attributes: map[string]string{vpn.AttrTransitGatewayID: "tgw-1"},
+			Tunnels: []*vpn.Tunnel{{Status: vpn.StatusUp, OutsideIP: "1.2.3.4"}}},
+	})
+
+	const truth = `
+		# HELP cc_vpn_tunnel_up If the site to site VPN tunnel status is up, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.
+		# TYPE cc_vpn_tunnel_up gauge
+		cc_vpn_tunnel_up{outside_ip="1.2.3.4",transit_gateway_id="tgw-1",tunnel_id="",vpn_id=""} 1
+	`
+
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(truth)); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
+
+func TestHandshakeAndTraffic(t *testing.T) {
+
+	underTest, registry := newCollectorForTesting()
+	defer underTest.Interrupt(nil)
+
+	// When the actor is run
+	go func(c *vpnCollector) {
+		_ = c.Execute()
+	}(underTest)
+
+	// Given a connection from a source that reports handshakes and traffic, and doesn't use an AWS gateway
+	underTest.Update([]*vpn.Connection{
+		{ID: "wireguard:wg0",
+			Tunnels: []*vpn.Tunnel{
+				{ID: "peer-a", Status: vpn.StatusUp, OutsideIP: "1.2.3.4",
+					LastHandshake: fixedClock{}.Now().Add(-90 * time.Second),
+					Traffic:       &vpn.Traffic{BytesIn: 100, BytesOut: 200}},
+				{ID: "peer-b", Status: vpn.StatusDown, OutsideIP: "5.6.7.8",
+					Traffic: &vpn.Traffic{}},
+			}},
+	})
+
+	// Then the handshake age should only be published for the tunnel that has handshook, and the connection and
+	// tunnel IDs used to identify the tunnels
+	const truth = `
+		# HELP cc_vpn_tunnel_handshake_age_seconds Seconds since the ends of the VPN tunnel last handshook, for sources that report it, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.
+		# TYPE cc_vpn_tunnel_handshake_age_seconds gauge
+		cc_vpn_tunnel_handshake_age_seconds{outside_ip="1.2.3.4",transit_gateway_id="",tunnel_id="peer-a",vpn_id="wireguard:wg0"} 90
+		# HELP cc_vpn_tunnel_received_bytes Bytes received through the VPN tunnel since it was set up, for sources that count them, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.
+		# TYPE cc_vpn_tunnel_received_bytes gauge
+		cc_vpn_tunnel_received_bytes{outside_ip="1.2.3.4",transit_gateway_id="",tunnel_id="peer-a",vpn_id="wireguard:wg0"} 100
+		cc_vpn_tunnel_received_bytes{outside_ip="5.6.7.8",transit_gateway_id="",tunnel_id="peer-b",vpn_id="wireguard:wg0"} 0
+		# HELP cc_vpn_tunnel_sent_bytes Bytes sent through the VPN tunnel since it was set up, for sources that count them, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.
+		# TYPE cc_vpn_tunnel_sent_bytes gauge
+		cc_vpn_tunnel_sent_bytes{outside_ip="1.2.3.4",transit_gateway_id="",tunnel_id="peer-a",vpn_id="wireguard:wg0"} 200
+		cc_vpn_tunnel_sent_bytes{outside_ip="5.6.7.8",transit_gateway_id="",tunnel_id="peer-b",vpn_id="wireguard:wg0"} 0
+		# HELP cc_vpn_tunnel_up If the site to site VPN tunnel status is up, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.
+		# TYPE cc_vpn_tunnel_up gauge
+		cc_vpn_tunnel_up{outside_ip="1.2.3.4",transit_gateway_id="",tunnel_id="peer-a",vpn_id="wireguard:wg0"} 1
+		cc_vpn_tunnel_up{outside_ip="5.6.7.8",transit_gateway_id="",tunnel_id="peer-b",vpn_id="wireguard:wg0"} 0
+	`
+
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(truth)); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+
+	// When the tunnels go away
+	underTest.Update([]*vpn.Connection{})
+
+	// Then so should their metrics
+	if err := testutil.GatherAndCompare(registry, strings.NewReader("")); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
+
 var oneUpOneDown = testCaseFor(1, 1)
 
 type updatetest struct {
@@ -64,7 +146,7 @@ func TestMetricsUpdated(t *testing.T) {
 	for _, tt := range updatedtests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			underTest := NewVpnStatusCollector(prometheus.NewRegistry(), log.NewNopLogger())
+			underTest, registry := newCollectorForTesting()
 			defer underTest.Interrupt(nil)
 
 			// When the actor is run
@@ -74,14 +156,14 @@ func TestMetricsUpdated(t *testing.T) {
 
 			underTest.Update(tt.firstupdate.telemetry)
 
-			if err := testutil.CollectAndCompare(underTest, strings.NewReader(tt.firstupdate.truth)); err != nil {
+			if err := testutil.GatherAndCompare(registry, strings.NewReader(tt.firstupdate.truth)); err != nil {
 				t.Errorf("First update failed:\n%s", err)
 				return
 			}
 
 			underTest.Update(tt.secondupdate.telemetry)
 
-			if err := testutil.CollectAndCompare(underTest, strings.NewReader(tt.secondupdate.truth)); err != nil {
+			if err := testutil.GatherAndCompare(registry, strings.NewReader(tt.secondupdate.truth)); err != nil {
 				t.Errorf("Second update failed:\n%s", err)
 				return
 			}
@@ -90,9 +172,96 @@ func TestMetricsUpdated(t *testing.T) {
 	}
 }
 
+func TestScrapesDontWaitForTheLoop(t *testing.T) {
+
+	// Given a collector that has had an update, then been interrupted
+	underTest, registry := newCollectorForTesting()
+	go func(c *vpnCollector) {
+		_ = c.Execute()
+	}(underTest)
+
+	test := testCaseFor(1, 1)
+	underTest.Update(test.telemetry)
+	underTest.Interrupt(nil)
+
+	// When it's scraped, and updated again
+	done := make(chan error)
+	go func() {
+		underTest.Update(testCaseFor(1, 0).telemetry)
+		done <- testutil.GatherAndCompare(registry, strings.NewReader(test.truth))
+	}()
+
+	// Then neither hangs, and the metrics are as of the last update
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected collecting result:\n%s", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for the scrape")
+	}
+}
+
+func TestScrapesBeforeAnyUpdate(t *testing.T) {
+
+	// Given a collector whose loop hasn't started
+	_, registry := newCollectorForTesting()
+
+	// When it's scraped
+	done := make(chan error)
+	go func() {
+		done <- testutil.GatherAndCompare(registry, strings.NewReader(""))
+	}()
+
+	// Then there are no metrics, rather than a hang
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected collecting result:\n%s", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for the scrape")
+	}
+}
+
+func TestScrapesDuringUpdates(t *testing.T) {
+
+	underTest, registry := newCollectorForTesting()
+	defer underTest.Interrupt(nil)
+
+	go func(c *vpnCollector) {
+		_ = c.Execute()
+	}(underTest)
+
+	// Given a tunnel that is reported twice with the same labels
+	tunnel := &vpn.Tunnel{Status: vpn.StatusUp, OutsideIP: "1.2.3.4", Traffic: &vpn.Traffic{}}
+	connections := []*vpn.Connection{{ID: "vpn-1", Tunnels: []*vpn.Tunnel{tunnel, tunnel}}}
+
+	// When they are scraped while being updated
+	updated := make(chan struct{})
+	go func() {
+		defer close(updated)
+		for i := 0; i < 100; i++ {
+			underTest.Update(connections)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		if _, err := registry.Gather(); err != nil {
+			t.Fatalf("Unexpected error scraping: %v", err)
+		}
+	}
+	<-updated
+
+	// Then there's one of each metric for the tunnel
+	if count := testutil.CollectAndCount(underTest); count != 3 {
+		t.Errorf("Expected 3 metrics, got %d", count)
+	}
+}
+
 // Holds vpn connection data and the corresponding metric output for it
 type telemetryAndTruth struct {
-	telemetry []*ec2.VpnConnection
+	telemetry []*vpn.Connection
 	truth     string
 }
 
@@ -100,22 +269,22 @@ type telemetryAndTruth struct {
 func testCaseFor(up int, down int) *telemetryAndTruth {
 
 	gwid := randomdata.RandStringRunes(10)
-	telemetry := append(genTelemetry(down, aws.String(ec2.TelemetryStatusDown)), genTelemetry(up, aws.String(ec2.TelemetryStatusUp))...)
+	telemetry := append(genTelemetry(down, vpn.StatusDown), genTelemetry(up, vpn.StatusUp)...)
 
 	return &telemetryAndTruth{
-		telemetry: []*ec2.VpnConnection{
-			{VpnGatewayId: aws.String(gwid),
-				VgwTelemetry: telemetry},
+		telemetry: []*vpn.Connection{
+			{Attributes: map[string]string{vpn.AttrVpnGatewayID: gwid},
+				Tunnels: telemetry},
 		},
 		truth: expectedOutputFor(gwid, telemetry),
 	}
 
 }
 
-func expectedOutputFor(gwid string, telemetry []*ec2.VgwTelemetry) string {
+func expectedOutputFor(gwid string, telemetry []*vpn.Tunnel) string {
 
 	const metadata = `
-		# HELP cc_vpn_tunnel_up If the site to site VPN tunnel status is up, partitioned by VPN Connection ID and Outside IP.
+		# HELP cc_vpn_tunnel_up If the site to site VPN tunnel status is up, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.
 		# TYPE cc_vpn_tunnel_up gauge
 	`
 	var str strings.Builder
@@ -132,28 +301,28 @@ func oneTunnelChangingState() updatetest {
 	gwid := randomdata.RandStringRunes(10)
 
 	// First update has the tunnel UP
-	first := genTelemetry(1, aws.String(ec2.TelemetryStatusUp))
+	first := genTelemetry(1, vpn.StatusUp)
 
 	firstUpdate := &telemetryAndTruth{
-		telemetry: []*ec2.VpnConnection{
-			{VpnGatewayId: aws.String(gwid),
-				VgwTelemetry: first},
+		telemetry: []*vpn.Connection{
+			{Attributes: map[string]string{vpn.AttrVpnGatewayID: gwid},
+				Tunnels: first},
 		},
 		truth: expectedOutputFor(gwid, first),
 	}
 
 	// Second update has the same tunnel but DOWN
-	second := []*ec2.VgwTelemetry{
+	second := []*vpn.Tunnel{
 		{
-			Status:           aws.String(ec2.TelemetryStatusDown),
-			OutsideIpAddress: first[0].OutsideIpAddress,
+			Status:    vpn.StatusDown,
+			OutsideIP: first[0].OutsideIP,
 		},
 	}
 
 	secondUpdate := &telemetryAndTruth{
-		telemetry: []*ec2.VpnConnection{
-			{VpnGatewayId: aws.String(gwid),
-				VgwTelemetry: second},
+		telemetry: []*vpn.Connection{
+			{Attributes: map[string]string{vpn.AttrVpnGatewayID: gwid},
+				Tunnels: second},
 		},
 		truth: expectedOutputFor(gwid, second),
 	}
@@ -166,28 +335,28 @@ func oneTunnelChangingState() updatetest {
 func someCommonDataBetweenUpdates() updatetest {
 
 	gwid := randomdata.RandStringRunes(10)
-	shared := append(genTelemetry(2, aws.String(ec2.TelemetryStatusDown)), genTelemetry(2, aws.String(ec2.TelemetryStatusUp))...)
+	shared := append(genTelemetry(2, vpn.StatusDown), genTelemetry(2, vpn.StatusUp)...)
 
 	var firstSharedUpdate, secondSharedUpdate *telemetryAndTruth
 
 	{
-		firstupdate := append(append(genTelemetry(1, aws.String(ec2.TelemetryStatusDown)), genTelemetry(1, aws.String(ec2.TelemetryStatusUp))...), shared...)
+		firstupdate := append(append(genTelemetry(1, vpn.StatusDown), genTelemetry(1, vpn.StatusUp)...), shared...)
 
 		firstSharedUpdate = &telemetryAndTruth{
-			telemetry: []*ec2.VpnConnection{
-				{VpnGatewayId: aws.String(gwid),
-					VgwTelemetry: firstupdate},
+			telemetry: []*vpn.Connection{
+				{Attributes: map[string]string{vpn.AttrVpnGatewayID: gwid},
+					Tunnels: firstupdate},
 			},
 			truth: expectedOutputFor(gwid, firstupdate),
 		}
 	}
 	{
-		secondupdate := append(append(genTelemetry(1, aws.String(ec2.TelemetryStatusDown)), genTelemetry(1, aws.String(ec2.TelemetryStatusUp))...), shared...)
+		secondupdate := append(append(genTelemetry(1, vpn.StatusDown), genTelemetry(1, vpn.StatusUp)...), shared...)
 
 		secondSharedUpdate = &telemetryAndTruth{
-			telemetry: []*ec2.VpnConnection{
-				{VpnGatewayId: aws.String(gwid),
-					VgwTelemetry: secondupdate},
+			telemetry: []*vpn.Connection{
+				{Attributes: map[string]string{vpn.AttrVpnGatewayID: gwid},
+					Tunnels: secondupdate},
 			},
 			truth: expectedOutputFor(gwid, secondupdate),
 		}
@@ -198,18 +367,18 @@ func someCommonDataBetweenUpdates() updatetest {
 }
 
 // toExpectedMetricString generates the expected metrics as a string for the supplied tunnel data
-func toExpectedMetricString(gwid string, tunnels []*ec2.VgwTelemetry) string {
+func toExpectedMetricString(gwid string, tunnels []*vpn.Tunnel) string {
 	var str strings.Builder
 
 	for _, tunnel := range tunnels {
 
 		status := 0
 
-		if *tunnel.Status == ec2.TelemetryStatusUp {
+		if tunnel.Status == vpn.StatusUp {
 			status = 1
 		}
 
-		str.WriteString(fmt.Sprintf("cc_vpn_tunnel_up{outside_ip=\"%s\",vpn_id=\"%s\"} %d\n", *tunnel.OutsideIpAddress, gwid, status))
+		str.WriteString(fmt.Sprintf("cc_vpn_tunnel_up{outside_ip=\"%s\",transit_gateway_id=\"\",tunnel_id=\"\",vpn_id=\"%s\"} %d\n", tunnel.OutsideIP, gwid, status))
 	}
 
 	return str.String()
@@ -217,15 +386,21 @@ func toExpectedMetricString(gwid string, tunnels []*ec2.VgwTelemetry) string {
 }
 
 // Builds a test case including the supplied data
-func genTelemetry(required int, status *string) []*ec2.VgwTelemetry {
+func genTelemetry(required int, status vpn.Status) []*vpn.Tunnel {
 
-	telemetry := make([]*ec2.VgwTelemetry, 0)
+	telemetry := make([]*vpn.Tunnel, 0)
 
 	for i := 0; i < required; i++ {
 		ip := randomdata.IpV4Address()
-		telemetry = append(telemetry, &ec2.VgwTelemetry{Status: status, OutsideIpAddress: aws.String(ip)})
+		telemetry = append(telemetry, &vpn.Tunnel{Status: status, OutsideIP: ip})
 	}
 
 	return telemetry
 
 }
+
+// newCollectorForTesting returns a collector registered with a registry to gather its metrics from, named by default
+func newCollectorForTesting() (*vpnCollector, *prometheus.Registry) {
+	registry := prometheus.NewRegistry()
+	return NewVpnStatusCollector(DefaultNaming.Registerer(registry), log.NewNopLogger(), fixedClock{}), registry
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"net/http"
+	"time"
+)
+
+// pushgatewayUpdater pushes the health of the tunnels to a Prometheus Pushgateway every time it's updated, for runs
+// of vpnck too short to be scraped
+type pushgatewayUpdater struct {
+	pusher               *push.Pusher
+	tunnelUpGaugeVec     *prometheus.GaugeVec
+	handshakeAgeGaugeVec *prometheus.GaugeVec
+	receivedGaugeVec     *prometheus.GaugeVec
+	sentGaugeVec         *prometheus.GaugeVec
+	clock                state.Clock
+	logger               log.Logger
+}
+
+// NewPushgatewayUpdater returns an instance ready to use, which pushes to the Pushgateway at the URL under the job and
+// grouping key, named as supplied. Each push replaces all the metrics of the group, so tunnels that have gone away
+// are removed.
+func NewPushgatewayUpdater(logger log.Logger, url string, job string, grouping prometheus.Labels, naming Naming, clock state.Clock) *pushgatewayUpdater {
+
+	u := pushgatewayUpdater{
+		tunnelUpGaugeVec:     prometheus.NewGaugeVec(tunnelUpOpts, TunnelLabelNames),
+		handshakeAgeGaugeVec: prometheus.NewGaugeVec(handshakeAgeOpts, TunnelLabelNames),
+		receivedGaugeVec:     prometheus.NewGaugeVec(receivedOpts, TunnelLabelNames),
+		sentGaugeVec:         prometheus.NewGaugeVec(sentOpts, TunnelLabelNames),
+		clock:                clock,
+		logger:               log.With(logger, "actor", "pushgateway"),
+	}
+
+	registry := prometheus.NewRegistry()
+	naming.Registerer(registry).MustRegister(u.tunnelUpGaugeVec, u.handshakeAgeGaugeVec, u.receivedGaugeVec, u.sentGaugeVec)
+
+	u.pusher = push.New(url, job).Gatherer(registry).Client(&http.Client{Timeout: 10 * time.Second})
+
+	for name, value := range grouping {
+		u.pusher = u.pusher.Grouping(name, value)
+	}
+
+	return &u
+}
+
+// Update pushes the health of the tunnels of the connections. Failures are logged rather than stopping the pipeline,
+// as the next update pushes the latest health anyway.
+func (u *pushgatewayUpdater) Update(connections []*vpn.Connection) {
+
+	now := u.clock.Now()
+
+	for _, vec := range []*prometheus.GaugeVec{u.tunnelUpGaugeVec, u.handshakeAgeGaugeVec, u.receivedGaugeVec, u.sentGaugeVec} {
+		vec.Reset()
+	}
+
+	for _, health := range Health(connections) {
+
+		u.tunnelUpGaugeVec.With(health.Labels).Set(health.UpValue())
+
+		if age, ok := health.HandshakeAge(now); ok {
+			u.handshakeAgeGaugeVec.With(health.Labels).Set(age.Seconds())
+		}
+
+		if health.Traffic != nil {
+			u.receivedGaugeVec.With(health.Labels).Set(float64(health.Traffic.BytesIn))
+			u.sentGaugeVec.With(health.Labels).Set(float64(health.Traffic.BytesOut))
+		}
+	}
+
+	if err := u.pusher.Push(); err != nil {
+		_ = level.Warn(u.logger).Log("msg", "Unable to push metrics to the Pushgateway", "err", err)
+	}
+}
@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pushgateway stands in for a Prometheus Pushgateway, keeping what's pushed to it as text
+type pushgateway struct {
+	mutex  sync.Mutex
+	status int
+	method string
+	path   string
+	pushed string
+}
+
+func (p *pushgateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.method = r.Method
+	p.path = r.URL.Path
+
+	var text bytes.Buffer
+	decoder := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+	for {
+		var family dto.MetricFamily
+		if err := decoder.Decode(&family); err == io.EOF {
+			break
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, _ = expfmt.MetricFamilyToText(&text, &family)
+	}
+	p.pushed = text.String()
+
+	w.WriteHeader(p.status)
+}
+
+// healthTestConnections has a tunnel that is up with a handshake and traffic, and one that's down with neither
+func healthTestConnections() []*vpn.Connection {
+	return []*vpn.Connection{
+		{
+			ID:         "vpn-1",
+			Attributes: map[string]string{vpn.AttrVpnGatewayID: "vgw-1"},
+			Tunnels: []*vpn.Tunnel{
+				{
+					OutsideIP:     "1.1.1.1",
+					Status:        vpn.StatusUp,
+					LastHandshake: fixedClock{}.Now().Add(-90 * time.Second),
+					Traffic:       &vpn.Traffic{BytesIn: 1024, BytesOut: 2048},
+				},
+				{
+					OutsideIP: "2.2.2.2",
+					Status:    vpn.StatusDown,
+				},
+			},
+		},
+	}
+}
+
+func TestPushgatewayUpdater(t *testing.T) {
+
+	// Given a Pushgateway
+	gateway := &pushgateway{status: http.StatusOK}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	naming := Naming{Namespace: "cc", Subsystem: "vpn", ConstLabels: prometheus.Labels{"cluster": "eu-1"}}
+	underTest := NewPushgatewayUpdater(log.NewNopLogger(), server.URL, "vpnck", prometheus.Labels{"site": "london"}, naming, fixedClock{})
+
+	// When it's updated
+	underTest.Update(healthTestConnections())
+
+	// Then the metrics replace those of the group
+	if gateway.method != http.MethodPut {
+		t.Errorf("Expected the metrics to be put, got %s", gateway.method)
+	}
+	if gateway.path != "/metrics/job/vpnck/site/london" {
+		t.Errorf("Unexpected grouping key %s", gateway.path)
+	}
+
+	// And they are the same as the collector publishes
+	expected := `# HELP cc_vpn_tunnel_handshake_age_seconds Seconds since the ends of the VPN tunnel last handshook, for sources that report it, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.
+# TYPE cc_vpn_tunnel_handshake_age_seconds gauge
+cc_vpn_tunnel_handshake_age_seconds{cluster="eu-1",outside_ip="1.1.1.1",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 90
+# HELP cc_vpn_tunnel_received_bytes Bytes received through the VPN tunnel since it was set up, for sources that count them, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.
+# TYPE cc_vpn_tunnel_received_bytes gauge
+cc_vpn_tunnel_received_bytes{cluster="eu-1",outside_ip="1.1.1.1",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 1024
+# HELP cc_vpn_tunnel_sent_bytes Bytes sent through the VPN tunnel since it was set up, for sources that count them, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.
+# TYPE cc_vpn_tunnel_sent_bytes gauge
+cc_vpn_tunnel_sent_bytes{cluster="eu-1",outside_ip="1.1.1.1",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 2048
+# HELP cc_vpn_tunnel_up If the site to site VPN tunnel status is up, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.
+# TYPE cc_vpn_tunnel_up gauge
+cc_vpn_tunnel_up{cluster="eu-1",outside_ip="1.1.1.1",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 1
+cc_vpn_tunnel_up{cluster="eu-1",outside_ip="2.2.2.2",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 0
+`
+	if diff := cmp.Diff(expected, gateway.pushed); diff != "" {
+		t.Errorf("Unexpected metrics pushed (-want +got):\n%s", diff)
+	}
+
+	// When a tunnel goes away
+	connections := healthTestConnections()
+	connections[0].Tunnels = connections[0].Tunnels[1:]
+	underTest.Update(connections)
+
+	// Then its metrics aren't pushed any more
+	expected = `# HELP cc_vpn_tunnel_up If the site to site VPN tunnel status is up, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.
+# TYPE cc_vpn_tunnel_up gauge
+cc_vpn_tunnel_up{cluster="eu-1",outside_ip="2.2.2.2",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1"} 0
+`
+	if diff := cmp.Diff(expected, gateway.pushed); diff != "" {
+		t.Errorf("Unexpected metrics pushed (-want +got):\n%s", diff)
+	}
+}
+
+func TestPushgatewayUpdaterCarriesOnWhenPushesFail(t *testing.T) {
+
+	// Given a Pushgateway that fails
+	gateway := &pushgateway{status: http.StatusInternalServerError}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	underTest := NewPushgatewayUpdater(log.NewNopLogger(), server.URL, "vpnck", nil, DefaultNaming, fixedClock{})
+
+	// When it's updated
+	underTest.Update(healthTestConnections())
+	underTest.Update(healthTestConnections())
+
+	// Then it keeps pushing
+	if gateway.path != "/metrics/job/vpnck" {
+		t.Errorf("Expected a push without a grouping key, got %s", gateway.path)
+	}
+}
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxPacketSize keeps datagrams within the MTU of most networks, as the DogStatsD clients do
+const maxPacketSize = 1432
+
+// statsdUpdater emits the health of the tunnels as DogStatsD gauges, tagged with the labels the Prometheus metrics
+// have, every time it's updated
+type statsdUpdater struct {
+	conn   net.Conn
+	prefix string
+	tags   []string
+	clock  state.Clock
+	logger log.Logger
+}
+
+// NewStatsdUpdater returns an instance ready to use, which sends to the StatsD server at the host:port address over
+// UDP. Metrics are named as supplied with dots rather than underscores separating the namespace and subsystem, such
+// as cc.vpn.tunnel_up, and the constant labels are added as tags.
+func NewStatsdUpdater(logger log.Logger, address string, naming Naming, clock state.Clock) (*statsdUpdater, error) {
+
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to send to StatsD at %s: %v", address, err)
+	}
+
+	var prefix string
+	for _, part := range []string{naming.Namespace, naming.Subsystem} {
+		if part != "" {
+			prefix += part + "."
+		}
+	}
+
+	return &statsdUpdater{
+		conn:   conn,
+		prefix: prefix,
+		tags:   statsdTags(naming.ConstLabels),
+		clock:  clock,
+		logger: log.With(logger, "actor", "statsd"),
+	}, nil
+}
+
+// Update emits the health of the tunnels of the connections. Failures are logged rather than stopping the pipeline,
+// as the next update emits the latest health anyway.
+func (u *statsdUpdater) Update(connections []*vpn.Connection) {
+
+	now := u.clock.Now()
+
+	var lines []string
+
+	for _, health := range Health(connections) {
+
+		tags := append(statsdTags(health.Labels), u.tags...)
+
+		lines = append(lines, u.gauge(tunnelUpOpts.Name, health.UpValue(), tags))
+
+		if age, ok := health.HandshakeAge(now); ok {
+			lines = append(lines, u.gauge(handshakeAgeOpts.Name, age.Seconds(), tags))
+		}
+
+		if health.Traffic != nil {
+			lines = append(lines, u.gauge(receivedOpts.Name, float64(health.Traffic.BytesIn), tags))
+			lines = append(lines, u.gauge(sentOpts.Name, float64(health.Traffic.BytesOut), tags))
+		}
+	}
+
+	for _, packet := range packets(lines) {
+		if _, err := u.conn.Write([]byte(packet)); err != nil {
+			_ = level.Warn(u.logger).Log("msg", "Unable to send metrics to StatsD", "err", err)
+			return
+		}
+	}
+}
+
+// gauge returns the DogStatsD line setting the gauge
+func (u *statsdUpdater) gauge(name string, value float64, tags []string) string {
+	line := u.prefix + name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|g"
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+// statsdTags returns the labels as name:value tags in name order. Labels with no value, such as the Transit Gateway
+// ID of tunnels that don't have one, aren't tags.
+func statsdTags(labels map[string]string) []string {
+
+	var tags []string
+	for name, value := range labels {
+		if value == "" {
+			continue
+		}
+		tags = append(tags, name+":"+strings.NewReplacer(",", "_", "|", "_").Replace(value))
+	}
+	sort.Strings(tags)
+
+	return tags
+}
+
+// packets packs the lines into as few datagrams as will fit them
+func packets(lines []string) []string {
+
+	var packets []string
+	var packet string
+
+	for _, line := range lines {
+		if packet != "" && len(packet)+1+len(line) > maxPacketSize {
+			packets = append(packets, packet)
+			packet = ""
+		}
+		if packet != "" {
+			packet += "\n"
+		}
+		packet += line
+	}
+
+	if packet != "" {
+		packets = append(packets, packet)
+	}
+
+	return packets
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"github.com/go-kit/kit/log"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsdUpdater(t *testing.T) {
+
+	// Given a StatsD server
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	naming := Naming{Namespace: "cc", Subsystem: "vpn", ConstLabels: prometheus.Labels{"cluster": "eu-1"}}
+	underTest, err := NewStatsdUpdater(log.NewNopLogger(), server.LocalAddr().String(), naming, fixedClock{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// When it's updated
+	underTest.Update(healthTestConnections())
+
+	// Then the same health the collector publishes is sent as tagged gauges
+	buf := make([]byte, maxPacketSize)
+	_ = server.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Nothing sent to StatsD: %v", err)
+	}
+
+	expected := []string{
+		"cc.vpn.tunnel_up:1|g|#outside_ip:1.1.1.1,vpn_id:vgw-1,cluster:eu-1",
+		"cc.vpn.tunnel_handshake_age_seconds:90|g|#outside_ip:1.1.1.1,vpn_id:vgw-1,cluster:eu-1",
+		"cc.vpn.tunnel_received_bytes:1024|g|#outside_ip:1.1.1.1,vpn_id:vgw-1,cluster:eu-1",
+		"cc.vpn.tunnel_sent_bytes:2048|g|#outside_ip:1.1.1.1,vpn_id:vgw-1,cluster:eu-1",
+		"cc.vpn.tunnel_up:0|g|#outside_ip:2.2.2.2,vpn_id:vgw-1,cluster:eu-1",
+	}
+	if diff := cmp.Diff(expected, strings.Split(string(buf[:n]), "\n")); diff != "" {
+		t.Errorf("Unexpected gauges sent (-want +got):\n%s", diff)
+	}
+}
+
+func TestStatsdNaming(t *testing.T) {
+
+	underTest, err := NewStatsdUpdater(log.NewNopLogger(), "127.0.0.1:8125", Naming{Subsystem: "vpn"}, fixedClock{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if got := underTest.gauge("tunnel_up", 1, nil); got != "vpn.tunnel_up:1|g" {
+		t.Errorf("Unexpected gauge %s", got)
+	}
+}
+
+func TestPackets(t *testing.T) {
+
+	// Given more lines than fit in a packet
+	line := strings.Repeat("x", 500)
+	lines := []string{line, line, line, line, line}
+
+	// When they're packed
+	got := packets(lines)
+
+	// Then they're split between packets, without splitting any line
+	expected := []string{line + "\n" + line, line + "\n" + line, line}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("Unexpected packets (-want +got):\n%s", diff)
+	}
+}
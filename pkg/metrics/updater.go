@@ -1,15 +1,17 @@
 package metrics
 
 import (
-	"github.com/aws/aws-sdk-go/service/ec2"
+	"fmt"
 	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/oklog/oklog/pkg/group"
 )
 
-// UpdaterStage inserts calls to an Updater in a pipeline of VPN status update handlers
-func UpdaterStage(logger log.Logger, updater Updater, in <-chan []*ec2.VpnConnection, out chan<- []*ec2.VpnConnection) actor.Actor {
+// UpdaterStage inserts calls to an Updater in a pipeline of VPN status update handlers. The update and the hand-off to
+// the next stage are traced as a span of the poll cycle of the connections, if they have one.
+func UpdaterStage(logger log.Logger, updater Updater, in <-chan state.Poll, out chan<- state.Poll) actor.Actor {
 
 	cancel := make(chan struct{})
 
@@ -19,9 +21,12 @@ func UpdaterStage(logger log.Logger, updater Updater, in <-chan []*ec2.VpnConnec
 
 			select {
 
-			case vpnStatus := <-in:
-				updater.Update(vpnStatus)
-				out <- vpnStatus
+			case poll := <-in:
+				span := poll.Cycle.Start("updater")
+				span.SetAttribute("updater", fmt.Sprintf("%T", updater))
+				updater.Update(poll.Connections)
+				out <- poll
+				span.End()
 
 			case <-cancel:
 				_ = level.Info(logger).Log("cancelled", "Asked to shut down")
@@ -37,7 +42,7 @@ func UpdaterStage(logger log.Logger, updater Updater, in <-chan []*ec2.VpnConnec
 }
 
 // AddUpdaterStage adds an updater as a stage to the supplied run group
-func AddUpdaterStage(group *group.Group, logger log.Logger, updater Updater, in <-chan []*ec2.VpnConnection, out chan<- []*ec2.VpnConnection) {
+func AddUpdaterStage(group *group.Group, logger log.Logger, updater Updater, in <-chan state.Poll, out chan<- state.Poll) {
 
 	actorLogger := log.With(logger, "actor", "vpn updater")
 
@@ -1,11 +1,11 @@
 package metrics
 
 import (
-	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
 	"github.com/go-kit/kit/log"
 	"github.com/google/go-cmp/cmp"
-	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/prometheus/client_golang/prometheus"
 	"testing"
 	"time"
@@ -13,10 +13,10 @@ import (
 
 var updatertests = []struct {
 	name      string
-	telemetry []*ec2.VpnConnection
+	telemetry []*vpn.Connection
 }{
 	{name: "Nil telemetry", telemetry: nil},
-	{name: "Empty telemetry", telemetry: make([]*ec2.VpnConnection, 0)},
+	{name: "Empty telemetry", telemetry: make([]*vpn.Connection, 0)},
 	{name: "One tunnel up", telemetry: testCaseFor(1, 0).telemetry},
 }
 
@@ -27,28 +27,29 @@ func TestUpdateCalled(t *testing.T) {
 
 			// Given undertest pipeline with one update sent to it
 			updater := &capturingUpdater{}
-			in := make(chan []*ec2.VpnConnection)
-			out := make(chan []*ec2.VpnConnection)
+			in := make(chan state.Poll)
+			out := make(chan state.Poll)
 
 			undertest := UpdaterStage(log.NewNopLogger(), updater, in, out)
 			defer undertest.Interrupt(nil)
-			go func() { in <- tt.telemetry }()
+			go func() { in <- state.Poll{Connections: tt.telemetry} }()
 
 			// When the stage is running
 			go func(a actor.Actor) {
 				_ = a.Execute()
 			}(undertest)
 
-			var received []*ec2.VpnConnection
+			var received []*vpn.Connection
 			select {
-			case received = <-out:
+			case poll := <-out:
+				received = poll.Connections
 			case <-time.After(1 * time.Second):
 				t.Error("Timed out waiting for telemetry to be passed down the pipeline")
 				return
 			}
 
 			// Then the update should be sent to the next stage in the pipeline
-			if !cmp.Equal(tt.telemetry, received, cmpopts.IgnoreUnexported(ec2.VgwTelemetry{}, ec2.VpnConnection{})) {
+			if !cmp.Equal(tt.telemetry, received) {
 				t.Errorf("Data sent to next stage incorrect : expected %v got %v", tt.telemetry, received)
 				return
 			}
@@ -59,7 +60,7 @@ func TestUpdateCalled(t *testing.T) {
 				return
 			}
 
-			if !cmp.Equal(updater.captured[0], tt.telemetry, cmpopts.IgnoreUnexported(ec2.VgwTelemetry{}, ec2.VpnConnection{})) {
+			if !cmp.Equal(updater.captured[0], tt.telemetry) {
 				t.Errorf("Data sent to the Updater incorrect : expected %v got %v", tt.telemetry, updater.captured[0])
 				return
 			}
@@ -69,14 +70,14 @@ func TestUpdateCalled(t *testing.T) {
 }
 
 type capturingUpdater struct {
-	captured [][]*ec2.VpnConnection
+	captured [][]*vpn.Connection
 }
 
-func (c *capturingUpdater) Update(telemetry []*ec2.VpnConnection) {
+func (c *capturingUpdater) Update(telemetry []*vpn.Connection) {
 	c.captured = append(c.captured, telemetry)
 }
 
-var vpnMetricActor = NewVpnStatusCollector(prometheus.NewRegistry(), log.NewNopLogger())
+var vpnMetricActor = NewVpnStatusCollector(prometheus.NewRegistry(), log.NewNopLogger(), fixedClock{})
 
 var interruptests = []struct {
 	name  string
@@ -84,6 +85,7 @@ var interruptests = []struct {
 }{
 	{name: "VPN metric publisher", actor: actor.NewActor(vpnMetricActor.Execute, vpnMetricActor.Interrupt)},
 	{name: "Updater Stage", actor: updaterStageForTesting()},
+	{name: "CloudWatch Stage", actor: cloudWatchActor(cloudWatchPollerForTesting(), make(chan state.Poll), make(chan state.Poll), make(chan time.Time))},
 }
 
 // Tests that the actors honour the contract as per https://github.com/oklog/run#run.
@@ -120,8 +122,8 @@ func updaterStageForTesting() actor.Actor {
 
 	// Given undertest pipeline with one update sent to it
 	updater := &capturingUpdater{}
-	in := make(chan []*ec2.VpnConnection)
-	out := make(chan []*ec2.VpnConnection)
+	in := make(chan state.Poll)
+	out := make(chan state.Poll)
 
 	return UpdaterStage(log.NewNopLogger(), updater, in, out)
 
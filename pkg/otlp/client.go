@@ -0,0 +1,136 @@
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The parts of the OTLP data model that are exported, as encoded in JSON for OTLP/HTTP.
+// See https://github.com/open-telemetry/opentelemetry-proto/tree/main/opentelemetry/proto
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+// ServiceName identifies vpnck as the source of the metrics and spans exported
+const ServiceName = "vpnck"
+
+// vpnckResource is the resource that metrics and spans are exported for
+var vpnckResource = resource{Attributes: attributes(map[string]string{"service.name": ServiceName})}
+
+// vpnckScope is the instrumentation scope that metrics and spans are recorded by
+var vpnckScope = scope{Name: "github.com/clearchannelinternational/vpncheck"}
+
+// attributes returns the OTLP attributes for the map, in key order so they are stable
+func attributes(m map[string]string) []keyValue {
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	kvs := make([]keyValue, 0, len(m))
+	for _, k := range keys {
+		kvs = append(kvs, keyValue{Key: k, Value: anyValue{StringValue: m[k]}})
+	}
+	return kvs
+}
+
+// unixNano returns the time in the form OTLP encodes them in JSON
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// client posts OTLP data to a collector
+type client struct {
+	endpoint string
+	headers  map[string]string
+	http     *http.Client
+}
+
+// newClient returns a client that posts to the OTLP/HTTP endpoint, such as http://localhost:4318, with the headers
+func newClient(endpoint string, headers map[string]string, timeout time.Duration) *client {
+	return &client{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		headers:  headers,
+		http:     &http.Client{Timeout: timeout},
+	}
+}
+
+// post sends the request encoded as JSON to the path of the endpoint
+func (c *client) post(path string, request interface{}) error {
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("OTLP export to %s failed with %s: %s", c.endpoint+path, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+// ParseHeaders parses comma separated key=value headers to send with exports, such as those for authentication
+func ParseHeaders(s string) (map[string]string, error) {
+
+	headers := make(map[string]string)
+
+	for _, pair := range strings.Split(s, ",") {
+
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid OTLP header %q: expected key=value", pair)
+		}
+
+		headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return headers, nil
+}
@@ -0,0 +1,122 @@
+package otlp
+
+import (
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/tracing"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/oklog/pkg/group"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// exporter exports metrics and spans to an OpenTelemetry collector using OTLP/HTTP with JSON encoding
+type exporter struct {
+	client   *client
+	gatherer prometheus.Gatherer
+	tracer   *tracing.Tracer
+	clock    state.Clock
+	start    time.Time
+	logger   log.Logger
+}
+
+// NewExporter returns an instance ready to use, which exports the metrics gathered from the gatherer and the spans
+// recorded by the tracer to the OTLP/HTTP endpoint, such as http://localhost:4318. The tracer can be nil to only
+// export metrics.
+func NewExporter(logger log.Logger, endpoint string, headers map[string]string, gatherer prometheus.Gatherer, tracer *tracing.Tracer, clock state.Clock) *exporter {
+	return &exporter{
+		client:   newClient(endpoint, headers, 10*time.Second),
+		gatherer: gatherer,
+		tracer:   tracer,
+		clock:    clock,
+		start:    clock.Now(),
+		logger:   log.With(logger, "actor", "otlp exporter"),
+	}
+}
+
+// AddExporterStage adds a stage to the run group that exports at the supplied interval
+func AddExporterStage(group *group.Group, exporter *exporter, interval *time.Duration) {
+
+	ticker := time.NewTicker(*interval)
+
+	a := exporterActor(exporter, ticker.C)
+	group.Add(a.Execute, func(err error) {
+		ticker.Stop()
+		a.Interrupt(err)
+	})
+
+}
+
+// exporterActor exports every time the tick channel fires
+func exporterActor(exporter *exporter, tick <-chan time.Time) actor.Actor {
+
+	cancel := make(chan struct{})
+
+	return actor.NewActor(
+		func() error {
+
+			for {
+				select {
+
+				case <-tick:
+					exporter.export()
+
+				case <-cancel:
+					_ = level.Info(exporter.logger).Log("cancelled", "Asked to terminate")
+					return nil
+				}
+			}
+		},
+		func(err error) {
+			_ = level.Info(exporter.logger).Log("interrupted", fmt.Sprintf("interrupted with %v", err))
+			close(cancel)
+		},
+	)
+
+}
+
+// export sends the current metrics and the spans ended since the last export. Failures are logged rather than
+// retried, as the next export has the latest metrics anyway.
+func (e *exporter) export() {
+
+	if err := e.exportMetrics(); err != nil {
+		_ = level.Warn(e.logger).Log("msg", "Unable to export metrics", "err", err)
+	}
+
+	if err := e.exportSpans(); err != nil {
+		_ = level.Warn(e.logger).Log("msg", "Unable to export spans", "err", err)
+	}
+}
+
+func (e *exporter) exportMetrics() error {
+
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	metrics := toMetrics(families, e.start, e.clock.Now())
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	return e.client.post("/v1/metrics", &metricsRequest{ResourceMetrics: []resourceMetrics{{
+		Resource:     vpnckResource,
+		ScopeMetrics: []scopeMetrics{{Scope: vpnckScope, Metrics: metrics}},
+	}}})
+}
+
+func (e *exporter) exportSpans() error {
+
+	ended := e.tracer.Flush()
+	if len(ended) == 0 {
+		return nil
+	}
+
+	return e.client.post("/v1/traces", &tracesRequest{ResourceSpans: []resourceSpans{{
+		Resource:   vpnckResource,
+		ScopeSpans: []scopeSpans{{Scope: vpnckScope, Spans: toSpans(ended)}},
+	}}})
+}
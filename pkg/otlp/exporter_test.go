@@ -0,0 +1,298 @@
+package otlp
+
+import (
+	"encoding/json"
+	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/tracing"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver stands in for an OpenTelemetry collector, keeping the requests posted to it
+type receiver struct {
+	mutex    sync.Mutex
+	status   int
+	requests map[string][]map[string]interface{}
+	headers  http.Header
+}
+
+func newReceiver(status int) (*receiver, *httptest.Server) {
+	r := &receiver{status: status, requests: make(map[string][]map[string]interface{})}
+	return r, httptest.NewServer(r)
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.mutex.Lock()
+	r.requests[req.URL.Path] = append(r.requests[req.URL.Path], body)
+	r.headers = req.Header
+	r.mutex.Unlock()
+
+	w.WriteHeader(r.status)
+}
+
+func (r *receiver) received(path string) []map[string]interface{} {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.requests[path]
+}
+
+var start = time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+
+func TestExport(t *testing.T) {
+
+	// Given a collector
+	rec, server := newReceiver(http.StatusOK)
+	defer server.Close()
+
+	// And metrics and spans to export
+	registry := prometheus.NewRegistry()
+	up := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "cc_vpn_tunnel_up", Help: "If the tunnel is up."}, []string{"vpn_id"})
+	up.WithLabelValues("vpn-1").Set(1)
+	received := prometheus.NewCounter(prometheus.CounterOpts{Name: "cc_vpn_tunnel_received_bytes", Help: "Bytes received."})
+	received.Add(42)
+	registry.MustRegister(up, received)
+
+	tracer := tracing.NewTracer()
+	cycle := tracer.StartCycle("poll")
+	cycle.Start("monitor").End()
+	cycle.End()
+
+	// When they are exported
+	exporter := NewExporter(log.NewNopLogger(), server.URL+"/", map[string]string{"Authorization": "Bearer secret"}, registry, tracer, fixedClock{start})
+	exporter.export()
+
+	// Then the metrics are posted
+	metricsRequests := rec.received("/v1/metrics")
+	if len(metricsRequests) != 1 {
+		t.Fatalf("Expected 1 metrics request, got %d", len(metricsRequests))
+	}
+
+	metrics := path(metricsRequests[0], "resourceMetrics", 0, "scopeMetrics", 0, "metrics").([]interface{})
+	if len(metrics) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(metrics))
+	}
+
+	counter := metrics[0].(map[string]interface{})
+	if counter["name"] != "cc_vpn_tunnel_received_bytes" || path(counter, "sum", "isMonotonic") != true || path(counter, "sum", "dataPoints", 0, "asDouble") != float64(42) {
+		t.Errorf("Expected the counter as a monotonic sum, got %v", counter)
+	}
+
+	gauge := metrics[1].(map[string]interface{})
+	wantAttributes := []interface{}{map[string]interface{}{"key": "vpn_id", "value": map[string]interface{}{"stringValue": "vpn-1"}}}
+	if gauge["name"] != "cc_vpn_tunnel_up" || !reflect.DeepEqual(path(gauge, "gauge", "dataPoints", 0, "attributes"), wantAttributes) {
+		t.Errorf("Expected the gauge with its labels as attributes, got %v", gauge)
+	}
+
+	// And the resource identifies vpnck
+	if path(metricsRequests[0], "resourceMetrics", 0, "resource", "attributes", 0, "value", "stringValue") != ServiceName {
+		t.Errorf("Expected the resource to be %s, got %v", ServiceName, metricsRequests[0])
+	}
+
+	// And the spans are posted
+	tracesRequests := rec.received("/v1/traces")
+	if len(tracesRequests) != 1 {
+		t.Fatalf("Expected 1 traces request, got %d", len(tracesRequests))
+	}
+
+	spans := path(tracesRequests[0], "resourceSpans", 0, "scopeSpans", 0, "spans").([]interface{})
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	if path(spans[0], "name") != "monitor" || path(spans[0], "parentSpanId") != path(spans[1], "spanId") {
+		t.Errorf("Expected the monitor span to be a child of the poll cycle, got %v", spans)
+	}
+
+	// And the headers are sent
+	if rec.headers.Get("Authorization") != "Bearer secret" || rec.headers.Get("Content-Type") != "application/json" {
+		t.Errorf("Expected the headers to be sent, got %v", rec.headers)
+	}
+
+	// When exported again with no spans ended since
+	exporter.export()
+
+	// Then only metrics are posted
+	if len(rec.received("/v1/metrics")) != 2 || len(rec.received("/v1/traces")) != 1 {
+		t.Errorf("Expected only metrics to be exported again")
+	}
+}
+
+func TestExportFailures(t *testing.T) {
+
+	// Given a collector that rejects everything
+	rec, server := newReceiver(http.StatusServiceUnavailable)
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "up", Help: "Up."}))
+
+	exporter := NewExporter(log.NewNopLogger(), server.URL, nil, registry, nil, fixedClock{start})
+
+	// When the metrics are exported
+	err := exporter.exportMetrics()
+
+	// Then it fails
+	if err == nil {
+		t.Error("Expected the export to fail")
+	}
+	if len(rec.received("/v1/metrics")) != 1 {
+		t.Error("Expected the metrics to have been posted")
+	}
+
+	// And spans aren't exported without a tracer
+	if err := exporter.exportSpans(); err != nil {
+		t.Errorf("Expected no spans to be exported, got %v", err)
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+
+	// Given a histogram with observations in and above its buckets
+	registry := prometheus.NewRegistry()
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency_seconds", Help: "Latency.", Buckets: []float64{1, 5}})
+	for _, v := range []float64{0.5, 0.5, 3, 10} {
+		h.Observe(v)
+	}
+	registry.MustRegister(h)
+	families, _ := registry.Gather()
+
+	// When converted to OTLP
+	metrics := toMetrics(families, start, start.Add(time.Minute))
+
+	// Then the counts are per bucket, with one more bucket than bounds
+	point := metrics[0].Histogram.DataPoints[0]
+	if !reflect.DeepEqual(point.BucketCounts, []string{"2", "1", "1"}) {
+		t.Errorf("Expected bucket counts [2 1 1], got %v", point.BucketCounts)
+	}
+	if !reflect.DeepEqual(point.ExplicitBounds, []double{1, 5}) {
+		t.Errorf("Expected bounds [1 5], got %v", point.ExplicitBounds)
+	}
+	if point.Count != "4" || point.Sum != 14 {
+		t.Errorf("Expected a count of 4 and sum of 14, got %s and %v", point.Count, point.Sum)
+	}
+}
+
+var headertests = []struct {
+	name    string
+	headers string
+	want    map[string]string
+	err     bool
+}{
+	{name: "None", headers: "", want: map[string]string{}},
+	{name: "Several", headers: "Authorization=Bearer x, X-Scope-OrgID=vpn", want: map[string]string{"Authorization": "Bearer x", "X-Scope-OrgID": "vpn"}},
+	{name: "Value with equals", headers: "api-key=a=b", want: map[string]string{"api-key": "a=b"}},
+	{name: "No value", headers: "Authorization", err: true},
+	{name: "No key", headers: "=x", err: true},
+}
+
+func TestParseHeaders(t *testing.T) {
+	for _, tt := range headertests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			got, err := ParseHeaders(tt.headers)
+
+			if tt.err {
+				if err == nil {
+					t.Errorf("Expected an error, got %v", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDoubleEncoding(t *testing.T) {
+	b, err := json.Marshal([]double{1.5, double(math.Inf(1)), double(math.NaN())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `[1.5,"Infinity","NaN"]` {
+		t.Errorf("Unexpected encoding %s", b)
+	}
+}
+
+var interruptests = []struct {
+	name  string
+	actor actor.Actor
+}{
+	{name: "Exporter", actor: exporterActor(NewExporter(log.NewNopLogger(), "http://localhost:4318", nil, prometheus.NewRegistry(), nil, fixedClock{start}), make(chan time.Time))},
+}
+
+// Tests that the actors honour the contract as per https://github.com/oklog/run#run.
+// When the interrupt function is called the actor should return
+func TestInterrupt(t *testing.T) {
+
+	for _, tt := range interruptests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			underTest := tt.actor
+
+			// Run the actor.
+			errors := make(chan error)
+			go func(a actor.Actor) {
+				errors <- a.Execute()
+			}(underTest)
+
+			// Signal for the actor to stop
+			underTest.Interrupt(nil)
+
+			select {
+			case <-errors:
+				return
+			case <-time.After(1 * time.Second):
+			}
+
+			t.Error("actor didn't shut down in response to interrupt")
+
+		})
+	}
+}
+
+// path walks the keys and indexes into decoded JSON
+func path(v interface{}, steps ...interface{}) interface{} {
+	for _, step := range steps {
+		switch s := step.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			v = m[s]
+		case int:
+			a, ok := v.([]interface{})
+			if !ok || s >= len(a) {
+				return nil
+			}
+			v = a[s]
+		}
+	}
+	return v
+}
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.now
+}
@@ -0,0 +1,213 @@
+package otlp
+
+import (
+	"encoding/json"
+	dto "github.com/prometheus/client_model/go"
+	"math"
+	"strconv"
+	"time"
+)
+
+type metricsRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type scopeMetrics struct {
+	Scope   scope    `json:"scope"`
+	Metrics []metric `json:"metrics"`
+}
+
+type metric struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Gauge       *gauge     `json:"gauge,omitempty"`
+	Sum         *sum       `json:"sum,omitempty"`
+	Histogram   *histogram `json:"histogram,omitempty"`
+	Summary     *summary   `json:"summary,omitempty"`
+}
+
+// aggregationCumulative is the temporality of everything exported, as Prometheus metrics are all cumulative
+const aggregationCumulative = 2
+
+type gauge struct {
+	DataPoints []numberDataPoint `json:"dataPoints"`
+}
+
+type sum struct {
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+	DataPoints             []numberDataPoint `json:"dataPoints"`
+}
+
+type numberDataPoint struct {
+	Attributes        []keyValue `json:"attributes"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	AsDouble          double     `json:"asDouble"`
+}
+
+type histogram struct {
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+	DataPoints             []histogramDataPoint `json:"dataPoints"`
+}
+
+type histogramDataPoint struct {
+	Attributes        []keyValue `json:"attributes"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	Count             string     `json:"count"`
+	Sum               double     `json:"sum"`
+	BucketCounts      []string   `json:"bucketCounts"`
+	ExplicitBounds    []double   `json:"explicitBounds"`
+}
+
+type summary struct {
+	DataPoints []summaryDataPoint `json:"dataPoints"`
+}
+
+type summaryDataPoint struct {
+	Attributes        []keyValue      `json:"attributes"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               double          `json:"sum"`
+	QuantileValues    []quantileValue `json:"quantileValues"`
+}
+
+type quantileValue struct {
+	Quantile double `json:"quantile"`
+	Value    double `json:"value"`
+}
+
+// double is a float encoded the way OTLP JSON expects, which allows for NaN and infinities unlike encoding/json
+type double float64
+
+func (d double) MarshalJSON() ([]byte, error) {
+	f := float64(d)
+	switch {
+	case math.IsNaN(f):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(f, 1):
+		return []byte(`"Infinity"`), nil
+	case math.IsInf(f, -1):
+		return []byte(`"-Infinity"`), nil
+	}
+	return json.Marshal(f)
+}
+
+// toMetrics converts metric families gathered from Prometheus to OTLP metrics, as at the supplied time. Counters,
+// histograms and summaries are cumulative from the start time.
+func toMetrics(families []*dto.MetricFamily, start time.Time, now time.Time) []metric {
+
+	startNano := unixNano(start)
+	nowNano := unixNano(now)
+
+	var metrics []metric
+
+	for _, family := range families {
+
+		m := metric{Name: family.GetName(), Description: family.GetHelp()}
+
+		switch family.GetType() {
+
+		case dto.MetricType_COUNTER:
+			m.Sum = &sum{AggregationTemporality: aggregationCumulative, IsMonotonic: true}
+			for _, sample := range family.Metric {
+				m.Sum.DataPoints = append(m.Sum.DataPoints, numberDataPoint{
+					Attributes:        labels(sample),
+					StartTimeUnixNano: startNano,
+					TimeUnixNano:      nowNano,
+					AsDouble:          double(sample.GetCounter().GetValue()),
+				})
+			}
+
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			m.Gauge = &gauge{}
+			for _, sample := range family.Metric {
+				value := sample.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = sample.GetUntyped().GetValue()
+				}
+				m.Gauge.DataPoints = append(m.Gauge.DataPoints, numberDataPoint{
+					Attributes:   labels(sample),
+					TimeUnixNano: nowNano,
+					AsDouble:     double(value),
+				})
+			}
+
+		case dto.MetricType_HISTOGRAM:
+			m.Histogram = &histogram{AggregationTemporality: aggregationCumulative}
+			for _, sample := range family.Metric {
+				m.Histogram.DataPoints = append(m.Histogram.DataPoints, toHistogramDataPoint(sample, startNano, nowNano))
+			}
+
+		case dto.MetricType_SUMMARY:
+			m.Summary = &summary{}
+			for _, sample := range family.Metric {
+				s := sample.GetSummary()
+				point := summaryDataPoint{
+					Attributes:        labels(sample),
+					StartTimeUnixNano: startNano,
+					TimeUnixNano:      nowNano,
+					Count:             strconv.FormatUint(s.GetSampleCount(), 10),
+					Sum:               double(s.GetSampleSum()),
+				}
+				for _, q := range s.Quantile {
+					point.QuantileValues = append(point.QuantileValues, quantileValue{Quantile: double(q.GetQuantile()), Value: double(q.GetValue())})
+				}
+				m.Summary.DataPoints = append(m.Summary.DataPoints, point)
+			}
+
+		default:
+			continue
+		}
+
+		metrics = append(metrics, m)
+	}
+
+	return metrics
+}
+
+// toHistogramDataPoint converts the cumulative buckets of a Prometheus histogram to the counts per bucket OTLP has,
+// where the last bucket is everything above the highest bound
+func toHistogramDataPoint(sample *dto.Metric, startNano string, nowNano string) histogramDataPoint {
+
+	h := sample.GetHistogram()
+
+	point := histogramDataPoint{
+		Attributes:        labels(sample),
+		StartTimeUnixNano: startNano,
+		TimeUnixNano:      nowNano,
+		Count:             strconv.FormatUint(h.GetSampleCount(), 10),
+		Sum:               double(h.GetSampleSum()),
+		BucketCounts:      []string{},
+		ExplicitBounds:    []double{},
+	}
+
+	var previous uint64
+	for _, bucket := range h.Bucket {
+		if math.IsInf(bucket.GetUpperBound(), 1) {
+			continue
+		}
+		point.ExplicitBounds = append(point.ExplicitBounds, double(bucket.GetUpperBound()))
+		point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(bucket.GetCumulativeCount()-previous, 10))
+		previous = bucket.GetCumulativeCount()
+	}
+	point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(h.GetSampleCount()-previous, 10))
+
+	return point
+}
+
+// labels returns the labels of the sample as OTLP attributes
+func labels(sample *dto.Metric) []keyValue {
+	m := make(map[string]string, len(sample.Label))
+	for _, l := range sample.Label {
+		m[l.GetName()] = l.GetValue()
+	}
+	return attributes(m)
+}
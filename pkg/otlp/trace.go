@@ -0,0 +1,78 @@
+package otlp
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/tracing"
+)
+
+type tracesRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes"`
+	Status            status     `json:"status"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// Span kinds and status codes, as defined by OTLP
+const (
+	kindInternal = 1
+	kindClient   = 3
+
+	statusUnset = 0
+	statusError = 2
+)
+
+// toSpans converts ended spans to OTLP spans
+func toSpans(ended []*tracing.Ended) []span {
+
+	spans := make([]span, 0, len(ended))
+
+	for _, e := range ended {
+
+		s := span{
+			TraceID:           e.TraceID,
+			SpanID:            e.ID,
+			ParentSpanID:      e.ParentID,
+			Name:              e.Name,
+			Kind:              kindInternal,
+			StartTimeUnixNano: unixNano(e.Start),
+			EndTimeUnixNano:   unixNano(e.End),
+			Attributes:        attributes(e.Attributes),
+			Status:            status{Code: statusUnset},
+		}
+
+		if e.Client {
+			s.Kind = kindClient
+		}
+
+		if e.Error != "" {
+			s.Status = status{Code: statusError, Message: e.Error}
+		}
+
+		spans = append(spans, s)
+	}
+
+	return spans
+}
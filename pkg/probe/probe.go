@@ -0,0 +1,142 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// run probes the target, returning an error if it can't be reached within the timeout
+func run(target Target, timeout time.Duration) error {
+	switch target.Kind {
+	case KindTCP:
+		return probeTCP(target.Address, timeout)
+	case KindHTTP:
+		return probeHTTP(target.Address, timeout)
+	case KindICMP:
+		return probeICMP(target.Address, timeout)
+	default:
+		return fmt.Errorf("unknown kind of probe %s", target.Kind)
+	}
+}
+
+// probeTCP succeeds if a connection can be opened to the address
+func probeTCP(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// probeHTTP succeeds if a GET of the URL doesn't respond with an error status
+func probeHTTP(url string, timeout time.Duration) error {
+
+	client := &http.Client{Timeout: timeout}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// ICMP message types
+const (
+	icmpEchoReply   = 0
+	icmpEchoRequest = 8
+)
+
+// probeICMP succeeds if the host replies to a ping. This needs a raw socket, so is only permitted when running as
+// root or with the CAP_NET_RAW capability.
+func probeICMP(host string, timeout time.Duration) error {
+
+	conn, err := net.DialTimeout("ip4:icmp", host, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	id := uint16(os.Getpid())
+	seq := uint16(time.Now().UnixNano())
+
+	if _, err := conn.Write(echoRequest(id, seq)); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 1500)
+	for {
+		n, err := conn.Read(reply)
+		if err != nil {
+			return err
+		}
+
+		if isEchoReply(reply[:n], id, seq) {
+			return nil
+		}
+	}
+}
+
+// echoRequest returns an ICMP echo request message
+func echoRequest(id uint16, seq uint16) []byte {
+
+	var b bytes.Buffer
+	b.WriteByte(icmpEchoRequest)
+	b.WriteByte(0)
+	_ = binary.Write(&b, binary.BigEndian, uint16(0))
+	_ = binary.Write(&b, binary.BigEndian, id)
+	_ = binary.Write(&b, binary.BigEndian, seq)
+	b.WriteString("vpnck")
+
+	msg := b.Bytes()
+	binary.BigEndian.PutUint16(msg[2:], checksum(msg))
+
+	return msg
+}
+
+// isEchoReply is true if the message is the reply to our echo request. Messages read from an ip4 socket may still
+// have their IP header.
+func isEchoReply(msg []byte, id uint16, seq uint16) bool {
+
+	if len(msg) >= 20 && msg[0]>>4 == 4 {
+		msg = msg[int(msg[0]&0x0f)*4:]
+	}
+
+	if len(msg) < 8 || msg[0] != icmpEchoReply {
+		return false
+	}
+
+	return binary.BigEndian.Uint16(msg[4:]) == id && binary.BigEndian.Uint16(msg[6:]) == seq
+}
+
+// checksum is the internet checksum of RFC 1071
+func checksum(b []byte) uint16 {
+
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+
+	return ^uint16(sum)
+}
@@ -0,0 +1,103 @@
+package probe
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTCPProbe(t *testing.T) {
+
+	// Given a local listener
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	address := listener.Addr().String()
+
+	// Then probing it should succeed
+	if err := run(Target{Kind: KindTCP, Address: address}, time.Second); err != nil {
+		t.Errorf("Expected the probe to succeed but got %v", err)
+	}
+
+	// and once it's closed probing it should fail
+	_ = listener.Close()
+
+	if err := run(Target{Kind: KindTCP, Address: address}, time.Second); err == nil {
+		t.Errorf("Expected the probe to fail")
+	}
+}
+
+var httptests = []struct {
+	name    string
+	status  int
+	success bool
+}{
+	{name: "OK", status: http.StatusOK, success: true},
+	{name: "Redirect", status: http.StatusNotModified, success: true},
+	{name: "Not found", status: http.StatusNotFound, success: false},
+	{name: "Server error", status: http.StatusInternalServerError, success: false},
+}
+
+func TestHTTPProbe(t *testing.T) {
+
+	for _, tt := range httptests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// Given a local server responding with the status
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			// When it's probed
+			err := run(Target{Kind: KindHTTP, Address: server.URL}, time.Second)
+
+			// Then the probe should succeed if the status isn't an error
+			if (err == nil) != tt.success {
+				t.Errorf("Expected success %v but got %v", tt.success, err)
+			}
+
+		})
+	}
+}
+
+func TestHTTPProbeTimeout(t *testing.T) {
+
+	// Given a local server that is slower than the timeout
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	// Then probing it should fail
+	if err := run(Target{Kind: KindHTTP, Address: server.URL}, 20*time.Millisecond); err == nil {
+		t.Errorf("Expected the probe to time out")
+	}
+}
+
+func TestICMPProbe(t *testing.T) {
+
+	// Raw sockets are only permitted to privileged users
+	conn, err := net.Dial("ip4:icmp", "127.0.0.1")
+	if err != nil {
+		t.Skipf("ICMP not permitted: %v", err)
+	}
+	_ = conn.Close()
+
+	if err := run(Target{Kind: KindICMP, Address: "127.0.0.1"}, time.Second); err != nil {
+		t.Errorf("Expected the probe to succeed but got %v", err)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+
+	// A message with its checksum filled in sums to zero
+	msg := echoRequest(1, 2)
+
+	if sum := checksum(msg); sum != 0 {
+		t.Errorf("Expected checksum of a checksummed message to be 0 but got %x", sum)
+	}
+}
@@ -0,0 +1,252 @@
+package probe
+
+import (
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/oklog/pkg/group"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
+)
+
+// maxConcurrentProbes is how many probes run at once, so lots of targets don't open lots of sockets at once
+const maxConcurrentProbes = 10
+
+// prober runs the probes configured for connections and publishes their results as Prometheus metrics
+type prober struct {
+	targets     map[string][]Target
+	timeout     time.Duration
+	concurrency int
+	clock       state.Clock
+	success     *prometheus.GaugeVec
+	duration    *prometheus.HistogramVec
+	connections []*vpn.Connection
+	results     map[string][]*vpn.Probe
+	logger      log.Logger
+}
+
+// NewProber returns an instance ready to use, which probes the targets configured for each connection ID. Probes fail
+// if they don't complete within the timeout.
+func NewProber(registerer prometheus.Registerer, logger log.Logger, targets map[string][]Target, timeout time.Duration, clock state.Clock) *prober {
+
+	labelNames := []string{"vpn_connection_id", "target"}
+
+	p := prober{
+		targets:     targets,
+		timeout:     timeout,
+		concurrency: maxConcurrentProbes,
+		clock:       clock,
+		success: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "probe_success",
+				Help: "If the latest probe of the target through the VPN connection succeeded, partitioned by VPN Connection ID and target.",
+			},
+			labelNames,
+		),
+		duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "probe_duration_seconds",
+				Help:    "How long probes of the target through the VPN connection took, partitioned by VPN Connection ID and target.",
+				Buckets: prometheus.DefBuckets,
+			},
+			labelNames,
+		),
+		results: make(map[string][]*vpn.Probe),
+		logger:  log.With(logger, "actor", "prober"),
+	}
+
+	registerer.MustRegister(p.success, p.duration)
+
+	return &p
+}
+
+// AddProberStage adds a stage to the run group that probes the targets of the connections it receives at the supplied
+// interval. Connections are sent to the next stage with the latest probe results every time they are received, and
+// again as a repeat of their poll every time they have been probed. Probing happens in the background, so polls
+// aren't held up by slow targets.
+func AddProberStage(group *group.Group, prober *prober, in <-chan state.Poll, out chan<- state.Poll, interval *time.Duration) {
+
+	ticker := time.NewTicker(*interval)
+
+	a := proberActor(prober, in, out, ticker.C)
+	group.Add(a.Execute, func(err error) {
+		ticker.Stop()
+		a.Interrupt(err)
+	})
+
+}
+
+// proberActor remembers the connections received and sends them down the out channel with the latest probe results.
+// It probes them in the background every time the tick channel fires, and sends them again as a repeat with the
+// results once they're all in.
+func proberActor(prober *prober, in <-chan state.Poll, out chan<- state.Poll, tick <-chan time.Time) actor.Actor {
+
+	cancel := make(chan struct{})
+
+	return actor.NewActor(
+		func() error {
+
+			// Only one round of probes runs at a time, and its results are buffered so it can finish even if we've stopped
+			probed := make(chan map[string][]*vpn.Probe, 1)
+			probing := false
+			start := func() {
+				if probing {
+					_ = level.Debug(prober.logger).Log("msg", "Still probing, so not starting again")
+					return
+				}
+				probing = true
+				connections := prober.connections
+				go func() { probed <- prober.probe(connections) }()
+			}
+
+			for {
+				var poll state.Poll
+
+				select {
+
+				case poll = <-in:
+					first := prober.connections == nil
+					prober.connections = poll.Connections
+
+					// Don't make people wait for the first tick to see any results
+					if first {
+						start()
+					}
+
+				case <-tick:
+					if prober.connections != nil {
+						start()
+					}
+					continue
+
+				case results := <-probed:
+					probing = false
+					prober.setResults(results)
+					poll = state.Poll{Connections: prober.connections, Repeat: true}
+
+				case <-cancel:
+					_ = level.Info(prober.logger).Log("cancelled", "Asked to terminate")
+					return nil
+				}
+
+				select {
+				case out <- poll.With(prober.withResults(poll.Connections)):
+				case <-cancel:
+					_ = level.Info(prober.logger).Log("cancelled", "Asked to terminate")
+					return nil
+				}
+			}
+		},
+		func(err error) {
+			_ = level.Info(prober.logger).Log("interrupted", fmt.Sprintf("interrupted with %v", err))
+			close(cancel)
+		},
+	)
+
+}
+
+// probe runs the probes for the connections in parallel, no more than the concurrency at once, and publishes the
+// results. Each probe is bounded by the timeout, so a round takes at most the timeout for every concurrency targets.
+func (p *prober) probe(connections []*vpn.Connection) map[string][]*vpn.Probe {
+
+	results := make(map[string][]*vpn.Probe)
+
+	var wg sync.WaitGroup
+	running := make(chan struct{}, p.concurrency)
+
+	for _, conn := range connections {
+
+		targets := p.targets[conn.ID]
+		if len(targets) == 0 {
+			continue
+		}
+
+		probes := make([]*vpn.Probe, len(targets))
+		results[conn.ID] = probes
+
+		for i, target := range targets {
+			wg.Add(1)
+			running <- struct{}{}
+			go func(id string, i int, target Target) {
+				defer wg.Done()
+				defer func() { <-running }()
+				probes[i] = p.run(id, target)
+			}(conn.ID, i, target)
+		}
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// setResults makes the results the latest. Metrics of connections that have gone away are deleted.
+func (p *prober) setResults(results map[string][]*vpn.Probe) {
+
+	for id := range p.results {
+		if _, ok := results[id]; !ok {
+			_ = level.Debug(p.logger).Log("msg", fmt.Sprintf("Removing probe metrics for redundant connection: %v", id))
+			for _, target := range p.targets[id] {
+				labels := prometheus.Labels{"vpn_connection_id": id, "target": target.String()}
+				p.success.Delete(labels)
+				p.duration.Delete(labels)
+			}
+		}
+	}
+
+	p.results = results
+}
+
+// run probes a target of a connection, and publishes the result
+func (p *prober) run(id string, target Target) *vpn.Probe {
+
+	start := p.clock.Now()
+	began := time.Now()
+
+	err := run(target, p.timeout)
+
+	result := &vpn.Probe{
+		Target:  target.String(),
+		Success: err == nil,
+		Latency: time.Since(began),
+		Time:    start,
+	}
+
+	labels := prometheus.Labels{"vpn_connection_id": id, "target": target.String()}
+	p.duration.With(labels).Observe(result.Latency.Seconds())
+
+	if err != nil {
+		_ = level.Warn(p.logger).Log("msg", "Probe failed", "connection", id, "target", target, "err", err)
+		result.Error = err.Error()
+		p.success.With(labels).Set(0)
+		return result
+	}
+
+	p.success.With(labels).Set(1)
+	return result
+}
+
+// withResults returns the connections with the latest results of their probes. Connections with probes are copied
+// rather than changed, as the previous stages and earlier readers may still hold them.
+func (p *prober) withResults(connections []*vpn.Connection) []*vpn.Connection {
+
+	probed := make([]*vpn.Connection, len(connections))
+
+	for i, conn := range connections {
+		results, ok := p.results[conn.ID]
+		if !ok {
+			probed[i] = conn
+			continue
+		}
+
+		c := *conn
+		c.Probes = results
+		probed[i] = &c
+	}
+
+	return probed
+}
@@ -0,0 +1,290 @@
+package probe
+
+import (
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/metrics"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const successMetadata = `
+	# HELP cc_vpn_probe_success If the latest probe of the target through the VPN connection succeeded, partitioned by VPN Connection ID and target.
+	# TYPE cc_vpn_probe_success gauge
+`
+
+func TestProbeResults(t *testing.T) {
+
+	// Given a connection with one target that can be reached and one that can't
+	up, down := reachableAndUnreachable(t)
+
+	registry := prometheus.NewRegistry()
+	underTest := NewProber(metrics.DefaultNaming.Registerer(registry), log.NewNopLogger(), map[string][]Target{"vpn-1": {up, down}}, time.Second, fixedClock{})
+	underTest.connections = upConnections("vpn-1", "vpn-2")
+
+	// When the targets are probed
+	underTest.setResults(underTest.probe(underTest.connections))
+
+	// Then the results should be published
+	truth := successMetadata + `
+		cc_vpn_probe_success{target="` + down.String() + `",vpn_connection_id="vpn-1"} 0
+		cc_vpn_probe_success{target="` + up.String() + `",vpn_connection_id="vpn-1"} 1
+	`
+
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(truth), "cc_vpn_probe_success"); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+
+	// and added to copies of the connections with targets
+	probed := underTest.withResults(underTest.connections)
+
+	if len(probed[0].Probes) != 2 || !probed[0].Probes[0].Success || probed[0].Probes[1].Success || probed[0].Probes[1].Error == "" {
+		t.Errorf("Probe results incorrect. Got %+v", probed[0].Probes)
+	}
+
+	if probed[0] == underTest.connections[0] || underTest.connections[0].Probes != nil {
+		t.Errorf("Connection with probes should have been copied, not changed")
+	}
+
+	if probed[1] != underTest.connections[1] {
+		t.Errorf("Connection without probes should have been passed on as is")
+	}
+
+	// and the connection should be unhealthy, even though its tunnel is up
+	if probed[0].Healthy() {
+		t.Errorf("Connection with a failing probe should not be healthy")
+	}
+}
+
+func TestProbeResultsRemoved(t *testing.T) {
+
+	// Given results have been published for a connection
+	up, _ := reachableAndUnreachable(t)
+
+	registry := prometheus.NewRegistry()
+	underTest := NewProber(metrics.DefaultNaming.Registerer(registry), log.NewNopLogger(), map[string][]Target{"vpn-1": {up}}, time.Second, fixedClock{})
+	underTest.connections = upConnections("vpn-1")
+	underTest.setResults(underTest.probe(underTest.connections))
+
+	// When the connection goes away
+	underTest.connections = upConnections("vpn-2")
+	underTest.setResults(underTest.probe(underTest.connections))
+
+	// Then so should its metrics
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(""), "cc_vpn_probe_success", "cc_vpn_probe_duration_seconds"); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
+
+func TestProberStageProbesOnTick(t *testing.T) {
+
+	up, _ := reachableAndUnreachable(t)
+
+	in := make(chan state.Poll)
+	out := make(chan state.Poll)
+	tick := make(chan time.Time)
+
+	prober := NewProber(prometheus.NewRegistry(), log.NewNopLogger(), map[string][]Target{"vpn-1": {up}}, time.Second, fixedClock{})
+	underTest := proberActor(prober, in, out, tick)
+	defer underTest.Interrupt(nil)
+
+	// When the stage is running
+	go func(a actor.Actor) {
+		_ = a.Execute()
+	}(underTest)
+
+	// Then connections received should be sent to the next stage straight away
+	go func() { in <- state.Poll{Connections: upConnections("vpn-1")} }()
+	polled, polledRepeat := receive(t, out)
+
+	// and sent again with their probe results once they're in, as a repeat of the poll so time isn't counted as polled
+	first, firstRepeat := receive(t, out)
+
+	// and sent again with fresh results every tick
+	go func() { tick <- time.Now() }()
+	second, secondRepeat := receive(t, out)
+
+	if polled == nil || first == nil || second == nil {
+		return
+	}
+
+	if polledRepeat || !firstRepeat || !secondRepeat {
+		t.Errorf("Expected only the connections sent again with results to be a repeat, but got %v, %v and %v", polledRepeat, firstRepeat, secondRepeat)
+	}
+
+	if len(first[0].Probes) != 1 || len(second[0].Probes) != 1 || first[0].Probes[0] == second[0].Probes[0] {
+		t.Errorf("Expected fresh probe results every tick but got %+v and %+v", first[0].Probes, second[0].Probes)
+	}
+}
+
+func TestSlowProbesDontHoldUpPolls(t *testing.T) {
+
+	// Given a target that takes a while to respond
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	in := make(chan state.Poll)
+	out := make(chan state.Poll)
+
+	prober := NewProber(prometheus.NewRegistry(), log.NewNopLogger(), map[string][]Target{"vpn-1": {{Kind: KindHTTP, Address: server.URL}}}, 10*time.Second, fixedClock{})
+	underTest := proberActor(prober, in, out, make(chan time.Time))
+	defer underTest.Interrupt(nil)
+	go func(a actor.Actor) {
+		_ = a.Execute()
+	}(underTest)
+
+	// When polls are received while it's being probed
+	for i := 0; i < 2; i++ {
+		go func() { in <- state.Poll{Connections: upConnections("vpn-1")} }()
+
+		// Then they're passed on without waiting for the probe
+		select {
+		case received := <-out:
+			if received.Repeat || received.Connections[0].Probes != nil {
+				t.Errorf("Expected the poll to be passed on before the probe finished, got %+v", received)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatal("Timed out waiting for the poll to be passed on")
+		}
+	}
+}
+
+func TestProbesBounded(t *testing.T) {
+
+	// Given more targets than can be probed at once
+	var mutex sync.Mutex
+	running, most := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mutex.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	var targets []Target
+	for i := 0; i < 6; i++ {
+		targets = append(targets, Target{Kind: KindHTTP, Address: fmt.Sprintf("%s/%d", server.URL, i)})
+	}
+
+	underTest := NewProber(prometheus.NewRegistry(), log.NewNopLogger(), map[string][]Target{"vpn-1": targets}, time.Second, fixedClock{})
+	underTest.concurrency = 2
+
+	// When they're probed
+	results := underTest.probe(upConnections("vpn-1"))
+
+	// Then they're all probed, but no more than the concurrency at once
+	if len(results["vpn-1"]) != len(targets) {
+		t.Errorf("Expected %d results, got %+v", len(targets), results)
+	}
+	for _, result := range results["vpn-1"] {
+		if !result.Success {
+			t.Errorf("Expected the probe to succeed, got %+v", result)
+		}
+	}
+	if most > 2 {
+		t.Errorf("Expected no more than 2 probes at once, got %d", most)
+	}
+}
+
+var interruptests = []struct {
+	name  string
+	actor actor.Actor
+}{
+	{name: "Prober", actor: proberActor(NewProber(prometheus.NewRegistry(), log.NewNopLogger(), nil, time.Second, fixedClock{}), make(chan state.Poll), make(chan state.Poll), make(chan time.Time))},
+}
+
+// Tests that the actors honour the contract as per https://github.com/oklog/run#run.
+// When the interrupt function is called the actor should return
+func TestInterrupt(t *testing.T) {
+
+	for _, tt := range interruptests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			underTest := tt.actor
+
+			// Run the actor.
+			errors := make(chan error)
+			go func(a actor.Actor) {
+				errors <- a.Execute()
+			}(underTest)
+
+			// Signal for the actor to stop
+			underTest.Interrupt(nil)
+
+			select {
+			case <-errors:
+				return
+			case <-time.After(1 * time.Second):
+			}
+
+			t.Error("actor didn't shut down in response to interrupt")
+
+		})
+	}
+}
+
+func receive(t *testing.T, out <-chan state.Poll) ([]*vpn.Connection, bool) {
+	select {
+	case received := <-out:
+		return received.Connections, received.Repeat
+	case <-time.After(1 * time.Second):
+		t.Error("Timed out waiting for connections to be passed down the pipeline")
+		return nil, false
+	}
+}
+
+// reachableAndUnreachable returns a TCP target with a local listener, and one without
+func reachableAndUnreachable(t *testing.T) (Target, Target) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	_ = closed.Close()
+
+	up, _ := ParseTarget("tcp://" + listener.Addr().String())
+	down, _ := ParseTarget("tcp://" + closed.Addr().String())
+
+	return up, down
+}
+
+func upConnections(ids ...string) []*vpn.Connection {
+	connections := make([]*vpn.Connection, len(ids))
+	for i, id := range ids {
+		connections[i] = &vpn.Connection{ID: id, Tunnels: []*vpn.Tunnel{{Status: vpn.StatusUp}}}
+	}
+	return connections
+}
+
+type fixedClock struct{}
+
+func (fixedClock) Now() time.Time { return time.Date(2009, 11, 17, 20, 34, 58, 0, time.UTC) }
@@ -0,0 +1,83 @@
+package probe
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// Kinds of target that can be probed
+const (
+	KindTCP  = "tcp"
+	KindHTTP = "http"
+	KindICMP = "icmp"
+)
+
+// Target is something on the far side of a connection to probe
+type Target struct {
+	// Kind of probe to run
+	Kind string
+
+	// Address to probe, which is a host:port for TCP, a URL for HTTP and a host for ICMP
+	Address string
+
+	// raw is the target as configured, which is how it's reported
+	raw string
+}
+
+// String returns the target as configured
+func (t Target) String() string {
+	return t.raw
+}
+
+// ParseTarget parses a target URL such as tcp://10.0.0.1:22, http://10.0.0.2/health, https://10.0.0.2/health or
+// icmp://10.0.0.3
+func ParseTarget(raw string) (Target, error) {
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Target{}, fmt.Errorf("invalid probe target %q: %v", raw, err)
+	}
+
+	switch u.Scheme {
+
+	case "tcp":
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return Target{}, fmt.Errorf("invalid probe target %q: tcp targets need a host and port", raw)
+		}
+		return Target{Kind: KindTCP, Address: u.Host, raw: raw}, nil
+
+	case "http", "https":
+		if u.Host == "" {
+			return Target{}, fmt.Errorf("invalid probe target %q: http targets need a host", raw)
+		}
+		return Target{Kind: KindHTTP, Address: raw, raw: raw}, nil
+
+	case "icmp":
+		if u.Hostname() == "" || u.Port() != "" {
+			return Target{}, fmt.Errorf("invalid probe target %q: icmp targets need a host and no port", raw)
+		}
+		return Target{Kind: KindICMP, Address: u.Hostname(), raw: raw}, nil
+
+	default:
+		return Target{}, fmt.Errorf("invalid probe target %q: expected a tcp, http, https or icmp URL", raw)
+	}
+}
+
+// ParseTargets parses the targets configured for each connection, keyed by connection ID
+func ParseTargets(configured map[string][]string) (map[string][]Target, error) {
+
+	targets := make(map[string][]Target)
+
+	for id, raws := range configured {
+		for _, raw := range raws {
+			target, err := ParseTarget(raw)
+			if err != nil {
+				return nil, fmt.Errorf("connection %s: %v", id, err)
+			}
+			targets[id] = append(targets[id], target)
+		}
+	}
+
+	return targets, nil
+}
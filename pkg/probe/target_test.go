@@ -0,0 +1,64 @@
+package probe
+
+import (
+	"testing"
+)
+
+var targettests = []struct {
+	name    string
+	raw     string
+	kind    string
+	address string
+	err     bool
+}{
+	{name: "TCP", raw: "tcp://10.0.0.1:22", kind: KindTCP, address: "10.0.0.1:22"},
+	{name: "TCP without port", raw: "tcp://10.0.0.1", err: true},
+	{name: "HTTP", raw: "http://10.0.0.2/health", kind: KindHTTP, address: "http://10.0.0.2/health"},
+	{name: "HTTPS", raw: "https://10.0.0.2:8443/health", kind: KindHTTP, address: "https://10.0.0.2:8443/health"},
+	{name: "HTTP without host", raw: "http:///health", err: true},
+	{name: "ICMP", raw: "icmp://10.0.0.3", kind: KindICMP, address: "10.0.0.3"},
+	{name: "ICMP with port", raw: "icmp://10.0.0.3:80", err: true},
+	{name: "Unknown scheme", raw: "udp://10.0.0.4:53", err: true},
+	{name: "Not a URL", raw: "10.0.0.5:22", err: true},
+}
+
+func TestParseTarget(t *testing.T) {
+
+	for _, tt := range targettests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			target, err := ParseTarget(tt.raw)
+
+			if tt.err {
+				if err == nil {
+					t.Errorf("Expected an error but got %+v", target)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+
+			if target.Kind != tt.kind || target.Address != tt.address || target.String() != tt.raw {
+				t.Errorf("Target incorrect. Expected %s %s but got %s %s", tt.kind, tt.address, target.Kind, target.Address)
+			}
+
+		})
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+
+	// Given targets configured for a connection, one of them invalid
+	configured := map[string][]string{"vpn-1": {"tcp://10.0.0.1:22", "ftp://10.0.0.1"}}
+
+	// When they are parsed
+	_, err := ParseTargets(configured)
+
+	// Then there should be an error
+	if err == nil {
+		t.Errorf("Expected an error for the invalid target")
+	}
+}
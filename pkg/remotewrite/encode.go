@@ -0,0 +1,161 @@
+package remotewrite
+
+import (
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The remote write protocol is a snappy compressed protobuf WriteRequest. Rather than depending on the whole of
+// Prometheus for its generated types, the few messages needed are encoded here. See
+// https://github.com/prometheus/prometheus/blob/main/prompb/remote.proto and types.proto.
+
+// label is a label of a time series, including its name as __name__
+type label struct {
+	Name  string
+	Value string
+}
+
+// timeSeries is a time series with a single sample
+type timeSeries struct {
+	Labels    []label
+	Value     float64
+	Timestamp time.Time
+}
+
+// Field numbers and wire types of the messages encoded
+const (
+	writeRequestTimeseries = 1
+
+	timeSeriesLabels  = 1
+	timeSeriesSamples = 2
+
+	labelName  = 1
+	labelValue = 2
+
+	sampleValue     = 1
+	sampleTimestamp = 2
+
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// encode returns the series as an encoded WriteRequest
+func encode(series []timeSeries) []byte {
+
+	request := proto.NewBuffer(nil)
+
+	for _, s := range series {
+
+		ts := proto.NewBuffer(nil)
+
+		for _, l := range s.Labels {
+			lb := proto.NewBuffer(nil)
+			encodeString(lb, labelName, l.Name)
+			encodeString(lb, labelValue, l.Value)
+			encodeMessage(ts, timeSeriesLabels, lb)
+		}
+
+		sample := proto.NewBuffer(nil)
+		encodeKey(sample, sampleValue, wireFixed64)
+		_ = sample.EncodeFixed64(math.Float64bits(s.Value))
+		encodeKey(sample, sampleTimestamp, wireVarint)
+		_ = sample.EncodeVarint(uint64(s.Timestamp.UnixNano() / int64(time.Millisecond)))
+		encodeMessage(ts, timeSeriesSamples, sample)
+
+		encodeMessage(request, writeRequestTimeseries, ts)
+	}
+
+	return request.Bytes()
+}
+
+func encodeKey(b *proto.Buffer, field uint64, wireType uint64) {
+	_ = b.EncodeVarint(field<<3 | wireType)
+}
+
+func encodeString(b *proto.Buffer, field uint64, s string) {
+	encodeKey(b, field, wireBytes)
+	_ = b.EncodeStringBytes(s)
+}
+
+func encodeMessage(b *proto.Buffer, field uint64, message *proto.Buffer) {
+	encodeKey(b, field, wireBytes)
+	_ = b.EncodeRawBytes(message.Bytes())
+}
+
+// toTimeSeries converts metric families gathered from Prometheus to time series as at the supplied time, splitting
+// histograms and summaries into their buckets or quantiles, sum and count the way Prometheus does when it scrapes them
+func toTimeSeries(families []*dto.MetricFamily, now time.Time) []timeSeries {
+
+	var series []timeSeries
+
+	add := func(name string, m *dto.Metric, value float64, extra ...label) {
+		labels := []label{{Name: "__name__", Value: name}}
+		for _, l := range m.Label {
+			labels = append(labels, label{Name: l.GetName(), Value: l.GetValue()})
+		}
+		labels = append(labels, extra...)
+
+		// Receivers expect labels sorted by name
+		sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
+		series = append(series, timeSeries{Labels: labels, Value: value, Timestamp: now})
+	}
+
+	for _, family := range families {
+
+		name := family.GetName()
+
+		for _, m := range family.Metric {
+
+			switch family.GetType() {
+
+			case dto.MetricType_COUNTER:
+				add(name, m, m.GetCounter().GetValue())
+
+			case dto.MetricType_GAUGE:
+				add(name, m, m.GetGauge().GetValue())
+
+			case dto.MetricType_UNTYPED:
+				add(name, m, m.GetUntyped().GetValue())
+
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				infSeen := false
+				for _, b := range h.Bucket {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						infSeen = true
+					}
+					add(name+"_bucket", m, float64(b.GetCumulativeCount()), label{Name: "le", Value: formatFloat(b.GetUpperBound())})
+				}
+				if !infSeen {
+					add(name+"_bucket", m, float64(h.GetSampleCount()), label{Name: "le", Value: "+Inf"})
+				}
+				add(name+"_sum", m, h.GetSampleSum())
+				add(name+"_count", m, float64(h.GetSampleCount()))
+
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.Quantile {
+					add(name, m, q.GetValue(), label{Name: "quantile", Value: formatFloat(q.GetQuantile())})
+				}
+				add(name+"_sum", m, s.GetSampleSum())
+				add(name+"_count", m, float64(s.GetSampleCount()))
+			}
+		}
+	}
+
+	return series
+}
+
+// formatFloat formats bucket bounds and quantiles as Prometheus does in the text exposition format
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
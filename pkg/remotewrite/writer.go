@@ -0,0 +1,281 @@
+package remotewrite
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/oklog/oklog/pkg/group"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Auth is how requests to the remote write endpoint are authenticated, with basic auth or a bearer token, or neither
+type Auth struct {
+	Username    string
+	Password    string
+	BearerToken string
+}
+
+// Validate returns an error if more than one way to authenticate is set
+func (a Auth) Validate() error {
+	if a.BearerToken != "" && (a.Username != "" || a.Password != "") {
+		return errors.New("only one of basic auth and a bearer token can be used for remote write")
+	}
+	if a.Password != "" && a.Username == "" {
+		return errors.New("a username is needed for remote write basic auth")
+	}
+	return nil
+}
+
+// How long to wait before retrying a batch that failed, which doubles every time it fails again
+const (
+	minBackoff = time.Second
+	maxBackoff = 2 * time.Minute
+)
+
+// writer pushes metrics to a Prometheus remote write endpoint, for networks where vpnck can't be scraped. Batches of
+// samples are queued, so they survive the endpoint being unavailable for a while, up to the size of the queue.
+type writer struct {
+	url        string
+	auth       Auth
+	client     *http.Client
+	gatherer   prometheus.Gatherer
+	clock      state.Clock
+	queue      [][]byte
+	queueSize  int
+	backoff    time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	batches    *prometheus.CounterVec
+	queued     prometheus.Gauge
+	logger     log.Logger
+}
+
+// NewWriter returns an instance ready to use, which pushes the metrics gathered from the gatherer to the remote write
+// endpoint. At most queueSize batches are kept while the endpoint is unavailable, after which the oldest are dropped.
+func NewWriter(registerer prometheus.Registerer, logger log.Logger, endpoint string, auth Auth, gatherer prometheus.Gatherer, queueSize int, clock state.Clock) (*writer, error) {
+
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid remote write URL %q: expected an http or https URL", endpoint)
+	}
+
+	if err := auth.Validate(); err != nil {
+		return nil, err
+	}
+
+	if queueSize < 1 {
+		return nil, fmt.Errorf("invalid remote write queue size %d: expected at least 1", queueSize)
+	}
+
+	w := writer{
+		url:        endpoint,
+		auth:       auth,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		gatherer:   gatherer,
+		clock:      clock,
+		queueSize:  queueSize,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+		batches: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "remote_write_batches_total",
+				Help: "Batches of samples pushed to the remote write endpoint, partitioned by whether they were sent, retried or dropped.",
+			},
+			[]string{"result"},
+		),
+		queued: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "remote_write_queued_batches",
+				Help: "Batches of samples waiting to be pushed to the remote write endpoint.",
+			},
+		),
+		logger: log.With(logger, "actor", "remote writer"),
+	}
+
+	registerer.MustRegister(w.batches, w.queued)
+
+	return &w, nil
+}
+
+// AddWriterStage adds a stage to the run group that queues the metrics to push at the supplied interval
+func AddWriterStage(group *group.Group, writer *writer, interval *time.Duration) {
+
+	ticker := time.NewTicker(*interval)
+
+	a := writerActor(writer, ticker.C)
+	group.Add(a.Execute, func(err error) {
+		ticker.Stop()
+		a.Interrupt(err)
+	})
+
+}
+
+// writerActor queues the metrics every time the tick channel fires and pushes the queue, backing off until the
+// batch at the front of the queue can be retried if the endpoint is unavailable
+func writerActor(writer *writer, tick <-chan time.Time) actor.Actor {
+
+	cancel := make(chan struct{})
+
+	return actor.NewActor(
+		func() error {
+
+			var retry <-chan time.Time
+
+			for {
+				select {
+
+				case <-tick:
+					writer.enqueue()
+
+					// Leave the queue alone until it's time to retry
+					if retry != nil {
+						continue
+					}
+
+				case <-retry:
+					retry = nil
+
+				case <-cancel:
+					_ = level.Info(writer.logger).Log("cancelled", "Asked to terminate")
+					return nil
+				}
+
+				if wait := writer.flush(); wait > 0 {
+					retry = time.After(wait)
+				}
+			}
+		},
+		func(err error) {
+			_ = level.Info(writer.logger).Log("interrupted", fmt.Sprintf("interrupted with %v", err))
+			close(cancel)
+		},
+	)
+
+}
+
+// enqueue gathers the metrics and adds them to the back of the queue as a batch, dropping the oldest batch if the
+// queue is full
+func (w *writer) enqueue() {
+
+	families, err := w.gatherer.Gather()
+	if err != nil {
+		_ = level.Warn(w.logger).Log("msg", "Unable to gather metrics to push", "err", err)
+		return
+	}
+
+	series := toTimeSeries(families, w.clock.Now())
+	if len(series) == 0 {
+		return
+	}
+
+	if len(w.queue) >= w.queueSize {
+		_ = level.Warn(w.logger).Log("msg", "Remote write queue is full, dropping the oldest batch", "size", w.queueSize)
+		w.queue = w.queue[1:]
+		w.batches.WithLabelValues("dropped").Inc()
+	}
+
+	w.queue = append(w.queue, snappy.Encode(nil, encode(series)))
+	w.queued.Set(float64(len(w.queue)))
+}
+
+// flush pushes the queued batches in order, returning how long to wait before retrying if the endpoint is
+// unavailable, or zero once the queue is empty. Batches the endpoint rejects are dropped, as retrying won't help.
+func (w *writer) flush() time.Duration {
+
+	defer func() { w.queued.Set(float64(len(w.queue))) }()
+
+	for len(w.queue) > 0 {
+
+		err := w.send(w.queue[0])
+
+		var recoverable *recoverableError
+		if errors.As(err, &recoverable) {
+			w.batches.WithLabelValues("retried").Inc()
+			w.backoff = nextBackoff(w.backoff, w.minBackoff, w.maxBackoff)
+			_ = level.Warn(w.logger).Log("msg", "Unable to push metrics, will retry", "err", err, "backoff", w.backoff)
+			return w.backoff
+		}
+
+		if err != nil {
+			w.batches.WithLabelValues("dropped").Inc()
+			_ = level.Warn(w.logger).Log("msg", "Remote write endpoint rejected metrics, dropping them", "err", err)
+		} else {
+			w.batches.WithLabelValues("sent").Inc()
+		}
+
+		w.queue = w.queue[1:]
+		w.backoff = 0
+	}
+
+	return 0
+}
+
+// nextBackoff doubles the backoff, between the min and max
+func nextBackoff(backoff time.Duration, min time.Duration, max time.Duration) time.Duration {
+	backoff *= 2
+	if backoff < min {
+		backoff = min
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// recoverableError is an error pushing a batch that's worth retrying
+type recoverableError struct {
+	error
+}
+
+// send posts the compressed batch to the endpoint
+func (w *writer) send(batch []byte) error {
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(batch))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "vpnck")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	switch {
+	case w.auth.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+w.auth.BearerToken)
+	case w.auth.Username != "":
+		req.SetBasicAuth(w.auth.Username, w.auth.Password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return &recoverableError{err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("remote write to %s failed with %s: %s", w.url, resp.Status, strings.TrimSpace(string(message)))
+
+	// Like Prometheus, retry when the endpoint is overloaded or broken, but not when the request is bad
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return &recoverableError{err}
+	}
+
+	return err
+}
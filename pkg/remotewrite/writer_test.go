@@ -0,0 +1,493 @@
+package remotewrite
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/metrics"
+	"github.com/go-kit/kit/log"
+	"github.com/golang/snappy"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver stands in for a remote write endpoint, decoding the series pushed to it. It responds with the statuses
+// in turn, then with 204 No Content.
+type receiver struct {
+	mutex    sync.Mutex
+	statuses []int
+	pushed   [][]timeSeries
+	requests []*http.Request
+	received chan struct{}
+}
+
+func newReceiver(statuses ...int) (*receiver, *httptest.Server) {
+	r := &receiver{statuses: statuses, received: make(chan struct{}, 100)}
+	return r, httptest.NewServer(r)
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	defer func() { r.received <- struct{}{} }()
+
+	r.requests = append(r.requests, req)
+
+	if len(r.statuses) > 0 {
+		status := r.statuses[0]
+		r.statuses = r.statuses[1:]
+		if status/100 != 2 {
+			http.Error(w, "failed", status)
+			return
+		}
+	}
+
+	compressed, _ := ioutil.ReadAll(req.Body)
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	series, err := decode(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.pushed = append(r.pushed, series)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *receiver) batches() [][]timeSeries {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.pushed
+}
+
+// decode decodes a WriteRequest, as a remote write endpoint would
+func decode(b []byte) ([]timeSeries, error) {
+
+	var series []timeSeries
+
+	err := decodeFields(b, func(field uint64, value []byte, _ uint64) error {
+		var ts timeSeries
+		err := decodeFields(value, func(field uint64, value []byte, _ uint64) error {
+			switch field {
+			case timeSeriesLabels:
+				var l label
+				err := decodeFields(value, func(field uint64, value []byte, _ uint64) error {
+					if field == labelName {
+						l.Name = string(value)
+					} else {
+						l.Value = string(value)
+					}
+					return nil
+				})
+				ts.Labels = append(ts.Labels, l)
+				return err
+			case timeSeriesSamples:
+				return decodeFields(value, func(field uint64, _ []byte, number uint64) error {
+					if field == sampleValue {
+						ts.Value = math.Float64frombits(number)
+					} else {
+						ts.Timestamp = time.Unix(0, int64(number)*int64(time.Millisecond)).UTC()
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+		series = append(series, ts)
+		return err
+	})
+
+	return series, err
+}
+
+// decodeFields calls the function with each field of the message, with either its bytes or its number
+func decodeFields(b []byte, f func(field uint64, value []byte, number uint64) error) error {
+
+	for len(b) > 0 {
+
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("invalid key")
+		}
+		b = b[n:]
+
+		var value []byte
+		var number uint64
+
+		switch key & 7 {
+		case wireVarint:
+			number, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errors.New("invalid varint")
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errors.New("invalid fixed64")
+			}
+			number = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return errors.New("invalid length")
+			}
+			value = b[n : n+int(length)]
+			b = b[n+int(length):]
+		default:
+			return fmt.Errorf("unexpected wire type %d", key&7)
+		}
+
+		if err := f(key>>3, value, number); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var now = time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+
+func newWriterForTesting(t *testing.T, url string, auth Auth, queueSize int) (*writer, *prometheus.Registry) {
+
+	t.Helper()
+
+	// The metrics to push
+	gathered := prometheus.NewRegistry()
+	up := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "cc_vpn_tunnel_up", Help: "Up."}, []string{"vpn_id", "outside_ip"})
+	up.WithLabelValues("vpn-1", "1.1.1.1").Set(1)
+	gathered.MustRegister(up)
+
+	// The metrics about pushing them
+	registry := prometheus.NewRegistry()
+
+	w, err := NewWriter(metrics.DefaultNaming.Registerer(registry), log.NewNopLogger(), url, auth, gathered, queueSize, fixedClock{now})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	w.minBackoff = time.Millisecond
+	w.maxBackoff = 4 * time.Millisecond
+
+	return w, registry
+}
+
+func TestPush(t *testing.T) {
+
+	// Given a remote write endpoint
+	rec, server := newReceiver()
+	defer server.Close()
+
+	w, _ := newWriterForTesting(t, server.URL+"/api/v1/write", Auth{Username: "vpnck", Password: "secret"}, 10)
+
+	// When the metrics are pushed
+	w.enqueue()
+	if wait := w.flush(); wait != 0 {
+		t.Fatalf("Expected the push to succeed, got a backoff of %v", wait)
+	}
+
+	// Then the series are received with sorted labels and the time they were gathered
+	want := [][]timeSeries{
+		{
+			{
+				Labels: []label{
+					{Name: "__name__", Value: "cc_vpn_tunnel_up"},
+					{Name: "outside_ip", Value: "1.1.1.1"},
+					{Name: "vpn_id", Value: "vpn-1"},
+				},
+				Value:     1,
+				Timestamp: now,
+			},
+		},
+	}
+	if diff := cmp.Diff(want, rec.batches()); diff != "" {
+		t.Errorf("Unexpected series pushed (-want +got):\n%s", diff)
+	}
+
+	// And the request is as remote write endpoints expect
+	req := rec.requests[0]
+	for header, value := range map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	} {
+		if got := req.Header.Get(header); got != value {
+			t.Errorf("Expected %s header of %q, got %q", header, value, got)
+		}
+	}
+	if username, password, ok := req.BasicAuth(); !ok || username != "vpnck" || password != "secret" {
+		t.Errorf("Expected basic auth, got %v", req.Header.Get("Authorization"))
+	}
+}
+
+func TestPushWithBearerToken(t *testing.T) {
+
+	rec, server := newReceiver()
+	defer server.Close()
+
+	w, _ := newWriterForTesting(t, server.URL, Auth{BearerToken: "abc123"}, 10)
+	w.enqueue()
+	w.flush()
+
+	if got := rec.requests[0].Header.Get("Authorization"); got != "Bearer abc123" {
+		t.Errorf("Expected the bearer token, got %q", got)
+	}
+}
+
+var failuretests = []struct {
+	name       string
+	statuses   []int
+	wantQueued int
+	wantResult string
+}{
+	{name: "Unavailable", statuses: []int{http.StatusServiceUnavailable}, wantQueued: 1, wantResult: "retried"},
+	{name: "Too many requests", statuses: []int{http.StatusTooManyRequests}, wantQueued: 1, wantResult: "retried"},
+	{name: "Bad request", statuses: []int{http.StatusBadRequest}, wantQueued: 0, wantResult: "dropped"},
+}
+
+func TestFailures(t *testing.T) {
+	for _, tt := range failuretests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// Given an endpoint that fails
+			rec, server := newReceiver(tt.statuses...)
+			defer server.Close()
+
+			w, registry := newWriterForTesting(t, server.URL, Auth{}, 10)
+
+			// When the metrics are pushed
+			w.enqueue()
+			wait := w.flush()
+
+			// Then they're only kept to retry if it's worth retrying
+			if len(w.queue) != tt.wantQueued {
+				t.Errorf("Expected %d queued batches, got %d", tt.wantQueued, len(w.queue))
+			}
+			if (wait > 0) != (tt.wantQueued > 0) {
+				t.Errorf("Unexpected backoff of %v", wait)
+			}
+
+			expected := `
+	# HELP cc_vpn_remote_write_batches_total Batches of samples pushed to the remote write endpoint, partitioned by whether they were sent, retried or dropped.
+	# TYPE cc_vpn_remote_write_batches_total counter
+	cc_vpn_remote_write_batches_total{result="` + tt.wantResult + `"} 1
+`
+			if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "cc_vpn_remote_write_batches_total"); err != nil {
+				t.Error(err)
+			}
+
+			// And a retry succeeds
+			if tt.wantQueued > 0 {
+				w.flush()
+				if len(rec.batches()) != 1 || len(w.queue) != 0 {
+					t.Errorf("Expected the retry to succeed, %d batches are still queued", len(w.queue))
+				}
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+
+	// Given an endpoint that's down
+	_, server := newReceiver(http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	defer server.Close()
+
+	w, _ := newWriterForTesting(t, server.URL, Auth{}, 10)
+	w.enqueue()
+
+	// When retrying repeatedly
+	var waits []time.Duration
+	for i := 0; i < 4; i++ {
+		waits = append(waits, w.flush())
+	}
+
+	// Then the backoff doubles up to the max
+	want := []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond, 4 * time.Millisecond}
+	if diff := cmp.Diff(want, waits); diff != "" {
+		t.Errorf("Unexpected backoffs (-want +got):\n%s", diff)
+	}
+
+	// And it resets once a batch is sent
+	w.flush()
+	if w.backoff != 0 {
+		t.Errorf("Expected the backoff to reset, got %v", w.backoff)
+	}
+}
+
+func TestQueueIsBounded(t *testing.T) {
+
+	// Given an endpoint that's down and a queue of 2 batches
+	_, server := newReceiver()
+	server.Close()
+
+	w, registry := newWriterForTesting(t, server.URL, Auth{}, 2)
+
+	// When 3 batches are queued
+	for i := 0; i < 3; i++ {
+		w.enqueue()
+		w.flush()
+	}
+
+	// Then the oldest is dropped
+	expected := `
+	# HELP cc_vpn_remote_write_queued_batches Batches of samples waiting to be pushed to the remote write endpoint.
+	# TYPE cc_vpn_remote_write_queued_batches gauge
+	cc_vpn_remote_write_queued_batches 2
+	# HELP cc_vpn_remote_write_batches_total Batches of samples pushed to the remote write endpoint, partitioned by whether they were sent, retried or dropped.
+	# TYPE cc_vpn_remote_write_batches_total counter
+	cc_vpn_remote_write_batches_total{result="dropped"} 1
+	cc_vpn_remote_write_batches_total{result="retried"} 3
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriterActorRetries(t *testing.T) {
+
+	// Given an endpoint that fails twice
+	rec, server := newReceiver(http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	w, _ := newWriterForTesting(t, server.URL, Auth{}, 10)
+
+	tick := make(chan time.Time)
+	underTest := writerActor(w, tick)
+	go func() { _ = underTest.Execute() }()
+	defer underTest.Interrupt(nil)
+
+	// When it's time to push
+	tick <- now
+
+	// Then the metrics are pushed once the endpoint recovers, without waiting for the next tick
+	for i := 0; i < 3; i++ {
+		select {
+		case <-rec.received:
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for push %d", i+1)
+		}
+	}
+
+	if len(rec.batches()) != 1 {
+		t.Errorf("Expected 1 batch to be pushed, got %d", len(rec.batches()))
+	}
+}
+
+var writertests = []struct {
+	name     string
+	endpoint string
+	auth     Auth
+	queue    int
+}{
+	{name: "Invalid URL", endpoint: "localhost:9090/api/v1/write", queue: 1},
+	{name: "Basic auth and bearer token", endpoint: "http://localhost/", auth: Auth{Username: "a", Password: "b", BearerToken: "c"}, queue: 1},
+	{name: "Password without username", endpoint: "http://localhost/", auth: Auth{Password: "b"}, queue: 1},
+	{name: "Empty queue", endpoint: "http://localhost/", queue: 0},
+}
+
+func TestNewWriterErrors(t *testing.T) {
+	for _, tt := range writertests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewWriter(prometheus.NewRegistry(), log.NewNopLogger(), tt.endpoint, tt.auth, prometheus.NewRegistry(), tt.queue, fixedClock{now})
+			if err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestHistogramSeries(t *testing.T) {
+
+	// Given a histogram
+	registry := prometheus.NewRegistry()
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency_seconds", Help: "Latency.", Buckets: []float64{0.5}})
+	h.Observe(0.1)
+	h.Observe(2)
+	registry.MustRegister(h)
+	families, _ := registry.Gather()
+
+	// When converted to series
+	series := toTimeSeries(families, now)
+
+	// Then there are series for each bucket, the sum and the count
+	var got []string
+	for _, s := range series {
+		var labels []string
+		for _, l := range s.Labels {
+			labels = append(labels, l.Name+"="+l.Value)
+		}
+		got = append(got, strings.Join(labels, ",")+" "+formatFloat(s.Value))
+	}
+
+	want := []string{
+		"__name__=latency_seconds_bucket,le=0.5 1",
+		"__name__=latency_seconds_bucket,le=+Inf 2",
+		"__name__=latency_seconds_sum 2.1",
+		"__name__=latency_seconds_count 2",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Unexpected series (-want +got):\n%s", diff)
+	}
+}
+
+var interruptests = []struct {
+	name  string
+	actor actor.Actor
+}{
+	{name: "Writer", actor: writerActor(&writer{logger: log.NewNopLogger()}, make(chan time.Time))},
+}
+
+// Tests that the actors honour the contract as per https://github.com/oklog/run#run.
+// When the interrupt function is called the actor should return
+func TestInterrupt(t *testing.T) {
+
+	for _, tt := range interruptests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			underTest := tt.actor
+
+			// Run the actor.
+			errors := make(chan error)
+			go func(a actor.Actor) {
+				errors <- a.Execute()
+			}(underTest)
+
+			// Signal for the actor to stop
+			underTest.Interrupt(nil)
+
+			select {
+			case <-errors:
+				return
+			case <-time.After(1 * time.Second):
+			}
+
+			t.Error("actor didn't shut down in response to interrupt")
+
+		})
+	}
+}
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.now
+}
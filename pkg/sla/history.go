@@ -0,0 +1,191 @@
+package sla
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Transition is when the subject of a history went up or down, or its status stopped being known
+type Transition struct {
+	Time time.Time `json:"time"`
+	Up   bool      `json:"up"`
+
+	// Unknown is true when the subject stopped being seen, such as while vpnck wasn't running, so the time until the
+	// next transition doesn't count for or against it
+	Unknown bool `json:"unknown,omitempty"`
+}
+
+// Timeline is the history of one connection or tunnel
+type Timeline struct {
+	// Transitions are in time order, with the status holding until the next one
+	Transitions []Transition `json:"transitions"`
+
+	// LastSeen is when the status was last known
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// History records when connections and tunnels went up and down, keyed by what they are the history of
+type History struct {
+	Timelines map[string]*Timeline `json:"timelines"`
+}
+
+// NewHistory returns an empty history
+func NewHistory() *History {
+	return &History{Timelines: make(map[string]*Timeline)}
+}
+
+// LoadHistory reads a history saved to the file at the supplied path. A file that doesn't exist yet gives an empty
+// history.
+func LoadHistory(path string) (*History, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return NewHistory(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	h := NewHistory()
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, err
+	}
+
+	if h.Timelines == nil {
+		h.Timelines = make(map[string]*Timeline)
+	}
+
+	// Nothing was seen while vpnck wasn't running
+	for _, t := range h.Timelines {
+		t.lapse()
+	}
+
+	return h, nil
+}
+
+// Save writes the history to the file at the supplied path, replacing it in one go so a crash can't leave it
+// half written
+func (h *History) Save(path string) error {
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Record notes the status of the subject at the supplied time, adding a transition if it changed. If it wasn't seen
+// for longer than staleAfter, its status wasn't known from when it was last seen until now, rather than holding. A
+// staleAfter of zero never treats the subject as stale.
+func (h *History) Record(key string, up bool, at time.Time, staleAfter time.Duration) {
+
+	t, ok := h.Timelines[key]
+	if !ok {
+		t = &Timeline{}
+		h.Timelines[key] = t
+	}
+
+	if staleAfter > 0 && at.Sub(t.LastSeen) > staleAfter {
+		t.lapse()
+	}
+
+	if n := len(t.Transitions); n == 0 || t.Transitions[n-1].Unknown || t.Transitions[n-1].Up != up {
+		t.Transitions = append(t.Transitions, Transition{Time: at, Up: up})
+	}
+
+	t.LastSeen = at
+}
+
+// lapse notes the status stopped being known when the subject was last seen
+func (t *Timeline) lapse() {
+	if n := len(t.Transitions); n > 0 && !t.Transitions[n-1].Unknown {
+		t.Transitions = append(t.Transitions, Transition{Time: t.LastSeen, Unknown: true})
+	}
+}
+
+// Prune forgets what happened before the supplied time, apart from the status at that time. Subjects not seen since
+// then are forgotten entirely.
+func (h *History) Prune(before time.Time) {
+
+	for key, t := range h.Timelines {
+
+		if t.LastSeen.Before(before) {
+			delete(h.Timelines, key)
+			continue
+		}
+
+		// Keep the last transition before the cut off, as the status at the cut off
+		keep := 0
+		for i, transition := range t.Transitions {
+			if transition.Time.After(before) {
+				break
+			}
+			keep = i
+		}
+
+		t.Transitions = t.Transitions[keep:]
+	}
+}
+
+// Availability returns the percentage of the window the subject was up for, ignoring time its status wasn't known
+// and the excluded intervals. It's false if the status wasn't known for any of the window.
+func (h *History) Availability(key string, window Interval, excluded []Interval) (float64, bool) {
+
+	t, ok := h.Timelines[key]
+	if !ok {
+		return 0, false
+	}
+
+	excluded = merge(excluded)
+
+	var known, up time.Duration
+
+	for i, transition := range t.Transitions {
+
+		end := t.LastSeen
+		if i+1 < len(t.Transitions) {
+			end = t.Transitions[i+1].Time
+		}
+
+		if transition.Unknown {
+			continue
+		}
+
+		segment, ok := window.clip(Interval{Start: transition.Time, End: end})
+		if !ok {
+			continue
+		}
+
+		d := segment.without(excluded)
+		known += d
+		if transition.Up {
+			up += d
+		}
+	}
+
+	if known == 0 {
+		return 0, false
+	}
+
+	return 100 * float64(up) / float64(known), true
+}
@@ -0,0 +1,200 @@
+package sla
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+
+func at(hours int) time.Time {
+	return start.Add(time.Duration(hours) * time.Hour)
+}
+
+// Up for the first 6 hours, down for 2 and up again for 2
+func tenHourHistory() *History {
+	h := NewHistory()
+	for hour := 0; hour <= 10; hour++ {
+		h.Record("tunnel", hour < 6 || hour >= 8, at(hour), 0)
+	}
+	return h
+}
+
+var availabilitytests = []struct {
+	name     string
+	window   Interval
+	excluded []Interval
+	percent  float64
+	known    bool
+}{
+	{name: "Whole history", window: Interval{at(0), at(10)}, percent: 80, known: true},
+	{name: "Window starting before history", window: Interval{at(-10), at(10)}, percent: 80, known: true},
+	{name: "Window while up", window: Interval{at(1), at(5)}, percent: 100, known: true},
+	{name: "Window while down", window: Interval{at(6), at(8)}, percent: 0, known: true},
+	{name: "Window after last seen", window: Interval{at(10), at(20)}, known: false},
+	{name: "Outage excluded", window: Interval{at(0), at(10)}, excluded: []Interval{{at(6), at(8)}}, percent: 100, known: true},
+	{name: "Overlapping exclusions", window: Interval{at(0), at(10)}, excluded: []Interval{{at(8), at(9)}, {at(7), at(9)}}, percent: 87.5, known: true},
+	{name: "Everything excluded", window: Interval{at(0), at(10)}, excluded: []Interval{{at(-1), at(11)}}, known: false},
+}
+
+func TestAvailability(t *testing.T) {
+
+	h := tenHourHistory()
+
+	for _, tt := range availabilitytests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			percent, known := h.Availability("tunnel", tt.window, tt.excluded)
+
+			if known != tt.known || (known && !cmp.Equal(percent, tt.percent, cmp.Comparer(approximately))) {
+				t.Errorf("Expected %v %v but got %v %v", tt.percent, tt.known, percent, known)
+			}
+
+		})
+	}
+}
+
+func TestUnknownSubject(t *testing.T) {
+	if _, known := tenHourHistory().Availability("elsewhere", Interval{at(0), at(10)}, nil); known {
+		t.Errorf("Expected availability of something never recorded to be unknown")
+	}
+}
+
+func TestRecordOnlyKeepsTransitions(t *testing.T) {
+
+	h := tenHourHistory()
+
+	want := []Transition{{Time: at(0), Up: true}, {Time: at(6), Up: false}, {Time: at(8), Up: true}}
+
+	if !cmp.Equal(want, h.Timelines["tunnel"].Transitions) {
+		t.Errorf("Transitions incorrect: %s", cmp.Diff(want, h.Timelines["tunnel"].Transitions))
+	}
+
+	if !h.Timelines["tunnel"].LastSeen.Equal(at(10)) {
+		t.Errorf("Expected last seen at %v but got %v", at(10), h.Timelines["tunnel"].LastSeen)
+	}
+}
+
+func TestPrune(t *testing.T) {
+
+	// Given a history with something not seen for a while
+	h := tenHourHistory()
+	h.Record("gone", true, at(1), 0)
+
+	// When it's pruned
+	h.Prune(at(7))
+
+	// Then only the status at the cut off and later transitions should be kept
+	want := []Transition{{Time: at(6), Up: false}, {Time: at(8), Up: true}}
+	if !cmp.Equal(want, h.Timelines["tunnel"].Transitions) {
+		t.Errorf("Transitions incorrect: %s", cmp.Diff(want, h.Timelines["tunnel"].Transitions))
+	}
+
+	// and what's gone should be forgotten
+	if _, ok := h.Timelines["gone"]; ok {
+		t.Errorf("Expected history of something not seen since the cut off to be forgotten")
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sla")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "history.json")
+
+	// Given no history has been saved yet
+	loaded, err := LoadHistory(path)
+	if err != nil || len(loaded.Timelines) != 0 {
+		t.Errorf("Expected an empty history but got %v and %v", loaded, err)
+		return
+	}
+
+	// When a history is saved and loaded again
+	h := tenHourHistory()
+	if err := h.Save(path); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	loaded, err = LoadHistory(path)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	// Then it should be the same, apart from the status not being known since it was last seen
+	h.Timelines["tunnel"].Transitions = append(h.Timelines["tunnel"].Transitions, Transition{Time: at(10), Unknown: true})
+	if !cmp.Equal(h, loaded) {
+		t.Errorf("Loaded history incorrect: %s", cmp.Diff(h, loaded))
+	}
+}
+
+func TestRestartGap(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sla")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "history.json")
+
+	// Given a tunnel up for the first 10 hours, when vpnck was stopped
+	if err := tenHourHistory().Save(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// When vpnck starts again 5 hours later, and the tunnel has gone down
+	h, err := LoadHistory(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	h.Record("tunnel", false, at(15), time.Hour)
+	h.Record("tunnel", false, at(16), time.Hour)
+
+	// Then the time vpnck was stopped doesn't count for or against the tunnel
+	percent, known := h.Availability("tunnel", Interval{at(0), at(16)}, nil)
+	if !known || !cmp.Equal(percent, 8.0/11*100, cmp.Comparer(approximately)) {
+		t.Errorf("Expected %v but got %v %v", 8.0/11*100, percent, known)
+	}
+}
+
+func TestStaleGap(t *testing.T) {
+
+	// Given a tunnel seen up every hour
+	h := NewHistory()
+	h.Record("tunnel", true, at(0), 2*time.Hour)
+	h.Record("tunnel", true, at(1), 2*time.Hour)
+
+	// When it isn't seen again for longer than it goes stale after, such as when it's not reported
+	h.Record("tunnel", true, at(5), 2*time.Hour)
+	h.Record("tunnel", true, at(6), 2*time.Hour)
+
+	// Then the gap is recorded as unknown rather than up
+	want := []Transition{{Time: at(0), Up: true}, {Time: at(1), Unknown: true}, {Time: at(5), Up: true}}
+	if !cmp.Equal(want, h.Timelines["tunnel"].Transitions) {
+		t.Errorf("Transitions incorrect: %s", cmp.Diff(want, h.Timelines["tunnel"].Transitions))
+	}
+
+	// and only the time it was seen counts
+	percent, known := h.Availability("tunnel", Interval{at(0), at(6)}, nil)
+	if !known || percent != 100 {
+		t.Errorf("Expected 100 but got %v %v", percent, known)
+	}
+	if _, known := h.Availability("tunnel", Interval{at(2), at(4)}, nil); known {
+		t.Errorf("Expected availability during the gap to be unknown")
+	}
+}
+
+func approximately(a, b float64) bool {
+	d := a - b
+	return d < 1e-9 && d > -1e-9
+}
@@ -0,0 +1,259 @@
+package sla
+
+import (
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/metrics"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/oklog/pkg/group"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// Exclusions returns the intervals that don't count towards the availability of a connection, or of one of its
+// tunnels when the tunnel isn't nil
+type Exclusions func(conn *vpn.Connection, tunnel *vpn.Tunnel) []Interval
+
+// tracker records the history of connections and tunnels, and works out their availability from it
+type tracker struct {
+	history              *History
+	file                 string
+	windows              []Window
+	exclusions           Exclusions
+	staleAfter           time.Duration
+	clock                state.Clock
+	tunnelGaugeVec       *prometheus.GaugeVec
+	connectionGaugeVec   *prometheus.GaugeVec
+	publishedTunnels     map[string]prometheus.Labels
+	publishedConnections map[string]prometheus.Labels
+	logger               log.Logger
+}
+
+// NewTracker returns an instance ready to use, which adds to the supplied history and saves it to the file after
+// every update if there is one. Exclusions can be nil if no time should be excluded. Connections and tunnels not seen
+// for two poll intervals have gone unknown since they were last seen, rather than holding their status.
+func NewTracker(registerer prometheus.Registerer, logger log.Logger, history *History, file string, windows []Window, exclusions Exclusions, interval time.Duration, clock state.Clock) *tracker {
+
+	t := tracker{
+		history:    history,
+		file:       file,
+		windows:    windows,
+		exclusions: exclusions,
+		staleAfter: 2 * interval,
+		clock:      clock,
+		tunnelGaugeVec: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "tunnel_availability_percent",
+				Help: "Percentage of the window the VPN tunnel was up for, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP, Tunnel ID and window.",
+			},
+			append(append([]string{}, metrics.TunnelLabelNames...), "window"),
+		),
+		connectionGaugeVec: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "connection_availability_percent",
+				Help: "Percentage of the window at least one tunnel of the VPN connection was up for, partitioned by VPN Connection ID and window.",
+			},
+			[]string{"vpn_connection_id", "window"},
+		),
+		publishedTunnels:     make(map[string]prometheus.Labels),
+		publishedConnections: make(map[string]prometheus.Labels),
+		logger:               log.With(logger, "actor", "sla tracker"),
+	}
+
+	registerer.MustRegister(t.tunnelGaugeVec, t.connectionGaugeVec)
+
+	return &t
+}
+
+// AddTrackerStage adds a stage to the run group that records the history of the connections it receives, and sends
+// them to the next stage with their availability. Repeats of a poll aren't recorded, as the connections weren't seen
+// again.
+func AddTrackerStage(group *group.Group, tracker *tracker, in <-chan state.Poll, out chan<- state.Poll) {
+
+	a := trackerActor(tracker, in, out)
+	group.Add(a.Execute, a.Interrupt)
+
+}
+
+// trackerActor updates the history with the connections received before sending them down the out channel
+func trackerActor(tracker *tracker, in <-chan state.Poll, out chan<- state.Poll) actor.Actor {
+
+	cancel := make(chan struct{})
+
+	return actor.NewActor(
+		func() error {
+
+			for {
+				select {
+
+				case poll := <-in:
+					select {
+					case out <- poll.With(tracker.update(poll.Connections, !poll.Repeat)):
+					case <-cancel:
+						_ = level.Info(tracker.logger).Log("cancelled", "Asked to terminate")
+						return nil
+					}
+
+				case <-cancel:
+					_ = level.Info(tracker.logger).Log("cancelled", "Asked to terminate")
+					return nil
+				}
+			}
+		},
+		func(err error) {
+			_ = level.Info(tracker.logger).Log("interrupted", fmt.Sprintf("interrupted with %v", err))
+			close(cancel)
+		},
+	)
+
+}
+
+// update records the status of the connections and their tunnels if they have just been seen, and returns copies of
+// them with their availability. Tunnels with an unknown status aren't recorded, so don't count for or against them.
+func (t *tracker) update(connections []*vpn.Connection, seen bool) []*vpn.Connection {
+
+	now := t.clock.Now()
+
+	if seen {
+		t.record(connections, now)
+	}
+
+	currentTunnels := make(map[string]prometheus.Labels)
+	currentConnections := make(map[string]prometheus.Labels)
+
+	tracked := make([]*vpn.Connection, len(connections))
+
+	for i, conn := range connections {
+
+		c := *conn
+		c.Timeline = t.timeline(connectionKey(conn))
+		c.Availability = t.availability(connectionKey(conn), t.excluded(conn, nil), now, func(w string, percent float64) {
+			labels := prometheus.Labels{"vpn_connection_id": conn.ID, "window": w}
+			t.connectionGaugeVec.With(labels).Set(percent)
+			currentConnections[fmt.Sprintf("%v", labels)] = labels
+		})
+
+		c.Tunnels = make([]*vpn.Tunnel, len(conn.Tunnels))
+		for j, tunnel := range conn.Tunnels {
+
+			copied := *tunnel
+			copied.Timeline = t.timeline(tunnelKey(conn, tunnel))
+			copied.Availability = t.availability(tunnelKey(conn, tunnel), t.excluded(conn, tunnel), now, func(w string, percent float64) {
+				labels := metrics.TunnelLabels(conn, tunnel)
+				labels["window"] = w
+				t.tunnelGaugeVec.With(labels).Set(percent)
+				currentTunnels[fmt.Sprintf("%v", labels)] = labels
+			})
+
+			c.Tunnels[j] = &copied
+		}
+
+		tracked[i] = &c
+	}
+
+	deleteRedundant(t.tunnelGaugeVec, t.publishedTunnels, currentTunnels)
+	deleteRedundant(t.connectionGaugeVec, t.publishedConnections, currentConnections)
+
+	t.publishedTunnels = currentTunnels
+	t.publishedConnections = currentConnections
+
+	return tracked
+}
+
+// availability works out the availability of the subject over each window, calling publish for each one it's known
+// for
+func (t *tracker) availability(key string, excluded []Interval, now time.Time, publish func(window string, percent float64)) []*vpn.Availability {
+
+	var availability []*vpn.Availability
+
+	for _, w := range t.windows {
+		percent, ok := t.history.Availability(key, w.Interval(now), excluded)
+		if !ok {
+			continue
+		}
+		availability = append(availability, &vpn.Availability{Window: w.Name, Percent: percent})
+		publish(w.Name, percent)
+	}
+
+	return availability
+}
+
+// timeline returns a copy of the history of the subject, as the history changes after it's sent on, or nil if it has
+// none
+func (t *tracker) timeline(key string) *vpn.Timeline {
+
+	history, ok := t.history.Timelines[key]
+	if !ok {
+		return nil
+	}
+
+	timeline := &vpn.Timeline{LastSeen: history.LastSeen}
+	for _, transition := range history.Transitions {
+		timeline.Transitions = append(timeline.Transitions, &vpn.Transition{Time: transition.Time, Up: transition.Up, Unknown: transition.Unknown})
+	}
+
+	return timeline
+}
+
+func (t *tracker) excluded(conn *vpn.Connection, tunnel *vpn.Tunnel) []Interval {
+	if t.exclusions == nil {
+		return nil
+	}
+	return t.exclusions(conn, tunnel)
+}
+
+// retention is how much history the longest window needs
+func (t *tracker) retention() time.Duration {
+	var longest time.Duration
+	for _, w := range t.windows {
+		if w.longest() > longest {
+			longest = w.longest()
+		}
+	}
+	return longest
+}
+
+func deleteRedundant(vec *prometheus.GaugeVec, published map[string]prometheus.Labels, current map[string]prometheus.Labels) {
+	for id, labels := range published {
+		if _, ok := current[id]; !ok {
+			vec.Delete(labels)
+		}
+	}
+}
+
+// record notes the status of the connections and their tunnels in the history, and saves it
+func (t *tracker) record(connections []*vpn.Connection, now time.Time) {
+
+	for _, conn := range connections {
+		t.history.Record(connectionKey(conn), conn.Up(), now, t.staleAfter)
+		for _, tunnel := range conn.Tunnels {
+			if tunnel.Status != vpn.StatusUnknown {
+				t.history.Record(tunnelKey(conn, tunnel), tunnel.Status == vpn.StatusUp, now, t.staleAfter)
+			}
+		}
+	}
+
+	t.history.Prune(now.Add(-t.retention()))
+
+	if t.file != "" {
+		if err := t.history.Save(t.file); err != nil {
+			_ = level.Warn(t.logger).Log("msg", "Unable to save availability history", "file", t.file, "err", err)
+		}
+	}
+}
+
+func connectionKey(conn *vpn.Connection) string {
+	return "connection/" + conn.ID
+}
+
+// tunnelKey identifies a tunnel by its ID, falling back to its outside IP for sources that don't give tunnels an ID
+func tunnelKey(conn *vpn.Connection, tunnel *vpn.Tunnel) string {
+	id := tunnel.ID
+	if id == "" {
+		id = tunnel.OutsideIP
+	}
+	return "tunnel/" + conn.ID + "/" + id
+}
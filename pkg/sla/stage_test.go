@@ -0,0 +1,217 @@
+package sla
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/metrics"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+const availabilityMetadata = `
+	# HELP cc_vpn_connection_availability_percent Percentage of the window at least one tunnel of the VPN connection was up for, partitioned by VPN Connection ID and window.
+	# TYPE cc_vpn_connection_availability_percent gauge
+	cc_vpn_connection_availability_percent{vpn_connection_id="vpn-1",window="24h"} 100
+	# HELP cc_vpn_tunnel_availability_percent Percentage of the window the VPN tunnel was up for, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP, Tunnel ID and window.
+	# TYPE cc_vpn_tunnel_availability_percent gauge
+	cc_vpn_tunnel_availability_percent{outside_ip="1.1.1.1",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1",window="24h"} 100
+	cc_vpn_tunnel_availability_percent{outside_ip="2.2.2.2",transit_gateway_id="",tunnel_id="",vpn_id="vgw-1",window="24h"} 50
+`
+
+func TestTrackerAvailability(t *testing.T) {
+
+	// Given a connection with one tunnel that stays up, and one that goes down half way through
+	registry := prometheus.NewRegistry()
+	clock := &movableClock{now: at(0)}
+	windows, _ := ParseWindows("24h")
+	underTest := NewTracker(metrics.DefaultNaming.Registerer(registry), log.NewNopLogger(), NewHistory(), "", windows, nil, time.Hour, clock)
+
+	underTest.update(connection(vpn.StatusUp, vpn.StatusUp), true)
+	clock.now = at(1)
+	underTest.update(connection(vpn.StatusUp, vpn.StatusDown), true)
+	clock.now = at(2)
+	sent := connection(vpn.StatusUp, vpn.StatusDown)
+
+	// When the connection is next updated
+	tracked := underTest.update(sent, true)
+
+	// Then the availability should be added to copies of the connection and its tunnels
+	if tracked[0] == sent[0] || tracked[0].Tunnels[0] == sent[0].Tunnels[0] || sent[0].Availability != nil || sent[0].Tunnels[0].Availability != nil {
+		t.Errorf("Connections should have been copied, not changed")
+	}
+
+	if a := tracked[0].Availability; len(a) != 1 || a[0].Window != "24h" || a[0].Percent != 100 {
+		t.Errorf("Connection availability incorrect, got %+v", a)
+	}
+
+	if a := tracked[0].Tunnels[1].Availability; len(a) != 1 || a[0].Percent != 50 {
+		t.Errorf("Tunnel availability incorrect, got %+v", a)
+	}
+
+	// with when they went up and down
+	wantTimeline := &vpn.Timeline{
+		Transitions: []*vpn.Transition{{Time: at(0), Up: true}, {Time: at(1), Up: false}},
+		LastSeen:    at(2),
+	}
+	if diff := cmp.Diff(wantTimeline, tracked[0].Tunnels[1].Timeline); diff != "" {
+		t.Errorf("Tunnel timeline incorrect (-want +got):\n%s", diff)
+	}
+
+	if tl := tracked[0].Timeline; tl == nil || len(tl.Transitions) != 1 || !tl.Transitions[0].Up {
+		t.Errorf("Connection timeline incorrect, got %+v", tl)
+	}
+
+	// and published
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(availabilityMetadata)); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+
+	// and removed when the connection goes away
+	underTest.update([]*vpn.Connection{}, true)
+
+	if err := testutil.GatherAndCompare(registry, strings.NewReader("")); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
+
+func TestTrackerExclusions(t *testing.T) {
+
+	// Given the outage of a tunnel is excluded
+	clock := &movableClock{now: at(0)}
+	windows, _ := ParseWindows("24h")
+	exclusions := func(conn *vpn.Connection, tunnel *vpn.Tunnel) []Interval {
+		if tunnel != nil && tunnel.OutsideIP == "2.2.2.2" {
+			return []Interval{{at(1), at(2)}}
+		}
+		return nil
+	}
+	underTest := NewTracker(prometheus.NewRegistry(), log.NewNopLogger(), NewHistory(), "", windows, exclusions, time.Hour, clock)
+
+	underTest.update(connection(vpn.StatusUp, vpn.StatusUp), true)
+	clock.now = at(1)
+	underTest.update(connection(vpn.StatusUp, vpn.StatusDown), true)
+	clock.now = at(2)
+
+	// When the connection is updated
+	tracked := underTest.update(connection(vpn.StatusUp, vpn.StatusDown), true)
+
+	// Then the tunnel should be fully available
+	if a := tracked[0].Tunnels[1].Availability; len(a) != 1 || a[0].Percent != 100 {
+		t.Errorf("Tunnel availability incorrect, got %+v", a)
+	}
+}
+
+func TestTrackerIgnoresRepeats(t *testing.T) {
+
+	// Given a connection seen up over an hour
+	clock := &movableClock{now: at(0)}
+	windows, _ := ParseWindows("24h")
+	history := NewHistory()
+	underTest := NewTracker(prometheus.NewRegistry(), log.NewNopLogger(), history, "", windows, nil, time.Hour, clock)
+	underTest.update(connection(vpn.StatusUp, vpn.StatusUp), true)
+	clock.now = at(1)
+	underTest.update(connection(vpn.StatusUp, vpn.StatusUp), true)
+
+	// When it's sent again later without being seen, such as with new probe results
+	clock.now = at(2)
+	tracked := underTest.update(connection(vpn.StatusUp, vpn.StatusDown), false)
+
+	// Then nothing is recorded, as the sources weren't polled again
+	if tl := history.Timelines[tunnelKey(tracked[0], tracked[0].Tunnels[1])]; tl == nil || len(tl.Transitions) != 1 || !tl.LastSeen.Equal(at(1)) {
+		t.Errorf("Expected the tunnel to be up when last seen at %s, but got %+v", at(1), tl)
+	}
+
+	// but the connections still have their availability
+	if a := tracked[0].Tunnels[1].Availability; len(a) != 1 || a[0].Percent != 100 {
+		t.Errorf("Tunnel availability incorrect, got %+v", a)
+	}
+}
+
+func TestTrackerStagePassesConnectionsOn(t *testing.T) {
+
+	in := make(chan state.Poll)
+	out := make(chan state.Poll)
+
+	windows, _ := ParseWindows("24h")
+	underTest := trackerActor(NewTracker(prometheus.NewRegistry(), log.NewNopLogger(), NewHistory(), "", windows, nil, time.Hour, &movableClock{now: at(0)}), in, out)
+	defer underTest.Interrupt(nil)
+
+	// When the stage is running
+	go func(a actor.Actor) {
+		_ = a.Execute()
+	}(underTest)
+
+	go func() { in <- state.Poll{Connections: connection(vpn.StatusUp, vpn.StatusDown)} }()
+
+	// Then the connections should be sent to the next stage in the pipeline
+	select {
+	case received := <-out:
+		if len(received.Connections) != 1 || received.Connections[0].ID != "vpn-1" {
+			t.Errorf("Data sent to next stage incorrect : got %v", received)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("Timed out waiting for connections to be passed down the pipeline")
+	}
+}
+
+var interruptests = []struct {
+	name  string
+	actor actor.Actor
+}{
+	{name: "Tracker", actor: trackerActor(NewTracker(prometheus.NewRegistry(), log.NewNopLogger(), NewHistory(), "", nil, nil, time.Hour, &movableClock{}), make(chan state.Poll), make(chan state.Poll))},
+}
+
+// Tests that the actors honour the contract as per https://github.com/oklog/run#run.
+// When the interrupt function is called the actor should return
+func TestInterrupt(t *testing.T) {
+
+	for _, tt := range interruptests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			underTest := tt.actor
+
+			// Run the actor.
+			errors := make(chan error)
+			go func(a actor.Actor) {
+				errors <- a.Execute()
+			}(underTest)
+
+			// Signal for the actor to stop
+			underTest.Interrupt(nil)
+
+			select {
+			case <-errors:
+				return
+			case <-time.After(1 * time.Second):
+			}
+
+			t.Error("actor didn't shut down in response to interrupt")
+
+		})
+	}
+}
+
+func connection(first vpn.Status, second vpn.Status) []*vpn.Connection {
+	return []*vpn.Connection{
+		{
+			ID:         "vpn-1",
+			Attributes: map[string]string{vpn.AttrVpnGatewayID: "vgw-1"},
+			Tunnels: []*vpn.Tunnel{
+				{ID: "1.1.1.1", OutsideIP: "1.1.1.1", Status: first},
+				{ID: "2.2.2.2", OutsideIP: "2.2.2.2", Status: second},
+			},
+		},
+	}
+}
+
+type movableClock struct {
+	now time.Time
+}
+
+func (c *movableClock) Now() time.Time { return c.now }
@@ -0,0 +1,137 @@
+package sla
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Month is the name of the window covering the calendar month so far
+const Month = "month"
+
+// Interval is a period of time, from its start up to its end
+type Interval struct {
+	Start time.Time
+	End   time.Time
+}
+
+// clip returns the part of the other interval inside this one, which is false if they don't overlap
+func (i Interval) clip(other Interval) (Interval, bool) {
+
+	if other.Start.Before(i.Start) {
+		other.Start = i.Start
+	}
+	if other.End.After(i.End) {
+		other.End = i.End
+	}
+
+	return other, other.End.After(other.Start)
+}
+
+// without returns how long the interval is once the merged excluded intervals are taken out
+func (i Interval) without(excluded []Interval) time.Duration {
+
+	d := i.End.Sub(i.Start)
+
+	for _, e := range excluded {
+		if overlap, ok := i.clip(e); ok {
+			d -= overlap.End.Sub(overlap.Start)
+		}
+	}
+
+	return d
+}
+
+// merge returns the intervals in order, with any that overlap combined
+func merge(intervals []Interval) []Interval {
+
+	if len(intervals) == 0 {
+		return nil
+	}
+
+	sorted := make([]Interval, len(intervals))
+	copy(sorted, intervals)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].Start.Before(sorted[b].Start) })
+
+	merged := []Interval{sorted[0]}
+	for _, i := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if i.Start.After(last.End) {
+			merged = append(merged, i)
+			continue
+		}
+		if i.End.After(last.End) {
+			last.End = i.End
+		}
+	}
+
+	return merged
+}
+
+// Window is a period to calculate availability over, ending now
+type Window struct {
+	// Name is how the window was configured, e.g. 24h, 7d or month
+	Name string
+
+	// length of the window, which is zero for the calendar month
+	length time.Duration
+}
+
+// Interval returns the interval the window covers, ending at the supplied time
+func (w Window) Interval(now time.Time) Interval {
+
+	if w.length == 0 {
+		now = now.UTC()
+		return Interval{Start: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), End: now}
+	}
+
+	return Interval{Start: now.Add(-w.length), End: now}
+}
+
+// longest is how far back a window can reach
+func (w Window) longest() time.Duration {
+	if w.length == 0 {
+		return 31 * 24 * time.Hour
+	}
+	return w.length
+}
+
+// ParseWindows parses a comma separated list of windows, each either a number of hours or days such as 24h or 30d,
+// or month for the calendar month so far
+func ParseWindows(s string) ([]Window, error) {
+
+	var windows []Window
+
+	for _, name := range strings.Split(s, ",") {
+
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		if name == Month {
+			windows = append(windows, Window{Name: name})
+			continue
+		}
+
+		unit := time.Hour
+		switch {
+		case strings.HasSuffix(name, "d"):
+			unit = 24 * time.Hour
+		case strings.HasSuffix(name, "h"):
+		default:
+			return nil, fmt.Errorf("invalid availability window %q: expected a number of hours or days such as 24h or 7d, or %s", name, Month)
+		}
+
+		n, err := strconv.Atoi(name[:len(name)-1])
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid availability window %q: expected a number of hours or days such as 24h or 7d, or %s", name, Month)
+		}
+
+		windows = append(windows, Window{Name: name, length: time.Duration(n) * unit})
+	}
+
+	return windows, nil
+}
@@ -0,0 +1,70 @@
+package sla
+
+import (
+	"testing"
+	"time"
+)
+
+var windowtests = []struct {
+	name   string
+	raw    string
+	names  []string
+	starts []time.Time
+	err    bool
+}{
+	{name: "Defaults", raw: "24h,7d,30d,month", names: []string{"24h", "7d", "30d", "month"},
+		starts: []time.Time{at(-24), at(-7 * 24), at(-30 * 24), time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)}},
+	{name: "Spaces and empty entries", raw: " 1h, ,2d ", names: []string{"1h", "2d"}, starts: []time.Time{at(-1), at(-48)}},
+	{name: "Nothing", raw: "", names: nil},
+	{name: "Minutes", raw: "30m", err: true},
+	{name: "Not a number", raw: "xd", err: true},
+	{name: "Zero", raw: "0h", err: true},
+}
+
+func TestParseWindows(t *testing.T) {
+
+	for _, tt := range windowtests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			windows, err := ParseWindows(tt.raw)
+
+			if tt.err {
+				if err == nil {
+					t.Errorf("Expected an error but got %v", windows)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+
+			if len(windows) != len(tt.names) {
+				t.Errorf("Expected windows %v but got %v", tt.names, windows)
+				return
+			}
+
+			for i, w := range windows {
+				interval := w.Interval(at(0))
+				if w.Name != tt.names[i] || !interval.Start.Equal(tt.starts[i]) || !interval.End.Equal(at(0)) {
+					t.Errorf("Expected window %s from %v but got %s from %v", tt.names[i], tt.starts[i], w.Name, interval.Start)
+				}
+			}
+
+		})
+	}
+}
+
+func TestCalendarMonth(t *testing.T) {
+
+	windows, _ := ParseWindows(Month)
+
+	// Part way through a month in another time zone the window should start at the beginning of the month in UTC
+	now := time.Date(2020, 4, 1, 1, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	interval := windows[0].Interval(now)
+
+	if want := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC); !interval.Start.Equal(want) {
+		t.Errorf("Expected the window to start at %v but got %v", want, interval.Start)
+	}
+}
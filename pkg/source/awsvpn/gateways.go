@@ -0,0 +1,230 @@
+package awsvpn
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/oklog/pkg/group"
+	"time"
+)
+
+// gatewayEnricher adds the last known details of the customer gateways and VPN gateways connections from AWS use.
+// Gateway details rarely change so are only refreshed from AWS at an interval, or when a gateway not seen before
+// turns up.
+type gatewayEnricher struct {
+	svc              ec2iface.EC2API
+	logger           log.Logger
+	customerGateways map[string]*ec2.CustomerGateway
+	vpnGateways      map[string]*ec2.VpnGateway
+
+	// The gateway IDs in use at the last refresh, so missing gateways don't cause a refresh on every poll
+	wanted map[string]bool
+}
+
+// gateways are the details of the gateways fetched from AWS by a refresh, or why they couldn't be
+type gateways struct {
+	customerGateways map[string]*ec2.CustomerGateway
+	vpnGateways      map[string]*ec2.VpnGateway
+	err              error
+}
+
+// NewGatewayEnricher returns an instance ready to use, which hasn't got any gateway details until it's refreshed
+func NewGatewayEnricher(logger log.Logger, svc ec2iface.EC2API) *gatewayEnricher {
+	return &gatewayEnricher{
+		svc:              svc,
+		logger:           log.With(logger, "actor", "gateways"),
+		customerGateways: make(map[string]*ec2.CustomerGateway),
+		vpnGateways:      make(map[string]*ec2.VpnGateway),
+		wanted:           make(map[string]bool),
+	}
+}
+
+// AddGatewayStage adds a stage to the run group that sends the connections it receives to the next stage with the
+// details of the gateways they use. The details are refreshed from AWS at the supplied interval, in the background so
+// polls aren't held up by them, and the connections are sent again as a repeat of their poll once they have been.
+func AddGatewayStage(group *group.Group, enricher *gatewayEnricher, in <-chan state.Poll, out chan<- state.Poll, interval *time.Duration) {
+
+	ticker := time.NewTicker(*interval)
+
+	a := gatewayActor(enricher, in, out, ticker.C)
+	group.Add(a.Execute, func(err error) {
+		ticker.Stop()
+		a.Interrupt(err)
+	})
+
+}
+
+// gatewayActor adds the known gateway details to the connections received before sending them down the out channel.
+// It refreshes the details every time the tick channel fires, or a connection uses a gateway not seen before, and
+// remembers the connections to enrich and send again as a repeat once the refresh is done.
+func gatewayActor(enricher *gatewayEnricher, in <-chan state.Poll, out chan<- state.Poll, tick <-chan time.Time) actor.Actor {
+
+	cancel := make(chan struct{})
+
+	return actor.NewActor(
+		func() error {
+
+			var connections []*vpn.Connection
+
+			// Only one refresh runs at a time, and its result is buffered so it can finish even if we've stopped
+			refreshed := make(chan gateways, 1)
+			refreshing := false
+			refresh := func() {
+				if refreshing {
+					return
+				}
+				refreshing = true
+				enricher.want(connections)
+				go func() { refreshed <- enricher.fetch() }()
+			}
+
+			for {
+				var poll state.Poll
+
+				select {
+
+				case poll = <-in:
+					connections = poll.Connections
+					if enricher.hasNewGateways(connections) {
+						refresh()
+					}
+
+				case <-tick:
+					refresh()
+					continue
+
+				case fetched := <-refreshed:
+					refreshing = false
+					if fetched.err != nil {
+						_ = level.Warn(enricher.logger).Log("msg", "Unable to refresh gateway details", "err", fetched.err)
+						continue
+					}
+					enricher.customerGateways = fetched.customerGateways
+					enricher.vpnGateways = fetched.vpnGateways
+					if connections == nil {
+						continue
+					}
+					poll = state.Poll{Connections: connections, Repeat: true}
+
+				case <-cancel:
+					_ = level.Info(enricher.logger).Log("cancelled", "Asked to terminate")
+					return nil
+				}
+
+				select {
+				case out <- poll.With(enricher.Enrich(poll.Connections)):
+				case <-cancel:
+					_ = level.Info(enricher.logger).Log("cancelled", "Asked to terminate")
+					return nil
+				}
+			}
+		},
+		func(err error) {
+			_ = level.Info(enricher.logger).Log("interrupted", fmt.Sprintf("interrupted with %v", err))
+			close(cancel)
+		},
+	)
+
+}
+
+// want notes the gateways the connections use as of a refresh. Even if the refresh fails we don't want to retry on
+// every poll.
+func (e *gatewayEnricher) want(connections []*vpn.Connection) {
+	e.wanted = make(map[string]bool)
+	for _, conn := range connections {
+		if d, ok := details(conn); ok {
+			e.wanted[aws.StringValue(d.CustomerGatewayId)] = true
+			e.wanted[aws.StringValue(d.VpnGatewayId)] = true
+		}
+	}
+}
+
+// hasNewGateways is true if any of the connections use a gateway that wasn't in use at the last refresh
+func (e *gatewayEnricher) hasNewGateways(connections []*vpn.Connection) bool {
+
+	for _, conn := range connections {
+		if d, ok := details(conn); ok {
+			if !e.wanted[aws.StringValue(d.CustomerGatewayId)] || !e.wanted[aws.StringValue(d.VpnGatewayId)] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// fetch loads the gateway details from AWS. It doesn't touch the enricher, so can run in the background.
+func (e *gatewayEnricher) fetch() gateways {
+
+	customerGateways, err := e.svc.DescribeCustomerGateways(&ec2.DescribeCustomerGatewaysInput{})
+	if err != nil {
+		return gateways{err: err}
+	}
+
+	vpnGateways, err := e.svc.DescribeVpnGateways(&ec2.DescribeVpnGatewaysInput{})
+	if err != nil {
+		return gateways{err: err}
+	}
+
+	fetched := gateways{
+		customerGateways: make(map[string]*ec2.CustomerGateway),
+		vpnGateways:      make(map[string]*ec2.VpnGateway),
+	}
+
+	for _, gateway := range customerGateways.CustomerGateways {
+		fetched.customerGateways[aws.StringValue(gateway.CustomerGatewayId)] = gateway
+	}
+
+	for _, gateway := range vpnGateways.VpnGateways {
+		fetched.vpnGateways[aws.StringValue(gateway.VpnGatewayId)] = gateway
+	}
+
+	return fetched
+}
+
+// Enrich returns copies of the connections from AWS with the known details of their gateways, in their raw details
+// and as attributes. Connections from other sources are returned as they are.
+func (e *gatewayEnricher) Enrich(connections []*vpn.Connection) []*vpn.Connection {
+
+	enriched := make([]*vpn.Connection, len(connections))
+
+	for i, conn := range connections {
+
+		d, ok := details(conn)
+		if !ok {
+			enriched[i] = conn
+			continue
+		}
+
+		withGateways := *d
+		withGateways.CustomerGateway = e.customerGateways[aws.StringValue(d.CustomerGatewayId)]
+		withGateways.VpnGateway = e.vpnGateways[aws.StringValue(d.VpnGatewayId)]
+
+		c := *conn
+		c.Raw = &withGateways
+		c.Attributes = make(map[string]string, len(conn.Attributes))
+		for name, value := range conn.Attributes {
+			c.Attributes[name] = value
+		}
+		addGatewayAttributes(attributeSetter(c.Attributes), &withGateways)
+
+		enriched[i] = &c
+	}
+
+	return enriched
+}
+
+// details returns the AWS details the connection was built from, if it came from AWS
+func details(conn *vpn.Connection) (*Details, bool) {
+	if conn.Source != SourceName {
+		return nil, false
+	}
+	d, ok := conn.Raw.(*Details)
+	return d, ok && d != nil && d.VpnConnection != nil
+}
@@ -0,0 +1,277 @@
+package awsvpn
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"testing"
+	"time"
+)
+
+func TestEnrich(t *testing.T) {
+
+	// Given the details of the gateways a connection uses are known
+	ec2Client := newMockEC2Client()
+	ec2Client.describeCustomerGateways = func(*ec2.DescribeCustomerGatewaysInput) (*ec2.DescribeCustomerGatewaysOutput, error) {
+		return &ec2.DescribeCustomerGatewaysOutput{
+			CustomerGateways: []*ec2.CustomerGateway{
+				{CustomerGatewayId: aws.String("cgw-1"), IpAddress: aws.String("9.9.9.9"), BgpAsn: aws.String("65000"), DeviceName: aws.String("router")},
+			},
+		}, nil
+	}
+	ec2Client.describeVpnGateways = func(*ec2.DescribeVpnGatewaysInput) (*ec2.DescribeVpnGatewaysOutput, error) {
+		return &ec2.DescribeVpnGatewaysOutput{
+			VpnGateways: []*ec2.VpnGateway{
+				{
+					VpnGatewayId:  aws.String("vgw-1"),
+					AmazonSideAsn: aws.Int64(64512),
+					VpcAttachments: []*ec2.VpcAttachment{
+						{VpcId: aws.String("vpc-1"), State: aws.String(ec2.AttachmentStatusAttached)},
+						{VpcId: aws.String("vpc-2"), State: aws.String(ec2.AttachmentStatusDetached)},
+					},
+				},
+			},
+		}, nil
+	}
+
+	underTest := NewGatewayEnricher(log.NewNopLogger(), ec2Client)
+	fetched := underTest.fetch()
+	if fetched.err != nil {
+		t.Fatalf("Unexpected error fetching: %v", fetched.err)
+	}
+	underTest.customerGateways, underTest.vpnGateways = fetched.customerGateways, fetched.vpnGateways
+
+	other := &vpn.Connection{ID: "wg0", Source: "wireguard"}
+	sent := []*vpn.Connection{connectionUsing("cgw-1", "vgw-1"), other}
+
+	// When the connections are enriched
+	enriched := underTest.Enrich(sent)
+
+	// Then the gateway details should be added to a copy of the connection from AWS
+	expectedAttributes := map[string]string{
+		"customer_gateway_id":          "cgw-1",
+		"customer_gateway_ip":          "9.9.9.9",
+		"customer_gateway_bgp_asn":     "65000",
+		"customer_gateway_device_name": "router",
+		vpn.AttrVpnGatewayID:           "vgw-1",
+		"vpn_gateway_amazon_side_asn":  "64512",
+		"vpn_gateway_vpc_ids":          "vpc-1",
+	}
+
+	for name, value := range expectedAttributes {
+		if enriched[0].Attribute(name) != value {
+			t.Errorf("Attribute %s incorrect. Expected `%s` but got `%s`", name, value, enriched[0].Attribute(name))
+		}
+	}
+
+	if details, ok := enriched[0].Raw.(*Details); !ok || details.CustomerGateway == nil || details.VpnGateway == nil {
+		t.Errorf("Raw details should include the gateways. Got %v", enriched[0].Raw)
+	}
+
+	if enriched[0] == sent[0] || sent[0].Attribute("customer_gateway_ip") != "" || sent[0].Raw.(*Details).CustomerGateway != nil {
+		t.Errorf("Connections should have been copied, not changed")
+	}
+
+	// and connections from other sources left as they are
+	if enriched[1] != other {
+		t.Errorf("Expected the connection from another source to be passed on as it is, got %+v", enriched[1])
+	}
+}
+
+func TestGatewaysRefreshed(t *testing.T) {
+
+	// Given a stage that adds the details of gateways
+	ec2Client := newMockEC2Client()
+	ec2Client.describeCustomerGateways = describeCustomerGatewaysWith("cgw-1", "cgw-2")
+
+	calls := 0
+	ec2Client.describeVpnGateways = func(input *ec2.DescribeVpnGatewaysInput) (*ec2.DescribeVpnGatewaysOutput, error) {
+		calls++
+		return describeVpnGatewaysWith("vgw-1")(input)
+	}
+
+	in := make(chan state.Poll)
+	out := make(chan state.Poll)
+	tick := make(chan time.Time)
+
+	underTest := gatewayActor(NewGatewayEnricher(log.NewNopLogger(), ec2Client), in, out, tick)
+	defer underTest.Interrupt(nil)
+	go func(a actor.Actor) {
+		_ = a.Execute()
+	}(underTest)
+
+	receive := func(repeat bool, customerGatewayIP bool) {
+		t.Helper()
+		select {
+		case received := <-out:
+			if received.Repeat != repeat {
+				t.Errorf("Expected a repeat %v, got %+v", repeat, received)
+			}
+			if d := received.Connections[0].Raw.(*Details); (d.CustomerGateway != nil) != customerGatewayIP {
+				t.Errorf("Expected the customer gateway to be known %v, got %v", customerGatewayIP, d)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatal("Timed out waiting for the connections")
+		}
+	}
+
+	// When connections using gateways not seen before are polled
+	go func() { in <- state.Poll{Connections: []*vpn.Connection{connectionUsing("cgw-1", "vgw-1")}} }()
+
+	// Then they're passed on straight away, and again as a repeat once the gateways have been loaded
+	receive(false, false)
+	receive(true, true)
+	if calls != 1 {
+		t.Errorf("Gateways should have been loaded once, but were loaded %d times", calls)
+	}
+
+	// When the same gateways are polled again they're passed on with the details already loaded
+	go func() { in <- state.Poll{Connections: []*vpn.Connection{connectionUsing("cgw-1", "vgw-1")}} }()
+	receive(false, true)
+
+	// When a new gateway turns up the details are loaded again, even if AWS doesn't know it yet
+	go func() { in <- state.Poll{Connections: []*vpn.Connection{connectionUsing("cgw-3", "vgw-1")}} }()
+	receive(false, false)
+	receive(true, false)
+	if calls != 2 {
+		t.Errorf("Gateways should have been reloaded for an unknown gateway, but were loaded %d times", calls)
+	}
+
+	// When the interval passes they are loaded again
+	go func() { tick <- time.Now() }()
+	receive(true, false)
+	if calls != 3 {
+		t.Errorf("Gateways should have been reloaded after the interval, but were loaded %d times", calls)
+	}
+}
+
+func TestNewGateways(t *testing.T) {
+
+	// Given the gateways of a connection were wanted at the last refresh
+	underTest := NewGatewayEnricher(log.NewNopLogger(), newMockEC2Client())
+	underTest.want([]*vpn.Connection{connectionUsing("cgw-1", "vgw-1")})
+
+	// Then the same gateways aren't new, even if AWS didn't know them
+	if underTest.hasNewGateways([]*vpn.Connection{connectionUsing("cgw-1", "vgw-1"), {ID: "wg0", Source: "wireguard"}}) {
+		t.Error("Expected the gateways wanted at the last refresh not to be new")
+	}
+
+	// but others are
+	if !underTest.hasNewGateways([]*vpn.Connection{connectionUsing("cgw-3", "vgw-1")}) {
+		t.Error("Expected a gateway not wanted at the last refresh to be new")
+	}
+}
+
+func TestGatewaysUnavailable(t *testing.T) {
+
+	// Given an ec2 client that isn't allowed to describe gateways
+	ec2Client := newMockEC2Client()
+	ec2Client.describeCustomerGateways = func(*ec2.DescribeCustomerGatewaysInput) (*ec2.DescribeCustomerGatewaysOutput, error) {
+		return nil, errors.New("access denied")
+	}
+
+	in := make(chan state.Poll)
+	out := make(chan state.Poll)
+	tick := make(chan time.Time)
+
+	underTest := gatewayActor(NewGatewayEnricher(log.NewNopLogger(), ec2Client), in, out, tick)
+	defer underTest.Interrupt(nil)
+	go func(a actor.Actor) {
+		_ = a.Execute()
+	}(underTest)
+
+	// When connections are polled
+	go func() { in <- state.Poll{Connections: []*vpn.Connection{connectionUsing("cgw-1", "vgw-1")}} }()
+
+	// Then they're still passed on, without the gateway details
+	select {
+	case received := <-out:
+		if len(received.Connections) != 1 || received.Connections[0].Attribute("customer_gateway_ip") != "" {
+			t.Errorf("Expected 1 connection without gateway details, but got %v", received.Connections)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timed out waiting for the connections")
+	}
+
+	// and not again, as there's nothing new to send
+	go func() { tick <- time.Now() }()
+	select {
+	case received := <-out:
+		t.Errorf("Expected nothing to be sent after failing to refresh, got %+v", received)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+var interruptests = []struct {
+	name  string
+	actor actor.Actor
+}{
+	{name: "Gateways", actor: gatewayActor(NewGatewayEnricher(log.NewNopLogger(), newMockEC2Client()), make(chan state.Poll), make(chan state.Poll), make(chan time.Time))},
+}
+
+// Tests that the actors honour the contract as per https://github.com/oklog/run#run.
+// When the interrupt function is called the actor should return
+func TestInterrupt(t *testing.T) {
+
+	for _, tt := range interruptests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			underTest := tt.actor
+
+			// Run the actor.
+			errors := make(chan error)
+			go func(a actor.Actor) {
+				errors <- a.Execute()
+			}(underTest)
+
+			// Signal for the actor to stop
+			underTest.Interrupt(nil)
+
+			select {
+			case <-errors:
+				return
+			case <-time.After(1 * time.Second):
+			}
+
+			t.Error("actor didn't shut down in response to interrupt")
+
+		})
+	}
+}
+
+// connectionUsing returns a connection from AWS using the gateways, as the source would send it
+func connectionUsing(customerGatewayId string, vpnGatewayId string) *vpn.Connection {
+	return toConnection(&Details{VpnConnection: &ec2.VpnConnection{
+		VpnConnectionId:   aws.String("vpn-1"),
+		CustomerGatewayId: aws.String(customerGatewayId),
+		VpnGatewayId:      aws.String(vpnGatewayId),
+	}})
+}
+
+func describeCustomerGatewaysWith(ids ...string) func(*ec2.DescribeCustomerGatewaysInput) (*ec2.DescribeCustomerGatewaysOutput, error) {
+
+	var gateways []*ec2.CustomerGateway
+	for _, id := range ids {
+		gateways = append(gateways, &ec2.CustomerGateway{CustomerGatewayId: aws.String(id)})
+	}
+
+	return func(*ec2.DescribeCustomerGatewaysInput) (*ec2.DescribeCustomerGatewaysOutput, error) {
+		return &ec2.DescribeCustomerGatewaysOutput{CustomerGateways: gateways}, nil
+	}
+}
+
+func describeVpnGatewaysWith(ids ...string) func(*ec2.DescribeVpnGatewaysInput) (*ec2.DescribeVpnGatewaysOutput, error) {
+
+	var gateways []*ec2.VpnGateway
+	for _, id := range ids {
+		gateways = append(gateways, &ec2.VpnGateway{VpnGatewayId: aws.String(id)})
+	}
+
+	return func(*ec2.DescribeVpnGatewaysInput) (*ec2.DescribeVpnGatewaysOutput, error) {
+		return &ec2.DescribeVpnGatewaysOutput{VpnGateways: gateways}, nil
+	}
+}
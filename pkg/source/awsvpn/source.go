@@ -0,0 +1,322 @@
+package awsvpn
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"strings"
+)
+
+// SourceName is the source connections from AWS are reported as
+const SourceName = "aws"
+
+// Details are the AWS resources a connection was built from
+type Details struct {
+	*ec2.VpnConnection
+
+	// TransitGatewayAttachment is only set for connections that terminate on a Transit Gateway
+	TransitGatewayAttachment *ec2.TransitGatewayAttachment
+
+	// CustomerGateway is the customer side of the connection
+	CustomerGateway *ec2.CustomerGateway
+
+	// VpnGateway is only set for connections that terminate on a VPN Gateway
+	VpnGateway *ec2.VpnGateway
+}
+
+// String returns the string representation
+func (d Details) String() string {
+	return awsutil.Prettify(d)
+}
+
+// source fetches AWS site to site VPN connections
+type source struct {
+	svc     ec2iface.EC2API
+	account string
+	region  string
+	logger  log.Logger
+}
+
+// NewSource returns a source of the AWS site to site VPN connections in the account and region, which connections
+// are labelled with unless they are empty. The details of the gateways connections use are left to the gateway stage.
+func NewSource(logger log.Logger, svc ec2iface.EC2API, account string, region string) vpn.Source {
+	return &source{
+		svc:     svc,
+		account: account,
+		region:  region,
+		logger:  log.With(logger, "source", SourceName),
+	}
+}
+
+// AccountID returns the ID of the AWS account the credentials belong to. Any credentials can look this up, without
+// needing any permissions.
+func AccountID(svc stsiface.STSAPI) (string, error) {
+
+	identity, err := svc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(identity.Account), nil
+}
+
+// Connections returns the current state of the VPN connections
+func (s *source) Connections() ([]*vpn.Connection, error) {
+
+	result, err := s.svc.DescribeVpnConnections(&ec2.DescribeVpnConnectionsInput{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	details := make([]*Details, len(result.VpnConnections))
+	for i, vpnConnection := range result.VpnConnections {
+		details[i] = &Details{VpnConnection: vpnConnection}
+	}
+
+	// Attachment details are nice to have, so don't give up if we can't get them
+	if err := addTransitGatewayAttachments(s.svc, details); err != nil {
+		_ = level.Warn(s.logger).Log("msg", "Unable to describe transit gateway attachments", "err", err)
+	}
+
+	connections := make([]*vpn.Connection, len(details))
+	for i, d := range details {
+		connections[i] = toConnection(d)
+
+		attributes := attributeSetter(connections[i].Attributes)
+		attributes.set(vpn.AttrAccountID, s.account)
+		attributes.set(vpn.AttrRegion, s.region)
+	}
+
+	return connections, nil
+}
+
+// addTransitGatewayAttachments looks up the Transit Gateway attachments for any connections that terminate on a
+// Transit Gateway, and adds them to the matching connection
+func addTransitGatewayAttachments(svc ec2iface.EC2API, connections []*Details) error {
+
+	byID := make(map[string]*Details)
+	var ids []*string
+
+	for _, conn := range connections {
+		if aws.StringValue(conn.TransitGatewayId) == "" {
+			continue
+		}
+		byID[aws.StringValue(conn.VpnConnectionId)] = conn
+		ids = append(ids, conn.VpnConnectionId)
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	input := &ec2.DescribeTransitGatewayAttachmentsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("resource-type"), Values: aws.StringSlice([]string{ec2.TransitGatewayAttachmentResourceTypeVpn})},
+			{Name: aws.String("resource-id"), Values: ids},
+		},
+	}
+
+	for {
+		result, err := svc.DescribeTransitGatewayAttachments(input)
+
+		if err != nil {
+			return err
+		}
+
+		for _, attachment := range result.TransitGatewayAttachments {
+			if conn, ok := byID[aws.StringValue(attachment.ResourceId)]; ok {
+				conn.TransitGatewayAttachment = attachment
+			}
+		}
+
+		if aws.StringValue(result.NextToken) == "" {
+			return nil
+		}
+
+		input.NextToken = result.NextToken
+	}
+
+}
+
+// toConnection maps the AWS details of a connection into the provider neutral model
+func toConnection(d *Details) *vpn.Connection {
+
+	conn := &vpn.Connection{
+		ID:         aws.StringValue(d.VpnConnectionId),
+		Name:       connectionName(d.Tags),
+		Source:     SourceName,
+		Tags:       make(map[string]string),
+		Attributes: make(map[string]string),
+		Tunnels:    make([]*vpn.Tunnel, 0, len(d.VgwTelemetry)),
+		Raw:        d,
+	}
+
+	for _, tag := range d.Tags {
+		conn.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+
+	attributes := attributeSetter(conn.Attributes)
+	attributes.set("state", aws.StringValue(d.State))
+	attributes.set("type", aws.StringValue(d.Type))
+	attributes.set(vpn.AttrVpnGatewayID, aws.StringValue(d.VpnGatewayId))
+	attributes.set(vpn.AttrTransitGatewayID, aws.StringValue(d.TransitGatewayId))
+	attributes.set("customer_gateway_id", aws.StringValue(d.CustomerGatewayId))
+
+	if a := d.TransitGatewayAttachment; a != nil {
+		attributes.set("transit_gateway_attachment_id", aws.StringValue(a.TransitGatewayAttachmentId))
+		attributes.set("transit_gateway_attachment_state", aws.StringValue(a.State))
+		if a.Association != nil {
+			attributes.set("transit_gateway_route_table_id", aws.StringValue(a.Association.TransitGatewayRouteTableId))
+			attributes.set("transit_gateway_association_state", aws.StringValue(a.Association.State))
+		}
+	}
+
+	addGatewayAttributes(attributes, d)
+
+	options := make(map[string]*ec2.TunnelOption)
+	if o := d.Options; o != nil {
+		if o.StaticRoutesOnly != nil {
+			attributes.set("static_routes_only", fmt.Sprintf("%t", *o.StaticRoutesOnly))
+		}
+		if o.EnableAcceleration != nil {
+			attributes.set("acceleration_enabled", fmt.Sprintf("%t", *o.EnableAcceleration))
+		}
+		for _, option := range o.TunnelOptions {
+			options[aws.StringValue(option.OutsideIpAddress)] = option
+		}
+	}
+
+	for _, route := range d.Routes {
+		conn.Routes = append(conn.Routes, &vpn.Route{
+			Destination: aws.StringValue(route.DestinationCidrBlock),
+			State:       aws.StringValue(route.State),
+			Origin:      aws.StringValue(route.Source),
+		})
+	}
+
+	for _, telemetry := range d.VgwTelemetry {
+		conn.Tunnels = append(conn.Tunnels, toTunnel(telemetry, options[aws.StringValue(telemetry.OutsideIpAddress)]))
+	}
+
+	return conn
+}
+
+// addGatewayAttributes sets the details of the customer gateway and VPN gateway of the connection as attributes, if
+// they're known
+func addGatewayAttributes(attributes attributeSetter, d *Details) {
+
+	if g := d.CustomerGateway; g != nil {
+		attributes.set("customer_gateway_ip", aws.StringValue(g.IpAddress))
+		attributes.set("customer_gateway_bgp_asn", aws.StringValue(g.BgpAsn))
+		attributes.set("customer_gateway_device_name", aws.StringValue(g.DeviceName))
+	}
+
+	if g := d.VpnGateway; g != nil {
+		if g.AmazonSideAsn != nil {
+			attributes.set("vpn_gateway_amazon_side_asn", fmt.Sprintf("%d", *g.AmazonSideAsn))
+		}
+
+		var vpcs []string
+		for _, attachment := range g.VpcAttachments {
+			if aws.StringValue(attachment.State) == ec2.AttachmentStatusAttached {
+				vpcs = append(vpcs, aws.StringValue(attachment.VpcId))
+			}
+		}
+		attributes.set("vpn_gateway_vpc_ids", strings.Join(vpcs, ","))
+	}
+}
+
+// toTunnel maps the AWS telemetry of a tunnel and its options, which can be nil, into the provider neutral model
+func toTunnel(telemetry *ec2.VgwTelemetry, options *ec2.TunnelOption) *vpn.Tunnel {
+
+	tunnel := &vpn.Tunnel{
+		ID:               aws.StringValue(telemetry.OutsideIpAddress),
+		OutsideIP:        aws.StringValue(telemetry.OutsideIpAddress),
+		Status:           vpn.StatusUnknown,
+		StatusMessage:    aws.StringValue(telemetry.StatusMessage),
+		LastStatusChange: aws.TimeValue(telemetry.LastStatusChange),
+		Attributes:       make(map[string]string),
+	}
+
+	switch aws.StringValue(telemetry.Status) {
+	case ec2.TelemetryStatusUp:
+		tunnel.Status = vpn.StatusUp
+	case ec2.TelemetryStatusDown:
+		tunnel.Status = vpn.StatusDown
+	}
+
+	if telemetry.AcceptedRouteCount != nil {
+		tunnel.Attributes["accepted_route_count"] = fmt.Sprintf("%d", *telemetry.AcceptedRouteCount)
+	}
+
+	if options != nil {
+		addTunnelOptions(attributeSetter(tunnel.Attributes), options)
+	}
+
+	return tunnel
+}
+
+// addTunnelOptions sets the IKE and IPsec options of the tunnel as attributes. The pre-shared key is left out, as
+// attributes are shown to anyone who can see the pages.
+func addTunnelOptions(attributes attributeSetter, o *ec2.TunnelOption) {
+
+	attributes.set("inside_cidr", aws.StringValue(o.TunnelInsideCidr))
+
+	var ikeVersions []string
+	for _, v := range o.IkeVersions {
+		ikeVersions = append(ikeVersions, aws.StringValue(v.Value))
+	}
+	attributes.set("ike_versions", strings.Join(ikeVersions, ","))
+
+	for name, value := range map[string]*int64{
+		"dpd_timeout_seconds":        o.DpdTimeoutSeconds,
+		"phase1_lifetime_seconds":    o.Phase1LifetimeSeconds,
+		"phase2_lifetime_seconds":    o.Phase2LifetimeSeconds,
+		"rekey_margin_time_seconds":  o.RekeyMarginTimeSeconds,
+		"rekey_fuzz_percentage":      o.RekeyFuzzPercentage,
+		"replay_window_size_packets": o.ReplayWindowSize,
+	} {
+		if value != nil {
+			attributes.set(name, fmt.Sprintf("%d", *value))
+		}
+	}
+
+	var phase1Encryption, phase2Encryption []string
+	for _, v := range o.Phase1EncryptionAlgorithms {
+		phase1Encryption = append(phase1Encryption, aws.StringValue(v.Value))
+	}
+	for _, v := range o.Phase2EncryptionAlgorithms {
+		phase2Encryption = append(phase2Encryption, aws.StringValue(v.Value))
+	}
+	attributes.set("phase1_encryption_algorithms", strings.Join(phase1Encryption, ","))
+	attributes.set("phase2_encryption_algorithms", strings.Join(phase2Encryption, ","))
+}
+
+// connectionName returns the value of the name tag, whatever case it's in
+func connectionName(tags []*ec2.Tag) string {
+
+	for _, tag := range tags {
+		if strings.ToLower(aws.StringValue(tag.Key)) == "name" {
+			return aws.StringValue(tag.Value)
+		}
+	}
+
+	return ""
+}
+
+// attributeSetter only sets attributes that have a value
+type attributeSetter map[string]string
+
+func (a attributeSetter) set(name string, value string) {
+	if value != "" {
+		a[name] = value
+	}
+}
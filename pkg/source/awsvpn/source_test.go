@@ -0,0 +1,374 @@
+package awsvpn
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+	"time"
+)
+
+var fixedTime = time.Date(2009, 11, 17, 20, 34, 58, 0, time.UTC)
+
+func TestConnections(t *testing.T) {
+
+	// Given a correctly configured ec2 client
+	ec2Client := newMockEC2Client()
+	ec2Client.describeVpnConnections = func(*ec2.DescribeVpnConnectionsInput) (*ec2.DescribeVpnConnectionsOutput, error) {
+		return &ec2.DescribeVpnConnectionsOutput{
+			VpnConnections: []*ec2.VpnConnection{
+				{
+					VpnConnectionId:   aws.String("vpn-1"),
+					VpnGatewayId:      aws.String("vgw-1"),
+					CustomerGatewayId: aws.String("cgw-1"),
+					Tags:              []*ec2.Tag{asTag("Name", "head office"), asTag("Team", "payments")},
+					VgwTelemetry: []*ec2.VgwTelemetry{
+						{OutsideIpAddress: aws.String("1.2.3.4"), Status: aws.String(ec2.TelemetryStatusUp), LastStatusChange: aws.Time(fixedTime)},
+						{OutsideIpAddress: aws.String("5.6.7.8"), Status: aws.String(ec2.TelemetryStatusDown), StatusMessage: aws.String("IPSEC IS DOWN")},
+					},
+				},
+			},
+		}, nil
+	}
+	underTest := NewSource(log.NewNopLogger(), ec2Client, "123456789012", "eu-west-1")
+
+	// When the connections are fetched
+	connections, err := underTest.Connections()
+
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	// Then they should be mapped to the provider neutral model
+	if len(connections) != 1 {
+		t.Errorf("Expected 1 connection, but got %d", len(connections))
+		return
+	}
+
+	conn := connections[0]
+
+	if conn.ID != "vpn-1" || conn.Name != "head office" || conn.Source != SourceName {
+		t.Errorf("Connection incorrect. Got ID `%s`, name `%s`, source `%s`", conn.ID, conn.Name, conn.Source)
+	}
+
+	if conn.Tags["Team"] != "payments" {
+		t.Errorf("Tags incorrect. Expected Team to be `payments` but got %v", conn.Tags)
+	}
+
+	expectedAttributes := map[string]string{
+		"customer_gateway_id": "cgw-1",
+		vpn.AttrVpnGatewayID:  "vgw-1",
+		vpn.AttrAccountID:     "123456789012",
+		vpn.AttrRegion:        "eu-west-1",
+	}
+
+	for name, value := range expectedAttributes {
+		if conn.Attribute(name) != value {
+			t.Errorf("Attribute %s incorrect. Expected `%s` but got `%s`", name, value, conn.Attribute(name))
+		}
+	}
+
+	if len(conn.Tunnels) != 2 {
+		t.Errorf("Expected 2 tunnels, but got %d", len(conn.Tunnels))
+		return
+	}
+
+	if up := conn.Tunnels[0]; up.ID != "1.2.3.4" || up.OutsideIP != "1.2.3.4" || up.Status != vpn.StatusUp || !up.LastStatusChange.Equal(fixedTime) {
+		t.Errorf("First tunnel incorrect. Got %+v", up)
+	}
+
+	if down := conn.Tunnels[1]; down.OutsideIP != "5.6.7.8" || down.Status != vpn.StatusDown || down.StatusMessage != "IPSEC IS DOWN" {
+		t.Errorf("Second tunnel incorrect. Got %+v", down)
+	}
+
+	// and the gateway details left to the gateway stage
+	if details, ok := conn.Raw.(*Details); !ok || details.CustomerGateway != nil || details.VpnGateway != nil {
+		t.Errorf("Raw details should be included without the gateways. Got %v", conn.Raw)
+	}
+
+}
+
+func TestConnectionsErrorHandling(t *testing.T) {
+
+	// Given an incorrectly configured ec2 client
+	ec2Client := newMockEC2Client()
+	expectedError := errors.New("test error")
+	ec2Client.describeVpnConnections = func(*ec2.DescribeVpnConnectionsInput) (*ec2.DescribeVpnConnectionsOutput, error) {
+		return nil, expectedError
+	}
+
+	underTest := NewSource(log.NewNopLogger(), ec2Client, "", "")
+
+	// When the connections are fetched
+	_, err := underTest.Connections()
+
+	// Then the error is returned
+	if err != expectedError {
+		t.Errorf("Expected an error `%v` but got `%v`", expectedError, err)
+	}
+
+}
+
+func TestTransitGatewayAttachments(t *testing.T) {
+
+	// Given an ec2 client that knows about a VPN connection attached to a transit gateway
+	ec2Client := newMockEC2Client()
+	ec2Client.describeVpnConnections = func(*ec2.DescribeVpnConnectionsInput) (*ec2.DescribeVpnConnectionsOutput, error) {
+		return &ec2.DescribeVpnConnectionsOutput{
+			VpnConnections: []*ec2.VpnConnection{
+				{VpnConnectionId: aws.String("vpn-1"), TransitGatewayId: aws.String("tgw-1")},
+			},
+		}, nil
+	}
+
+	// with the attachment details split over two pages
+	ec2Client.describeTransitGatewayAttachments = func(input *ec2.DescribeTransitGatewayAttachmentsInput) (*ec2.DescribeTransitGatewayAttachmentsOutput, error) {
+		if input.NextToken == nil {
+			return &ec2.DescribeTransitGatewayAttachmentsOutput{
+				TransitGatewayAttachments: []*ec2.TransitGatewayAttachment{
+					{ResourceId: aws.String("vpn-unrelated"), TransitGatewayAttachmentId: aws.String("tgw-attach-0")},
+				},
+				NextToken: aws.String("page-2"),
+			}, nil
+		}
+		return &ec2.DescribeTransitGatewayAttachmentsOutput{
+			TransitGatewayAttachments: []*ec2.TransitGatewayAttachment{
+				{
+					ResourceId:                 aws.String("vpn-1"),
+					TransitGatewayAttachmentId: aws.String("tgw-attach-1"),
+					State:                      aws.String(ec2.TransitGatewayAttachmentStateAvailable),
+					Association:                &ec2.TransitGatewayAttachmentAssociation{TransitGatewayRouteTableId: aws.String("tgw-rtb-1")},
+				},
+			},
+		}, nil
+	}
+
+	underTest := NewSource(log.NewNopLogger(), ec2Client, "", "")
+
+	// When the connections are fetched
+	connections, err := underTest.Connections()
+
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	// Then the connection should have its attachment details
+	expectedAttributes := map[string]string{
+		vpn.AttrTransitGatewayID:           "tgw-1",
+		"transit_gateway_attachment_id":    "tgw-attach-1",
+		"transit_gateway_attachment_state": "available",
+		"transit_gateway_route_table_id":   "tgw-rtb-1",
+	}
+
+	for name, value := range expectedAttributes {
+		if connections[0].Attribute(name) != value {
+			t.Errorf("Attribute %s incorrect. Expected `%s` but got `%s`", name, value, connections[0].Attribute(name))
+		}
+	}
+
+	if connections[0].Attribute(vpn.AttrVpnGatewayID) != "" {
+		t.Errorf("Connections attached to a transit gateway shouldn't have a VPN gateway. Got `%s`", connections[0].Attribute(vpn.AttrVpnGatewayID))
+	}
+
+}
+
+func TestTransitGatewayAttachmentsUnavailable(t *testing.T) {
+
+	// Given an ec2 client that isn't allowed to describe transit gateway attachments
+	ec2Client := newMockEC2Client()
+	ec2Client.describeVpnConnections = func(*ec2.DescribeVpnConnectionsInput) (*ec2.DescribeVpnConnectionsOutput, error) {
+		return &ec2.DescribeVpnConnectionsOutput{
+			VpnConnections: []*ec2.VpnConnection{
+				{VpnConnectionId: aws.String("vpn-1"), TransitGatewayId: aws.String("tgw-1")},
+			},
+		}, nil
+	}
+	ec2Client.describeTransitGatewayAttachments = func(*ec2.DescribeTransitGatewayAttachmentsInput) (*ec2.DescribeTransitGatewayAttachmentsOutput, error) {
+		return nil, errors.New("access denied")
+	}
+	underTest := NewSource(log.NewNopLogger(), ec2Client, "", "")
+
+	// When the connections are fetched
+	connections, err := underTest.Connections()
+
+	// Then the connection should still be returned, without any attachment details
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	if len(connections) != 1 || connections[0].Attribute("transit_gateway_attachment_id") != "" {
+		t.Errorf("Expected 1 connection without attachment details, but got %v", connections)
+	}
+
+}
+
+var nametests = []struct {
+	name  string
+	tags  []*ec2.Tag
+	truth string
+}{
+	{name: "Pascal case", tags: []*ec2.Tag{asTag("Name", "blah")}, truth: "blah"},
+	{name: "No tags", tags: []*ec2.Tag{}, truth: ""},
+	{name: "No name tag", tags: []*ec2.Tag{asTag("foo", "bar")}, truth: ""},
+	{name: "Lower case", tags: []*ec2.Tag{asTag("name", "bar")}, truth: "bar"},
+}
+
+func TestConnectionName(t *testing.T) {
+
+	for _, tt := range nametests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			if name := connectionName(tt.tags); name != tt.truth {
+				t.Errorf("want %s; got %s", tt.truth, name)
+			}
+
+		})
+	}
+}
+
+func TestTunnelOptionsAndRoutes(t *testing.T) {
+
+	// Given a connection with tunnel options and static routes
+	details := &Details{VpnConnection: &ec2.VpnConnection{
+		VpnConnectionId: aws.String("vpn-1"),
+		Options: &ec2.VpnConnectionOptions{
+			StaticRoutesOnly: aws.Bool(true),
+			TunnelOptions: []*ec2.TunnelOption{
+				{
+					OutsideIpAddress:           aws.String("5.6.7.8"),
+					TunnelInsideCidr:           aws.String("169.254.10.0/30"),
+					IkeVersions:                []*ec2.IKEVersionsListValue{{Value: aws.String("ikev1")}, {Value: aws.String("ikev2")}},
+					DpdTimeoutSeconds:          aws.Int64(30),
+					Phase1EncryptionAlgorithms: []*ec2.Phase1EncryptionAlgorithmsListValue{{Value: aws.String("AES256")}},
+					PreSharedKey:               aws.String("secret"),
+				},
+			},
+		},
+		Routes: []*ec2.VpnStaticRoute{
+			{DestinationCidrBlock: aws.String("10.0.0.0/16"), State: aws.String(ec2.VpnStateAvailable), Source: aws.String(ec2.VpnStaticRouteSourceStatic)},
+			{DestinationCidrBlock: aws.String("10.1.0.0/16"), State: aws.String(ec2.VpnStatePending)},
+		},
+		VgwTelemetry: []*ec2.VgwTelemetry{
+			{OutsideIpAddress: aws.String("1.2.3.4")},
+			{OutsideIpAddress: aws.String("5.6.7.8")},
+		},
+	}}
+
+	// When it's mapped
+	conn := toConnection(details)
+
+	// Then the options are attributes of the tunnel they are for
+	if conn.Attribute("static_routes_only") != "true" {
+		t.Errorf("want static routes only; got %v", conn.Attributes)
+	}
+
+	if len(conn.Tunnels[0].Attributes) != 0 {
+		t.Errorf("want no options for the first tunnel; got %v", conn.Tunnels[0].Attributes)
+	}
+
+	want := map[string]string{
+		"inside_cidr":                  "169.254.10.0/30",
+		"ike_versions":                 "ikev1,ikev2",
+		"dpd_timeout_seconds":          "30",
+		"phase1_encryption_algorithms": "AES256",
+	}
+	if diff := cmp.Diff(want, conn.Tunnels[1].Attributes); diff != "" {
+		t.Errorf("Tunnel options incorrect, without the pre-shared key (-want +got):\n%s", diff)
+	}
+
+	// And the routes are mapped with their states
+	wantRoutes := []*vpn.Route{
+		{Destination: "10.0.0.0/16", State: "available", Origin: "Static"},
+		{Destination: "10.1.0.0/16", State: "pending"},
+	}
+	if diff := cmp.Diff(wantRoutes, conn.Routes); diff != "" {
+		t.Errorf("Routes incorrect (-want +got):\n%s", diff)
+	}
+}
+
+func TestAccountID(t *testing.T) {
+
+	// Given credentials for an account
+	svc := &mockSTSClient{account: "123456789012"}
+
+	// When the account is looked up
+	account, err := AccountID(svc)
+
+	// Then it's the account of the credentials
+	if err != nil || account != "123456789012" {
+		t.Errorf("want account 123456789012; got %q, %v", account, err)
+	}
+
+	// Unless it can't be looked up
+	svc.err = errors.New("no credentials")
+	if _, err := AccountID(svc); err == nil {
+		t.Error("want an error when the account can't be looked up")
+	}
+}
+
+func asTag(k string, v string) *ec2.Tag {
+	return &ec2.Tag{Key: aws.String(k), Value: aws.String(v)}
+}
+
+type mockSTSClient struct {
+	stsiface.STSAPI
+	account string
+	err     error
+}
+
+func (m *mockSTSClient) GetCallerIdentity(*sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &sts.GetCallerIdentityOutput{Account: aws.String(m.account)}, nil
+}
+
+type mockEC2Client struct {
+	ec2iface.EC2API
+	describeVpnConnections            func(*ec2.DescribeVpnConnectionsInput) (*ec2.DescribeVpnConnectionsOutput, error)
+	describeTransitGatewayAttachments func(*ec2.DescribeTransitGatewayAttachmentsInput) (*ec2.DescribeTransitGatewayAttachmentsOutput, error)
+	describeCustomerGateways          func(*ec2.DescribeCustomerGatewaysInput) (*ec2.DescribeCustomerGatewaysOutput, error)
+	describeVpnGateways               func(*ec2.DescribeVpnGatewaysInput) (*ec2.DescribeVpnGatewaysOutput, error)
+}
+
+func (m *mockEC2Client) DescribeVpnConnections(input *ec2.DescribeVpnConnectionsInput) (*ec2.DescribeVpnConnectionsOutput, error) {
+	return m.describeVpnConnections(input)
+}
+
+func (m *mockEC2Client) DescribeTransitGatewayAttachments(input *ec2.DescribeTransitGatewayAttachmentsInput) (*ec2.DescribeTransitGatewayAttachmentsOutput, error) {
+	return m.describeTransitGatewayAttachments(input)
+}
+
+func (m *mockEC2Client) DescribeCustomerGateways(input *ec2.DescribeCustomerGatewaysInput) (*ec2.DescribeCustomerGatewaysOutput, error) {
+	return m.describeCustomerGateways(input)
+}
+
+func (m *mockEC2Client) DescribeVpnGateways(input *ec2.DescribeVpnGatewaysInput) (*ec2.DescribeVpnGatewaysOutput, error) {
+	return m.describeVpnGateways(input)
+}
+
+func newMockEC2Client() *mockEC2Client {
+	return &mockEC2Client{
+		describeVpnConnections: func(*ec2.DescribeVpnConnectionsInput) (*ec2.DescribeVpnConnectionsOutput, error) {
+			return &ec2.DescribeVpnConnectionsOutput{}, nil
+		},
+		describeTransitGatewayAttachments: func(*ec2.DescribeTransitGatewayAttachmentsInput) (*ec2.DescribeTransitGatewayAttachmentsOutput, error) {
+			return &ec2.DescribeTransitGatewayAttachmentsOutput{}, nil
+		},
+		describeCustomerGateways: func(*ec2.DescribeCustomerGatewaysInput) (*ec2.DescribeCustomerGatewaysOutput, error) {
+			return &ec2.DescribeCustomerGatewaysOutput{}, nil
+		},
+		describeVpnGateways: func(*ec2.DescribeVpnGatewaysInput) (*ec2.DescribeVpnGatewaysOutput, error) {
+			return &ec2.DescribeVpnGatewaysOutput{}, nil
+		},
+	}
+}
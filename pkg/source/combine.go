@@ -0,0 +1,55 @@
+package source
+
+import (
+	"errors"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// combined fetches connections from several sources
+type combined struct {
+	sources []vpn.Source
+	logger  log.Logger
+}
+
+// Combine returns a source of the connections from all the supplied sources. If some of the sources fail the
+// connections from the rest are still returned, with an error only being returned if every source fails.
+func Combine(logger log.Logger, sources ...vpn.Source) vpn.Source {
+
+	if len(sources) == 1 {
+		return sources[0]
+	}
+
+	return &combined{sources: sources, logger: logger}
+}
+
+func (c *combined) Connections() ([]*vpn.Connection, error) {
+
+	if len(c.sources) == 0 {
+		return nil, errors.New("no sources of VPN connections")
+	}
+
+	connections := make([]*vpn.Connection, 0)
+	var lastErr error
+	failed := 0
+
+	for _, source := range c.sources {
+
+		found, err := source.Connections()
+		if err != nil {
+			_ = level.Warn(c.logger).Log("msg", "Unable to get connections from source", "err", err)
+			lastErr = err
+			failed++
+			continue
+		}
+
+		connections = append(connections, found...)
+	}
+
+	if failed == len(c.sources) {
+		return nil, lastErr
+	}
+
+	return connections, nil
+}
@@ -0,0 +1,73 @@
+package source
+
+import (
+	"errors"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"testing"
+)
+
+type fakeSource struct {
+	connections []*vpn.Connection
+	err         error
+}
+
+func (f fakeSource) Connections() ([]*vpn.Connection, error) {
+	return f.connections, f.err
+}
+
+var failure = errors.New("test error")
+
+var combinetests = []struct {
+	name     string
+	sources  []vpn.Source
+	expected []string
+	err      bool
+}{
+	{name: "No sources", sources: nil, err: true},
+	{name: "One source", sources: []vpn.Source{fakeSource{connections: connections("a")}}, expected: []string{"a"}},
+	{name: "Several sources", sources: []vpn.Source{fakeSource{connections: connections("a", "b")}, fakeSource{connections: connections("c")}}, expected: []string{"a", "b", "c"}},
+	{name: "Some sources fail", sources: []vpn.Source{fakeSource{err: failure}, fakeSource{connections: connections("c")}}, expected: []string{"c"}},
+	{name: "All sources fail", sources: []vpn.Source{fakeSource{err: failure}, fakeSource{err: failure}}, err: true},
+}
+
+func TestCombine(t *testing.T) {
+
+	for _, tt := range combinetests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			found, err := Combine(log.NewNopLogger(), tt.sources...).Connections()
+
+			if tt.err {
+				if err == nil {
+					t.Error("Expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+
+			if len(found) != len(tt.expected) {
+				t.Errorf("want %d connections; got %d", len(tt.expected), len(found))
+				return
+			}
+
+			for i, id := range tt.expected {
+				if found[i].ID != id {
+					t.Errorf("want connection %s; got %s", id, found[i].ID)
+				}
+			}
+		})
+	}
+}
+
+func connections(ids ...string) []*vpn.Connection {
+	var found []*vpn.Connection
+	for _, id := range ids {
+		found = append(found, &vpn.Connection{ID: id})
+	}
+	return found
+}
@@ -0,0 +1,252 @@
+package strongswan
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SourceName is the source connections from strongSwan are reported as
+const SourceName = "strongswan"
+
+// IKE and CHILD SA states where traffic can flow through the tunnel
+var (
+	upIkeStates   = map[string]bool{"ESTABLISHED": true, "REKEYING": true, "REKEYED": true}
+	upChildStates = map[string]bool{"INSTALLED": true, "UPDATING": true, "REKEYING": true, "REKEYED": true}
+)
+
+// source fetches the connections known to a strongSwan charon daemon over its VICI socket
+type source struct {
+	network string
+	address string
+	timeout time.Duration
+	clock   state.Clock
+}
+
+// NewSource returns a source of the connections charon knows about, talking to it over the VICI unix socket at the
+// supplied path. Each connection is made up of a tunnel per CHILD SA.
+func NewSource(socket string, timeout time.Duration, clock state.Clock) vpn.Source {
+	return &source{network: "unix", address: socket, timeout: timeout, clock: clock}
+}
+
+// Connections returns the configured connections, with the state of any security associations for them
+func (s *source) Connections() ([]*vpn.Connection, error) {
+
+	client, err := dialVici(s.network, s.address, s.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	conns, err := client.streamedRequest("list-conns", "list-conn")
+	if err != nil {
+		return nil, err
+	}
+
+	sas, err := client.streamedRequest("list-sas", "list-sa")
+	if err != nil {
+		return nil, err
+	}
+
+	return toConnections(conns, sas, s.clock.Now()), nil
+}
+
+// toConnections maps the configured connections, and the IKE SAs established for them, into the provider neutral
+// model. The tunnels of a configured connection are down until a CHILD SA is installed for them.
+func toConnections(conns []message, sas []message, now time.Time) []*vpn.Connection {
+
+	byName := make(map[string]*vpn.Connection)
+
+	for _, event := range conns {
+		for name := range event {
+			byName[name] = fromConfig(name, event.section(name))
+		}
+	}
+
+	for _, event := range sas {
+		for name := range event {
+
+			conn, ok := byName[name]
+			if !ok {
+				conn = newConnection(name)
+				byName[name] = conn
+			}
+
+			addSecurityAssociation(conn, event.section(name), now)
+		}
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	connections := make([]*vpn.Connection, len(names))
+	for i, name := range names {
+		connections[i] = byName[name]
+	}
+
+	return connections
+}
+
+func newConnection(name string) *vpn.Connection {
+	return &vpn.Connection{
+		ID:         SourceName + ":" + name,
+		Name:       name,
+		Source:     SourceName,
+		Tags:       make(map[string]string),
+		Attributes: make(map[string]string),
+		Tunnels:    make([]*vpn.Tunnel, 0),
+	}
+}
+
+// fromConfig builds a connection from its configuration, with a tunnel that is down for each CHILD SA it configures
+func fromConfig(name string, config message) *vpn.Connection {
+
+	conn := newConnection(name)
+	conn.Raw = config
+
+	remoteAddrs := config.list("remote_addrs")
+
+	setAttribute(conn.Attributes, "local_addrs", strings.Join(config.list("local_addrs"), ","))
+	setAttribute(conn.Attributes, "remote_addrs", strings.Join(remoteAddrs, ","))
+	setAttribute(conn.Attributes, "version", config.get("version"))
+
+	children := config.section("children")
+	for _, child := range sortedKeys(children) {
+
+		tunnel := &vpn.Tunnel{
+			ID:            child,
+			Status:        vpn.StatusDown,
+			StatusMessage: "no CHILD SA",
+			Attributes:    map[string]string{"child_sa": child},
+		}
+
+		if len(remoteAddrs) > 0 {
+			tunnel.OutsideIP = remoteAddrs[0]
+		}
+
+		setAttribute(tunnel.Attributes, "local_ts", strings.Join(children.section(child).list("local-ts"), ","))
+		setAttribute(tunnel.Attributes, "remote_ts", strings.Join(children.section(child).list("remote-ts"), ","))
+
+		conn.Tunnels = append(conn.Tunnels, tunnel)
+	}
+
+	return conn
+}
+
+// addSecurityAssociation updates the tunnels of the connection with the state of an IKE SA and its CHILD SAs
+func addSecurityAssociation(conn *vpn.Connection, ike message, now time.Time) {
+
+	if conn.Raw == nil {
+		conn.Raw = ike
+	}
+
+	remoteHost := ike.get("remote-host")
+	ikeState := ike.get("state")
+
+	setAttribute(conn.Attributes, "remote_id", ike.get("remote-id"))
+	setAttribute(conn.Attributes, "ike_established", ago(ike.get("established"), now))
+	setAttribute(conn.Attributes, "ike_rekey_time", from(ike.get("rekey-time"), now))
+
+	children := ike.section("child-sas")
+	for _, key := range sortedKeys(children) {
+
+		child := children.section(key)
+
+		name := child.get("name")
+		if name == "" {
+			name = key
+		}
+
+		tunnel := tunnelFor(conn, name, key)
+		tunnel.OutsideIP = remoteHost
+		tunnel.StatusMessage = ""
+		tunnel.Attributes["ike_state"] = ikeState
+		tunnel.Attributes["child_state"] = child.get("state")
+
+		tunnel.Status = vpn.StatusDown
+		if upIkeStates[ikeState] && upChildStates[child.get("state")] {
+			tunnel.Status = vpn.StatusUp
+		}
+
+		if installed, err := strconv.ParseInt(child.get("install-time"), 10, 64); err == nil {
+			tunnel.LastStatusChange = now.Add(-time.Duration(installed) * time.Second)
+		}
+
+		tunnel.Traffic = &vpn.Traffic{}
+		tunnel.Traffic.BytesIn, _ = strconv.ParseUint(child.get("bytes-in"), 10, 64)
+		tunnel.Traffic.BytesOut, _ = strconv.ParseUint(child.get("bytes-out"), 10, 64)
+
+		setAttribute(tunnel.Attributes, "rekey_time", from(child.get("rekey-time"), now))
+		setAttribute(tunnel.Attributes, "local_ts", strings.Join(child.list("local-ts"), ","))
+		setAttribute(tunnel.Attributes, "remote_ts", strings.Join(child.list("remote-ts"), ","))
+	}
+
+	// Configured tunnels without a CHILD SA still go through this IKE SA's peer
+	for _, tunnel := range conn.Tunnels {
+		if tunnel.Attributes["ike_state"] == "" {
+			tunnel.OutsideIP = remoteHost
+			tunnel.Attributes["ike_state"] = ikeState
+		}
+	}
+}
+
+// tunnelFor returns the tunnel of the connection for the named CHILD SA, adding one if there isn't one yet. A CHILD
+// SA with the same name as one already added, such as the new one while it's being rekeyed, is identified by its key
+// in the IKE SA instead, as tunnels of a connection need different IDs.
+func tunnelFor(conn *vpn.Connection, child string, key string) *vpn.Tunnel {
+
+	id := child
+	for _, tunnel := range conn.Tunnels {
+		if tunnel.Attributes["child_sa"] != child {
+			continue
+		}
+		if tunnel.Attributes["child_state"] == "" {
+			return tunnel
+		}
+		id = key
+	}
+
+	tunnel := &vpn.Tunnel{ID: id, Attributes: map[string]string{"child_sa": child}}
+	conn.Tunnels = append(conn.Tunnels, tunnel)
+
+	return tunnel
+}
+
+// ago returns the time the supplied number of seconds before now, or an empty string if it isn't a number
+func ago(seconds string, now time.Time) string {
+	s, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil {
+		return ""
+	}
+	return now.Add(-time.Duration(s) * time.Second).Format(time.RFC3339)
+}
+
+// from returns the time the supplied number of seconds after now, or an empty string if it isn't a number
+func from(seconds string, now time.Time) string {
+	s, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil {
+		return ""
+	}
+	return now.Add(time.Duration(s) * time.Second).Format(time.RFC3339)
+}
+
+func sortedKeys(m message) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func setAttribute(attributes map[string]string, name string, value string) {
+	if value != "" {
+		attributes[name] = value
+	}
+}
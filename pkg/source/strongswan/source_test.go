@@ -0,0 +1,188 @@
+package strongswan
+
+import (
+	"github.com/clearchannelinternational/vpncheck/pkg/metrics"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+var fixedTime = time.Date(2009, 11, 17, 20, 34, 58, 0, time.UTC)
+
+type fixedClock struct{}
+
+func (fixedClock) Now() time.Time { return fixedTime }
+
+func TestConnections(t *testing.T) {
+
+	// Given charon has two connections configured, one of them established
+	socket := startFakeVici(t, map[string][]message{
+		"list-conns": {
+			{"aws-tunnel-1": message{
+				"remote_addrs": []string{"1.2.3.4"},
+				"version":      "IKEv2",
+				"children":     message{"aws-child-1": message{"remote-ts": []string{"10.0.0.0/16"}}},
+			}},
+			{"datacentre": message{
+				"remote_addrs": []string{"5.6.7.8"},
+				"children":     message{"dc-child": message{}},
+			}},
+		},
+		"list-sas": {
+			{"aws-tunnel-1": message{
+				"state":       "ESTABLISHED",
+				"remote-host": "1.2.3.4",
+				"established": "3600",
+				"child-sas": message{
+					"aws-child-1-7": message{
+						"name":         "aws-child-1",
+						"state":        "INSTALLED",
+						"install-time": "60",
+						"rekey-time":   "120",
+						"bytes-in":     "1000",
+						"bytes-out":    "2000",
+					},
+				},
+			}},
+		},
+	})
+
+	underTest := NewSource(socket, time.Second, fixedClock{})
+
+	// When the connections are fetched
+	connections, err := underTest.Connections()
+
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	// Then both connections should be returned
+	if len(connections) != 2 {
+		t.Errorf("Expected 2 connections, but got %d", len(connections))
+		return
+	}
+
+	established, configured := connections[0], connections[1]
+
+	if established.ID != "strongswan:aws-tunnel-1" || established.Name != "aws-tunnel-1" || established.Source != SourceName {
+		t.Errorf("Connection incorrect. Got ID `%s`, name `%s`, source `%s`", established.ID, established.Name, established.Source)
+	}
+
+	if established.Attribute("version") != "IKEv2" || established.Attribute("ike_established") != "2009-11-17T19:34:58Z" {
+		t.Errorf("Connection attributes incorrect. Got %v", established.Attributes)
+	}
+
+	// with the established tunnel up
+	if len(established.Tunnels) != 1 {
+		t.Errorf("Expected 1 tunnel, but got %d", len(established.Tunnels))
+		return
+	}
+
+	tunnel := established.Tunnels[0]
+
+	if tunnel.Status != vpn.StatusUp || tunnel.OutsideIP != "1.2.3.4" || tunnel.ID != "aws-child-1" {
+		t.Errorf("Expected tunnel to 1.2.3.4 to be UP, but got %s to %s", tunnel.Status, tunnel.OutsideIP)
+	}
+
+	if !tunnel.LastStatusChange.Equal(fixedTime.Add(-time.Minute)) {
+		t.Errorf("Tunnel status change incorrect. Expected %s but got %s", fixedTime.Add(-time.Minute), tunnel.LastStatusChange)
+	}
+
+	if tunnel.Traffic == nil || tunnel.Traffic.BytesIn != 1000 || tunnel.Traffic.BytesOut != 2000 {
+		t.Errorf("Tunnel traffic incorrect. Expected 1000 in and 2000 out but got %+v", tunnel.Traffic)
+	}
+
+	if tunnel.Attributes["rekey_time"] != "2009-11-17T20:36:58Z" || tunnel.Attributes["remote_ts"] != "10.0.0.0/16" {
+		t.Errorf("Tunnel attributes incorrect. Got %v", tunnel.Attributes)
+	}
+
+	// and the configured tunnel down
+	if len(configured.Tunnels) != 1 || configured.Tunnels[0].Status != vpn.StatusDown || configured.Tunnels[0].OutsideIP != "5.6.7.8" {
+		t.Errorf("Expected a DOWN tunnel to 5.6.7.8, but got %+v", configured.Tunnels)
+	}
+
+}
+
+func TestMultipleChildren(t *testing.T) {
+
+	// Given an IKE SA with two CHILD SAs, one of them being rekeyed so there are two with its name
+	sas := []message{
+		{"datacentre": message{
+			"state":       "ESTABLISHED",
+			"remote-host": "5.6.7.8",
+			"child-sas": message{
+				"servers-1": message{"name": "servers", "state": "INSTALLED"},
+				"servers-2": message{"name": "servers", "state": "REKEYED"},
+				"storage-3": message{"name": "storage", "state": "DELETING"},
+			},
+		}},
+	}
+
+	// When the connections are built and their metrics published
+	connections := toConnections(nil, sas, fixedTime)
+
+	registry := prometheus.NewRegistry()
+	collector := metrics.NewVpnStatusCollector(metrics.DefaultNaming.Registerer(registry), log.NewNopLogger(), fixedClock{})
+	go func() { _ = collector.Execute() }()
+	defer collector.Interrupt(nil)
+	collector.Update(connections)
+
+	// Then each CHILD SA has its own tunnel and series, even though they all go to the same outside IP
+	const truth = `
+		# HELP cc_vpn_tunnel_up If the site to site VPN tunnel status is up, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.
+		# TYPE cc_vpn_tunnel_up gauge
+		cc_vpn_tunnel_up{outside_ip="5.6.7.8",transit_gateway_id="",tunnel_id="servers",vpn_id="strongswan:datacentre"} 1
+		cc_vpn_tunnel_up{outside_ip="5.6.7.8",transit_gateway_id="",tunnel_id="servers-2",vpn_id="strongswan:datacentre"} 1
+		cc_vpn_tunnel_up{outside_ip="5.6.7.8",transit_gateway_id="",tunnel_id="storage",vpn_id="strongswan:datacentre"} 0
+	`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(truth), "cc_vpn_tunnel_up"); err != nil {
+		t.Errorf("Unexpected metrics:\n%s", err)
+	}
+}
+
+var statustests = []struct {
+	name       string
+	ikeState   string
+	childState string
+	truth      vpn.Status
+}{
+	{name: "Established", ikeState: "ESTABLISHED", childState: "INSTALLED", truth: vpn.StatusUp},
+	{name: "Rekeying", ikeState: "REKEYING", childState: "REKEYING", truth: vpn.StatusUp},
+	{name: "Connecting", ikeState: "CONNECTING", childState: "CREATED", truth: vpn.StatusDown},
+	{name: "Child being deleted", ikeState: "ESTABLISHED", childState: "DELETING", truth: vpn.StatusDown},
+}
+
+func TestTunnelStatus(t *testing.T) {
+
+	for _, tt := range statustests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			sas := []message{
+				{"conn": message{
+					"state":     tt.ikeState,
+					"child-sas": message{"child": message{"state": tt.childState}},
+				}},
+			}
+
+			connections := toConnections(nil, sas, fixedTime)
+
+			if status := connections[0].Tunnels[0].Status; status != tt.truth {
+				t.Errorf("want %s; got %s", tt.truth, status)
+			}
+		})
+	}
+}
+
+func TestConnectionsWithoutCharon(t *testing.T) {
+
+	underTest := NewSource("/nonexistent/charon.vici", time.Second, fixedClock{})
+
+	if _, err := underTest.Connections(); err == nil {
+		t.Error("Expected an error when charon can't be reached")
+	}
+}
@@ -0,0 +1,391 @@
+package strongswan
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Packet types of the VICI protocol, see https://github.com/strongswan/strongswan/blob/master/src/libcharon/plugins/vici/README.md
+const (
+	cmdRequest      = 0
+	cmdResponse     = 1
+	cmdUnknown      = 2
+	eventRegister   = 3
+	eventUnregister = 4
+	eventConfirm    = 5
+	eventUnknown    = 6
+	event           = 7
+)
+
+// Element types of VICI messages
+const (
+	sectionStart = 1
+	sectionEnd   = 2
+	keyValue     = 3
+	listStart    = 4
+	listItem     = 5
+	listEnd      = 6
+)
+
+// message is a decoded VICI message. Values are either a string, a []string for lists, or a message for sections.
+type message map[string]interface{}
+
+// get returns the string value of the named key, or an empty string if there isn't one
+func (m message) get(key string) string {
+	v, _ := m[key].(string)
+	return v
+}
+
+// list returns the values of the named list, or nil if there isn't one
+func (m message) list(key string) []string {
+	v, _ := m[key].([]string)
+	return v
+}
+
+// section returns the named section, or nil if there isn't one
+func (m message) section(key string) message {
+	v, _ := m[key].(message)
+	return v
+}
+
+// packet is a VICI packet
+type packet struct {
+	kind    byte
+	name    string
+	message message
+}
+
+// named is true for packet types that carry a name
+func (p packet) named() bool {
+	switch p.kind {
+	case cmdRequest, eventRegister, eventUnregister, event:
+		return true
+	}
+	return false
+}
+
+// viciClient talks to the charon daemon over its VICI socket
+type viciClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialVici connects to the VICI socket, with all operations on the connection having to complete within the timeout
+func dialVici(network string, address string, timeout time.Duration) (*viciClient, error) {
+
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &viciClient{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func (c *viciClient) Close() error {
+	return c.conn.Close()
+}
+
+// streamedRequest sends the command and collects the messages of the named event it streams before responding
+func (c *viciClient) streamedRequest(command string, eventName string) ([]message, error) {
+
+	if err := c.registration(eventRegister, eventName); err != nil {
+		return nil, err
+	}
+
+	if err := c.write(packet{kind: cmdRequest, name: command, message: message{}}); err != nil {
+		return nil, err
+	}
+
+	var events []message
+
+	for {
+		p, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+
+		switch p.kind {
+		case event:
+			if p.name == eventName {
+				events = append(events, p.message)
+			}
+		case cmdResponse:
+			if p.message.get("success") == "no" {
+				return nil, fmt.Errorf("vici command %s failed: %s", command, p.message.get("errmsg"))
+			}
+			return events, c.registration(eventUnregister, eventName)
+		case cmdUnknown:
+			return nil, fmt.Errorf("vici command %s unknown", command)
+		default:
+			return nil, fmt.Errorf("unexpected vici packet type %d", p.kind)
+		}
+	}
+}
+
+// registration registers or unregisters for the named event
+func (c *viciClient) registration(kind byte, eventName string) error {
+
+	if err := c.write(packet{kind: kind, name: eventName}); err != nil {
+		return err
+	}
+
+	p, err := c.read()
+	if err != nil {
+		return err
+	}
+
+	switch p.kind {
+	case eventConfirm:
+		return nil
+	case eventUnknown:
+		return fmt.Errorf("vici event %s unknown", eventName)
+	default:
+		return fmt.Errorf("unexpected vici packet type %d", p.kind)
+	}
+}
+
+func (c *viciClient) write(p packet) error {
+	_, err := c.conn.Write(encodePacket(p))
+	return err
+}
+
+func (c *viciClient) read() (packet, error) {
+	return readPacket(c.reader)
+}
+
+// encodePacket returns the packet prefixed by its length, ready to send
+func encodePacket(p packet) []byte {
+
+	var body bytes.Buffer
+	body.WriteByte(p.kind)
+
+	if p.named() {
+		writeName(&body, p.name)
+	}
+
+	if p.message != nil {
+		writeMessage(&body, p.message)
+	}
+
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.BigEndian, uint32(body.Len()))
+	b.Write(body.Bytes())
+
+	return b.Bytes()
+}
+
+func writeName(b *bytes.Buffer, name string) {
+	b.WriteByte(byte(len(name)))
+	b.WriteString(name)
+}
+
+func writeValue(b *bytes.Buffer, value string) {
+	_ = binary.Write(b, binary.BigEndian, uint16(len(value)))
+	b.WriteString(value)
+}
+
+func writeMessage(b *bytes.Buffer, m message) {
+
+	for name, value := range m {
+		switch v := value.(type) {
+		case string:
+			b.WriteByte(keyValue)
+			writeName(b, name)
+			writeValue(b, v)
+		case []string:
+			b.WriteByte(listStart)
+			writeName(b, name)
+			for _, item := range v {
+				b.WriteByte(listItem)
+				writeValue(b, item)
+			}
+			b.WriteByte(listEnd)
+		case message:
+			b.WriteByte(sectionStart)
+			writeName(b, name)
+			writeMessage(b, v)
+			b.WriteByte(sectionEnd)
+		}
+	}
+}
+
+// readPacket reads the next length prefixed packet
+func readPacket(r io.Reader) (packet, error) {
+
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return packet{}, err
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+
+	return decodePacket(body)
+}
+
+func decodePacket(body []byte) (packet, error) {
+
+	d := &decoder{data: body}
+
+	kind, err := d.byte()
+	if err != nil {
+		return packet{}, err
+	}
+
+	p := packet{kind: kind}
+
+	if p.named() {
+		if p.name, err = d.name(); err != nil {
+			return packet{}, err
+		}
+	}
+
+	switch p.kind {
+	case cmdRequest, cmdResponse, event:
+		if p.message, err = d.message(false); err != nil {
+			return packet{}, err
+		}
+	}
+
+	return p, nil
+}
+
+var errTruncated = errors.New("truncated vici message")
+
+// decoder reads the elements of a VICI message
+type decoder struct {
+	data []byte
+}
+
+func (d *decoder) byte() (byte, error) {
+	if len(d.data) < 1 {
+		return 0, errTruncated
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b, nil
+}
+
+func (d *decoder) bytes(n int) ([]byte, error) {
+	if len(d.data) < n {
+		return nil, errTruncated
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+func (d *decoder) name() (string, error) {
+	n, err := d.byte()
+	if err != nil {
+		return "", err
+	}
+	b, err := d.bytes(int(n))
+	return string(b), err
+}
+
+func (d *decoder) value() (string, error) {
+	n, err := d.bytes(2)
+	if err != nil {
+		return "", err
+	}
+	b, err := d.bytes(int(binary.BigEndian.Uint16(n)))
+	return string(b), err
+}
+
+// message decodes elements until the end of the data, or the end of the section if in one
+func (d *decoder) message(inSection bool) (message, error) {
+
+	m := message{}
+
+	for len(d.data) > 0 {
+
+		kind, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind {
+
+		case sectionEnd:
+			if !inSection {
+				return nil, errors.New("unexpected end of vici section")
+			}
+			return m, nil
+
+		case sectionStart:
+			name, err := d.name()
+			if err != nil {
+				return nil, err
+			}
+			if m[name], err = d.message(true); err != nil {
+				return nil, err
+			}
+
+		case keyValue:
+			name, err := d.name()
+			if err != nil {
+				return nil, err
+			}
+			if m[name], err = d.value(); err != nil {
+				return nil, err
+			}
+
+		case listStart:
+			name, err := d.name()
+			if err != nil {
+				return nil, err
+			}
+			if m[name], err = d.list(); err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, fmt.Errorf("unexpected vici element type %d", kind)
+		}
+	}
+
+	if inSection {
+		return nil, errTruncated
+	}
+
+	return m, nil
+}
+
+func (d *decoder) list() ([]string, error) {
+
+	items := make([]string, 0)
+
+	for {
+		kind, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case listEnd:
+			return items, nil
+		case listItem:
+			item, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		default:
+			return nil, fmt.Errorf("unexpected vici list element type %d", kind)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package strongswan
+
+import (
+	"bytes"
+	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var packettests = []struct {
+	name   string
+	packet packet
+}{
+	{name: "Event registration", packet: packet{kind: eventRegister, name: "list-sa"}},
+	{name: "Confirmation", packet: packet{kind: eventConfirm}},
+	{name: "Empty request", packet: packet{kind: cmdRequest, name: "list-sas", message: message{}}},
+	{name: "Nested message", packet: packet{kind: event, name: "list-sa", message: message{
+		"conn": message{
+			"state":       "ESTABLISHED",
+			"remote-host": "1.2.3.4",
+			"child-sas": message{
+				"child": message{"local-ts": []string{"10.0.0.0/16", "10.1.0.0/16"}, "remote-ts": []string{}},
+			},
+		},
+	}}},
+}
+
+func TestPacketEncoding(t *testing.T) {
+
+	for _, tt := range packettests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			encoded := encodePacket(tt.packet)
+
+			decoded, err := readPacket(bytes.NewReader(encoded))
+			if err != nil {
+				t.Errorf("Unable to decode packet: %v", err)
+				return
+			}
+
+			if !cmp.Equal(tt.packet, decoded, cmp.AllowUnexported(packet{})) {
+				t.Errorf("Packet changed after encoding and decoding : %s", cmp.Diff(tt.packet, decoded, cmp.AllowUnexported(packet{})))
+			}
+		})
+	}
+}
+
+func TestTruncatedPacket(t *testing.T) {
+
+	encoded := encodePacket(packet{kind: event, name: "list-sa", message: message{"conn": message{"state": "ESTABLISHED"}}})
+
+	// Given a packet that claims to be longer than it is
+	truncated := append([]byte{0, 0, 0, byte(len(encoded) - 6)}, encoded[4:len(encoded)-2]...)
+
+	if _, err := readPacket(bytes.NewReader(truncated)); err == nil {
+		t.Error("Expected an error decoding a truncated packet")
+	}
+}
+
+func TestStreamedRequest(t *testing.T) {
+
+	// Given a VICI server that streams two events
+	events := []message{
+		{"first": message{"state": "ESTABLISHED"}},
+		{"second": message{"state": "CONNECTING"}},
+	}
+	socket := startFakeVici(t, map[string][]message{"list-sas": events})
+
+	client, err := dialVici("unix", socket, time.Second)
+	if err != nil {
+		t.Errorf("Unable to connect: %v", err)
+		return
+	}
+	defer client.Close()
+
+	// When a streamed request is made
+	received, err := client.streamedRequest("list-sas", "list-sa")
+
+	// Then all the events should be returned
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	if !cmp.Equal(events, received) {
+		t.Errorf("Events incorrect : %s", cmp.Diff(events, received))
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+
+	socket := startFakeVici(t, map[string][]message{})
+
+	client, err := dialVici("unix", socket, time.Second)
+	if err != nil {
+		t.Errorf("Unable to connect: %v", err)
+		return
+	}
+	defer client.Close()
+
+	if _, err := client.streamedRequest("list-sas", "list-sa"); err == nil {
+		t.Error("Expected an error for an unknown command")
+	}
+}
+
+// startFakeVici starts a VICI server on a unix socket, that streams the supplied events in response to the commands
+// they're keyed by. It returns the path of the socket.
+func startFakeVici(t *testing.T, responses map[string][]message) string {
+
+	dir, err := ioutil.TempDir("", "vici")
+	if err != nil {
+		t.Fatalf("Unable to create socket directory: %v", err)
+	}
+
+	socket := filepath.Join(dir, "charon.vici")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Unable to listen on socket: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+		_ = os.RemoveAll(dir)
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeVici(conn, responses)
+		}
+	}()
+
+	return socket
+}
+
+func serveFakeVici(conn net.Conn, responses map[string][]message) {
+
+	defer conn.Close()
+
+	registered := ""
+
+	for {
+		p, err := readPacket(conn)
+		if err != nil {
+			return
+		}
+
+		var replies []packet
+
+		switch p.kind {
+		case eventRegister:
+			registered = p.name
+			replies = append(replies, packet{kind: eventConfirm})
+		case eventUnregister:
+			registered = ""
+			replies = append(replies, packet{kind: eventConfirm})
+		case cmdRequest:
+			events, ok := responses[p.name]
+			if !ok {
+				replies = append(replies, packet{kind: cmdUnknown})
+				break
+			}
+			for _, e := range events {
+				replies = append(replies, packet{kind: event, name: registered, message: e})
+			}
+			replies = append(replies, packet{kind: cmdResponse, message: message{}})
+		}
+
+		for _, reply := range replies {
+			if _, err := conn.Write(encodePacket(reply)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package wireguard
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/clearchannelinternational/vpncheck/pkg/state"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SourceName is the source connections from WireGuard are reported as
+const SourceName = "wireguard"
+
+// The number of tab separated fields on the interface and peer lines of `wg show all dump`
+const (
+	interfaceFields = 5
+	peerFields      = 9
+)
+
+// Dumper returns the output of `wg show all dump`
+type Dumper func() ([]byte, error)
+
+// FileDumper returns a Dumper that reads previously recorded output from the file at the supplied path
+func FileDumper(path string) Dumper {
+	return func() ([]byte, error) {
+		return ioutil.ReadFile(path)
+	}
+}
+
+// CommandDumper returns a Dumper that runs the supplied command, such as `wg show all dump`, and returns its output
+func CommandDumper(command string) Dumper {
+	return func() ([]byte, error) {
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			return nil, fmt.Errorf("no wireguard command to run")
+		}
+
+		var stderr bytes.Buffer
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stderr = &stderr
+
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("running %s failed: %v %s", command, err, strings.TrimSpace(stderr.String()))
+		}
+		return out, nil
+	}
+}
+
+// source reports WireGuard interfaces as connections, with a tunnel for each of their peers
+type source struct {
+	dump             Dumper
+	handshakeTimeout time.Duration
+	clock            state.Clock
+}
+
+// NewSource returns a source of the WireGuard interfaces in the dumps. A peer is up if its latest handshake was
+// within the handshake timeout.
+func NewSource(dump Dumper, handshakeTimeout time.Duration, clock state.Clock) vpn.Source {
+	return &source{dump: dump, handshakeTimeout: handshakeTimeout, clock: clock}
+}
+
+// Connections returns the interfaces and peers in the latest dump
+func (s *source) Connections() ([]*vpn.Connection, error) {
+
+	out, err := s.dump()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseDump(out, s.handshakeTimeout, s.clock.Now())
+}
+
+// parseDump maps the output of `wg show all dump` into the provider neutral model. Each interface has a line of its
+// own, followed by a line for each of its peers, all prefixed by the interface name.
+func parseDump(out []byte, handshakeTimeout time.Duration, now time.Time) ([]*vpn.Connection, error) {
+
+	connections := make([]*vpn.Connection, 0)
+	byName := make(map[string]*vpn.Connection)
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for line := 1; scanner.Scan(); line++ {
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		fields := strings.Split(text, "\t")
+
+		switch len(fields) {
+
+		case interfaceFields:
+			conn := toConnection(fields)
+			byName[conn.Name] = conn
+			connections = append(connections, conn)
+
+		case peerFields:
+			conn, ok := byName[fields[0]]
+			if !ok {
+				return nil, fmt.Errorf("line %d: peer of unknown wireguard interface %s", line, fields[0])
+			}
+			tunnel, err := toTunnel(fields, handshakeTimeout, now)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			conn.Tunnels = append(conn.Tunnels, tunnel)
+
+		default:
+			return nil, fmt.Errorf("line %d: expected %d or %d fields but got %d", line, interfaceFields, peerFields, len(fields))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return connections, nil
+}
+
+// toConnection maps an interface line, which is made up of the interface, private key, public key, listen port and
+// fwmark. The private key is never kept.
+func toConnection(fields []string) *vpn.Connection {
+
+	conn := &vpn.Connection{
+		ID:         SourceName + ":" + fields[0],
+		Name:       fields[0],
+		Source:     SourceName,
+		Tags:       make(map[string]string),
+		Attributes: make(map[string]string),
+		Tunnels:    make([]*vpn.Tunnel, 0),
+		Raw:        fields[0] + "\t(hidden)\t" + strings.Join(fields[2:], "\t"),
+	}
+
+	setAttribute(conn.Attributes, "public_key", fields[2])
+	setAttribute(conn.Attributes, "listen_port", fields[3])
+	setAttribute(conn.Attributes, "fwmark", fields[4])
+
+	return conn
+}
+
+// toTunnel maps a peer line, which is made up of the interface, public key, preshared key, endpoint, allowed ips,
+// latest handshake, bytes received, bytes sent and persistent keepalive
+func toTunnel(fields []string, handshakeTimeout time.Duration, now time.Time) (*vpn.Tunnel, error) {
+
+	handshake, err := strconv.ParseInt(fields[5], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid latest handshake %q", fields[5])
+	}
+
+	rx, err := strconv.ParseUint(fields[6], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transfer rx %q", fields[6])
+	}
+
+	tx, err := strconv.ParseUint(fields[7], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transfer tx %q", fields[7])
+	}
+
+	tunnel := &vpn.Tunnel{
+		ID:         fields[1],
+		OutsideIP:  endpointHost(fields[3]),
+		Status:     vpn.StatusDown,
+		Traffic:    &vpn.Traffic{BytesIn: rx, BytesOut: tx},
+		Attributes: make(map[string]string),
+	}
+
+	setAttribute(tunnel.Attributes, "public_key", fields[1])
+	setAttribute(tunnel.Attributes, "endpoint", none(fields[3]))
+	setAttribute(tunnel.Attributes, "allowed_ips", none(fields[4]))
+	setAttribute(tunnel.Attributes, "persistent_keepalive", none(fields[8]))
+
+	if handshake == 0 {
+		tunnel.StatusMessage = "no handshake"
+		return tunnel, nil
+	}
+
+	tunnel.LastHandshake = time.Unix(handshake, 0).UTC()
+
+	age := now.Sub(tunnel.LastHandshake)
+	if age <= handshakeTimeout {
+		tunnel.Status = vpn.StatusUp
+	}
+	tunnel.StatusMessage = fmt.Sprintf("latest handshake %v ago", age.Truncate(time.Second))
+
+	return tunnel, nil
+}
+
+// endpointHost returns the IP address of a peer's endpoint, or an empty string if it has none
+func endpointHost(endpoint string) string {
+	host, _, err := net.SplitHostPort(none(endpoint))
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// none maps the "(none)" wg uses for missing values, and "off" for a disabled keepalive, to an empty string
+func none(value string) string {
+	if value == "(none)" || value == "off" {
+		return ""
+	}
+	return value
+}
+
+func setAttribute(attributes map[string]string, name string, value string) {
+	if value != "" {
+		attributes[name] = value
+	}
+}
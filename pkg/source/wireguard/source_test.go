@@ -0,0 +1,249 @@
+package wireguard
+
+import (
+	"errors"
+	"github.com/clearchannelinternational/vpncheck/pkg/metrics"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A minute after the latest handshake recorded in testdata/all.dump
+var fixedTime = time.Unix(1600000060, 0).UTC()
+
+type fixedClock struct{}
+
+func (fixedClock) Now() time.Time { return fixedTime }
+
+func TestConnections(t *testing.T) {
+
+	// Given a dump of two interfaces, with peers that have handshaken recently, a while ago and never
+	underTest := NewSource(FileDumper("testdata/all.dump"), 3*time.Minute, fixedClock{})
+
+	// When the connections are fetched
+	connections, err := underTest.Connections()
+
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	// Then there should be a connection for each interface
+	if len(connections) != 2 {
+		t.Errorf("Expected 2 connections but got %d", len(connections))
+		return
+	}
+
+	wg0 := connections[0]
+	if wg0.ID != "wireguard:wg0" || wg0.Name != "wg0" || wg0.Source != SourceName {
+		t.Errorf("Connection incorrect. Got %+v", wg0)
+	}
+
+	if wg0.Attribute("listen_port") != "51820" {
+		t.Errorf("Expected listen port 51820 but got %q", wg0.Attribute("listen_port"))
+	}
+
+	// and the private key should not be kept
+	if raw, _ := wg0.Raw.(string); raw != "wg0\t(hidden)\tSzA3b0tOQmZ3a0dLcVR6b1FKd1JvSmVCZ2xKSkNONjY=\t51820\toff" {
+		t.Errorf("Raw interface details incorrect. Got %q", raw)
+	}
+
+	// and a tunnel for each peer
+	if len(wg0.Tunnels) != 3 || len(connections[1].Tunnels) != 1 {
+		t.Errorf("Expected 3 and 1 tunnels but got %d and %d", len(wg0.Tunnels), len(connections[1].Tunnels))
+		return
+	}
+
+	tunnel := wg0.Tunnels[0]
+	if tunnel.OutsideIP != "203.0.113.10" {
+		t.Errorf("Expected outside IP 203.0.113.10 but got %q", tunnel.OutsideIP)
+	}
+
+	if tunnel.ID != "UGVlckFQZWVyQVBlZXJBUGVlckFQZWVyQVBlZXJBUGU=" {
+		t.Errorf("Expected the peer's public key as the tunnel ID but got %q", tunnel.ID)
+	}
+
+	if tunnel.LastHandshake != time.Unix(1600000000, 0).UTC() {
+		t.Errorf("Latest handshake incorrect. Got %v", tunnel.LastHandshake)
+	}
+
+	if tunnel.Traffic == nil || tunnel.Traffic.BytesIn != 123456 || tunnel.Traffic.BytesOut != 654321 {
+		t.Errorf("Tunnel traffic incorrect. Expected 123456 in and 654321 out but got %+v", tunnel.Traffic)
+	}
+
+	if tunnel.Attributes["allowed_ips"] != "10.10.0.0/16" || tunnel.Attributes["persistent_keepalive"] != "25" {
+		t.Errorf("Tunnel attributes incorrect. Got %v", tunnel.Attributes)
+	}
+
+	if wg0.Tunnels[1].OutsideIP != "2001:db8::1" {
+		t.Errorf("Expected outside IP 2001:db8::1 but got %q", wg0.Tunnels[1].OutsideIP)
+	}
+
+	// and preshared keys should not be kept
+	for _, value := range connections[1].Tunnels[0].Attributes {
+		if value == "cHJlc2hhcmVkcHJlc2hhcmVkcHJlc2hhcmVkcHJlc2g=" {
+			t.Errorf("Preshared key should not be an attribute. Got %v", connections[1].Tunnels[0].Attributes)
+		}
+	}
+}
+
+var statustests = []struct {
+	name    string
+	iface   int
+	peer    int
+	status  vpn.Status
+	message string
+}{
+	{"handshake within timeout", 0, 0, vpn.StatusUp, "latest handshake 1m0s ago"},
+	{"handshake too long ago", 0, 1, vpn.StatusDown, "latest handshake 2h47m40s ago"},
+	{"never handshaken", 0, 2, vpn.StatusDown, "no handshake"},
+	{"handshake on another interface", 1, 0, vpn.StatusUp, "latest handshake 1m10s ago"},
+}
+
+func TestTunnelStatus(t *testing.T) {
+
+	underTest := NewSource(FileDumper("testdata/all.dump"), 3*time.Minute, fixedClock{})
+
+	connections, err := underTest.Connections()
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	for _, tt := range statustests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			tunnel := connections[tt.iface].Tunnels[tt.peer]
+
+			if tunnel.Status != tt.status || tunnel.StatusMessage != tt.message {
+				t.Errorf("Expected %s %q but got %s %q", tt.status, tt.message, tunnel.Status, tunnel.StatusMessage)
+			}
+
+		})
+	}
+}
+
+func TestNeverHandshaken(t *testing.T) {
+
+	underTest := NewSource(FileDumper("testdata/all.dump"), 3*time.Minute, fixedClock{})
+
+	connections, err := underTest.Connections()
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	// A peer without an endpoint or handshake has neither an outside IP nor a handshake time
+	tunnel := connections[0].Tunnels[2]
+	if tunnel.OutsideIP != "" || !tunnel.LastHandshake.IsZero() {
+		t.Errorf("Expected no outside IP or handshake but got %q and %v", tunnel.OutsideIP, tunnel.LastHandshake)
+	}
+}
+
+func TestPeersWithoutEndpoints(t *testing.T) {
+
+	// Given an interface with two peers without endpoints, one that has handshaken recently and one that never has
+	underTest := NewSource(func() ([]byte, error) {
+		return []byte("wg0\t(none)\tSzA3b0tOQmZ3a0dLcVR6b1FKd1JvSmVCZ2xKSkNONjY=\t51820\toff\n" +
+			"wg0\tUGVlckFQZWVyQVBlZXJBUGVlckFQZWVyQVBlZXJBUGU=\t(none)\t(none)\t10.10.0.0/16\t1600000000\t0\t0\toff\n" +
+			"wg0\tUGVlckNQZWVyQ1BlZXJDUGVlckNQZWVyQ1BlZXJDUGU=\t(none)\t(none)\t10.30.0.0/16\t0\t0\t0\toff\n"), nil
+	}, 3*time.Minute, fixedClock{})
+
+	connections, err := underTest.Connections()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// When their metrics are published
+	registry := prometheus.NewRegistry()
+	collector := metrics.NewVpnStatusCollector(metrics.DefaultNaming.Registerer(registry), log.NewNopLogger(), fixedClock{})
+	go func() { _ = collector.Execute() }()
+	defer collector.Interrupt(nil)
+	collector.Update(connections)
+
+	// Then each peer has its own series, told apart by its public key, rather than one hiding the other
+	const truth = `
+		# HELP cc_vpn_tunnel_up If the site to site VPN tunnel status is up, partitioned by VPN Connection ID, Transit Gateway ID, Outside IP and Tunnel ID.
+		# TYPE cc_vpn_tunnel_up gauge
+		cc_vpn_tunnel_up{outside_ip="",transit_gateway_id="",tunnel_id="UGVlckFQZWVyQVBlZXJBUGVlckFQZWVyQVBlZXJBUGU=",vpn_id="wireguard:wg0"} 1
+		cc_vpn_tunnel_up{outside_ip="",transit_gateway_id="",tunnel_id="UGVlckNQZWVyQ1BlZXJDUGVlckNQZWVyQ1BlZXJDUGU=",vpn_id="wireguard:wg0"} 0
+	`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(truth), "cc_vpn_tunnel_up"); err != nil {
+		t.Errorf("Unexpected metrics:\n%s", err)
+	}
+}
+
+var dumptests = []struct {
+	name    string
+	dumper  Dumper
+	tunnels []int
+	err     bool
+}{
+	{"interface without peers", FileDumper("testdata/nopeers.dump"), []int{0}, false},
+	{"truncated peer line", FileDumper("testdata/truncated.dump"), nil, true},
+	{"missing file", FileDumper("testdata/missing.dump"), nil, true},
+	{"failing dumper", func() ([]byte, error) { return nil, errors.New("wg not installed") }, nil, true},
+	{"peer before its interface", func() ([]byte, error) {
+		return []byte("wg0\tcGVlcg==\t(none)\t(none)\t(none)\t0\t0\t0\toff\n"), nil
+	}, nil, true},
+	{"empty dump", func() ([]byte, error) { return []byte("\n"), nil }, []int{}, false},
+}
+
+func TestDumps(t *testing.T) {
+
+	for _, tt := range dumptests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			underTest := NewSource(tt.dumper, time.Minute, fixedClock{})
+
+			connections, err := underTest.Connections()
+
+			if tt.err {
+				if err == nil {
+					t.Errorf("Expected an error but got %d connections", len(connections))
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+
+			if len(connections) != len(tt.tunnels) {
+				t.Errorf("Expected %d connections but got %d", len(tt.tunnels), len(connections))
+				return
+			}
+
+			for i, n := range tt.tunnels {
+				if len(connections[i].Tunnels) != n {
+					t.Errorf("Expected %d tunnels but got %d", n, len(connections[i].Tunnels))
+				}
+			}
+
+		})
+	}
+}
+
+func TestCommandDumper(t *testing.T) {
+
+	// Given a command that prints a dump
+	dump := CommandDumper("cat testdata/all.dump")
+
+	// When it's run
+	out, err := dump()
+
+	// Then its output should be returned
+	if err != nil || len(out) == 0 {
+		t.Errorf("Expected output but got %d bytes and %v", len(out), err)
+	}
+
+	// and a failing command should be an error
+	if _, err := CommandDumper("cat testdata/missing.dump")(); err == nil {
+		t.Errorf("Expected an error for a failing command")
+	}
+}
@@ -1,7 +1,6 @@
 package state
 
 import (
-	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/clearchannelinternational/vpncheck/pkg/actor"
 	"github.com/go-kit/kit/log"
 	"time"
@@ -15,8 +14,8 @@ var interruptests = []struct {
 	name  string
 	actor actor.Actor
 }{
-	{name: "State Monitor", actor: monitorActor(log.NewNopLogger(), NewUTCClock(), &State{}, make(chan []*ec2.VpnConnection))},
-	{name: "AddPollerStage", actor: pollerActor(log.NewNopLogger(), make(chan []*ec2.VpnConnection, 1), newMockEC2Client(), &fiveMinutes)},
+	{name: "State Monitor", actor: monitorActor(log.NewNopLogger(), NewUTCClock(), make(chan Poll), &State{})},
+	{name: "AddPollerStage", actor: pollerActor(log.NewNopLogger(), make(chan Poll, 1), newMockSource(), &fiveMinutes, nil)},
 }
 
 // Tests that the actors honour the contract as per https://github.com/oklog/run#run.
@@ -2,7 +2,6 @@ package state
 
 import (
 	"fmt"
-	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/clearchannelinternational/vpncheck/pkg/actor"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -23,29 +22,42 @@ func NewUTCClock() *utcClock {
 	return &utcClock{}
 }
 
-// AddMonitorStage adds a stage to the run group that updates the provided state reference when updates are received via the supplied channel.
-func AddMonitorStage(g *run.Group, logger log.Logger, updates <-chan []*ec2.VpnConnection, clock Clock, state *State) {
+// AddMonitorStage adds a stage to the run group that updates the provided state reference, and any other updaters, when
+// updates are received via the supplied channel. They are updated with the time the poll made it through the pipeline,
+// which repeats of it don't change.
+// As the end of the pipeline, it ends the poll cycle of the connections, if they have one.
+func AddMonitorStage(g *run.Group, logger log.Logger, updates <-chan Poll, clock Clock, updaters ...Updater) {
 
 	actorLogger := log.With(logger, "actor", "monitor state")
 
-	stateMonitor := monitorActor(actorLogger, clock, state, updates)
+	stateMonitor := monitorActor(actorLogger, clock, updates, updaters...)
 	g.Add(stateMonitor.Execute, stateMonitor.Interrupt)
 
 }
 
-// monitorActor returns an actor that updates the provided state reference when updates are received via the supplied channel.
-func monitorActor(logger log.Logger, clock Clock, updater Updater, updates <-chan []*ec2.VpnConnection) actor.Actor {
+// monitorActor returns an actor that updates the provided updaters when updates are received via the supplied channel.
+func monitorActor(logger log.Logger, clock Clock, updates <-chan Poll, updaters ...Updater) actor.Actor {
 
 	cancel := make(chan struct{})
 
 	return actor.NewActor(
 		func() error {
 
+			var timestamp time.Time
+
 			for {
 				select {
-				case vpnConnections := <-updates:
-					_ = level.Debug(logger).Log("msg", "Got update")
-					updater.Update(vpnConnections, clock.Now())
+				case poll := <-updates:
+					_ = level.Debug(logger).Log("msg", "Got update", "repeat", poll.Repeat)
+					if !poll.Repeat {
+						timestamp = clock.Now()
+					}
+					span := poll.Cycle.Start("monitor")
+					for _, updater := range updaters {
+						updater.Update(poll.Connections, timestamp)
+					}
+					span.End()
+					poll.Cycle.End()
 
 				case <-cancel:
 					_ = level.Info(logger).Log("cancelled", "Asked to terminate")
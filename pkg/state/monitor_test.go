@@ -1,9 +1,9 @@
 package state
 
 import (
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/tracing"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
 	"github.com/go-kit/kit/log"
 	"testing"
 	"time"
@@ -15,16 +15,16 @@ func TestStateUpdate(t *testing.T) {
 
 	// Given an update sent to a channel
 	waiter := newStateWaiter(vpnState)
-	updates := make(chan []*ec2.VpnConnection, 1)
+	updates := make(chan Poll, 1)
 
 	expectedClock := newFixedClock()
-	underTest := monitorActor(log.NewNopLogger(), expectedClock, waiter, updates)
+	underTest := monitorActor(log.NewNopLogger(), expectedClock, updates, waiter)
 	defer underTest.Interrupt(nil)
 
-	expectedGatewayId := aws.String("blahblahblah")
-	expectedConnection := &ec2.VpnConnection{VpnGatewayId: expectedGatewayId}
+	expectedID := "blahblahblah"
+	expectedConnection := &vpn.Connection{ID: expectedID}
 
-	updates <- []*ec2.VpnConnection{expectedConnection}
+	updates <- Poll{Connections: []*vpn.Connection{expectedConnection}}
 
 	// When the actor is run
 	go func(a actor.Actor) {
@@ -45,8 +45,8 @@ func TestStateUpdate(t *testing.T) {
 		return
 	}
 
-	if *vpnState.Connections[0].VpnGatewayId != *expectedGatewayId {
-		t.Errorf("Updated connection state incorrect. Expected a gateway id of `%s` but got `%s`", *expectedGatewayId, *vpnState.Connections[0].VpnGatewayId)
+	if vpnState.Connections[0].ID != expectedID {
+		t.Errorf("Updated connection state incorrect. Expected an id of `%s` but got `%s`", expectedID, vpnState.Connections[0].ID)
 	}
 
 	if !vpnState.Timestamp.Equal(expectedClock.Now()) {
@@ -55,12 +55,86 @@ func TestStateUpdate(t *testing.T) {
 
 }
 
+func TestRepeatedPollKeepsTimestamp(t *testing.T) {
+
+	// Given the state has been updated with a poll
+	updates := make(chan Poll)
+	recorder := timestampRecorder(make(chan time.Time, 1))
+	clock := newFixedClock()
+	polled := clock.Now()
+
+	underTest := monitorActor(log.NewNopLogger(), clock, updates, recorder)
+	defer underTest.Interrupt(nil)
+	go func(a actor.Actor) {
+		_ = a.Execute()
+	}(underTest)
+
+	updates <- Poll{Connections: []*vpn.Connection{{ID: "vpn-1"}}}
+	<-recorder
+
+	// When the poll is repeated later, such as with new probe results
+	clock.fixedNow = polled.Add(time.Minute)
+	updates <- Poll{Connections: []*vpn.Connection{{ID: "vpn-1"}}, Repeat: true}
+
+	// Then the state is updated with the time of the poll, as the sources haven't been polled again
+	if got := <-recorder; !got.Equal(polled) {
+		t.Errorf("want timestamp %s; got %s", polled, got)
+	}
+
+	// and the next poll updates it
+	updates <- Poll{Connections: []*vpn.Connection{{ID: "vpn-1"}}}
+	if got := <-recorder; !got.Equal(clock.fixedNow) {
+		t.Errorf("want timestamp %s; got %s", clock.fixedNow, got)
+	}
+}
+
+func TestRepeatDoesNotEndCycle(t *testing.T) {
+
+	// Given a poll cycle going through the pipeline
+	updates := make(chan Poll)
+	recorder := timestampRecorder(make(chan time.Time, 1))
+	tracer := tracing.NewTracer()
+	cycle := tracer.StartCycle("poll")
+
+	underTest := monitorActor(log.NewNopLogger(), newFixedClock(), updates, recorder)
+	defer underTest.Interrupt(nil)
+	go func(a actor.Actor) {
+		_ = a.Execute()
+	}(underTest)
+
+	// When a repeat of an earlier poll gets to the end of the pipeline first, such as with new probe results
+	updates <- Poll{Connections: []*vpn.Connection{{ID: "vpn-1"}}, Repeat: true}
+	<-recorder
+
+	// Then the cycle hasn't ended
+	if ended := tracer.Flush(); len(ended) != 0 {
+		t.Errorf("Expected no spans to have ended, got %+v", ended)
+	}
+
+	// and it ends when its own poll gets there, with the monitor span in it
+	updates <- Poll{Connections: []*vpn.Connection{{ID: "vpn-1"}}, Cycle: cycle}
+	<-recorder
+	updates <- Poll{Connections: []*vpn.Connection{{ID: "vpn-1"}}, Repeat: true}
+	<-recorder
+	ended := tracer.Flush()
+	if len(ended) != 2 || ended[0].Name != "monitor" || ended[0].ParentID != ended[1].ID || ended[1].Name != "poll" {
+		t.Errorf("Expected the monitor span then the poll cycle to end, got %+v", ended)
+	}
+}
+
+// timestampRecorder sends the timestamp of every update down the channel
+type timestampRecorder chan time.Time
+
+func (r timestampRecorder) Update(connections []*vpn.Connection, timeStamp time.Time) {
+	r <- timeStamp
+}
+
 type stateWaiter struct {
 	decorated Updater
 	c         chan struct{}
 }
 
-func (sw stateWaiter) Update(connections []*ec2.VpnConnection, timeStamp time.Time) {
+func (sw stateWaiter) Update(connections []*vpn.Connection, timeStamp time.Time) {
 	defer close(sw.c)
 	sw.decorated.Update(connections, timeStamp)
 }
@@ -2,30 +2,32 @@ package state
 
 import (
 	"fmt"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/tracing"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/oklog/run"
+	"strconv"
 	"time"
 )
 
-// AddPollerStage adds a stage to the run group that polls AWS for VPN telemetry data and sends down the status channel
-func AddPollerStage(g *run.Group, logger log.Logger, status chan<- []*ec2.VpnConnection, svc ec2iface.EC2API, interval *time.Duration) {
+// AddPollerStage adds a stage to the run group that polls the source for the state of VPN connections and sends down the status channel.
+// Each poll starts a new poll cycle for the tracer, which can be nil. Failed polls are logged and polled again at the
+// next interval, rather than stopping vpnck, so the failure can be reported by the readiness probe.
+func AddPollerStage(g *run.Group, logger log.Logger, status chan<- Poll, source vpn.Source, interval *time.Duration, tracer *tracing.Tracer) {
 
-	actorLogger := log.With(logger, "actor", "AWS poller")
+	actorLogger := log.With(logger, "actor", "poller")
 
-	poller := pollerActor(actorLogger, status, svc, interval)
+	poller := pollerActor(actorLogger, status, source, interval, tracer)
 	g.Add(poller.Execute, poller.Interrupt)
 
 }
 
-// pollerActor polls AWS for VPN telemetry data and sends down the status channel
-func pollerActor(logger log.Logger, status chan<- []*ec2.VpnConnection, svc ec2iface.EC2API, interval *time.Duration) actor.Actor {
+// pollerActor polls the source for the state of VPN connections and sends down the status channel
+func pollerActor(logger log.Logger, status chan<- Poll, source vpn.Source, interval *time.Duration, tracer *tracing.Tracer) actor.Actor {
 
 	cancel := make(chan struct{})
-	input := &ec2.DescribeVpnConnectionsInput{}
 	ticker := time.NewTicker(*interval)
 
 	return actor.NewActor(
@@ -33,15 +35,28 @@ func pollerActor(logger log.Logger, status chan<- []*ec2.VpnConnection, svc ec2i
 
 			for {
 
-				result, err := svc.DescribeVpnConnections(input)
+				cycle := tracer.StartCycle("poll")
+				span := cycle.Start("source")
+				connections, err := source.Connections()
+				span.SetError(err)
+				span.End()
 
 				if err != nil {
-					return err
+					cycle.SetError(err)
+					cycle.End()
+					_ = level.Error(logger).Log("msg", "Unable to poll the source, will try again at the next interval", "err", err)
+				} else {
+					cycle.SetAttribute("connections", strconv.Itoa(len(connections)))
+
+					select {
+					case status <- Poll{Connections: connections, Cycle: cycle}:
+						_ = level.Debug(logger).Log("msg", "Sent updated VPN telemetry data to next stage")
+					case <-cancel:
+						_ = level.Info(logger).Log("cancelled", "Asked to terminate")
+						return nil
+					}
 				}
 
-				status <- result.VpnConnections
-				_ = level.Debug(logger).Log("msg", "Sent updated VPN telemetry data to next stage")
-
 				select {
 
 				case <-ticker.C:
@@ -2,10 +2,8 @@ package state
 
 import (
 	"errors"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/clearchannelinternational/vpncheck/pkg/actor"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
 	"github.com/go-kit/kit/log"
 	"testing"
 	"time"
@@ -13,15 +11,15 @@ import (
 
 func TestPollingForOneRequest(t *testing.T) {
 
-	status := make(chan []*ec2.VpnConnection)
+	status := make(chan Poll)
 
-	// Given a correctly configured ec2 client
-	ec2Client := newMockEC2Client()
-	expectedGatewayId := "blahblahblah"
-	ec2Client.describeVpnConnections = describeVpnConnectionsWith(expectedGatewayId)
+	// Given a correctly configured source
+	source := newMockSource()
+	expectedID := "blahblahblah"
+	source.connections = connectionsWith(expectedID)
 
 	duration := time.Hour
-	underTest := pollerActor(log.NewNopLogger(), status, ec2Client, &duration)
+	underTest := pollerActor(log.NewNopLogger(), status, source, &duration, nil)
 	defer underTest.Interrupt(nil)
 
 	// When the actor is run
@@ -30,7 +28,7 @@ func TestPollingForOneRequest(t *testing.T) {
 	}(underTest)
 
 	// Then the vpn connection status should be sent down the channel
-	var update []*ec2.VpnConnection
+	var update Poll
 	select {
 	case update = <-status:
 		// expected - state has been updated
@@ -39,83 +37,78 @@ func TestPollingForOneRequest(t *testing.T) {
 		return
 	}
 
-	if len(update) == 0 {
-		t.Error("Should have received a populated update")
+	if len(update.Connections) == 0 || update.Repeat {
+		t.Error("Should have received a populated update of a new poll")
 		return
 	}
 
-	if *update[0].VpnGatewayId != expectedGatewayId {
-		t.Errorf("VPN Connection Details incorrect. Expected a gateway ID of `%s` but got `%s`", expectedGatewayId, *update[0].VpnGatewayId)
+	if update.Connections[0].ID != expectedID {
+		t.Errorf("VPN Connection Details incorrect. Expected an ID of `%s` but got `%s`", expectedID, update.Connections[0].ID)
 	}
 
 }
 
 func TestPollingErrorHandling(t *testing.T) {
 
-	status := make(chan []*ec2.VpnConnection)
+	status := make(chan Poll)
 
-	// Given an incorrectly configured ec2 client
-	ec2Client := newMockEC2Client()
-	expectedError := errors.New("test error")
-	ec2Client.describeVpnConnections = describeVpnConnectionsReturnsErr(expectedError)
+	// Given a source that fails, and then recovers
+	source := newMockSource()
+	expectedID := "blahblahblah"
+	failed := false
+	source.connections = func() ([]*vpn.Connection, error) {
+		if !failed {
+			failed = true
+			return nil, errors.New("test error")
+		}
+		return connectionsWith(expectedID)()
+	}
 
-	duration := time.Hour
-	underTest := pollerActor(log.NewNopLogger(), status, ec2Client, &duration)
+	duration := 10 * time.Millisecond
+	underTest := pollerActor(log.NewNopLogger(), status, source, &duration, nil)
 	defer underTest.Interrupt(nil)
 
 	// When the actor is run
-	foundErrors := make(chan error)
+	foundErrors := make(chan error, 1)
 	go func(a actor.Actor) {
 		foundErrors <- a.Execute()
 	}(underTest)
 
-	// any error is returned
+	// Then it keeps polling, rather than stopping, and sends the connections once the source recovers
 	select {
-	case err := <-foundErrors:
-		// expected - the error has been propagated
-		if err.Error() != expectedError.Error() {
-			t.Errorf("Expected an error `%v` but got `%v`", expectedError, err)
+	case update := <-status:
+		if len(update.Connections) == 0 || update.Connections[0].ID != expectedID {
+			t.Errorf("Expected the connections polled after the failure, got %+v", update)
 		}
+	case err := <-foundErrors:
+		t.Errorf("Expected the poller to keep running, but it stopped with `%v`", err)
 	case <-time.After(1 * time.Second):
-		t.Errorf("No error was sent")
-		return
+		t.Errorf("No status was sent after the source recovered")
 	}
 
 }
 
-type mockEC2Client struct {
-	ec2iface.EC2API
-	describeVpnConnections func(*ec2.DescribeVpnConnectionsInput) (*ec2.DescribeVpnConnectionsOutput, error)
+type mockSource struct {
+	connections func() ([]*vpn.Connection, error)
 }
 
-func (m *mockEC2Client) DescribeVpnConnections(input *ec2.DescribeVpnConnectionsInput) (*ec2.DescribeVpnConnectionsOutput, error) {
-	return m.describeVpnConnections(input)
+func (m *mockSource) Connections() ([]*vpn.Connection, error) {
+	return m.connections()
 }
 
-func newMockEC2Client() *mockEC2Client {
-	return &mockEC2Client{
-		describeVpnConnections: func(*ec2.DescribeVpnConnectionsInput) (*ec2.DescribeVpnConnectionsOutput, error) {
-			return &ec2.DescribeVpnConnectionsOutput{}, nil
+func newMockSource() *mockSource {
+	return &mockSource{
+		connections: func() ([]*vpn.Connection, error) {
+			return []*vpn.Connection{}, nil
 		},
 	}
 }
 
-func describeVpnConnectionsWith(gatewayId string) func(*ec2.DescribeVpnConnectionsInput) (*ec2.DescribeVpnConnectionsOutput, error) {
-
-	expectedGatewayId := aws.String(gatewayId)
-	expectedConnection := &ec2.VpnConnection{VpnGatewayId: expectedGatewayId}
-	expectedVpnConnections := []*ec2.VpnConnection{expectedConnection}
-
-	return func(*ec2.DescribeVpnConnectionsInput) (*ec2.DescribeVpnConnectionsOutput, error) {
-		return &ec2.DescribeVpnConnectionsOutput{
-			VpnConnections: expectedVpnConnections,
-		}, nil
-	}
-}
+func connectionsWith(id string) func() ([]*vpn.Connection, error) {
 
-func describeVpnConnectionsReturnsErr(err error) func(*ec2.DescribeVpnConnectionsInput) (*ec2.DescribeVpnConnectionsOutput, error) {
+	expectedConnections := []*vpn.Connection{{ID: id}}
 
-	return func(*ec2.DescribeVpnConnectionsInput) (*ec2.DescribeVpnConnectionsOutput, error) {
-		return nil, err
+	return func() ([]*vpn.Connection, error) {
+		return expectedConnections, nil
 	}
 }
@@ -1,22 +1,43 @@
 package state
 
 import (
-	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/clearchannelinternational/vpncheck/pkg/tracing"
+	"github.com/clearchannelinternational/vpncheck/pkg/vpn"
 	"time"
 )
 
+// Poll is the connections from one poll of the sources, as sent between the stages of the pipeline
+type Poll struct {
+	Connections []*vpn.Connection
+
+	// Repeat is true when a stage sends the connections of an earlier poll again, such as the prober with the results
+	// of probing them since. Stages that note when connections were seen, or the pipeline last got a poll through,
+	// ignore repeats, as the sources haven't been polled again.
+	Repeat bool
+
+	// Cycle is the span of the poll cycle, which stages start their spans in and the end of the pipeline ends. Repeats
+	// aren't part of a poll cycle, so don't have one.
+	Cycle *tracing.Span
+}
+
+// With returns a copy of the poll with the supplied connections, such as the connections a stage has added to
+func (p Poll) With(connections []*vpn.Connection) Poll {
+	p.Connections = connections
+	return p
+}
+
 // Can update the status of a VPN connection
 type Updater interface {
-	Update(connections []*ec2.VpnConnection, timeStamp time.Time)
+	Update(connections []*vpn.Connection, timeStamp time.Time)
 }
 
 // State represents the last-known state of the VPN Connections.
 type State struct {
-	Connections []*ec2.VpnConnection
+	Connections []*vpn.Connection
 	Timestamp   time.Time
 }
 
-func (s *State) Update(connections []*ec2.VpnConnection, timeStamp time.Time) {
+func (s *State) Update(connections []*vpn.Connection, timeStamp time.Time) {
 	s.Connections = connections
 	s.Timestamp = timeStamp
 }
@@ -0,0 +1,242 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"sync"
+	"time"
+)
+
+// maxPending is how many ended spans are kept until they're flushed, so they can't build up if exports fail
+const maxPending = 10000
+
+// Tracer records spans of the work done for each poll cycle, for exporting.
+// Stages can still be working on one poll cycle when the next starts, so the span of a cycle is passed down the pipeline
+// with its connections, and spans are started as its children. Calls made to AWS, which can't be passed it, are children
+// of the cycle that started last. A nil Tracer records nothing, so can be used when tracing isn't enabled.
+type Tracer struct {
+	mutex   sync.Mutex
+	cycle   *Span
+	pending []*Ended
+}
+
+// NewTracer returns a tracer ready to use
+func NewTracer() *Tracer {
+	return &Tracer{}
+}
+
+// Span is an operation being traced
+type Span struct {
+	tracer     *Tracer
+	traceID    string
+	id         string
+	parentID   string
+	name       string
+	client     bool
+	start      time.Time
+	attributes map[string]string
+	err        error
+	ended      bool
+}
+
+// Ended is a span that has ended
+type Ended struct {
+	// TraceID and ID are random hex, with the trace ID shared by the spans of a poll cycle
+	TraceID string
+	ID      string
+
+	// ParentID is the ID of the span this one is part of, which is empty for the span of a poll cycle
+	ParentID string
+
+	Name string
+
+	// Client is true for calls to other services, such as AWS
+	Client bool
+
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+
+	// Error is why the operation failed, which is empty if it succeeded
+	Error string
+}
+
+// StartCycle starts the span of a new poll cycle, which calls made to AWS are children of until it ends or another cycle
+// starts. The cycle ends when its span does, which is left to the end of the pipeline.
+func (t *Tracer) StartCycle(name string) *Span {
+
+	if t == nil {
+		return nil
+	}
+
+	s := t.newSpan(name, false, nil)
+
+	t.mutex.Lock()
+	t.cycle = s
+	t.mutex.Unlock()
+
+	return s
+}
+
+// Start starts a span as a child of this one, such as the work done by a stage for a poll cycle. A nil span has no
+// children, so work on connections that aren't part of a poll cycle isn't traced.
+func (s *Span) Start(name string) *Span {
+
+	if s == nil {
+		return nil
+	}
+
+	return s.tracer.newSpan(name, false, s)
+}
+
+func (t *Tracer) currentCycle() *Span {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.cycle
+}
+
+func (t *Tracer) newSpan(name string, client bool, parent *Span) *Span {
+
+	s := &Span{
+		tracer:     t,
+		id:         newID(8),
+		name:       name,
+		client:     client,
+		start:      time.Now(),
+		attributes: make(map[string]string),
+	}
+
+	if parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.id
+	} else {
+		s.traceID = newID(16)
+	}
+
+	return s
+}
+
+// SetAttribute adds an attribute describing the operation
+func (s *Span) SetAttribute(key string, value string) {
+	if s == nil {
+		return
+	}
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+	s.attributes[key] = value
+}
+
+// SetError records that the operation failed
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+	s.err = err
+}
+
+// End ends the span, so it's flushed next time. Ending a span again does nothing.
+func (s *Span) End() {
+
+	if s == nil {
+		return
+	}
+
+	end := time.Now()
+
+	t := s.tracer
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if s.ended {
+		return
+	}
+	s.ended = true
+
+	if t.cycle == s {
+		t.cycle = nil
+	}
+
+	ended := &Ended{
+		TraceID:    s.traceID,
+		ID:         s.id,
+		ParentID:   s.parentID,
+		Name:       s.name,
+		Client:     s.client,
+		Start:      s.start,
+		End:        end,
+		Attributes: s.attributes,
+	}
+
+	if s.err != nil {
+		ended.Error = s.err.Error()
+	}
+
+	if len(t.pending) < maxPending {
+		t.pending = append(t.pending, ended)
+	}
+}
+
+// Flush returns the spans that have ended since it was last called
+func (t *Tracer) Flush() []*Ended {
+
+	if t == nil {
+		return nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	pending := t.pending
+	t.pending = nil
+
+	return pending
+}
+
+// InstrumentAWS adds handlers that trace each call made to AWS with the handlers, as a child of the poll cycle that
+// started last. Clients only pick up handlers added to a session before they are created.
+func (t *Tracer) InstrumentAWS(handlers *request.Handlers) {
+
+	if t == nil {
+		return
+	}
+
+	var spans sync.Map
+
+	// Validate runs once per call, whereas Send runs again for every retry
+	handlers.Validate.PushFrontNamed(request.NamedHandler{
+		Name: "vpncheck.tracing.StartSpan",
+		Fn: func(r *request.Request) {
+			s := t.newSpan(r.ClientInfo.ServiceName+"."+r.Operation.Name, true, t.currentCycle())
+			s.attributes["rpc.system"] = "aws-api"
+			s.attributes["rpc.service"] = r.ClientInfo.ServiceName
+			s.attributes["rpc.method"] = r.Operation.Name
+			spans.Store(r, s)
+		},
+	})
+
+	handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "vpncheck.tracing.EndSpan",
+		Fn: func(r *request.Request) {
+
+			value, ok := spans.Load(r)
+			if !ok {
+				return
+			}
+			spans.Delete(r)
+
+			s := value.(*Span)
+			s.SetError(r.Error)
+			s.End()
+		},
+	})
+}
+
+// newID returns a random ID of the supplied number of bytes, encoded as hex
+func newID(bytes int) string {
+	b := make([]byte, bytes)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,169 @@
+package tracing
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"testing"
+)
+
+func TestSpansOfACycleShareATrace(t *testing.T) {
+
+	// Given a tracer
+	tracer := NewTracer()
+
+	// When a cycle is traced with a span in it
+	cycle := tracer.StartCycle("poll")
+	cycle.SetAttribute("connections", "2")
+	span := cycle.Start("monitor")
+	span.SetError(errors.New("failed"))
+	span.End()
+	cycle.End()
+
+	// Then both spans are flushed
+	ended := tracer.Flush()
+	if len(ended) != 2 {
+		t.Fatalf("Expected 2 ended spans, got %d", len(ended))
+	}
+
+	child, parent := ended[0], ended[1]
+
+	// And the span is a child of the cycle
+	if parent.Name != "poll" || parent.ParentID != "" {
+		t.Errorf("Expected the cycle span to have no parent, got %+v", parent)
+	}
+	if child.Name != "monitor" || child.ParentID != parent.ID || child.TraceID != parent.TraceID {
+		t.Errorf("Expected the span to be a child of the cycle %+v, got %+v", parent, child)
+	}
+
+	// And the attributes and errors are recorded
+	if parent.Attributes["connections"] != "2" {
+		t.Errorf("Expected the cycle to have the connections attribute, got %v", parent.Attributes)
+	}
+	if child.Error != "failed" || parent.Error != "" {
+		t.Errorf("Expected only the child to have failed, got %q and %q", child.Error, parent.Error)
+	}
+
+	// And nothing is flushed again
+	if again := tracer.Flush(); len(again) != 0 {
+		t.Errorf("Expected nothing to be flushed again, got %d spans", len(again))
+	}
+}
+
+func TestCyclesOverlap(t *testing.T) {
+
+	// Given a tracer with a cycle still going through the pipeline
+	tracer := NewTracer()
+	handlers := request.Handlers{}
+	tracer.InstrumentAWS(&handlers)
+	first := tracer.StartCycle("poll")
+
+	// When another cycle starts, and a call is made to AWS
+	second := tracer.StartCycle("poll")
+	r := request.New(aws.Config{}, metadata.ClientInfo{ServiceName: "ec2"}, handlers, nil, &request.Operation{Name: "DescribeVpnConnections"}, nil, nil)
+	r.Handlers.Validate.Run(r)
+	r.Handlers.Complete.Run(r)
+
+	// Then the first cycle hasn't ended
+	ended := tracer.Flush()
+	if len(ended) != 1 {
+		t.Fatalf("Expected 1 ended span, got %d", len(ended))
+	}
+
+	// And the call is part of the second cycle
+	if ended[0].ParentID != second.id || ended[0].TraceID != second.traceID {
+		t.Errorf("Expected the call to be part of the second cycle, got %+v", ended[0])
+	}
+
+	// And spans of the first cycle are still its children, ending only once
+	span := first.Start("monitor")
+	span.End()
+	span.End()
+	first.End()
+	ended = tracer.Flush()
+	if len(ended) != 2 || ended[0].ParentID != first.id || ended[0].TraceID == second.traceID || ended[1].ID != first.id {
+		t.Errorf("Expected the span then the first cycle to end, got %+v", ended)
+	}
+}
+
+func TestCallsAfterACycleStartATrace(t *testing.T) {
+
+	// Given a cycle that has ended
+	tracer := NewTracer()
+	handlers := request.Handlers{}
+	tracer.InstrumentAWS(&handlers)
+	tracer.StartCycle("poll").End()
+	tracer.Flush()
+
+	// When a call is made to AWS
+	r := request.New(aws.Config{}, metadata.ClientInfo{ServiceName: "ec2"}, handlers, nil, &request.Operation{Name: "DescribeVpnConnections"}, nil, nil)
+	r.Handlers.Validate.Run(r)
+	r.Handlers.Complete.Run(r)
+
+	// Then it's a span of its own trace
+	ended := tracer.Flush()
+	if len(ended) != 1 || ended[0].ParentID != "" || ended[0].TraceID == "" {
+		t.Errorf("Expected a span of its own trace, got %+v", ended)
+	}
+}
+
+func TestNilSpanHasNoChildren(t *testing.T) {
+
+	var cycle *Span
+
+	if span := cycle.Start("monitor"); span != nil {
+		t.Errorf("Expected no span, got %+v", span)
+	}
+}
+
+func TestNilTracerRecordsNothing(t *testing.T) {
+
+	var tracer *Tracer
+
+	cycle := tracer.StartCycle("poll")
+	cycle.SetAttribute("connections", "1")
+	span := cycle.Start("monitor")
+	span.SetError(errors.New("failed"))
+	span.End()
+	cycle.End()
+	tracer.InstrumentAWS(&request.Handlers{})
+
+	if ended := tracer.Flush(); ended != nil {
+		t.Errorf("Expected nothing to be recorded, got %v", ended)
+	}
+}
+
+func TestInstrumentAWS(t *testing.T) {
+
+	// Given a tracer instrumenting AWS calls
+	tracer := NewTracer()
+	handlers := request.Handlers{}
+	tracer.InstrumentAWS(&handlers)
+
+	// When a call fails within a cycle
+	cycle := tracer.StartCycle("poll")
+	r := request.New(aws.Config{}, metadata.ClientInfo{ServiceName: "ec2"}, handlers, nil, &request.Operation{Name: "DescribeVpnConnections"}, nil, nil)
+	r.Handlers.Validate.Run(r)
+	r.Error = awserr.New("Throttling", "Rate exceeded", nil)
+	r.Handlers.Complete.Run(r)
+	cycle.End()
+
+	// Then the call is a client span of the cycle
+	ended := tracer.Flush()
+	if len(ended) != 2 {
+		t.Fatalf("Expected 2 ended spans, got %d", len(ended))
+	}
+
+	call := ended[0]
+	if call.Name != "ec2.DescribeVpnConnections" || !call.Client || call.ParentID != ended[1].ID {
+		t.Errorf("Expected a client span for the call in the cycle, got %+v", call)
+	}
+	if call.Attributes["rpc.service"] != "ec2" || call.Attributes["rpc.method"] != "DescribeVpnConnections" {
+		t.Errorf("Expected the service and method as attributes, got %v", call.Attributes)
+	}
+	if call.Error == "" {
+		t.Error("Expected the call to have failed")
+	}
+}
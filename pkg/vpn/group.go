@@ -0,0 +1,75 @@
+package vpn
+
+import (
+	"sort"
+)
+
+// Ungrouped is the group of connections that don't have the tag they are grouped by
+const Ungrouped = "ungrouped"
+
+// Group is the connections that have the same value of a tag
+type Group struct {
+	// Name is the value of the tag, or Ungrouped for connections without it
+	Name string
+
+	Connections []*Connection
+}
+
+// GroupByTag returns the connections grouped by the value of the tag, in order of the values with any ungrouped
+// connections last. Connections stay in the order supplied within their group.
+func GroupByTag(connections []*Connection, tag string) []*Group {
+
+	byName := make(map[string]*Group)
+	var groups []*Group
+	var ungrouped *Group
+
+	for _, conn := range connections {
+
+		name, ok := conn.Tags[tag]
+		if !ok || name == "" {
+			if ungrouped == nil {
+				ungrouped = &Group{Name: Ungrouped}
+			}
+			ungrouped.Connections = append(ungrouped.Connections, conn)
+			continue
+		}
+
+		g, ok := byName[name]
+		if !ok {
+			g = &Group{Name: name}
+			byName[name] = g
+			groups = append(groups, g)
+		}
+		g.Connections = append(g.Connections, conn)
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+
+	if ungrouped != nil {
+		groups = append(groups, ungrouped)
+	}
+
+	return groups
+}
+
+// Healthy is how many of the connections in the group are healthy
+func (g *Group) Healthy() int {
+	healthy := 0
+	for _, conn := range g.Connections {
+		if conn.Healthy() {
+			healthy++
+		}
+	}
+	return healthy
+}
+
+// Up is how many of the connections in the group have at least one tunnel up
+func (g *Group) Up() int {
+	up := 0
+	for _, conn := range g.Connections {
+		if conn.Up() {
+			up++
+		}
+	}
+	return up
+}
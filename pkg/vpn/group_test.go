@@ -0,0 +1,55 @@
+package vpn
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupByTag(t *testing.T) {
+
+	// Given connections tagged with different sites, and some without a site
+	up, down := []*Tunnel{{Status: StatusUp}}, []*Tunnel{{Status: StatusDown}}
+	connections := []*Connection{
+		{ID: "vpn-1", Tags: map[string]string{"Site": "paris"}, Tunnels: up},
+		{ID: "vpn-2", Tags: map[string]string{"Team": "payments"}, Tunnels: up},
+		{ID: "vpn-3", Tags: map[string]string{"Site": "london"}, Tunnels: down},
+		{ID: "vpn-4", Tags: map[string]string{"Site": "paris"}, Tunnels: up, Probes: []*Probe{{Success: false}}},
+		{ID: "vpn-5", Tags: map[string]string{"Site": ""}, Tunnels: down},
+		{ID: "vpn-6", Tags: map[string]string{"Site": "london"}, Tunnels: up},
+	}
+
+	// When they are grouped by site
+	groups := GroupByTag(connections, "Site")
+
+	// Then they are grouped in order of site, with those without one last
+	type summary struct {
+		name    string
+		ids     []string
+		healthy int
+		up      int
+	}
+	var got []summary
+	for _, g := range groups {
+		s := summary{name: g.Name, healthy: g.Healthy(), up: g.Up()}
+		for _, conn := range g.Connections {
+			s.ids = append(s.ids, conn.ID)
+		}
+		got = append(got, s)
+	}
+
+	want := []summary{
+		{name: "london", ids: []string{"vpn-3", "vpn-6"}, healthy: 1, up: 1},
+		{name: "paris", ids: []string{"vpn-1", "vpn-4"}, healthy: 1, up: 2},
+		{name: Ungrouped, ids: []string{"vpn-2", "vpn-5"}, healthy: 1, up: 1},
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %+v; got %+v", want, got)
+	}
+}
+
+func TestGroupByTagWithoutConnections(t *testing.T) {
+	if groups := GroupByTag(nil, "Site"); len(groups) != 0 {
+		t.Errorf("want no groups; got %+v", groups)
+	}
+}
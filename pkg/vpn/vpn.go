@@ -0,0 +1,215 @@
+package vpn
+
+import (
+	"time"
+)
+
+// Status of a tunnel
+type Status string
+
+const (
+	StatusUp      Status = "UP"
+	StatusDown    Status = "DOWN"
+	StatusUnknown Status = "UNKNOWN"
+)
+
+// Well known attributes, which sources should set when they apply
+const (
+	// The VPN gateway the connection terminates on
+	AttrVpnGatewayID = "vpn_gateway_id"
+
+	// The transit gateway the connection terminates on
+	AttrTransitGatewayID = "transit_gateway_id"
+
+	// The cloud account the connection belongs to
+	AttrAccountID = "account_id"
+
+	// The cloud region the connection is in
+	AttrRegion = "region"
+)
+
+// Source is somewhere the state of VPN connections can be fetched from
+type Source interface {
+	// Connections returns the current state of every VPN connection the source knows about
+	Connections() ([]*Connection, error)
+}
+
+// Connection is a VPN connection between two sites, made up of one or more tunnels.
+type Connection struct {
+	// ID uniquely identifies the connection
+	ID string `json:"id"`
+
+	// Name is a human friendly name for the connection, which can be empty
+	Name string `json:"name,omitempty"`
+
+	// Source is the name of the source that reported the connection
+	Source string `json:"source"`
+
+	// Tags are any key value pairs the connection has been labelled with
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Attributes are any other details about the connection the source knows
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	Tunnels []*Tunnel `json:"tunnels"`
+
+	// Routes are the static routes over the connection, for sources that have them
+	Routes []*Route `json:"routes,omitempty"`
+
+	// Probes are the latest results of probing targets on the far side of the connection, if any are configured
+	Probes []*Probe `json:"probes,omitempty"`
+
+	// Availability of the connection, i.e. at least one of its tunnels being up, over the configured windows
+	Availability []*Availability `json:"availability,omitempty"`
+
+	// Maintenance is any maintenance going on for the connection as a whole
+	Maintenance []*Maintenance `json:"maintenance,omitempty"`
+
+	// Acknowledgement is of the problem with the connection, if someone has said they're dealing with it
+	Acknowledgement *Acknowledgement `json:"acknowledgement,omitempty"`
+
+	// Timeline is when the connection went up and down, and is nil if it isn't tracked
+	Timeline *Timeline `json:"timeline,omitempty"`
+
+	// Raw is the data the source built the connection from. It can include secrets, such as the pre-shared keys of
+	// tunnels, so is left out of JSON and only shown on the raw page.
+	Raw interface{} `json:"-"`
+}
+
+// Tunnel is one path traffic can take over a connection
+type Tunnel struct {
+	// ID identifies the tunnel within its connection, and stays the same while the tunnel exists
+	ID string `json:"id"`
+
+	// OutsideIP is the address of the far end of the tunnel
+	OutsideIP string `json:"outside_ip"`
+
+	Status Status `json:"status"`
+
+	// StatusMessage explains the status, and can be empty
+	StatusMessage string `json:"status_message,omitempty"`
+
+	// LastStatusChange is when the status last changed, and is zero if not known
+	LastStatusChange time.Time `json:"last_status_change"`
+
+	// LastHandshake is when the ends of the tunnel last exchanged keys, and is zero if not known
+	LastHandshake time.Time `json:"last_handshake"`
+
+	// Traffic through the tunnel, which is nil if not known
+	Traffic *Traffic `json:"traffic,omitempty"`
+
+	// Attributes are any other details about the tunnel the source knows
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	// Availability of the tunnel over the configured windows
+	Availability []*Availability `json:"availability,omitempty"`
+
+	// Maintenance is any maintenance going on for the tunnel, including that of its connection
+	Maintenance []*Maintenance `json:"maintenance,omitempty"`
+
+	// Timeline is when the tunnel went up and down, and is nil if it isn't tracked
+	Timeline *Timeline `json:"timeline,omitempty"`
+}
+
+// Route is a static route to the far side of a connection
+type Route struct {
+	// Destination is the CIDR block the route is to
+	Destination string `json:"destination"`
+
+	// State is the state of the route as the source reports it, e.g. available
+	State string `json:"state"`
+
+	// Origin is where the route came from as the source reports it, e.g. Static, and can be empty
+	Origin string `json:"origin,omitempty"`
+}
+
+// Traffic counts the traffic through a tunnel
+type Traffic struct {
+	BytesIn  uint64 `json:"bytes_in"`
+	BytesOut uint64 `json:"bytes_out"`
+}
+
+// Probe is the result of checking a target on the far side of a connection can be reached through it
+type Probe struct {
+	// Target is what was probed, e.g. tcp://10.0.0.1:22
+	Target string `json:"target"`
+
+	Success bool `json:"success"`
+
+	// Latency is how long the probe took
+	Latency time.Duration `json:"latency_ns"`
+
+	// Error explains why the probe failed, and is empty if it succeeded
+	Error string `json:"error,omitempty"`
+
+	// Time is when the probe was run
+	Time time.Time `json:"time"`
+}
+
+// Availability is how much of a window of time a connection or tunnel was up for. Time the status wasn't known for
+// isn't counted.
+type Availability struct {
+	// Window is the name of the window, e.g. 24h or month
+	Window string `json:"window"`
+
+	Percent float64 `json:"percent"`
+}
+
+// Timeline is the history of when a connection or tunnel went up and down, as far back as the availability windows
+// need
+type Timeline struct {
+	// Transitions are in time order, with the status holding until the next one
+	Transitions []*Transition `json:"transitions"`
+
+	// LastSeen is when the status was last known
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Transition is when a connection or tunnel went up or down, or its status stopped being known
+type Transition struct {
+	Time time.Time `json:"time"`
+	Up   bool      `json:"up"`
+
+	// Unknown is true when the connection or tunnel stopped being seen, until the next transition
+	Unknown bool `json:"unknown,omitempty"`
+}
+
+// Acknowledgement is someone noting they're dealing with a problem with a connection
+type Acknowledgement struct {
+	By      string    `json:"by"`
+	Comment string    `json:"comment,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Maintenance is planned work that can take a connection or tunnel down
+type Maintenance struct {
+	Reason string    `json:"reason"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+}
+
+// Attribute returns the value of the named attribute, or an empty string if it isn't set
+func (c *Connection) Attribute(name string) string {
+	return c.Attributes[name]
+}
+
+// Up is true if any of the tunnels of the connection are up
+func (c *Connection) Up() bool {
+	for _, tunnel := range c.Tunnels {
+		if tunnel.Status == StatusUp {
+			return true
+		}
+	}
+	return false
+}
+
+// Healthy is true if the connection is up and every probe through it succeeded. Tunnels can report being up even
+// though no traffic flows through them.
+func (c *Connection) Healthy() bool {
+	for _, probe := range c.Probes {
+		if !probe.Success {
+			return false
+		}
+	}
+	return c.Up()
+}
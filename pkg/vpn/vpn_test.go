@@ -0,0 +1,58 @@
+package vpn
+
+import (
+	"testing"
+)
+
+var uptests = []struct {
+	name    string
+	tunnels []*Tunnel
+	truth   bool
+}{
+	{name: "No tunnels", tunnels: nil, truth: false},
+	{name: "All tunnels up", tunnels: []*Tunnel{{Status: StatusUp}, {Status: StatusUp}}, truth: true},
+	{name: "One tunnel up", tunnels: []*Tunnel{{Status: StatusDown}, {Status: StatusUp}}, truth: true},
+	{name: "No tunnels up", tunnels: []*Tunnel{{Status: StatusDown}, {Status: StatusUnknown}}, truth: false},
+}
+
+func TestUp(t *testing.T) {
+
+	for _, tt := range uptests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			conn := &Connection{Tunnels: tt.tunnels}
+
+			if conn.Up() != tt.truth {
+				t.Errorf("want %v; got %v", tt.truth, conn.Up())
+			}
+
+		})
+	}
+}
+
+var healthytests = []struct {
+	name    string
+	tunnels []*Tunnel
+	probes  []*Probe
+	truth   bool
+}{
+	{name: "Up without probes", tunnels: []*Tunnel{{Status: StatusUp}}, probes: nil, truth: true},
+	{name: "Up with probes succeeding", tunnels: []*Tunnel{{Status: StatusUp}}, probes: []*Probe{{Success: true}}, truth: true},
+	{name: "Up with a probe failing", tunnels: []*Tunnel{{Status: StatusUp}}, probes: []*Probe{{Success: true}, {Success: false}}, truth: false},
+	{name: "Down with probes succeeding", tunnels: []*Tunnel{{Status: StatusDown}}, probes: []*Probe{{Success: true}}, truth: false},
+}
+
+func TestHealthy(t *testing.T) {
+
+	for _, tt := range healthytests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			conn := &Connection{Tunnels: tt.tunnels, Probes: tt.probes}
+
+			if conn.Healthy() != tt.truth {
+				t.Errorf("want %v; got %v", tt.truth, conn.Healthy())
+			}
+
+		})
+	}
+}
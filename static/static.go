@@ -0,0 +1,10 @@
+// Package static holds the stylesheets and fonts of the HTML pages, embedded in the binary so the pages don't load
+// anything from third parties and work on networks without internet access
+package static
+
+import "embed"
+
+// FS holds the assets, named as their paths under this directory
+//
+//go:embed *.css fonts/*.woff2
+var FS embed.FS
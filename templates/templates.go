@@ -0,0 +1,10 @@
+// Package templates holds the templates the HTML pages are rendered with, embedded in the binary so it doesn't
+// depend on the directory it's started from
+package templates
+
+import "embed"
+
+// FS holds the templates, named as their file names
+//
+//go:embed *.gohtml
+var FS embed.FS